@@ -0,0 +1,137 @@
+// Package api provides a client for the Smuggle agent HTTP API. The types
+// within this package mirror the JSON returned by the API, so it can be used
+// without depending on the agent internals.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// EnvHTTPAddr is the environment variable used to set the address of the
+	// Smuggle agent within the default configuration.
+	EnvHTTPAddr = "SMUGGLE_HTTP_ADDR"
+
+	// DefaultAddress is the address of the Smuggle agent used when neither the
+	// configuration nor the environment specify one.
+	DefaultAddress = "http://localhost:9090"
+)
+
+// Config is the configuration for the API client.
+type Config struct {
+
+	// Address is the address of the Smuggle agent, including the scheme.
+	Address string
+
+	// HTTPClient is the client used to perform requests. If nil, a client
+	// with a 30 second timeout is used.
+	HTTPClient *http.Client
+}
+
+// DefaultConfig returns the default configuration for the API client, with
+// the address read from the SMUGGLE_HTTP_ADDR environment variable if set.
+func DefaultConfig() *Config {
+	cfg := Config{Address: DefaultAddress}
+
+	if addr := os.Getenv(EnvHTTPAddr); addr != "" {
+		cfg.Address = addr
+	}
+
+	return &cfg
+}
+
+// Client is a client for the Smuggle agent HTTP API.
+type Client struct {
+	address    *url.URL
+	httpClient *http.Client
+}
+
+// NewClient returns a new API client using the passed configuration. If the
+// configuration is nil, the default configuration is used.
+func NewClient(cfg *Config) (*Client, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+
+	address := cfg.Address
+	if address == "" {
+		address = DefaultAddress
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse address: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("address %q must include the scheme and host", address)
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return &Client{
+		address:    u,
+		httpClient: httpClient,
+	}, nil
+}
+
+// ResponseError is returned when the API responds with an unexpected status
+// code.
+type ResponseError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("unexpected response code %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether the error is a ResponseError with a 404 status
+// code.
+func IsNotFound(err error) bool {
+	respErr, ok := err.(*ResponseError)
+	return ok && respErr.StatusCode == http.StatusNotFound
+}
+
+// get performs a GET request against the API path, which is relative to the
+// API version prefix, and decodes the JSON response body into out.
+func (c *Client) get(path string, out any) error {
+
+	u := c.address.JoinPath("/v1", path)
+
+	resp, err := c.httpClient.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+
+		// Endpoints return errors as a JSON object, but responses from
+		// proxies or unknown routes may not be JSON.
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		message := strings.TrimSpace(string(body))
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+			message = errResp.Error
+		}
+
+		return &ResponseError{StatusCode: resp.StatusCode, Message: message}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestDefaultConfig(t *testing.T) {
+	t.Setenv(EnvHTTPAddr, "")
+	must.Eq(t, DefaultAddress, DefaultConfig().Address)
+
+	t.Setenv(EnvHTTPAddr, "http://10.0.0.1:9090")
+	must.Eq(t, "http://10.0.0.1:9090", DefaultConfig().Address)
+}
+
+func TestNewClient(t *testing.T) {
+
+	client, err := NewClient(&Config{Address: "http://localhost:9090"})
+	must.NoError(t, err)
+	must.Eq(t, "localhost:9090", client.address.Host)
+
+	// An empty address should use the default.
+	client, err = NewClient(&Config{})
+	must.NoError(t, err)
+	must.Eq(t, "localhost:9090", client.address.Host)
+
+	// Addresses without a scheme cannot be used to perform requests.
+	_, err = NewClient(&Config{Address: "localhost:9090"})
+	must.Error(t, err)
+}
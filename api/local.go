@@ -0,0 +1,71 @@
+package api
+
+import "time"
+
+// SubnetLease is the lease the local agent holds on its subnet within a
+// network.
+type SubnetLease struct {
+	ClientID            string    `json:"client_id"`
+	NetworkName         string    `json:"network_name"`
+	IPv4Network         string    `json:"ipv4_network"`
+	TTL                 string    `json:"ttl"`
+	RenewInterval       string    `json:"renew_interval"`
+	Expiration          time.Time `json:"expiration"`
+	LastRenewal         time.Time `json:"last_renewal"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// LocalRoutes are the entries the local agent has programmed to route traffic
+// to the remote subnets of a network.
+type LocalRoutes struct {
+	NetworkName string           `json:"network_name"`
+	Provider    string           `json:"provider"`
+	Routes      []*RouteEntry    `json:"routes"`
+	FDB         []*FDBEntry      `json:"fdb"`
+	Neighbors   []*NeighborEntry `json:"neighbors"`
+}
+
+// RouteEntry is a route to a remote subnet via a gateway.
+type RouteEntry struct {
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway"`
+	Interface   string `json:"interface"`
+}
+
+// FDBEntry is a forwarding database entry for a remote VTEP.
+type FDBEntry struct {
+	MAC         string `json:"mac"`
+	Destination string `json:"destination"`
+}
+
+// NeighborEntry is a permanent ARP or NDP entry for a remote gateway.
+type NeighborEntry struct {
+	IP  string `json:"ip"`
+	MAC string `json:"mac"`
+}
+
+// LocalLeases returns the subnet leases held by the local agent. This is only
+// available when the agent is running the client.
+func (c *Client) LocalLeases() ([]*SubnetLease, error) {
+	var resp struct {
+		Leases []*SubnetLease `json:"leases"`
+	}
+	if err := c.get("/local/leases", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Leases, nil
+}
+
+// LocalRoutes returns the routing entries programmed by the local agent for
+// each network whose provider supports listing them. This is only available
+// when the agent is running the client.
+func (c *Client) LocalRoutes() ([]*LocalRoutes, error) {
+	var resp struct {
+		Networks []*LocalRoutes `json:"networks"`
+	}
+	if err := c.get("/local/routes", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Networks, nil
+}
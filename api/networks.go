@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"net/url"
+	"time"
+)
+
+// Network is a network configuration.
+type Network struct {
+	Name      string           `json:"name"`
+	IPMasq    *bool            `json:"ipmasq"`
+	IPv4      *IPv4Config      `json:"ipv4"`
+	IPv6      *IPv6Config      `json:"ipv6,omitempty"`
+	Provider  *ProviderConfig  `json:"provider"`
+	Lease     *LeaseConfig     `json:"lease,omitempty"`
+	Placement *PlacementConfig `json:"placement,omitempty"`
+}
+
+// IPv4Config is the IPv4 address space of a network.
+type IPv4Config struct {
+	Network     string           `json:"network"`
+	Min         string           `json:"min"`
+	Max         string           `json:"max"`
+	Size        uint             `json:"size"`
+	SizeClasses []*IPv4SizeClass `json:"size_classes,omitempty"`
+}
+
+// IPv4SizeClass overrides the IPv4 subnet size for clients running on
+// matching Nomad nodes.
+type IPv4SizeClass struct {
+	Size        uint              `json:"size"`
+	NodeClasses []string          `json:"node_classes,omitempty"`
+	NodeMeta    map[string]string `json:"node_meta,omitempty"`
+}
+
+// IPv6Config is the IPv6 address space of a dual-stack network.
+type IPv6Config struct {
+	Network string `json:"network"`
+	Size    uint   `json:"size"`
+}
+
+// ProviderConfig is the network provider of a network and its configuration.
+type ProviderConfig struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// PlacementConfig selects the Nomad nodes whose clients configure a network.
+type PlacementConfig struct {
+	Datacenters []string          `json:"datacenters,omitempty"`
+	NodePools   []string          `json:"node_pools,omitempty"`
+	NodeClasses []string          `json:"node_classes,omitempty"`
+	NodeMeta    map[string]string `json:"node_meta,omitempty"`
+}
+
+// LeaseConfig is the subnet lease configuration of a network. The durations
+// are formatted as Go duration strings, such as "10m".
+type LeaseConfig struct {
+	TTL           string `json:"ttl"`
+	RenewInterval string `json:"renew_interval"`
+}
+
+// Subnet is the subnet allocated to a client within a network.
+type Subnet struct {
+	ClientID        string          `json:"client_id"`
+	NetworkName     string          `json:"network_name"`
+	Provider        string          `json:"provider"`
+	NomadNodeID     string          `json:"nomad_node_id,omitempty"`
+	NomadNodeName   string          `json:"nomad_node_name,omitempty"`
+	NomadDatacenter string          `json:"nomad_datacenter,omitempty"`
+	HostIPv4        string          `json:"host_ipv4"`
+	HostIPv6        string          `json:"host_ipv6,omitempty"`
+	Config          json.RawMessage `json:"config"`
+	Expiration      time.Time       `json:"expiration"`
+	Expired         bool            `json:"expired"`
+	IPv4Network     string          `json:"ipv4_network"`
+	IPv6Network     string          `json:"ipv6_network,omitempty"`
+	MTU             int             `json:"mtu"`
+}
+
+// ListNetworks returns all the network configurations within the store.
+func (c *Client) ListNetworks() ([]*Network, error) {
+	var resp struct {
+		Networks []*Network `json:"networks"`
+	}
+	if err := c.get("/networks", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Networks, nil
+}
+
+// ListNetworkSubnets returns the subnets allocated within the network. If the
+// network does not exist, the error satisfies IsNotFound.
+func (c *Client) ListNetworkSubnets(name string) ([]*Subnet, error) {
+	var resp struct {
+		Subnets []*Subnet `json:"subnets"`
+	}
+	if err := c.get("/networks/"+url.PathEscape(name)+"/subnets", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Subnets, nil
+}
+
+// GetClientSubnets returns the subnets allocated to the client within each
+// network. If the client has no subnets, the error satisfies IsNotFound.
+func (c *Client) GetClientSubnets(clientID string) ([]*Subnet, error) {
+	var resp struct {
+		Subnets []*Subnet `json:"subnets"`
+	}
+	if err := c.get("/subnets/"+url.PathEscape(clientID), &resp); err != nil {
+		return nil, err
+	}
+	return resp.Subnets, nil
+}
@@ -0,0 +1,20 @@
+package api
+
+// Health is the health of the Smuggle agent.
+type Health struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+
+	// Leader indicates whether the server of the agent is the leader, running
+	// the reapers. It is nil when the agent does not run a server.
+	Leader *bool `json:"leader,omitempty"`
+}
+
+// Health returns the health of the Smuggle agent.
+func (c *Client) Health() (*Health, error) {
+	var resp Health
+	if err := c.get("/system/health", &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
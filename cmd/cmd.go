@@ -10,6 +10,7 @@ import (
 
 	"github.com/rasorp/smuggle/internal/cmd/agent"
 	"github.com/rasorp/smuggle/internal/cmd/network"
+	"github.com/rasorp/smuggle/internal/cmd/subnet"
 	clihelp "github.com/rasorp/smuggle/internal/helper/cli"
 	"github.com/rasorp/smuggle/internal/version"
 )
@@ -29,13 +30,14 @@ func main() {
 		Commands: []*cli.Command{
 			agent.Command(),
 			network.Command(),
+			subnet.Command(),
 		},
 		Name:  "smuggle",
 		Usage: "Layer 3 network fabric for IBM HashiCorp Nomad",
 		Description: strings.TrimSpace(
 			`
 Smuggle is a lightweight layer 3 overlay network fabric for IBM HashiCorp Nomad.
-It currently supports VXLAN overlays.
+It currently supports VXLAN and WireGuard overlays.
 
 While other container networking solutions exist, most are focused on Kubernetes
 and are either incompatible with Nomad or require additional services to be run
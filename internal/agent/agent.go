@@ -14,6 +14,8 @@ import (
 	"github.com/rasorp/smuggle/internal/config"
 	"github.com/rasorp/smuggle/internal/http"
 	"github.com/rasorp/smuggle/internal/log"
+	"github.com/rasorp/smuggle/internal/metrics"
+	"github.com/rasorp/smuggle/internal/store/consul"
 	"github.com/rasorp/smuggle/internal/store/file"
 	"github.com/rasorp/smuggle/internal/store/nvar"
 	"github.com/rasorp/smuggle/internal/types"
@@ -28,6 +30,11 @@ type Agent struct {
 
 	httpServer *http.Server
 
+	// store is shared by the client, server and HTTP server, so the backend
+	// API client is created and its connectivity checked once, and each store
+	// operation is recorded within the metrics once.
+	store types.Store
+
 	client *client.Client
 	server *server.Server
 }
@@ -45,8 +52,12 @@ func New(cfg *config.AgentConfig) (*Agent, error) {
 		logger: logger.Named(log.ComponentNameAgent),
 	}
 
-	if cfg.HTTP != nil && cfg.HTTP.Enabled != nil && *cfg.HTTP.Enabled {
-		a.httpServer = http.New(cfg.HTTP, logger)
+	httpEnabled := cfg.HTTP != nil && cfg.HTTP.Enabled != nil && *cfg.HTTP.Enabled
+
+	if cfg.Client.IsEnabled() || cfg.Server.IsEnabled() || httpEnabled {
+		if a.store, err = a.setupStore(); err != nil {
+			return nil, fmt.Errorf("failed to setup store: %w", err)
+		}
 	}
 
 	if cfg.Client.IsEnabled() {
@@ -60,21 +71,40 @@ func New(cfg *config.AgentConfig) (*Agent, error) {
 		}
 	}
 
+	// The HTTP server is set up last, as it exposes the state of the client.
+	if httpEnabled {
+		httpReq := &http.ServerReq{
+			Config: cfg.HTTP,
+			Logger: logger,
+			Store:  a.store,
+		}
+		if a.client != nil {
+			httpReq.Client = a.client
+		}
+		if a.server != nil {
+			httpReq.Server = a.server
+		}
+		a.httpServer = http.New(httpReq)
+	}
+
 	return &a, nil
 }
 
 func (a *Agent) setupClient() error {
 
-	store, err := a.setupStore()
+	// The Nomad client is used to discover the local node, which is optional,
+	// so connectivity is not checked here.
+	nomadClient, err := config.NomadClient(a.cfg.Nomad)
 	if err != nil {
-		return fmt.Errorf("failed to setup store: %w", err)
+		return fmt.Errorf("failed to create Nomad client: %w", err)
 	}
 
 	clientReq := &client.ClientReq{
 		Config:   a.cfg.Client,
 		CNIStore: file.NewCNIStore("/opt/smuggle/config"),
 		Logger:   a.logger,
-		Store:    store,
+		Store:    a.store,
+		Nomad:    nomadClient,
 	}
 
 	cl, err := client.New(clientReq)
@@ -88,15 +118,18 @@ func (a *Agent) setupClient() error {
 
 func (a *Agent) setupServer() error {
 
-	store, err := a.setupStore()
-	if err != nil {
-		return fmt.Errorf("failed to setup store: %w", err)
-	}
-
 	serverReq := &server.ServerReq{
 		Config: a.cfg.Server,
 		Logger: a.logger,
-		Store:  store,
+		Store:  a.store,
+	}
+
+	if a.cfg.Server.Reaper.NodeEventsEnabled() || a.cfg.Server.Leader.IsEnabled() {
+		nomadClient, err := a.setupNomadClient()
+		if err != nil {
+			return fmt.Errorf("failed to setup Nomad client: %w", err)
+		}
+		serverReq.Nomad = nomadClient
 	}
 
 	server, err := server.New(serverReq)
@@ -108,19 +141,33 @@ func (a *Agent) setupServer() error {
 	return nil
 }
 
+// setupStore creates the configured store backend, wrapped so each call is
+// recorded within the agent metrics. It is called once, and the store is
+// shared by the agent components.
 func (a *Agent) setupStore() (types.Store, error) {
 
-	nomadClient, err := a.setupNomadClient()
-	if err != nil {
-		return nil, err
-	}
+	var store types.Store
 
 	switch a.cfg.Store.Backend {
 	case "nvar":
-		return nvar.New(nomadClient, a.cfg.Store.NVar.Path), nil
+		nomadClient, err := a.setupNomadClient()
+		if err != nil {
+			return nil, err
+		}
+		store = nvar.New(nomadClient, a.cfg.Store.NVar.Path)
+	case "consul":
+		consulClient, err := a.setupConsulClient()
+		if err != nil {
+			return nil, err
+		}
+		store = consul.New(consulClient, a.cfg.Store.Consul.Path)
+	case "file":
+		store = file.NewStore(a.cfg.Store.File.Path)
 	default:
 		return nil, fmt.Errorf("unsupported store backend: %q", a.cfg.Store.Backend)
 	}
+
+	return metrics.NewStore(store), nil
 }
 
 func (a *Agent) Start() error {
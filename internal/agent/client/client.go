@@ -10,10 +10,12 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hashicorp/nomad/api"
 	"go.uber.org/zap"
 
 	"github.com/rasorp/smuggle/internal/config"
 	"github.com/rasorp/smuggle/internal/log"
+	"github.com/rasorp/smuggle/internal/metrics"
 	"github.com/rasorp/smuggle/internal/network"
 	"github.com/rasorp/smuggle/internal/types"
 )
@@ -22,6 +24,12 @@ const (
 	// clientIDFileName is the name of the file that stores the client ID within
 	// the data directory.
 	clientIDFileName = "id"
+
+	// maxSubnetClaimAttempts is the number of times the client attempts to
+	// claim a subnet CIDR before failing. Each conflict results in a fresh
+	// allocation, so this only needs to cover a handful of clients racing to
+	// allocate at the same time.
+	maxSubnetClaimAttempts = 10
 )
 
 type Client struct {
@@ -29,26 +37,40 @@ type Client struct {
 
 	logger *zap.Logger
 
-	// id is the unique identifier for this client instance. It is persisted
-	// to disk in the data directory, so it remains consistent across restarts
-	// and changes to the host.
+	// id is the unique identifier for this client instance. It is either the
+	// ID of the local Nomad node, or is persisted to disk in the data
+	// directory, so it remains consistent across restarts and changes to the
+	// host.
 	id atomic.Value
 
 	// store is used to persist client state information and receive updates
 	// about other subnets in the Smuggle network.
 	store types.Store
 
+	// nomad is the optional Nomad API client used to discover the local Nomad
+	// node. nomadNode is nil until the node is discovered; discovery is
+	// retried during start and then in the background, so access must be
+	// protected by networksLock.
+	nomad                 *api.Client
+	nomadNode             *api.Node
+	nomadDiscoveryTimeout time.Duration
+
 	//
 	cniStore types.CNIStore
 
 	networkManager *network.Manager
 
-	// networks tracks the networks that this Smuggle client is aware of and
-	// should configure on the host.
-	networks []*types.Network
+	// networks tracks the networks that this Smuggle client has configured on
+	// the host, keyed by network name. It is modified as networks are added to
+	// the store, so access must be protected by networksLock.
+	networks     map[string]*clientNetwork
+	networksLock sync.Mutex
 
-	//
-	subnets []*types.Subnet
+	// leases tracks the state of the subnet lease of each configured network,
+	// keyed by network name. It is updated by the subnet heartbeats, so access
+	// must be protected by leasesLock.
+	leases     map[string]*types.SubnetLease
+	leasesLock sync.RWMutex
 
 	// shtutdownCh is used to signal to all client processes that the agent is
 	// shutting down. All long-running processes should monitor this channel and
@@ -63,29 +85,37 @@ type ClientReq struct {
 	Logger   *zap.Logger
 	Store    types.Store
 	CNIStore types.CNIStore
+	Nomad    *api.Client
 }
 
 func New(req *ClientReq) (*Client, error) {
 
-	netManager, err := network.NewManager(req.Logger, req.Config.NetworkInterface)
+	netManager, err := network.NewManager(
+		req.Logger,
+		req.Config.NetworkInterface,
+		req.Config.FirewallBackend,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create network manager: %w", err)
 	}
 
 	return &Client{
-		cfg:            req.Config,
-		logger:         req.Logger.Named(log.ComponentNameClient),
-		networks:       []*types.Network{},
-		store:          req.Store,
-		cniStore:       req.CNIStore,
-		networkManager: netManager,
-		shutdownCh:     make(chan struct{}),
+		cfg:                   req.Config,
+		logger:                req.Logger.Named(log.ComponentNameClient),
+		networks:              make(map[string]*clientNetwork),
+		leases:                make(map[string]*types.SubnetLease),
+		store:                 req.Store,
+		nomad:                 req.Nomad,
+		nomadDiscoveryTimeout: nomadDiscoveryTimeout,
+		cniStore:              req.CNIStore,
+		networkManager:        netManager,
+		shutdownCh:            make(chan struct{}),
 	}, nil
 }
 
 func (c *Client) Start() error {
 
-	if err := c.generateID(); err != nil {
+	if err := c.setupID(); err != nil {
 		return fmt.Errorf("failed to get client ID: %w", err)
 	}
 
@@ -93,11 +123,15 @@ func (c *Client) Start() error {
 		return fmt.Errorf("failed to initialize client: %w", err)
 	}
 
-	if err := c.startSubnetUpdateHandler(); err != nil {
-		return fmt.Errorf("failed to start remote subnet handler: %w", err)
+	if err := c.startNetworkWatcher(); err != nil {
+		return fmt.Errorf("failed to start network watcher: %w", err)
+	}
+
+	if err := c.startNetworkMonitor(); err != nil {
+		return fmt.Errorf("failed to start network monitor: %w", err)
 	}
 
-	c.startHeartbeaters()
+	c.startNomadDiscovery()
 
 	return nil
 }
@@ -106,6 +140,7 @@ func (c *Client) Stop() error {
 	c.logger.Info("stopping client processes")
 
 	close(c.shutdownCh)
+	c.networkManager.Stop()
 
 	// In order to avoid blocking forever is the shutdown groups do not
 	// terminate correctly, we use a timer to enforce a timeout. In order to do
@@ -128,9 +163,24 @@ func (c *Client) Stop() error {
 		return errors.New("timeout waiting for shutdown")
 	case <-waitFinishedCh:
 	}
+
+	// Leaving is performed once all processes have stopped, so the subnet
+	// heartbeats cannot overwrite the expired subnets.
+	if c.cfg.LeaveOnShutdown {
+		c.logger.Info("leaving client networks")
+
+		if err := c.leaveNetworks(); err != nil {
+			return fmt.Errorf("failed to leave networks: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// Init configures all the networks currently within the store on the host,
+// skipping those whose placement does not match the local Nomad node. It is not
+// an error if no networks exist, as the network watcher configures networks as
+// they are added.
 func (c *Client) Init() error {
 
 	// Read all network configurations from the store that we are able to see
@@ -141,96 +191,206 @@ func (c *Client) Init() error {
 	}
 
 	if len(listResp.Networks) == 0 {
-		return errors.New("no networks configurations found")
+		c.logger.Info("no network configurations found; waiting for networks to be added")
+		return nil
 	}
 
-	for _, networkConfig := range listResp.Networks {
+	return c.syncNetworks(listResp.Networks)
+}
 
-		// Validate the network configuration.
-		if err := networkConfig.Validate(); err != nil {
-			return fmt.Errorf("invalid network: %w", err)
-		}
+// initSubnet sets up the local subnet via the network provider, stores the
+// result and writes the CNI config. The returned subnet is the one populated
+// by the provider and should be used for all further operations, as it
+// contains the provider specific configuration published to remote hosts.
+func (c *Client) initSubnet(netCfg *types.Network, cfg *types.Subnet) (*types.Subnet, error) {
 
-		c.networks = append(c.networks, networkConfig)
+	providerResp, err := c.networkManager.SetLocal(&types.NetworkProviderSetReq{Client: cfg})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up local subnet: %w", err)
+	}
 
-		clientSubnetResp, err := c.store.GetSubnet(&types.StoreGetSubnetReq{
-			ID:          c.id.Load().(string),
-			NetworkName: networkConfig.Name,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to get client subnet config: %w", err)
-		}
+	// Renew the lease as the subnet is written, as a subnet read from the
+	// store may have been written before the client restarted. The subnet may
+	// also have been expired when the client left the network or by an
+	// operator, which peers treat as deleted, so it is marked as live again.
+	// The heartbeat renews a copy of this subnet, so it is also kept live.
+	providerResp.Network.Expiration = time.Now().Add(netCfg.LeaseTTL())
+	providerResp.Network.Expired = false
+
+	if c.nomadNode != nil {
+		providerResp.Network.NomadNodeID = c.nomadNode.ID
+		providerResp.Network.NomadNodeName = c.nomadNode.Name
+		providerResp.Network.NomadDatacenter = c.nomadNode.Datacenter
+	}
+
+	if _, err := c.store.SetSubnet(&types.StoreSetSubnetReq{
+		Subnet: providerResp.Network,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to store client subnet: %w", err)
+	}
 
-		// Perform the canonicalization, so we have all fields set correctly
-		// set. It would be possible to write this back to the data store, but
-		// seeing as this happens on the client, if more than one started at the
-		// same time, they would all race to write it back.
-		networkConfig.Canonicalize()
+	if err := c.cniStore.Set(types.GenerateCNIConfig(netCfg, providerResp.Network)); err != nil {
+		return nil, fmt.Errorf("failed to write CNI config: %w", err)
+	}
 
-		subnet := clientSubnetResp.Subnet
+	return providerResp.Network, nil
+}
 
-		if subnet == nil {
+// ensureIPv4Subnet ensures the client holds a claim on the subnet IPv4 CIDR
+// within the store. If the subnet is nil, a new subnet is allocated. If the
+// CIDR is claimed by another client, which happens when clients allocate
+// subnets at the same time, a fresh subnet is allocated which excludes all the
+// conflicting CIDRs and the claim is attempted again.
+func (c *Client) ensureIPv4Subnet(network *types.Network, subnet *types.Subnet) (*types.Subnet, error) {
 
-			//
-			subnetListReq := types.StoreListSubnetsReq{Network: networkConfig.Name}
+	var conflicts []*types.Subnet
 
-			subnetListResp, err := c.store.ListSubnets(&subnetListReq)
+	for attempt := 1; ; attempt++ {
+		if subnet == nil {
+			subnetListResp, err := c.store.ListSubnets(&types.StoreListSubnetsReq{Network: network.Name})
 			if err != nil {
-				return fmt.Errorf("failed to list existing client subnets: %w", err)
+				return nil, fmt.Errorf("failed to list existing client subnets: %w", err)
 			}
 
-			subnet, err = c.networkManager.GenerateIPv4Subnet(c.getID(), networkConfig, subnetListResp.Subnets)
+			subnet, err = c.networkManager.GenerateIPv4Subnet(
+				c.getID(), network, network.IPv4.SubnetSize(c.nodeAttributes()),
+				append(subnetListResp.Subnets, conflicts...))
 			if err != nil {
-				return fmt.Errorf("failed to generate IPv4 subnet: %w", err)
+				return nil, err
 			}
 		}
 
-		c.logger.Info("initializing local host subnet", networkConfig.LoggingPairs()...)
-
-		if err := c.initSubnet(networkConfig, subnet); err != nil {
-			return fmt.Errorf("failed to initialize subnet: %w", err)
+		err := c.claimIPv4Subnet(network, subnet.IPv4Network)
+		if err == nil {
+			return subnet, nil
 		}
+		if !errors.Is(err, types.ErrSubnetConflict) || attempt == maxSubnetClaimAttempts {
+			return nil, err
+		}
+
+		c.logger.Warn("subnet IPv4 CIDR claimed by another client; allocating new subnet",
+			append(subnet.LoggingPairs(), zap.Int("attempt", attempt), zap.Error(err))...,
+		)
 
-		c.subnets = append(c.subnets, subnet)
+		conflicts = append(conflicts, &types.Subnet{
+			NetworkName: network.Name,
+			IPv4Network: subnet.IPv4Network,
+		})
+		subnet = nil
+	}
+}
+
+// ensureIPv6Subnet ensures the subnet IPv6 allocation matches the network
+// configuration. This allocates an IPv6 range for dual-stack networks if the
+// subnet does not have one, which includes subnets allocated before IPv6 was
+// enabled on the network. If the network is not dual-stack, any IPv6 range is
+// removed from the subnet. The IPv6 CIDR is claimed in the same manner as the
+// IPv4 CIDR.
+func (c *Client) ensureIPv6Subnet(network *types.Network, subnet *types.Subnet) error {
+
+	if network.IPv6 == nil {
+		subnet.IPv6Network = nil
+		return nil
+	}
+
+	var conflicts []*types.Subnet
+
+	for attempt := 1; ; attempt++ {
+		if subnet.IPv6Network == nil {
+			subnetListResp, err := c.store.ListSubnets(&types.StoreListSubnetsReq{Network: network.Name})
+			if err != nil {
+				return fmt.Errorf("failed to list existing client subnets: %w", err)
+			}
 
-		if networkConfig.IPMasq != nil && *networkConfig.IPMasq {
-			if err := c.networkManager.Firewall.SetupMasqRules(networkConfig, subnet); err != nil {
-				return fmt.Errorf("failed to set up firewall masquerade rules: %w", err)
+			ipv6Network, err := c.networkManager.GenerateIPv6Subnet(
+				network, append(subnetListResp.Subnets, conflicts...))
+			if err != nil {
+				return err
 			}
+
+			subnet.IPv6Network = ipv6Network
 		}
 
-		if err := c.networkManager.Firewall.SetupForwardRules(networkConfig); err != nil {
-			return fmt.Errorf("failed to set up firewall forward rules: %w", err)
+		err := c.claimSubnet(network, subnet.IPv6Network.String())
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, types.ErrSubnetConflict) || attempt == maxSubnetClaimAttempts {
+			return err
 		}
 
-		c.logger.Info("successfully initialized local host subnet", subnet.LoggingPairs()...)
-	}
+		c.logger.Warn("subnet IPv6 CIDR claimed by another client; allocating new subnet",
+			append(subnet.LoggingPairs(), zap.Int("attempt", attempt), zap.Error(err))...,
+		)
 
-	if err := c.networkManager.Firewall.EnsureIsolation(c.networks); err != nil {
-		return fmt.Errorf("failed to ensure network isolation: %w", err)
+		conflicts = append(conflicts, &types.Subnet{
+			NetworkName: network.Name,
+			IPv6Network: subnet.IPv6Network,
+		})
+		subnet.IPv6Network = nil
 	}
+}
 
+// claimIPv4Subnet claims each unit of the IPv4 subnet within the network for
+// this client. If a unit is claimed by another client, the units claimed
+// before it remain held by this client until its subnet is deleted. Other
+// clients allocating them conflict and allocate elsewhere, so this only costs
+// address space rather than risking overlap.
+func (c *Client) claimIPv4Subnet(network *types.Network, subnet *types.IPv4Net) error {
+	for _, unit := range network.IPv4.ClaimNetworks(subnet) {
+		if err := c.claimSubnet(network, unit.String()); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (c *Client) initSubnet(netCfg *types.Network, cfg *types.Subnet) error {
+// claimSubnet claims the CIDR within the network for this client.
+func (c *Client) claimSubnet(network *types.Network, cidr string) error {
+	_, err := c.store.ClaimSubnet(&types.StoreClaimSubnetReq{
+		ClientID:    c.getID(),
+		NetworkName: network.Name,
+		CIDR:        cidr,
+	})
+	if errors.Is(err, types.ErrSubnetConflict) {
+		metrics.SubnetClaimConflicts.WithLabelValues(network.Name).Inc()
+	}
+	return err
+}
 
-	providerResp, err := c.networkManager.SetLocal(&types.NetworkProviderSetReq{Client: cfg})
-	if err != nil {
-		return fmt.Errorf("failed to set up local subnet: %w", err)
+// setupID discovers the local Nomad node and sets the client ID. When the
+// client is configured to use the Nomad node ID, the node must be discovered,
+// so a host whose data directory is wiped rejoins with the same identity.
+// Otherwise, the ID is read from or generated within the data directory, and
+// the node is used to place networks, annotate the subnets and expire them
+// sooner when the node fails, so the client can start without it and continue
+// discovering it in the background. Discovery is only retried during start
+// when the node is required, so a client without Nomad starts immediately.
+func (c *Client) setupID() error {
+
+	err := c.discoverNomadNode()
+	if err != nil && c.nomadNodeRequired() {
+		err = c.waitForNomadNode()
 	}
 
-	if _, err := c.store.SetSubnet(&types.StoreSetSubnetReq{
-		Subnet: providerResp.Network,
-	}); err != nil {
-		return fmt.Errorf("failed to store client subnet: %w", err)
+	if c.cfg.UseNomadNodeID {
+		if err != nil {
+			return fmt.Errorf("failed to discover Nomad node: %w", err)
+		}
+		if c.nomadNode == nil {
+			return errors.New("using the Nomad node ID requires a Nomad client")
+		}
+
+		c.id.Store(c.nomadNode.ID)
+		return nil
 	}
 
-	if err := c.cniStore.Set(types.GenerateCNIConfig(netCfg, cfg)); err != nil {
-		return fmt.Errorf("failed to write CNI config: %w", err)
+	if err != nil {
+		c.logger.Warn("failed to discover Nomad node; retrying in the background",
+			zap.Error(err))
 	}
 
-	return nil
+	return c.generateID()
 }
 
 // generateID attempts to read the client ID from disk. If the file does not exist,
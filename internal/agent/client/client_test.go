@@ -0,0 +1,363 @@
+package client
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/shoenig/test/must"
+	"github.com/shoenig/test/wait"
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/config"
+	"github.com/rasorp/smuggle/internal/network"
+	"github.com/rasorp/smuggle/internal/store/file"
+	"github.com/rasorp/smuggle/internal/store/memory"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// testClient returns a client using the passed store, with a network manager
+// that has no providers or firewall. It can therefore only be used to test
+// behaviour which does not modify the host networking.
+func testClient(t *testing.T, store types.Store) *Client {
+	t.Helper()
+
+	c := &Client{
+		cfg:            &config.ClientConfig{DataDir: t.TempDir()},
+		logger:         zap.NewNop(),
+		store:          store,
+		networkManager: &network.Manager{},
+		networks:       make(map[string]*clientNetwork),
+		leases:         make(map[string]*types.SubnetLease),
+		shutdownCh:     make(chan struct{}),
+	}
+	c.id.Store("client-1")
+
+	return c
+}
+
+// testProvider is a network provider which does not modify the host, so the
+// client can configure networks within tests.
+type testProvider struct{}
+
+func (p *testProvider) Name() string { return types.ProviderNameVXLAN }
+
+func (p *testProvider) SetLocal(req *types.NetworkProviderSetReq) (*types.NetworkProviderSetResp, error) {
+	return &types.NetworkProviderSetResp{Network: req.Client.Copy()}, nil
+}
+
+func (p *testProvider) DeleteLocal(_ *types.NetworkProviderDeleteLocalReq) (*types.NetworkProviderDeleteLocalResp, error) {
+	return &types.NetworkProviderDeleteLocalResp{}, nil
+}
+
+func (p *testProvider) DeleteRemote(_ *types.NetworkProviderDeleteRemoteReq) (*types.NetworkProviderDeleteRemoteResp, error) {
+	return &types.NetworkProviderDeleteRemoteResp{}, nil
+}
+
+func (p *testProvider) SetRemote(_ *types.NetworkProviderSetRemoteReq) (*types.NetworkProviderSetRemoteResp, error) {
+	return &types.NetworkProviderSetRemoteResp{}, nil
+}
+
+func (p *testProvider) Reconcile(_ *types.NetworkProviderReconcileReq) (*types.NetworkProviderReconcileResp, error) {
+	return &types.NetworkProviderReconcileResp{}, nil
+}
+
+// testFirewall is a firewall which does not modify the host.
+type testFirewall struct{}
+
+func (f *testFirewall) EnsureIsolation(_ []*types.Network) error               { return nil }
+func (f *testFirewall) SetupForwardRules(_ *types.Network) error               { return nil }
+func (f *testFirewall) SetupMasqRules(_ *types.Network, _ *types.Subnet) error { return nil }
+func (f *testFirewall) RemoveNetworkRules(_ *types.Network, _ *types.Subnet) error {
+	return nil
+}
+
+// testNetworkClient returns a client using the passed store, whose network
+// manager uses providers and a firewall which do not modify the host, so the
+// client can configure networks. A network named "vxlan" is written to the
+// store.
+func testNetworkClient(t *testing.T, store *memory.MemoryStore) *Client {
+	t.Helper()
+
+	var networkConfig types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`,
+	), &networkConfig))
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &networkConfig})
+	must.NoError(t, err)
+
+	c := testClient(t, store)
+	c.networkManager = network.NewManagerWithProviders(zap.NewNop(), &testFirewall{}, &testProvider{})
+	c.cniStore = file.NewCNIStore(t.TempDir())
+
+	return c
+}
+
+// testGetSubnet returns the subnet of the client within the vxlan network.
+func testGetSubnet(t *testing.T, c *Client) *types.Subnet {
+	t.Helper()
+
+	resp, err := c.store.GetSubnet(&types.StoreGetSubnetReq{ID: c.getID(), NetworkName: "vxlan"})
+	must.NoError(t, err)
+	must.NotNil(t, resp.Subnet)
+	return resp.Subnet
+}
+
+func TestClient_Init_afterLeave(t *testing.T) {
+	c := testNetworkClient(t, memory.New())
+
+	must.NoError(t, c.Init())
+	must.NoError(t, c.leaveNetworks())
+	must.True(t, testGetSubnet(t, c).Expired)
+
+	// The subnet left behind is reused on restart, so it must be marked as
+	// live again, otherwise peers never route to the client.
+	must.NoError(t, c.Init())
+
+	subnet := testGetSubnet(t, c)
+	must.False(t, subnet.Expired)
+	must.True(t, subnet.Expiration.After(time.Now()))
+
+	must.NoError(t, c.Stop())
+}
+
+func TestClient_Init_networkChanged(t *testing.T) {
+	store := memory.New()
+	c := testNetworkClient(t, store)
+
+	must.NoError(t, c.Init())
+	subnet := testGetSubnet(t, c)
+
+	c.networksLock.Lock()
+	clientNet := c.networks["vxlan"]
+	c.networksLock.Unlock()
+
+	// Syncing an unchanged network should leave it running.
+	must.NoError(t, c.Init())
+
+	c.networksLock.Lock()
+	must.EqOp(t, clientNet, c.networks["vxlan"])
+	c.networksLock.Unlock()
+
+	var network types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"},"lease":{"ttl":"1h"}}`,
+	), &network))
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &network})
+	must.NoError(t, err)
+
+	// The changed network should be restarted using the new configuration,
+	// while keeping its subnet.
+	must.NoError(t, c.Init())
+
+	c.networksLock.Lock()
+	must.NotEqOp(t, clientNet, c.networks["vxlan"])
+	must.Eq(t, time.Hour, c.networks["vxlan"].network.LeaseTTL())
+	c.networksLock.Unlock()
+
+	must.Eq(t, subnet.IPv4Network, testGetSubnet(t, c).IPv4Network)
+
+	must.NoError(t, c.Stop())
+}
+
+func TestClient_renewSubnet(t *testing.T) {
+	store := memory.New()
+	c := testNetworkClient(t, store)
+	must.NoError(t, c.Init())
+
+	// Expiring the subnet while the client runs, as the server does when the
+	// Nomad node is down, should cause the client to restore it.
+	expired := testGetSubnet(t, c)
+	expired.Expired = true
+	expired.Expiration = time.Now()
+	_, err := store.SetSubnet(&types.StoreSetSubnetReq{Subnet: expired})
+	must.NoError(t, err)
+
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool {
+			subnet := testGetSubnet(t, c)
+			return !subnet.Expired && subnet.Expiration.After(time.Now())
+		}),
+		wait.Timeout(5*time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+
+	// While the subnet is live, its claims are held, so renewing it should
+	// not claim them again.
+	c.networksLock.Lock()
+	clientNet := c.networks["vxlan"]
+	c.networksLock.Unlock()
+
+	calls := store.Calls(memory.OperationClaimSubnet)
+	_, err = c.renewSubnet(clientNet.network, clientNet.subnet)
+	must.NoError(t, err)
+	must.Eq(t, calls, store.Calls(memory.OperationClaimSubnet))
+
+	// Once the server has deleted the subnet, its claims are released, so
+	// restoring must claim them again.
+	_, err = store.DeleteSubnet(&types.StoreDeleteSubnetReq{ID: c.getID(), NetworkName: "vxlan"})
+	must.NoError(t, err)
+
+	_, err = c.renewSubnet(clientNet.network, clientNet.subnet)
+	must.NoError(t, err)
+	must.False(t, testGetSubnet(t, c).Expired)
+	must.Greater(t, calls, store.Calls(memory.OperationClaimSubnet))
+
+	_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{
+		ClientID: "client-2", NetworkName: "vxlan", CIDR: clientNet.subnet.IPv4Network.String(),
+	})
+	must.ErrorIs(t, err, types.ErrSubnetConflict)
+
+	must.NoError(t, c.Stop())
+}
+
+func TestClient_Init_storeErrors(t *testing.T) {
+
+	t.Run("no networks", func(t *testing.T) {
+		c := testClient(t, memory.New())
+		must.NoError(t, c.Init())
+	})
+
+	t.Run("list networks failure", func(t *testing.T) {
+		store := memory.New()
+		store.FailNext(memory.OperationListNetworks, 1, nil)
+
+		c := testClient(t, store)
+		must.ErrorIs(t, c.Init(), memory.ErrInjected)
+	})
+
+	t.Run("invalid network", func(t *testing.T) {
+		store := memory.New()
+		_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{Name: "vxlan"}})
+		must.NoError(t, err)
+
+		c := testClient(t, store)
+		must.ErrorContains(t, c.Init(), "invalid network")
+	})
+
+	t.Run("network not placed on node", func(t *testing.T) {
+		store := memory.New()
+		_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{
+			Name:      "vxlan",
+			Placement: &types.PlacementConfig{NodePools: []string{"gpu"}},
+		}})
+		must.NoError(t, err)
+
+		// The network is invalid, so would fail to be configured if it were
+		// not skipped. Clients which have not discovered their Nomad node do
+		// not match any placement.
+		c := testClient(t, store)
+		must.NoError(t, c.Init())
+
+		c.nomadNode = &api.Node{Datacenter: "dc1", NodePool: "default"}
+		must.NoError(t, c.Init())
+		must.MapEmpty(t, c.networks)
+
+		c.nomadNode.NodePool = "gpu"
+		must.ErrorContains(t, c.Init(), "invalid network")
+	})
+}
+
+func TestClient_StartStop(t *testing.T) {
+	store := memory.New()
+	c := testClient(t, store)
+
+	// The client should start without any networks and wait for them to be
+	// added.
+	must.NoError(t, c.Start())
+	must.Eq(t, 1, store.Calls(memory.OperationWatchNetworks))
+
+	// Stopping the client should stop the network watcher.
+	must.NoError(t, c.Stop())
+}
+
+func TestClient_ensureIPv4Subnet(t *testing.T) {
+
+	var network types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`,
+	), &network))
+
+	var subnet types.Subnet
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"client_id":"client-1","network_name":"vxlan","ipv4_network":"10.10.1.0/24"}`,
+	), &subnet))
+
+	t.Run("claim existing subnet", func(t *testing.T) {
+		store := memory.New()
+		c := testClient(t, store)
+
+		// Claiming the subnet multiple times should be idempotent, as happens
+		// on each client restart.
+		for range 2 {
+			resp, err := c.ensureIPv4Subnet(&network, &subnet)
+			must.NoError(t, err)
+			must.Eq(t, &subnet, resp)
+		}
+
+		// Another client must not be able to claim the same CIDR.
+		_, err := store.ClaimSubnet(&types.StoreClaimSubnetReq{
+			ClientID: "client-2", NetworkName: "vxlan", CIDR: "10.10.1.0/24",
+		})
+		must.ErrorIs(t, err, types.ErrSubnetConflict)
+	})
+
+	t.Run("claim size class units", func(t *testing.T) {
+		store := memory.New()
+		c := testClient(t, store)
+
+		classNetwork := network
+		classNetwork.IPv4 = &types.IPv4Config{
+			Network: network.IPv4.Network,
+			Size:    24,
+			SizeClasses: []*types.IPv4SizeClass{
+				{Size: 25, NodeClasses: []string{"small"}},
+			},
+		}
+
+		_, err := c.ensureIPv4Subnet(&classNetwork, &subnet)
+		must.NoError(t, err)
+
+		// The subnet is claimed in units of the smallest size, so a smaller
+		// subnet overlapping it conflicts with the claim.
+		_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{
+			ClientID: "client-2", NetworkName: "vxlan", CIDR: "10.10.1.128/25",
+		})
+		must.ErrorIs(t, err, types.ErrSubnetConflict)
+	})
+
+	t.Run("claim failure", func(t *testing.T) {
+		store := memory.New()
+		store.FailNext(memory.OperationClaimSubnet, 1, nil)
+
+		c := testClient(t, store)
+
+		// Errors other than conflicts should not trigger a fresh allocation.
+		_, err := c.ensureIPv4Subnet(&network, &subnet)
+		must.ErrorIs(t, err, memory.ErrInjected)
+		must.Eq(t, 0, store.Calls(memory.OperationListSubnets))
+	})
+}
+
+func TestClient_generateID(t *testing.T) {
+	c := testClient(t, memory.New())
+	c.id.Store("")
+
+	// A new ID should be generated and persisted when one does not exist.
+	must.NoError(t, c.generateID())
+
+	id := c.getID()
+	must.NotEq(t, "", id)
+
+	data, err := os.ReadFile(filepath.Join(c.cfg.DataDir, clientIDFileName))
+	must.NoError(t, err)
+	must.Eq(t, id, string(data))
+
+	// The persisted ID should be used on subsequent calls.
+	c.id.Store("")
+	must.NoError(t, c.generateID())
+	must.Eq(t, id, c.getID())
+}
@@ -1,71 +1,184 @@
 package client
 
 import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
 	"time"
 
+	"github.com/sethvargo/go-retry"
 	"go.uber.org/zap"
 
+	"github.com/rasorp/smuggle/internal/metrics"
 	"github.com/rasorp/smuggle/internal/types"
 )
 
-func (c *Client) startHeartbeaters() {
-	for _, subnet := range c.subnets {
-		go c.startSubnetHeartbeat(subnet)
-	}
-}
+const (
+	// heartbeatInitialBackoff is the delay before the first retry of a failed
+	// subnet renewal. Each subsequent failure doubles the delay.
+	heartbeatInitialBackoff = 1 * time.Second
+
+	// heartbeatMaxBackoff is the maximum delay between retries of a failed
+	// subnet renewal. The delay is also capped at the renew interval, so
+	// retries are never less frequent than renewals.
+	heartbeatMaxBackoff = 1 * time.Minute
+
+	// heartbeatJitterPercent is the percentage of jitter applied to retry
+	// delays, so clients which lost access to the store at the same time do
+	// not retry in lockstep.
+	heartbeatJitterPercent = 20
+)
 
-func (c *Client) startSubnetHeartbeat(cfg *types.Subnet) {
-	c.shutdownGroup.Add(1)
+// startSubnetHeartbeat periodically renews the subnet lease within the store,
+// according to the lease settings of the network, until the context is
+// cancelled or the client is shut down. Failed renewals are retried using a
+// jittered exponential backoff, unless the subnet CIDR has been claimed by
+// another client, in which case a new subnet is allocated. The caller must add
+// to the shutdown wait group before starting the heartbeat.
+func (c *Client) startSubnetHeartbeat(ctx context.Context, network *types.Network, subnet *types.Subnet) {
 	defer c.shutdownGroup.Done()
 
-	// Calculate the heartbeat interval as half of the TTL to ensure we update
-	// before expiration. This provides a safety margin.
-	heartbeatInterval := types.DefaultSubnetTTL / 3
+	ttl := network.LeaseTTL()
+	renewInterval := network.LeaseRenewInterval()
 
-	ticker := time.NewTicker(heartbeatInterval)
-	defer ticker.Stop()
+	c.setLease(&types.SubnetLease{
+		ClientID:      subnet.ClientID,
+		NetworkName:   subnet.NetworkName,
+		IPv4Network:   subnet.IPv4Network,
+		TTL:           types.Duration(ttl),
+		RenewInterval: types.Duration(renewInterval),
+		Expiration:    subnet.Expiration,
+	})
+
+	timer := time.NewTimer(renewInterval)
+	defer timer.Stop()
 
 	c.logger.Info("starting subnet heartbeat",
-		append(cfg.LoggingPairs(), zap.String("interval", heartbeatInterval.String()))...,
+		append(subnet.LoggingPairs(),
+			zap.String("ttl", ttl.String()),
+			zap.String("interval", renewInterval.String()),
+		)...,
 	)
 
+	// backoff is set on the first failed renewal and cleared on success, so
+	// each run of failures starts from the initial delay.
+	var backoff retry.Backoff
+
 	for {
 		select {
-		case <-ticker.C:
-			// Create a copy of the subnet config to update the expiration time
-			// without modifying the original reference. Then write this update
-			// back to the store.
-			cfgCopy := cfg.Copy()
-			cfgCopy.Expiration = time.Now().Add(types.DefaultSubnetTTL)
-
-			_, err := c.store.SetSubnet(&types.StoreSetSubnetReq{Subnet: cfg})
-
-			// Adjust the ticker interval based on success or failure. On
-			// success, we maintain the regular interval. On failure, we shorten
-			// the interval to retry sooner.
-			//
-			// TODO(jrasell): Consider implementing some form of backoff, so we
-			// don't "hammer" the store on persistent failures that may take a
-			// while to resolve.
-			switch err {
-			case nil:
-				ticker.Reset(types.DefaultSubnetTTL / 3)
-
-				c.logger.Debug("updated subnet expiration",
-					zap.String("network", cfg.NetworkName),
-					zap.Time("new_expiration", cfg.Expiration),
+		case <-timer.C:
+			expiration, err := c.renewSubnet(network, subnet)
+			lease := c.updateLease(subnet.NetworkName, expiration, err)
+
+			if err == nil {
+				metrics.Heartbeats.WithLabelValues(subnet.NetworkName, metrics.ResultSuccess).Inc()
+				backoff = nil
+				timer.Reset(renewInterval)
+
+				c.logger.Debug("renewed subnet lease",
+					zap.String("network", subnet.NetworkName),
+					zap.Time("expiration", expiration),
 				)
-			default:
-				ticker.Reset(10 * time.Second)
+				continue
+			}
+
+			metrics.Heartbeats.WithLabelValues(subnet.NetworkName, metrics.ResultFailure).Inc()
 
-				c.logger.Error("failed to update subnet expiration",
-					zap.String("network", cfg.NetworkName),
-					zap.Error(err),
+			// Another client has claimed the CIDR since the subnet was
+			// deleted by the server, so writing it would overlap with that
+			// client. The network is set up again with a new subnet, which
+			// starts a new heartbeat.
+			if errors.Is(err, types.ErrSubnetConflict) {
+				c.logger.Error("subnet CIDR claimed by another client; reallocating subnet",
+					append(subnet.LoggingPairs(), zap.Error(err))...,
 				)
+				c.startReallocation(network, subnet)
+				return
+			}
+
+			if backoff == nil {
+				backoff = retry.WithJitterPercent(heartbeatJitterPercent,
+					retry.WithCappedDuration(min(renewInterval, heartbeatMaxBackoff),
+						retry.NewExponential(heartbeatInitialBackoff)))
+			}
+
+			retryIn, _ := backoff.Next()
+			timer.Reset(retryIn)
+
+			fields := []zap.Field{
+				zap.String("network", subnet.NetworkName),
+				zap.Int("consecutive_failures", lease.ConsecutiveFailures),
+				zap.String("retry_in", retryIn.String()),
+				zap.Time("expiration", lease.Expiration),
+				zap.Error(err),
+			}
+
+			// Once the lease has expired, the server will expire the subnet
+			// and other clients remove their routes to this host, so make
+			// this clearly visible to operators.
+			if time.Now().After(lease.Expiration) {
+				c.logger.Error("failed to renew subnet lease, which has expired", fields...)
+			} else {
+				c.logger.Warn("failed to renew subnet lease", fields...)
 			}
+		case <-ctx.Done():
+			c.logger.Info("stopping subnet heartbeat", zap.String("network", subnet.NetworkName))
+			return
 		case <-c.shutdownCh:
-			c.logger.Info("shutting down subnet heartbeat", zap.String("network", cfg.NetworkName))
+			c.logger.Info("shutting down subnet heartbeat", zap.String("network", subnet.NetworkName))
 			return
 		}
 	}
 }
+
+// Leases returns the state of the subnet lease of each configured network,
+// ordered by network name.
+func (c *Client) Leases() []*types.SubnetLease {
+	c.leasesLock.RLock()
+	defer c.leasesLock.RUnlock()
+
+	leases := make([]*types.SubnetLease, 0, len(c.leases))
+	for _, name := range slices.Sorted(maps.Keys(c.leases)) {
+		lease := *c.leases[name]
+		leases = append(leases, &lease)
+	}
+	return leases
+}
+
+func (c *Client) setLease(lease *types.SubnetLease) {
+	c.leasesLock.Lock()
+	defer c.leasesLock.Unlock()
+	c.leases[lease.NetworkName] = lease
+}
+
+func (c *Client) deleteLease(networkName string) {
+	c.leasesLock.Lock()
+	defer c.leasesLock.Unlock()
+	delete(c.leases, networkName)
+}
+
+// updateLease records the result of a renewal attempt against the lease of
+// the network and returns a copy of the updated lease.
+func (c *Client) updateLease(networkName string, expiration time.Time, err error) types.SubnetLease {
+	c.leasesLock.Lock()
+	defer c.leasesLock.Unlock()
+
+	lease, ok := c.leases[networkName]
+	if !ok {
+		lease = &types.SubnetLease{NetworkName: networkName}
+		c.leases[networkName] = lease
+	}
+
+	if err == nil {
+		lease.Expiration = expiration
+		lease.LastRenewal = time.Now()
+		lease.LastError = ""
+		lease.ConsecutiveFailures = 0
+	} else {
+		lease.LastError = err.Error()
+		lease.ConsecutiveFailures++
+	}
+
+	return *lease
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test/must"
+	"github.com/shoenig/test/wait"
+
+	"github.com/rasorp/smuggle/internal/store/memory"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func TestClient_startSubnetHeartbeat(t *testing.T) {
+
+	store := memory.New()
+	c := testClient(t, store)
+
+	// Use a short TTL, so the heartbeat runs frequently.
+	network := &types.Network{
+		Name:  "vxlan",
+		Lease: &types.LeaseConfig{TTL: types.Duration(30 * time.Millisecond)},
+	}
+	subnet := &types.Subnet{ClientID: c.getID(), NetworkName: "vxlan"}
+
+	start := time.Now()
+
+	c.shutdownGroup.Add(1)
+	go c.startSubnetHeartbeat(context.Background(), network, subnet)
+
+	// The heartbeat should write the subnet to the store on each interval.
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool { return store.Calls(memory.OperationSetSubnet) >= 3 }),
+		wait.Timeout(5*time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+
+	// The renewed expiration should be written, rather than that of the
+	// original subnet.
+	resp, err := store.GetSubnet(&types.StoreGetSubnetReq{ID: c.getID(), NetworkName: "vxlan"})
+	must.NoError(t, err)
+	must.NotNil(t, resp.Subnet)
+	must.True(t, resp.Subnet.Expiration.After(start))
+
+	leases := c.Leases()
+	must.Len(t, 1, leases)
+	must.Eq(t, "vxlan", leases[0].NetworkName)
+	must.Eq(t, types.Duration(10*time.Millisecond), leases[0].RenewInterval)
+	must.False(t, leases[0].LastRenewal.IsZero())
+
+	// The heartbeat should stop once shutdown is signalled.
+	must.NoError(t, c.Stop())
+}
+
+func TestClient_startSubnetHeartbeat_failure(t *testing.T) {
+
+	store := memory.New()
+	store.FailNext(memory.OperationSetSubnet, 1000, nil)
+
+	c := testClient(t, store)
+
+	network := &types.Network{
+		Name:  "vxlan",
+		Lease: &types.LeaseConfig{TTL: types.Duration(30 * time.Millisecond)},
+	}
+	subnet := &types.Subnet{ClientID: c.getID(), NetworkName: "vxlan"}
+
+	c.shutdownGroup.Add(1)
+	go c.startSubnetHeartbeat(context.Background(), network, subnet)
+
+	// Failed renewals should be recorded against the lease.
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool {
+			leases := c.Leases()
+			return len(leases) == 1 && leases[0].ConsecutiveFailures >= 2
+		}),
+		wait.Timeout(5*time.Second),
+		wait.Gap(5*time.Millisecond),
+	))
+
+	must.Eq(t, memory.ErrInjected.Error(), c.Leases()[0].LastError)
+
+	// The heartbeat should retry and clear the failures once renewal
+	// succeeds.
+	store.FailNext(memory.OperationSetSubnet, 0, nil)
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool {
+			leases := c.Leases()
+			return len(leases) == 1 && leases[0].ConsecutiveFailures == 0 && leases[0].LastError == ""
+		}),
+		wait.Timeout(5*time.Second),
+		wait.Gap(5*time.Millisecond),
+	))
+
+	must.NoError(t, c.Stop())
+}
+
+func TestClient_startSubnetHeartbeat_conflict(t *testing.T) {
+
+	store := memory.New()
+	c := testNetworkClient(t, store)
+
+	// Use a short TTL, so the heartbeat runs frequently.
+	var network types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"},"lease":{"ttl":"150ms"}}`,
+	), &network))
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &network})
+	must.NoError(t, err)
+
+	must.NoError(t, c.Init())
+	original := testGetSubnet(t, c).IPv4Network.String()
+
+	// Deleting the subnet releases its claims, as the server does once the
+	// subnet is expired, so another client can claim the CIDR.
+	_, err = store.DeleteSubnet(&types.StoreDeleteSubnetReq{ID: c.getID(), NetworkName: "vxlan"})
+	must.NoError(t, err)
+	_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{
+		ClientID: "client-2", NetworkName: "vxlan", CIDR: original,
+	})
+	must.NoError(t, err)
+
+	// The heartbeat must not write the subnet back, and should allocate a new
+	// subnet instead.
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool {
+			resp, err := store.GetSubnet(&types.StoreGetSubnetReq{ID: c.getID(), NetworkName: "vxlan"})
+			must.NoError(t, err)
+			return resp.Subnet != nil && resp.Subnet.IPv4Network.String() != original
+		}),
+		wait.Timeout(5*time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+
+	must.NoError(t, c.Stop())
+}
+
+func TestClient_removeNetwork_stopsHeartbeat(t *testing.T) {
+
+	store := memory.New()
+	c := testNetworkClient(t, store)
+
+	// Use a short TTL, so the heartbeat runs frequently.
+	var network types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"},"lease":{"ttl":"150ms"}}`,
+	), &network))
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &network})
+	must.NoError(t, err)
+
+	must.NoError(t, c.Init())
+
+	// Delay writes, so the network is removed while a renewal is in progress.
+	calls := store.Calls(memory.OperationSetSubnet)
+	store.SetLatency(memory.OperationSetSubnet, 200*time.Millisecond)
+
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool { return store.Calls(memory.OperationSetSubnet) > calls }),
+		wait.Timeout(5*time.Second),
+		wait.Gap(time.Millisecond),
+	))
+
+	// Removing the network must wait for the renewal to finish, so the
+	// deleted subnet is not written back.
+	must.NoError(t, c.syncNetworks(nil))
+	store.SetLatency(memory.OperationSetSubnet, 0)
+
+	time.Sleep(300 * time.Millisecond)
+
+	resp, err := store.GetSubnet(&types.StoreGetSubnetReq{ID: c.getID(), NetworkName: "vxlan"})
+	must.NoError(t, err)
+	must.Nil(t, resp.Subnet)
+
+	must.NoError(t, c.Stop())
+}
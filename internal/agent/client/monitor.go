@@ -0,0 +1,161 @@
+package client
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+const (
+	// monitorDebounceInterval is how long the network monitor waits after
+	// receiving an event before repairing the network. A single operator
+	// action, such as deleting a link, generates many events, so this avoids
+	// repairing the same network repeatedly.
+	monitorDebounceInterval = 2 * time.Second
+
+	// monitorRetryInterval is how long the network monitor waits before
+	// retrying a failed repair.
+	monitorRetryInterval = 10 * time.Second
+)
+
+// pendingRepair tracks the events received for a network which has not yet
+// been repaired.
+type pendingRepair struct {
+	linkDeleted bool
+	events      int
+}
+
+// startNetworkMonitor starts watching the host for the removal of network
+// configuration owned by the providers, so it can be repaired without waiting
+// for the next reconciliation.
+func (c *Client) startNetworkMonitor() error {
+
+	eventCh, err := c.networkManager.StartMonitor()
+	if err != nil {
+		return err
+	}
+
+	c.shutdownGroup.Add(1)
+	go c.networkMonitorImpl(eventCh)
+
+	return nil
+}
+
+func (c *Client) networkMonitorImpl(eventCh <-chan *types.NetworkProviderEvent) {
+	defer c.shutdownGroup.Done()
+
+	pending := make(map[string]*pendingRepair)
+
+	// The timer channel is nil while no repairs are pending, so it never
+	// fires. retrying is set while the timer is waiting to retry failed
+	// repairs rather than debouncing new events.
+	var (
+		timer    *time.Timer
+		timerCh  <-chan time.Time
+		retrying bool
+	)
+
+	for {
+		select {
+		case event := <-eventCh:
+			c.logger.Debug("received network monitor event",
+				zap.String("network", event.NetworkName),
+				zap.String("reason", event.Reason),
+			)
+
+			repair, ok := pending[event.NetworkName]
+			if !ok {
+				repair = &pendingRepair{}
+				pending[event.NetworkName] = repair
+			}
+			repair.linkDeleted = repair.linkDeleted || event.LinkDeleted
+			repair.events++
+
+			// New events are repaired after the debounce interval, even if
+			// failed repairs are waiting to be retried, in which case they
+			// are retried along with them.
+			if timerCh == nil || retrying {
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.NewTimer(monitorDebounceInterval)
+				timerCh = timer.C
+				retrying = false
+			}
+		case <-timerCh:
+			timerCh = nil
+			retrying = false
+
+			for _, name := range slices.Sorted(maps.Keys(pending)) {
+				repair := pending[name]
+
+				c.logger.Info("repairing network after host changes",
+					zap.String("network", name),
+					zap.Bool("link_deleted", repair.linkDeleted),
+					zap.Int("events", repair.events),
+				)
+
+				if err := c.repairNetwork(name, repair.linkDeleted); err != nil {
+					c.logger.Error("failed to repair network",
+						zap.String("network", name),
+						zap.Error(err),
+					)
+					continue
+				}
+				delete(pending, name)
+			}
+
+			// Failed repairs remain pending and are retried after a longer
+			// interval, unless further events arrive first, which retries
+			// them after the debounce interval instead.
+			if len(pending) > 0 {
+				timer = time.NewTimer(monitorRetryInterval)
+				timerCh = timer.C
+				retrying = true
+			}
+		case <-c.shutdownCh:
+			if timer != nil {
+				timer.Stop()
+			}
+			c.logger.Info("shutting down network monitor")
+			return
+		}
+	}
+}
+
+// repairNetwork restores the configuration of the network on the host. If the
+// link was deleted, the local subnet is set up again and its processes are
+// restarted, which causes the subnet watcher to program all remote subnets.
+// Otherwise, the remote subnets are reconciled. Networks which are no longer
+// configured are ignored, as their removal generates events.
+func (c *Client) repairNetwork(name string, linkDeleted bool) error {
+
+	c.networksLock.Lock()
+	defer c.networksLock.Unlock()
+
+	clientNet, ok := c.networks[name]
+	if !ok {
+		c.logger.Debug("skipping repair of unconfigured network", zap.String("network", name))
+		return nil
+	}
+
+	if !linkDeleted {
+		return c.reconcileSubnets(clientNet.network, clientNet.subnet)
+	}
+
+	// Stop the processes of the network and wait for them to exit before
+	// starting new ones, so the old and new processes never run at once. If
+	// the subnet cannot be set up again, the network remains tracked, so the
+	// repair can be retried and the network can still be removed.
+	clientNet.stop()
+
+	if err := c.startNetwork(clientNet.network, clientNet.subnet); err != nil {
+		return fmt.Errorf("failed to set up local subnet: %w", err)
+	}
+	return nil
+}
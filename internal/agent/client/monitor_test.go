@@ -0,0 +1,20 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/shoenig/test/must"
+
+	"github.com/rasorp/smuggle/internal/store/memory"
+)
+
+func TestClient_repairNetwork_unconfigured(t *testing.T) {
+	store := memory.New()
+	c := testClient(t, store)
+
+	// Events for networks which are not configured, such as those generated
+	// when a network is removed, should not trigger a repair.
+	must.NoError(t, c.repairNetwork("vxlan", true))
+	must.NoError(t, c.repairNetwork("vxlan", false))
+	must.Eq(t, 0, store.Calls(memory.OperationAll))
+}
@@ -0,0 +1,463 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/metrics"
+	"github.com/rasorp/smuggle/internal/store"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// clientNetwork tracks a network which has been configured on the host along
+// with the processes which manage it.
+type clientNetwork struct {
+	network *types.Network
+	subnet  *types.Subnet
+
+	// cancel stops the subnet watcher, heartbeat and reconciler of the
+	// network, and group tracks them, so they can be waited on to exit.
+	cancel context.CancelFunc
+	group  sync.WaitGroup
+}
+
+// stop cancels the processes of the network and waits for them to exit, so
+// none of them can write to the store or host once this returns. The processes
+// must not acquire the networks lock, as callers hold it while waiting.
+func (n *clientNetwork) stop() {
+	n.cancel()
+	n.group.Wait()
+}
+
+// runNetworkProcess runs the process of the network in a goroutine which is
+// tracked by both the shutdown group and the network. The process must mark
+// the shutdown group as done when it exits.
+func (c *Client) runNetworkProcess(clientNet *clientNetwork, process func()) {
+	c.shutdownGroup.Add(1)
+	clientNet.group.Add(1)
+
+	go func() {
+		defer clientNet.group.Done()
+		process()
+	}()
+}
+
+// startNetworkWatcher watches the store for network configuration changes, so
+// networks added while the client is running are configured on the host
+// without requiring a restart.
+func (c *Client) startNetworkWatcher() error {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	resp, err := c.store.WatchNetworks(&types.StoreWatchNetworksReq{Context: ctx})
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	c.shutdownGroup.Add(1)
+	go c.networkWatcherImpl(cancel, resp)
+
+	return nil
+}
+
+func (c *Client) networkWatcherImpl(cancel context.CancelFunc, resp *types.StoreWatchNetworksResp) {
+	defer c.shutdownGroup.Done()
+	defer cancel()
+
+	for {
+		select {
+		case err, ok := <-resp.ErrorCh:
+			if !ok {
+				return
+			}
+			metrics.WatcherErrors.WithLabelValues(metrics.WatcherNetworks).Inc()
+			c.logger.Error("error received from network watcher", zap.Error(err))
+		case networks, ok := <-resp.NetworksCh:
+			if !ok {
+				return
+			}
+			if err := c.syncNetworks(networks); err != nil {
+				c.logger.Error("failed to configure networks", zap.Error(err))
+			}
+		case <-c.shutdownCh:
+			c.logger.Info("shutting down network watcher")
+			return
+		}
+	}
+}
+
+// syncNetworks configures each of the passed networks which is not already
+// configured on the host, restarts each configured network whose configuration
+// has changed, and tears down each configured network which is no longer
+// passed. Networks whose placement does not match the local Nomad node are
+// treated as though they were not passed, so a network whose placement changes
+// to exclude the node is torn down. A failure to configure one network does
+// not stop the others from being configured; the failed network is retried
+// when the networks are next synced.
+func (c *Client) syncNetworks(networks []*types.Network) error {
+
+	c.networksLock.Lock()
+	defer c.networksLock.Unlock()
+
+	var (
+		errs    []error
+		changed bool
+	)
+
+	desired := make(map[string]struct{}, len(networks))
+	node := c.nodeAttributes()
+
+	for _, networkConfig := range networks {
+		if !networkConfig.Placement.Matches(node) {
+			c.logger.Debug("skipping network not placed on the local Nomad node",
+				networkConfig.LoggingPairs()...)
+			continue
+		}
+
+		desired[networkConfig.Name] = struct{}{}
+
+		if clientNet, ok := c.networks[networkConfig.Name]; ok {
+
+			// An invalid configuration cannot be configured, so the running
+			// network is kept rather than being torn down for it.
+			if err := networkConfig.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("invalid network %q: %w", networkConfig.Name, err))
+				continue
+			}
+
+			// The running configuration was canonicalized when the network
+			// was added, so the passed configuration must be too before they
+			// are compared.
+			networkConfig.Canonicalize()
+
+			if reflect.DeepEqual(clientNet.network, networkConfig) {
+				continue
+			}
+
+			if err := c.restartNetwork(networkConfig); err != nil {
+				errs = append(errs, fmt.Errorf("failed to restart network %q: %w", networkConfig.Name, err))
+			}
+			changed = true
+			continue
+		}
+
+		if err := c.addNetwork(networkConfig); err != nil {
+			errs = append(errs, fmt.Errorf("failed to add network %q: %w", networkConfig.Name, err))
+			continue
+		}
+		changed = true
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.networks)) {
+		if _, ok := desired[name]; ok {
+			continue
+		}
+
+		if err := c.removeNetwork(name); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove network %q: %w", name, err))
+		}
+		changed = true
+	}
+
+	// The isolation rules cover all networks, so they only need updating when
+	// the set of configured networks has changed.
+	if changed {
+		if err := c.networkManager.Firewall.EnsureIsolation(c.configuredNetworks()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to ensure network isolation: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// configuredNetworks returns the networks configured on the host, ordered by
+// name, so the resulting firewall rules are deterministic. The caller must
+// hold the networks lock.
+func (c *Client) configuredNetworks() []*types.Network {
+	networks := make([]*types.Network, 0, len(c.networks))
+	for _, name := range slices.Sorted(maps.Keys(c.networks)) {
+		networks = append(networks, c.networks[name].network)
+	}
+	return networks
+}
+
+// LocalRoutes returns the routing entries programmed on the host for each
+// configured network, ordered by network name. Networks whose provider does
+// not support listing its entries are omitted.
+func (c *Client) LocalRoutes() ([]*types.LocalRoutes, error) {
+
+	c.networksLock.Lock()
+	defer c.networksLock.Unlock()
+
+	routes := []*types.LocalRoutes{}
+
+	for _, name := range slices.Sorted(maps.Keys(c.networks)) {
+		clientNet := c.networks[name]
+
+		resp, err := c.networkManager.ListRoutes(&types.NetworkProviderListRoutesReq{
+			Network: clientNet.network,
+			Local:   clientNet.subnet,
+		})
+		if errors.Is(err, types.ErrNotSupported) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list routes of network %q: %w", name, err)
+		}
+
+		routes = append(routes, resp.Routes)
+	}
+
+	return routes, nil
+}
+
+// addNetwork configures the network on the host and starts the processes
+// which manage it. The caller must hold the networks lock.
+func (c *Client) addNetwork(networkConfig *types.Network) error {
+
+	// Validate the network configuration.
+	if err := networkConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid network: %w", err)
+	}
+
+	clientSubnetResp, err := c.store.GetSubnet(&types.StoreGetSubnetReq{
+		ID:          c.getID(),
+		NetworkName: networkConfig.Name,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get client subnet config: %w", err)
+	}
+
+	// Perform the canonicalization, so we have all fields set correctly
+	// set. It would be possible to write this back to the data store, but
+	// seeing as this happens on the client, if more than one started at the
+	// same time, they would all race to write it back.
+	networkConfig.Canonicalize()
+
+	subnet, err := c.ensureIPv4Subnet(networkConfig, clientSubnetResp.Subnet)
+	if err != nil {
+		return fmt.Errorf("failed to allocate IPv4 subnet: %w", err)
+	}
+
+	if err := c.ensureIPv6Subnet(networkConfig, subnet); err != nil {
+		return fmt.Errorf("failed to allocate IPv6 subnet: %w", err)
+	}
+
+	return c.startNetwork(networkConfig, subnet)
+}
+
+// startNetwork sets up the allocated subnet on the host, starts the processes
+// which manage it and tracks the network as configured. It is also used to
+// set up the subnet again when the monitor reports its link was deleted. The
+// caller must hold the networks lock.
+func (c *Client) startNetwork(networkConfig *types.Network, subnet *types.Subnet) error {
+
+	c.logger.Info("initializing local host subnet", networkConfig.LoggingPairs()...)
+
+	subnet, err := c.initSubnet(networkConfig, subnet)
+	if err != nil {
+		return fmt.Errorf("failed to initialize subnet: %w", err)
+	}
+
+	if networkConfig.IPMasq != nil && *networkConfig.IPMasq {
+		if err := c.networkManager.Firewall.SetupMasqRules(networkConfig, subnet); err != nil {
+			return fmt.Errorf("failed to set up firewall masquerade rules: %w", err)
+		}
+	}
+
+	if err := c.networkManager.Firewall.SetupForwardRules(networkConfig); err != nil {
+		return fmt.Errorf("failed to set up firewall forward rules: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	clientNet := &clientNetwork{
+		network: networkConfig,
+		subnet:  subnet,
+		cancel:  cancel,
+	}
+
+	if err := c.startSubnetUpdateHandler(ctx, clientNet); err != nil {
+		cancel()
+		return fmt.Errorf("failed to start remote subnet handler: %w", err)
+	}
+
+	c.runNetworkProcess(clientNet, func() { c.startSubnetHeartbeat(ctx, networkConfig, subnet) })
+
+	if c.cfg.ReconcileInterval > 0 {
+		c.runNetworkProcess(clientNet, func() { c.startSubnetReconciler(ctx, networkConfig, subnet) })
+	}
+
+	c.networks[networkConfig.Name] = clientNet
+
+	c.logger.Info("successfully initialized local host subnet", subnet.LoggingPairs()...)
+
+	return nil
+}
+
+// removeNetwork stops the processes which manage the network and tears down
+// everything which was configured on the host and in the store for it. Each
+// teardown step is attempted even if an earlier one fails, so as much as
+// possible is removed, and the network is no longer tracked as configured
+// once this returns. The caller must hold the networks lock.
+func (c *Client) removeNetwork(name string) error {
+
+	clientNet := c.networks[name]
+	delete(c.networks, name)
+
+	c.logger.Info("removing local host subnet", clientNet.network.LoggingPairs()...)
+
+	// Stop the subnet watcher and heartbeat first and wait for them to exit,
+	// so a renewal in progress cannot write the subnet back to the store once
+	// it has been deleted and its claims released.
+	clientNet.stop()
+	c.deleteLease(name)
+
+	errs := c.teardownLocal(clientNet)
+
+	if _, err := c.store.DeleteSubnet(&types.StoreDeleteSubnetReq{
+		ID:          c.getID(),
+		NetworkName: name,
+	}); err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete client subnet: %w", err))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	c.logger.Info("successfully removed local host subnet", clientNet.subnet.LoggingPairs()...)
+
+	return nil
+}
+
+// restartNetwork stops the processes which manage the network and tears it
+// down on the host, then configures it again using the passed configuration,
+// so changes to the network apply without restarting the client. Unlike
+// removing the network, the subnet is kept within the store and reused, as
+// when the client restarts, so allocations keep their addresses. The network
+// is added even if tearing it down fails, so it is not left unconfigured. The
+// caller must hold the networks lock.
+func (c *Client) restartNetwork(networkConfig *types.Network) error {
+
+	clientNet := c.networks[networkConfig.Name]
+	delete(c.networks, networkConfig.Name)
+
+	c.logger.Info("network configuration changed; restarting local host subnet",
+		networkConfig.LoggingPairs()...)
+
+	clientNet.stop()
+	c.deleteLease(networkConfig.Name)
+
+	errs := c.teardownLocal(clientNet)
+
+	if err := c.addNetwork(networkConfig); err != nil {
+		errs = append(errs, fmt.Errorf("failed to add network: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// reallocateNetwork removes the network and adds it again, so it is set up
+// with a newly allocated subnet. Removing the network deletes the subnet from
+// the store and releases the claims still held by this client. Networks which
+// are no longer configured, or have been reconfigured with a subnet other than
+// the passed one, are ignored.
+func (c *Client) reallocateNetwork(name string, subnet *types.Subnet) error {
+
+	c.networksLock.Lock()
+	defer c.networksLock.Unlock()
+
+	clientNet, ok := c.networks[name]
+	if !ok || clientNet.subnet != subnet {
+		return nil
+	}
+
+	if err := c.removeNetwork(name); err != nil {
+		return err
+	}
+
+	if err := c.addNetwork(clientNet.network); err != nil {
+
+		// The network is no longer configured, so its isolation rules are
+		// removed. It is configured again when the networks are next synced.
+		if err := c.networkManager.Firewall.EnsureIsolation(c.configuredNetworks()); err != nil {
+			c.logger.Error("failed to ensure network isolation", zap.Error(err))
+		}
+		return fmt.Errorf("failed to add network: %w", err)
+	}
+
+	return nil
+}
+
+// leaveNetworks marks the subnet of each configured network as expired within
+// the store and tears down the network on the host. Peers treat an expired
+// subnet as deleted, so they remove their routes to this host immediately
+// rather than once the subnet TTL has passed. The subnet watchers and
+// heartbeats must have been stopped, otherwise a heartbeat could overwrite the
+// expired subnet.
+func (c *Client) leaveNetworks() error {
+
+	c.networksLock.Lock()
+	defer c.networksLock.Unlock()
+
+	var errs []error
+
+	for _, name := range slices.Sorted(maps.Keys(c.networks)) {
+		clientNet := c.networks[name]
+		delete(c.networks, name)
+
+		c.logger.Info("leaving network", clientNet.subnet.LoggingPairs()...)
+
+		clientNet.stop()
+		c.deleteLease(name)
+
+		if err := store.ExpireSubnet(c.store, clientNet.subnet); err != nil {
+			errs = append(errs, fmt.Errorf("failed to leave network %q: %w", name, err))
+		}
+
+		for _, err := range c.teardownLocal(clientNet) {
+			errs = append(errs, fmt.Errorf("failed to leave network %q: %w", name, err))
+		}
+	}
+
+	// Passing no networks removes all the isolation rules.
+	if err := c.networkManager.Firewall.EnsureIsolation(nil); err != nil {
+		errs = append(errs, fmt.Errorf("failed to remove network isolation: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// teardownLocal removes the network interface, firewall rules and CNI
+// configuration which were configured on the host for the network. Each step
+// is attempted even if an earlier one fails and all errors are returned.
+func (c *Client) teardownLocal(clientNet *clientNetwork) []error {
+
+	var errs []error
+
+	if _, err := c.networkManager.DeleteLocal(&types.NetworkProviderDeleteLocalReq{
+		Network: clientNet.network,
+		Client:  clientNet.subnet,
+	}); err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete local subnet: %w", err))
+	}
+
+	if err := c.networkManager.Firewall.RemoveNetworkRules(clientNet.network, clientNet.subnet); err != nil {
+		errs = append(errs, fmt.Errorf("failed to remove firewall rules: %w", err))
+	}
+
+	if err := c.cniStore.Delete(clientNet.network.Name); err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete CNI config: %w", err))
+	}
+
+	return errs
+}
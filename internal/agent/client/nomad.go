@@ -0,0 +1,189 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/sethvargo/go-retry"
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+const (
+	// nomadDiscoveryTimeout is how long the client retries discovering the
+	// local Nomad node when starting, which covers the Nomad agent starting
+	// alongside the client.
+	nomadDiscoveryTimeout = 30 * time.Second
+
+	// nomadDiscoveryInitialBackoff is the delay before the first retry of a
+	// failed Nomad node discovery. Each subsequent failure doubles the delay.
+	nomadDiscoveryInitialBackoff = 1 * time.Second
+
+	// nomadDiscoveryMaxBackoff is the maximum delay between retries of a
+	// failed Nomad node discovery.
+	nomadDiscoveryMaxBackoff = 1 * time.Minute
+)
+
+// discoverNomadNode reads the ID of the Nomad node this client runs on from
+// the local Nomad agent, then reads the node itself, so its ID, name and
+// datacenter can be recorded on the client subnets. This allows the server to
+// expire the subnets when Nomad reports the node as down or purged, and
+// operators to map subnets to nodes. Clients created without a Nomad API
+// client skip discovery.
+func (c *Client) discoverNomadNode() error {
+
+	if c.nomad == nil {
+		return nil
+	}
+
+	self, err := c.nomad.Agent().Self()
+	if err != nil {
+		return fmt.Errorf("failed to query Nomad agent: %w", err)
+	}
+
+	// The client stats are only reported by agents running in client mode,
+	// which is required for the node to run allocations using Smuggle.
+	nodeID := self.Stats["client"]["node_id"]
+	if nodeID == "" {
+		return errors.New("local Nomad agent is not running in client mode")
+	}
+
+	node, _, err := c.nomad.Nodes().Info(nodeID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to read Nomad node %q: %w", nodeID, err)
+	}
+
+	c.networksLock.Lock()
+	c.nomadNode = node
+	c.networksLock.Unlock()
+
+	return nil
+}
+
+// nomadDiscoveryBackoff returns the backoff used between failed attempts to
+// discover the local Nomad node.
+func nomadDiscoveryBackoff() retry.Backoff {
+	return retry.WithJitterPercent(heartbeatJitterPercent,
+		retry.WithCappedDuration(nomadDiscoveryMaxBackoff,
+			retry.NewExponential(nomadDiscoveryInitialBackoff)))
+}
+
+// nomadNodeRequired indicates whether the client depends on the local Nomad
+// node to start correctly, either because it uses the node ID as its identity
+// or because a network places itself or sizes its subnets using the node
+// attributes. Failing to list the networks is not treated as requiring the
+// node, as configuring the networks fails for the same reason.
+func (c *Client) nomadNodeRequired() bool {
+
+	if c.cfg.UseNomadNodeID {
+		return true
+	}
+
+	resp, err := c.store.ListNetworks(nil)
+	if err != nil {
+		return false
+	}
+
+	return slices.ContainsFunc(resp.Networks, func(network *types.Network) bool {
+		return !network.Placement.IsEmpty() || network.IPv4 != nil && network.IPv4.MixedSizes()
+	})
+}
+
+// waitForNomadNode discovers the local Nomad node, retrying failures until the
+// discovery timeout of the client passes or the client is shut down. The error
+// of the last attempt is returned.
+func (c *Client) waitForNomadNode() error {
+
+	deadline := time.Now().Add(c.nomadDiscoveryTimeout)
+	backoff := nomadDiscoveryBackoff()
+
+	for {
+		err := c.discoverNomadNode()
+		if err == nil {
+			return nil
+		}
+
+		retryIn, _ := backoff.Next()
+		if time.Now().Add(retryIn).After(deadline) {
+			return err
+		}
+
+		c.logger.Warn("failed to discover Nomad node; retrying",
+			zap.String("retry_in", retryIn.String()), zap.Error(err))
+
+		select {
+		case <-time.After(retryIn):
+		case <-c.shutdownCh:
+			return err
+		}
+	}
+}
+
+// startNomadDiscovery retries discovering the local Nomad node in the
+// background when it could not be discovered during start. Once discovered,
+// the networks are synced again, so networks whose placement matches the node
+// are configured. Subnets already written are annotated with the node when
+// they are next reallocated or the client restarts.
+func (c *Client) startNomadDiscovery() {
+
+	c.networksLock.Lock()
+	discovered := c.nomadNode != nil
+	c.networksLock.Unlock()
+
+	if c.nomad == nil || discovered {
+		return
+	}
+
+	c.shutdownGroup.Add(1)
+	go c.nomadDiscoveryImpl()
+}
+
+func (c *Client) nomadDiscoveryImpl() {
+	defer c.shutdownGroup.Done()
+
+	backoff := nomadDiscoveryBackoff()
+
+	for {
+		retryIn, _ := backoff.Next()
+
+		select {
+		case <-time.After(retryIn):
+		case <-c.shutdownCh:
+			return
+		}
+
+		if err := c.discoverNomadNode(); err != nil {
+			c.logger.Debug("failed to discover Nomad node; retrying", zap.Error(err))
+			continue
+		}
+
+		c.logger.Info("discovered Nomad node; syncing networks")
+
+		if err := c.Init(); err != nil {
+			c.logger.Error("failed to configure networks", zap.Error(err))
+		}
+		return
+	}
+}
+
+// nodeAttributes returns the attributes of the local Nomad node which network
+// placements are evaluated against, or nil if the node was not discovered. The
+// attributes are read when the node is discovered, so changes to the node, such
+// as its metadata, require the client to be restarted. The caller must hold the
+// networks lock.
+func (c *Client) nodeAttributes() *types.NodeAttributes {
+
+	if c.nomadNode == nil {
+		return nil
+	}
+
+	return &types.NodeAttributes{
+		Datacenter: c.nomadNode.Datacenter,
+		NodePool:   c.nomadNode.NodePool,
+		NodeClass:  c.nomadNode.NodeClass,
+		Meta:       c.nomadNode.Meta,
+	}
+}
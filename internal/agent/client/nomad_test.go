@@ -0,0 +1,194 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/shoenig/test/must"
+	"github.com/shoenig/test/wait"
+
+	"github.com/rasorp/smuggle/internal/store/memory"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+const testNomadNodeID = "4d6b3c4a-9a8e-4f0c-8d2b-1f7e6a5c3b21"
+
+// testNomadAgent starts a fake Nomad agent which responds to the agent self
+// request with the response currently stored in self and reports the node
+// testNomadNodeID. It returns a Nomad API client pointing at the agent.
+func testNomadAgent(t *testing.T, self *atomic.Value) *api.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/agent/self":
+			_, _ = w.Write([]byte(self.Load().(string)))
+		case "/v1/node/" + testNomadNodeID:
+			_, _ = w.Write([]byte(`{"ID":"` + testNomadNodeID + `","Name":"node-1","Datacenter":"dc1"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	nomadClient, err := api.NewClient(&api.Config{Address: srv.URL})
+	must.NoError(t, err)
+
+	return nomadClient
+}
+
+func TestClient_discoverNomadNode(t *testing.T) {
+
+	// Clients without a Nomad API client should skip discovery.
+	c := testClient(t, memory.New())
+	must.NoError(t, c.discoverNomadNode())
+	must.Nil(t, c.nomadNode)
+
+	var response atomic.Value
+	c.nomad = testNomadAgent(t, &response)
+
+	// Agents which are not running in client mode do not report a node ID.
+	response.Store(`{"stats":{"nomad":{"leader":"true"}}}`)
+	must.ErrorContains(t, c.discoverNomadNode(), "not running in client mode")
+
+	// Nodes which cannot be read should fail discovery.
+	response.Store(`{"stats":{"client":{"node_id":"unknown"}}}`)
+	must.ErrorContains(t, c.discoverNomadNode(), `failed to read Nomad node "unknown"`)
+	must.Nil(t, c.nomadNode)
+
+	response.Store(`{"stats":{"client":{"node_id":"` + testNomadNodeID + `"}}}`)
+	must.NoError(t, c.discoverNomadNode())
+	must.Eq(t, testNomadNodeID, c.nomadNode.ID)
+	must.Eq(t, "node-1", c.nomadNode.Name)
+	must.Eq(t, "dc1", c.nomadNode.Datacenter)
+}
+
+func TestClient_setupID(t *testing.T) {
+
+	t.Run("generated", func(t *testing.T) {
+		c := testClient(t, memory.New())
+		c.id.Store("")
+
+		// Failing to discover the node should not stop the ID being generated.
+		var response atomic.Value
+		response.Store(`{"stats":{}}`)
+		c.nomad = testNomadAgent(t, &response)
+
+		must.NoError(t, c.setupID())
+		must.NotEq(t, "", c.getID())
+		must.Nil(t, c.nomadNode)
+	})
+
+	t.Run("not required", func(t *testing.T) {
+		c := testClient(t, memory.New())
+		c.id.Store("")
+
+		// When the node is not required, discovery is attempted once, so the
+		// client does not wait for the discovery timeout.
+		c.nomadDiscoveryTimeout = time.Minute
+
+		var response atomic.Value
+		response.Store(`{"stats":{}}`)
+		c.nomad = testNomadAgent(t, &response)
+
+		start := time.Now()
+		must.NoError(t, c.setupID())
+		must.Less(t, 10*time.Second, time.Since(start))
+		must.NotEq(t, "", c.getID())
+	})
+
+	t.Run("Nomad node", func(t *testing.T) {
+		c := testClient(t, memory.New())
+		c.id.Store("")
+		c.cfg.UseNomadNodeID = true
+
+		// Without a Nomad API client, the node cannot be discovered.
+		must.ErrorContains(t, c.setupID(), "requires a Nomad client")
+
+		var response atomic.Value
+		response.Store(`{"stats":{}}`)
+		c.nomad = testNomadAgent(t, &response)
+		must.ErrorContains(t, c.setupID(), "failed to discover Nomad node")
+
+		response.Store(`{"stats":{"client":{"node_id":"` + testNomadNodeID + `"}}}`)
+		must.NoError(t, c.setupID())
+		must.Eq(t, testNomadNodeID, c.getID())
+	})
+}
+
+func TestClient_nomadNodeRequired(t *testing.T) {
+
+	store := memory.New()
+	c := testClient(t, store)
+	must.False(t, c.nomadNodeRequired())
+
+	c.cfg.UseNomadNodeID = true
+	must.True(t, c.nomadNodeRequired())
+	c.cfg.UseNomadNodeID = false
+
+	// Networks without placement or size classes do not depend on the node.
+	var networkConfig types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`,
+	), &networkConfig))
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &networkConfig})
+	must.NoError(t, err)
+	must.False(t, c.nomadNodeRequired())
+
+	var placedConfig types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"placed","ipv4":{"network":"10.11.0.0/16","size":24},"provider":{"name":"vxlan"},`+
+			`"placement":{"datacenters":["dc1"]}}`,
+	), &placedConfig))
+	_, err = store.SetNetwork(&types.StoreSetNetworkReq{Network: &placedConfig})
+	must.NoError(t, err)
+	must.True(t, c.nomadNodeRequired())
+}
+
+func TestClient_startNomadDiscovery(t *testing.T) {
+
+	store := memory.New()
+	c := testNetworkClient(t, store)
+
+	// The network is only placed on nodes within dc1, so it cannot be
+	// configured until the node is discovered.
+	var networkConfig types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"},`+
+			`"placement":{"datacenters":["dc1"]}}`,
+	), &networkConfig))
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &networkConfig})
+	must.NoError(t, err)
+
+	var response atomic.Value
+	response.Store(`{"stats":{}}`)
+	c.nomad = testNomadAgent(t, &response)
+
+	must.NoError(t, c.Start())
+
+	c.networksLock.Lock()
+	must.MapEmpty(t, c.networks)
+	c.networksLock.Unlock()
+
+	// Once the Nomad agent reports the node, the background discovery should
+	// configure the network without the client being restarted.
+	response.Store(`{"stats":{"client":{"node_id":"` + testNomadNodeID + `"}}}`)
+
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool {
+			c.networksLock.Lock()
+			defer c.networksLock.Unlock()
+			return len(c.networks) == 1
+		}),
+		wait.Timeout(5*time.Second),
+		wait.Gap(50*time.Millisecond),
+	))
+
+	must.Eq(t, testNomadNodeID, testGetSubnet(t, c).NomadNodeID)
+	must.NoError(t, c.Stop())
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// startSubnetReconciler periodically reconciles the routing configuration to
+// remote subnets on the host with the subnets within the store, until the
+// context is cancelled or the client is shut down. This repairs entries which
+// have been removed from the host and changes which failed to be applied when
+// received from the subnet watcher. The caller must add to the shutdown wait
+// group before starting the reconciler.
+func (c *Client) startSubnetReconciler(ctx context.Context, network *types.Network, subnet *types.Subnet) {
+	defer c.shutdownGroup.Done()
+
+	ticker := time.NewTicker(c.cfg.ReconcileInterval)
+	defer ticker.Stop()
+
+	c.logger.Info("starting subnet reconciler",
+		append(subnet.LoggingPairs(), zap.String("interval", c.cfg.ReconcileInterval.String()))...,
+	)
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.reconcileSubnets(network, subnet); err != nil {
+				c.logger.Error("failed to reconcile remote subnets",
+					zap.String("network", network.Name),
+					zap.Error(err),
+				)
+			}
+		case <-ctx.Done():
+			c.logger.Info("stopping subnet reconciler", zap.String("network", network.Name))
+			return
+		case <-c.shutdownCh:
+			c.logger.Info("shutting down subnet reconciler", zap.String("network", network.Name))
+			return
+		}
+	}
+}
+
+// reconcileSubnets performs a single reconciliation of the routing
+// configuration to the remote subnets of the network.
+func (c *Client) reconcileSubnets(network *types.Network, subnet *types.Subnet) error {
+
+	listResp, err := c.store.ListSubnets(&types.StoreListSubnetsReq{Network: network.Name})
+	if err != nil {
+		return fmt.Errorf("failed to list subnets: %w", err)
+	}
+
+	resp, err := c.networkManager.Reconcile(&types.NetworkProviderReconcileReq{
+		Network: network,
+		Local:   subnet,
+		Remotes: c.remoteSubnets(listResp.Subnets),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Any repair or removal indicates the host had drifted from the store, so
+	// log at info level to make this visible to operators.
+	if resp.Repaired > 0 || resp.Removed > 0 {
+		c.logger.Info("reconciled remote subnets",
+			zap.String("network", network.Name),
+			zap.Int("repaired", resp.Repaired),
+			zap.Int("removed", resp.Removed),
+		)
+	} else {
+		c.logger.Debug("remote subnets in sync", zap.String("network", network.Name))
+	}
+
+	return nil
+}
+
+// remoteSubnets filters the passed subnets to those which should be routable
+// from this client. The local subnet is excluded, as are expired subnets,
+// which the subnet watcher treats as deleted.
+func (c *Client) remoteSubnets(subnets []*types.Subnet) []*types.Subnet {
+	var remotes []*types.Subnet
+
+	for _, subnet := range subnets {
+		if subnet.ClientID == c.getID() || subnet.Expired {
+			continue
+		}
+		remotes = append(remotes, subnet)
+	}
+
+	return remotes
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/shoenig/test/must"
+
+	"github.com/rasorp/smuggle/internal/store/memory"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func TestClient_remoteSubnets(t *testing.T) {
+	c := testClient(t, memory.New())
+
+	subnets := []*types.Subnet{
+		{ClientID: "client-1", NetworkName: "vxlan"},
+		{ClientID: "client-2", NetworkName: "vxlan"},
+		{ClientID: "client-3", NetworkName: "vxlan", Expired: true},
+	}
+
+	// Only the unexpired subnets of other clients should be returned.
+	remotes := c.remoteSubnets(subnets)
+	must.Len(t, 1, remotes)
+	must.Eq(t, "client-2", remotes[0].ClientID)
+}
+
+func TestClient_reconcileSubnets_storeError(t *testing.T) {
+	store := memory.New()
+	store.FailNext(memory.OperationListSubnets, 1, nil)
+
+	c := testClient(t, store)
+
+	// A store failure should be returned before the host networking is
+	// inspected.
+	err := c.reconcileSubnets(&types.Network{Name: "vxlan"}, &types.Subnet{ClientID: "client-1"})
+	must.ErrorIs(t, err, memory.ErrInjected)
+}
@@ -2,44 +2,70 @@ package client
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/rasorp/smuggle/internal/metrics"
 	"github.com/rasorp/smuggle/internal/types"
 )
 
-func (c *Client) startSubnetUpdateHandler() error {
+// startSubnetUpdateHandler watches the subnets of the network, so remote
+// subnet networking is configured as other clients join and leave, and the
+// local subnet is restored if it is expired while the client is running. The
+// watch runs until the context is cancelled or the client is shut down.
+func (c *Client) startSubnetUpdateHandler(ctx context.Context, clientNet *clientNetwork) error {
 
-	for _, network := range c.networks {
-		c.logger.Debug("starting subnet watcher for network", zap.String("network_name", network.Name))
+	c.logger.Debug("starting subnet watcher for network", zap.String("network_name", clientNet.network.Name))
 
-		req := &types.StoreWatchSubnetsReq{
-			Context:     context.Background(),
-			NetworkName: network.Name,
-		}
-
-		resp, err := c.store.WatchSubnets(req)
-		if err != nil {
-			return err
-		}
+	req := &types.StoreWatchSubnetsReq{
+		Context:     ctx,
+		NetworkName: clientNet.network.Name,
+	}
 
-		go c.subnetUpdateHandlerImpl(resp)
+	resp, err := c.store.WatchSubnets(req)
+	if err != nil {
+		return err
 	}
+
+	c.runNetworkProcess(clientNet, func() {
+		c.subnetUpdateHandlerImpl(ctx, clientNet.network, clientNet.subnet, resp)
+	})
+
 	return nil
 }
 
-func (c *Client) subnetUpdateHandlerImpl(req *types.StoreWatchSubnetsResp) {
-	c.shutdownGroup.Add(1)
+func (c *Client) subnetUpdateHandlerImpl(
+	ctx context.Context,
+	network *types.Network,
+	local *types.Subnet,
+	req *types.StoreWatchSubnetsResp,
+) {
 	defer c.shutdownGroup.Done()
 
 	for {
 		select {
-		case err := <-req.ErrorCh:
+		case err, ok := <-req.ErrorCh:
+			if !ok {
+				return
+			}
+			metrics.WatcherErrors.WithLabelValues(metrics.WatcherSubnets).Inc()
 			c.logger.Error("error received from subnet watcher", zap.Error(err))
-		case set := <-req.ModifyCh:
+		case set, ok := <-req.ModifyCh:
+			if !ok {
+				return
+			}
 			c.handleSubnetSet(set)
-		case del := <-req.DeleteCh:
-			c.handleSubnetDelete(del)
+		case del, ok := <-req.DeleteCh:
+			if !ok {
+				return
+			}
+			c.handleSubnetDelete(network, local, del)
+		case <-ctx.Done():
+			return
 		case <-c.shutdownCh:
 			c.logger.Info("shutting down subnet update handler")
 			return
@@ -47,17 +73,34 @@ func (c *Client) subnetUpdateHandlerImpl(req *types.StoreWatchSubnetsResp) {
 	}
 }
 
-func (c *Client) handleSubnetDelete(subnets []*types.Subnet) {
+func (c *Client) handleSubnetDelete(network *types.Network, local *types.Subnet, subnets []*types.Subnet) {
 	for _, subnet := range subnets {
 
-		// If the agent has got an update about itself being expired, the
-		// cluster stability is likely compromised. As the addition is not
-		// hanled here, we simply skip the deletion attempt as it won't because
-		// we don't add local subnets this way.
+		// The local subnet is expired while the client is running when the
+		// server expires the subnets of its Nomad node, such as during a long
+		// Nomad agent restart, or an operator expires it. Peers have removed
+		// their routes to this host and the server releases the claims once
+		// the reaper threshold passes, so the subnet is restored immediately
+		// rather than on the next heartbeat.
 		if subnet.ClientID == c.getID() {
-			c.logger.Warn("received subnet deletion for local client; skipping",
-				subnet.LoggingPairs()...,
-			)
+			c.logger.Warn("local subnet was expired; restoring", subnet.LoggingPairs()...)
+
+			expiration, err := c.renewSubnet(network, local)
+			c.updateLease(network.Name, expiration, err)
+
+			switch {
+			case errors.Is(err, types.ErrSubnetConflict):
+				c.logger.Error("local subnet CIDR claimed by another client; reallocating subnet",
+					append(subnet.LoggingPairs(), zap.Error(err))...,
+				)
+				c.startReallocation(network, local)
+			case err != nil:
+				c.logger.Error("failed to restore local subnet",
+					append(subnet.LoggingPairs(), zap.Error(err))...,
+				)
+			default:
+				c.logger.Info("successfully restored local subnet", subnet.LoggingPairs()...)
+			}
 			continue
 		}
 
@@ -96,3 +139,86 @@ func (c *Client) handleSubnetSet(subnets []*types.Subnet) {
 		}
 	}
 }
+
+// renewSubnet claims the CIDRs of the local subnet and writes it to the store
+// with a renewed lease, which also marks it as live if it was expired. The
+// claims are made before the write unless they are still held, as they are
+// released if the server deletes the subnet, such as after a partition, and
+// writing the subnet without them would leave its CIDRs unprotected. Claiming
+// is idempotent for the owning client. If another client has since claimed a
+// CIDR, an error wrapping ErrSubnetConflict is returned and the subnet is not
+// written. The renewed expiration is returned.
+func (c *Client) renewSubnet(network *types.Network, local *types.Subnet) (time.Time, error) {
+
+	if !c.claimsHeld(local) {
+		if local.IPv4Network != nil {
+			if err := c.claimIPv4Subnet(network, local.IPv4Network); err != nil {
+				return time.Time{}, fmt.Errorf("failed to claim IPv4 subnet: %w", err)
+			}
+		}
+		if local.IPv6Network != nil {
+			if err := c.claimSubnet(network, local.IPv6Network.String()); err != nil {
+				return time.Time{}, fmt.Errorf("failed to claim IPv6 subnet: %w", err)
+			}
+		}
+	}
+
+	// Renew a copy of the subnet, so the original is not modified and the
+	// renewed expiration is what gets written to the store.
+	renewed := local.Copy()
+	renewed.Expired = false
+	renewed.Expiration = time.Now().Add(network.LeaseTTL())
+
+	if _, err := c.store.SetSubnet(&types.StoreSetSubnetReq{Subnet: renewed}); err != nil {
+		return time.Time{}, err
+	}
+
+	return renewed.Expiration, nil
+}
+
+// claimsHeld indicates whether the claims of the local subnet are still held
+// by this client. The claims are only released when the subnet is deleted, and
+// the server only deletes subnets once they have expired, so the claims are
+// held while the stored subnet is live and has the same CIDRs. This saves
+// claiming each unit of the subnet again on every renewal, which for networks
+// with size classes can be hundreds of claims. Failing to read the subnet is
+// treated as the claims not being held, so they are made again.
+func (c *Client) claimsHeld(local *types.Subnet) bool {
+
+	resp, err := c.store.GetSubnet(&types.StoreGetSubnetReq{
+		ID:          c.getID(),
+		NetworkName: local.NetworkName,
+	})
+	if err != nil || resp.Subnet == nil || resp.Subnet.Expired {
+		return false
+	}
+
+	return reflect.DeepEqual(resp.Subnet.IPv4Network, local.IPv4Network) &&
+		reflect.DeepEqual(resp.Subnet.IPv6Network, local.IPv6Network)
+}
+
+// startReallocation tears down the network and configures it again with a
+// newly allocated subnet, once the CIDRs of the local subnet have been claimed
+// by another client. This runs asynchronously, as it stops the processes of
+// the network, which include the caller. If the network has already been
+// reconfigured with a different subnet, nothing is done.
+func (c *Client) startReallocation(network *types.Network, local *types.Subnet) {
+
+	c.shutdownGroup.Add(1)
+
+	go func() {
+		defer c.shutdownGroup.Done()
+
+		select {
+		case <-c.shutdownCh:
+			return
+		default:
+		}
+
+		if err := c.reallocateNetwork(network.Name, local); err != nil {
+			c.logger.Error("failed to reallocate subnet",
+				append(local.LoggingPairs(), zap.Error(err))...,
+			)
+		}
+	}()
+}
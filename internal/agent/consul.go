@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/config"
+	"github.com/rasorp/smuggle/internal/helper/retry"
+)
+
+// setupConsulClient initializes and returns a Consul API client based on the
+// agent's store configuration. It also performs an initial connectivity check
+// to ensure the client can communicate with the Consul agent. This check will
+// be retried until successful or a timeout occurs, so the function may block
+// for a short period.
+func (a *Agent) setupConsulClient() (*api.Client, error) {
+
+	consulClient, err := config.ConsulClient(a.cfg.Store.Consul)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Consul client: %w", err)
+	}
+
+	// Perform an initial API connectivity check. The leader endpoint does not
+	// require ACL authentication and is a lighweight call, so it is the best
+	// choice for this.
+	return consulClient, retry.Retry(
+		func() error {
+			_, err := consulClient.Status().Leader()
+			if err != nil {
+				a.logger.Warn("failed to ping the Consul API", zap.Error(err))
+			}
+			return err
+		},
+	)
+}
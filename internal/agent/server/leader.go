@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"go.uber.org/zap"
+)
+
+// leaderReleaseTimeout is the maximum time spent releasing the leader lock
+// after stepping down, so shutdown is not blocked by an unreachable Nomad API.
+const leaderReleaseTimeout = 5 * time.Second
+
+// IsLeader indicates whether the server is currently the leader and is running
+// the reapers. When leader election is disabled, the server is always the
+// leader.
+func (s *Server) IsLeader() bool { return s.leader.Load() }
+
+// runLeaderProcesses runs the processes which must only run on a single server
+// within the cluster, as they would otherwise race on writes to the store. It
+// blocks until the processes stop once the context is cancelled.
+func (s *Server) runLeaderProcesses(ctx context.Context) {
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.startNetworkReaper(ctx)
+	}()
+
+	if s.cfg.Reaper.NodeEventsEnabled() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.startNodeReaper(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// leaderElectionImpl competes for the leader lock until shutdown. Whenever the
+// lock is acquired, the server leads until the lock is lost or the server shuts
+// down, after which it competes for the lock again.
+func (s *Server) leaderElectionImpl() {
+	defer s.shutdownGroup.Done()
+
+	ctx, cancel := s.shutdownContext()
+	defer cancel()
+
+	// Attempting to acquire the lock at half the TTL means a follower takes
+	// over shortly after the lock of a failed leader expires.
+	interval := s.leaderLock.LockTTL() / 2
+
+	for {
+		_, err := s.leaderLock.Acquire(ctx)
+		switch {
+		case err == nil:
+			s.lead(ctx)
+		case errors.Is(err, api.ErrLockConflict), ctx.Err() != nil:
+		default:
+			s.logger.Error("failed to acquire leader lock", zap.Error(err))
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			s.logger.Info("shutting down leader election")
+			return
+		}
+	}
+}
+
+// lead runs the leader processes while renewing the leader lock. It returns
+// once the lock cannot be renewed or the context is cancelled, having stopped
+// the leader processes and released the lock.
+func (s *Server) lead(ctx context.Context) {
+
+	s.logger.Info("acquired leadership")
+	s.leader.Store(true)
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	doneCh := make(chan struct{})
+
+	go func() {
+		defer close(doneCh)
+		s.runLeaderProcesses(leaderCtx)
+	}()
+
+	ttl := s.leaderLock.LockTTL()
+
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+
+	for leading := true; leading; {
+		select {
+		case <-ticker.C:
+			// The renewal is bounded, so the leader processes are stopped by
+			// the time the lock expires and a follower could take over.
+			renewCtx, renewCancel := context.WithTimeout(ctx, ttl/2)
+			err := s.leaderLock.Renew(renewCtx)
+			renewCancel()
+
+			if err != nil {
+				if ctx.Err() == nil {
+					s.logger.Error("failed to renew leader lock", zap.Error(err))
+				}
+				leading = false
+			}
+		case <-ctx.Done():
+			leading = false
+		}
+	}
+
+	// The leader processes are stopped before the lock is released, so they
+	// never run on more than one server at once.
+	cancel()
+	<-doneCh
+	s.leader.Store(false)
+
+	// Releasing the lock allows a follower to take over without waiting for
+	// the TTL to pass. If the lock has already been lost, this fails with a
+	// conflict which can be ignored.
+	releaseCtx, releaseCancel := context.WithTimeout(context.Background(), leaderReleaseTimeout)
+	defer releaseCancel()
+
+	if err := s.leaderLock.Release(releaseCtx); err != nil && !errors.Is(err, api.ErrLockConflict) {
+		s.logger.Warn("failed to release leader lock", zap.Error(err))
+	}
+
+	s.logger.Info("lost leadership")
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/shoenig/test/must"
+	"github.com/shoenig/test/wait"
+
+	"github.com/rasorp/smuggle/internal/store/memory"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// testLocker is an api.Locker whose lock is either free or held by another
+// server, as set by the test.
+type testLocker struct {
+	lock sync.Mutex
+
+	// heldElsewhere causes lock operations to conflict, as though another
+	// server holds the lock.
+	heldElsewhere bool
+	held          bool
+	releases      int
+}
+
+func (l *testLocker) Acquire(_ context.Context) (string, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.heldElsewhere {
+		return "", api.ErrLockConflict
+	}
+	l.held = true
+	return "smuggle/leader", nil
+}
+
+func (l *testLocker) Release(_ context.Context) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.releases++
+	if !l.held {
+		return api.ErrLockConflict
+	}
+	l.held = false
+	return nil
+}
+
+func (l *testLocker) Renew(_ context.Context) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.held || l.heldElsewhere {
+		l.held = false
+		return api.ErrLockConflict
+	}
+	return nil
+}
+
+func (l *testLocker) LockTTL() time.Duration { return 100 * time.Millisecond }
+
+func (l *testLocker) setHeldElsewhere(held bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.heldElsewhere = held
+}
+
+func (l *testLocker) getReleases() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.releases
+}
+
+func TestServer_leaderElection(t *testing.T) {
+	store := memory.New()
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{Name: "vxlan"}})
+	must.NoError(t, err)
+
+	locker := &testLocker{heldElsewhere: true}
+
+	srv := testServer(t, store)
+	srv.leaderLock = locker
+	must.NoError(t, srv.Start())
+
+	// While another server holds the lock, the reaper must not run.
+	time.Sleep(200 * time.Millisecond)
+	must.False(t, srv.IsLeader())
+	must.Eq(t, 0, store.Calls(memory.OperationListNetworks))
+
+	// Once the lock is free, the server should take over and run the reaper.
+	locker.setHeldElsewhere(false)
+
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool { return srv.IsLeader() && store.Calls(memory.OperationListNetworks) > 0 }),
+		wait.Timeout(5*time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+
+	// Losing the lock should stop the reaper, so the server steps down.
+	locker.setHeldElsewhere(true)
+
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool { return !srv.IsLeader() && locker.getReleases() > 0 }),
+		wait.Timeout(5*time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+
+	// The server should lead again once the lock is free, and release the
+	// lock on shutdown so a follower can take over.
+	locker.setHeldElsewhere(false)
+
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(srv.IsLeader),
+		wait.Timeout(5*time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+
+	releases := locker.getReleases()
+
+	must.NoError(t, srv.Stop())
+	must.False(t, srv.IsLeader())
+	must.Eq(t, releases+1, locker.getReleases())
+	must.False(t, locker.held)
+}
+
+func TestServer_leaderElectionDisabled(t *testing.T) {
+	srv := testServer(t, memory.New())
+	must.False(t, srv.IsLeader())
+
+	// Without leader election, the server always leads.
+	must.NoError(t, srv.Start())
+	must.True(t, srv.IsLeader())
+	must.NoError(t, srv.Stop())
+}
@@ -0,0 +1,325 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/metrics"
+	"github.com/rasorp/smuggle/internal/store"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+const (
+	// nodeEventRetryInterval is the interval between attempts to subscribe to
+	// the Nomad event stream after the subscription fails.
+	nodeEventRetryInterval = 10 * time.Second
+
+	// nodeExpireRetryInterval is the interval after which expiring the subnets
+	// of a node is retried, when the store fails.
+	nodeExpireRetryInterval = 30 * time.Second
+
+	// nodeDeregistrationEventType is the Nomad event type emitted when a node
+	// is purged or garbage collected.
+	nodeDeregistrationEventType = "NodeDeregistration"
+)
+
+// nodeEvent describes a change to the state of a Nomad node, as reported by
+// the Nomad event stream.
+type nodeEvent struct {
+	NodeID string
+
+	// Down indicates the node is down or has been purged, so the subnets of its
+	// clients should be expired once the grace period passes. Otherwise, the
+	// node is alive and any pending expiry is cancelled.
+	Down bool
+
+	// Reason describes the state of the node for logging.
+	Reason string
+}
+
+// startNodeReaper subscribes to the Nomad node events, so the subnets of
+// clients whose node is down or purged are expired once the grace period
+// passes, rather than once their lease expires. It blocks until the context is
+// cancelled, which happens on shutdown or when the server loses leadership.
+func (s *Server) startNodeReaper(ctx context.Context) {
+
+	eventCh := make(chan *nodeEvent)
+	eventsDoneCh := make(chan struct{})
+
+	go func() {
+		defer close(eventsDoneCh)
+		s.nodeEventsImpl(ctx, eventCh)
+	}()
+
+	s.nodeReaperImpl(ctx, eventCh)
+	<-eventsDoneCh
+}
+
+// nodeEventsImpl sends the Nomad node events on the channel until the context
+// is cancelled. The nodes which are already down are sent whenever the event
+// stream is subscribed to, as their state does not produce further events and
+// events may have been missed while the stream was failing.
+func (s *Server) nodeEventsImpl(ctx context.Context, eventCh chan<- *nodeEvent) {
+
+	var (
+		index uint64
+		err   error
+	)
+
+	for {
+		if err := s.sendDownNodes(ctx, eventCh); err != nil && ctx.Err() == nil {
+			s.logger.Error("failed to list Nomad nodes", zap.Error(err))
+		}
+
+		index, err = s.streamNodeEvents(ctx, index, eventCh)
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger.Error("Nomad node event stream failed", zap.Error(err))
+
+		select {
+		case <-time.After(nodeEventRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendDownNodes sends an event for each Nomad node which is currently down.
+func (s *Server) sendDownNodes(ctx context.Context, eventCh chan<- *nodeEvent) error {
+
+	nodes, _, err := s.nomad.Nodes().List((&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if node.Status != api.NodeStatusDown {
+			continue
+		}
+
+		select {
+		case eventCh <- &nodeEvent{NodeID: node.ID, Down: true, Reason: "node status down"}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// streamNodeEvents subscribes to the Nomad node events starting after the
+// passed index and sends each event on the channel. It returns once the stream
+// fails, along with the index of the last event received, so the stream can be
+// resumed.
+func (s *Server) streamNodeEvents(ctx context.Context, index uint64, eventCh chan<- *nodeEvent) (uint64, error) {
+
+	topics := map[api.Topic][]string{api.TopicNode: {"*"}}
+
+	stream, err := s.nomad.EventStream().Stream(ctx, topics, index, nil)
+	if err != nil {
+		return index, fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	for events := range stream {
+		if events.Err != nil {
+			return index, events.Err
+		}
+		if events.IsHeartbeat() {
+			continue
+		}
+
+		for _, event := range events.Events {
+			ev, err := parseNodeEvent(&event)
+			if err != nil {
+				s.logger.Warn("failed to parse Nomad node event",
+					zap.String("type", event.Type), zap.Error(err))
+				continue
+			}
+			if ev == nil {
+				continue
+			}
+
+			select {
+			case eventCh <- ev:
+			case <-ctx.Done():
+				return index, ctx.Err()
+			}
+		}
+
+		index = events.Index
+	}
+
+	return index, errors.New("event stream closed")
+}
+
+// parseNodeEvent converts the Nomad event into a node event. A nil event is
+// returned if the Nomad event does not include a node.
+func parseNodeEvent(event *api.Event) (*nodeEvent, error) {
+
+	if event.Type == nodeDeregistrationEventType {
+		return &nodeEvent{NodeID: event.Key, Down: true, Reason: "node purged"}, nil
+	}
+
+	node, err := event.Node()
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, nil
+	}
+
+	return &nodeEvent{
+		NodeID: node.ID,
+		Down:   node.Status == api.NodeStatusDown,
+		Reason: "node status " + node.Status,
+	}, nil
+}
+
+// nodeExpiry tracks a Nomad node which is down, until its subnets are expired.
+type nodeExpiry struct {
+
+	// Down is when the server learned the node was down. Subnets renewed since
+	// are not expired, as their client is still running.
+	Down time.Time
+
+	// Deadline is when the subnets of the node are expired.
+	Deadline time.Time
+}
+
+// nodeReaperImpl tracks the Nomad nodes which are down and expires the subnets
+// of their clients once the grace period passes. Nodes which come back before
+// the grace period passes are no longer tracked, so brief outages do not
+// remove the routes to the node. Pending expiries are dropped when the context
+// is cancelled, as the next leader learns of the down nodes when it subscribes.
+func (s *Server) nodeReaperImpl(ctx context.Context, eventCh <-chan *nodeEvent) {
+
+	// pending holds the down nodes whose subnets are yet to be expired, keyed
+	// by the node ID.
+	pending := make(map[string]*nodeExpiry)
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("stopping node reaper")
+			return
+		case ev := <-eventCh:
+			s.handleNodeEvent(pending, ev)
+		case <-timer.C:
+			s.expireDownNodes(pending)
+		}
+
+		// Reset the timer to fire at the earliest pending expiry.
+		timer.Stop()
+
+		var next time.Time
+		for _, expiry := range pending {
+			if next.IsZero() || expiry.Deadline.Before(next) {
+				next = expiry.Deadline
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+func (s *Server) handleNodeEvent(pending map[string]*nodeExpiry, ev *nodeEvent) {
+
+	_, ok := pending[ev.NodeID]
+
+	switch {
+	case ev.Down && !ok:
+		now := time.Now()
+		pending[ev.NodeID] = &nodeExpiry{Down: now, Deadline: now.Add(s.cfg.Reaper.NodeGracePeriod)}
+		s.logger.Info("Nomad node is down; expiring its subnets after grace period",
+			zap.String("nomad_node_id", ev.NodeID),
+			zap.String("reason", ev.Reason),
+			zap.Duration("grace_period", s.cfg.Reaper.NodeGracePeriod),
+		)
+	case !ev.Down && ok:
+		delete(pending, ev.NodeID)
+		s.logger.Info("Nomad node recovered; cancelled expiring its subnets",
+			zap.String("nomad_node_id", ev.NodeID),
+			zap.String("reason", ev.Reason),
+		)
+	}
+}
+
+// expireDownNodes expires the subnets of each pending node whose grace period
+// has passed. Nodes whose subnets fail to be expired are retried later.
+func (s *Server) expireDownNodes(pending map[string]*nodeExpiry) {
+
+	now := time.Now()
+
+	for nodeID, expiry := range pending {
+		if expiry.Deadline.After(now) {
+			continue
+		}
+
+		if err := s.expireNodeSubnets(nodeID, expiry.Down); err != nil {
+			s.logger.Error("failed to expire subnets of down Nomad node",
+				zap.String("nomad_node_id", nodeID), zap.Error(err))
+			expiry.Deadline = now.Add(nodeExpireRetryInterval)
+			continue
+		}
+
+		delete(pending, nodeID)
+	}
+}
+
+// expireNodeSubnets marks the subnets of all clients running on the Nomad
+// node as expired. The expiration is set to now, so the network reaper deletes
+// the subnets once the reaper threshold has passed. Subnets whose lease was
+// renewed after the node went down are skipped, as their client is still
+// running, such as when the Nomad agent alone is restarting.
+func (s *Server) expireNodeSubnets(nodeID string, down time.Time) error {
+
+	networks, err := s.store.ListNetworks(&types.StoreGetNetworksReq{})
+	if err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	var errs []error
+
+	for _, network := range networks.Networks {
+		subnetsResp, err := s.store.ListSubnets(&types.StoreListSubnetsReq{Network: network.Name})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list subnets of network %q: %w", network.Name, err))
+			continue
+		}
+
+		for _, subnet := range subnetsResp.Subnets {
+			if subnet.NomadNodeID != nodeID || subnet.Expired {
+				continue
+			}
+
+			// The lease expiration is set to the TTL after each renewal, so
+			// the time of the last renewal can be derived from it.
+			if renewed := subnet.Expiration.Add(-network.LeaseTTL()); renewed.After(down) {
+				s.logger.Info("skipping expiring subnet renewed since Nomad node went down",
+					append(subnet.LoggingPairs(), zap.Time("renewed", renewed))...)
+				continue
+			}
+
+			if err := store.ExpireSubnet(s.store, subnet); err != nil {
+				errs = append(errs, fmt.Errorf("failed to expire subnet of client %q: %w", subnet.ClientID, err))
+				continue
+			}
+
+			metrics.ReaperExpired.WithLabelValues(subnet.NetworkName).Inc()
+			s.logger.Info("successfully marked subnet of down Nomad node as expired", subnet.LoggingPairs()...)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/shoenig/test/must"
+	"github.com/shoenig/test/wait"
+
+	"github.com/rasorp/smuggle/internal/store/memory"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func TestServer_nodeReaper(t *testing.T) {
+	store := memory.New()
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{Name: "vxlan"}})
+	must.NoError(t, err)
+
+	for id, nodeID := range map[string]string{
+		"client-1": "node-1",
+		"client-2": "node-2",
+		"client-3": "",
+	} {
+		_, err := store.SetSubnet(&types.StoreSetSubnetReq{
+			Subnet: &types.Subnet{
+				ClientID:    id,
+				NetworkName: "vxlan",
+				NomadNodeID: nodeID,
+				Expiration:  time.Now().Add(time.Hour),
+			},
+		})
+		must.NoError(t, err)
+	}
+
+	srv := testServer(t, store)
+	srv.cfg.Reaper.NodeGracePeriod = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	eventCh := make(chan *nodeEvent)
+	doneCh := make(chan struct{})
+
+	go func() {
+		defer close(doneCh)
+		srv.nodeReaperImpl(ctx, eventCh)
+	}()
+
+	// The second node recovers within the grace period, so its subnet should
+	// not be expired.
+	eventCh <- &nodeEvent{NodeID: "node-1", Down: true}
+	eventCh <- &nodeEvent{NodeID: "node-2", Down: true}
+	eventCh <- &nodeEvent{NodeID: "node-2", Down: false}
+
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool { return testGetSubnet(t, store, "client-1").Expired }),
+		wait.Timeout(5*time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+
+	expired := testGetSubnet(t, store, "client-1")
+	must.True(t, expired.Expiration.Before(time.Now()))
+
+	must.False(t, testGetSubnet(t, store, "client-2").Expired)
+	must.False(t, testGetSubnet(t, store, "client-3").Expired)
+
+	cancel()
+	<-doneCh
+}
+
+func TestServer_expireNodeSubnets_renewed(t *testing.T) {
+	store := memory.New()
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{
+		Name:  "vxlan",
+		Lease: &types.LeaseConfig{TTL: types.Duration(time.Hour)},
+	}})
+	must.NoError(t, err)
+
+	down := time.Now().Add(-time.Minute)
+
+	// The first client last renewed before the node went down, while the
+	// second renewed since, so is still running.
+	for id, renewed := range map[string]time.Time{
+		"client-1": down.Add(-time.Minute),
+		"client-2": down.Add(time.Second),
+	} {
+		_, err := store.SetSubnet(&types.StoreSetSubnetReq{
+			Subnet: &types.Subnet{
+				ClientID:    id,
+				NetworkName: "vxlan",
+				NomadNodeID: "node-1",
+				Expiration:  renewed.Add(time.Hour),
+			},
+		})
+		must.NoError(t, err)
+	}
+
+	srv := testServer(t, store)
+	must.NoError(t, srv.expireNodeSubnets("node-1", down))
+
+	must.True(t, testGetSubnet(t, store, "client-1").Expired)
+	must.False(t, testGetSubnet(t, store, "client-2").Expired)
+}
+
+func TestServer_expireNodeSubnets_storeFailure(t *testing.T) {
+	store := memory.New()
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{Name: "vxlan"}})
+	must.NoError(t, err)
+
+	_, err = store.SetSubnet(&types.StoreSetSubnetReq{
+		Subnet: &types.Subnet{ClientID: "client-1", NetworkName: "vxlan", NomadNodeID: "node-1"},
+	})
+	must.NoError(t, err)
+
+	srv := testServer(t, store)
+
+	// A failure should keep the node pending, so expiring is retried.
+	store.FailNext(memory.OperationSetSubnet, 1, nil)
+
+	pending := map[string]*nodeExpiry{"node-1": {Down: time.Now(), Deadline: time.Now()}}
+	srv.expireDownNodes(pending)
+	must.MapContainsKey(t, pending, "node-1")
+	must.False(t, testGetSubnet(t, store, "client-1").Expired)
+
+	pending["node-1"].Deadline = time.Now()
+	srv.expireDownNodes(pending)
+	must.MapEmpty(t, pending)
+	must.True(t, testGetSubnet(t, store, "client-1").Expired)
+}
+
+func TestParseNodeEvent(t *testing.T) {
+	testCases := []struct {
+		name     string
+		event    *api.Event
+		expected *nodeEvent
+	}{
+		{
+			name: "node down",
+			event: &api.Event{
+				Topic:   api.TopicNode,
+				Type:    "NodeRegistration",
+				Key:     "node-1",
+				Payload: map[string]any{"Node": map[string]any{"ID": "node-1", "Status": "down"}},
+			},
+			expected: &nodeEvent{NodeID: "node-1", Down: true, Reason: "node status down"},
+		},
+		{
+			name: "node ready",
+			event: &api.Event{
+				Topic:   api.TopicNode,
+				Type:    "NodeRegistration",
+				Key:     "node-1",
+				Payload: map[string]any{"Node": map[string]any{"ID": "node-1", "Status": "ready"}},
+			},
+			expected: &nodeEvent{NodeID: "node-1", Down: false, Reason: "node status ready"},
+		},
+		{
+			name: "node purged",
+			event: &api.Event{
+				Topic: api.TopicNode,
+				Type:  nodeDeregistrationEventType,
+				Key:   "node-1",
+			},
+			expected: &nodeEvent{NodeID: "node-1", Down: true, Reason: "node purged"},
+		},
+		{
+			name: "no node",
+			event: &api.Event{
+				Topic: api.TopicNode,
+				Type:  "NodeEvent",
+			},
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ev, err := parseNodeEvent(tc.event)
+			must.NoError(t, err)
+			must.Eq(t, tc.expected, ev)
+		})
+	}
+}
@@ -1,16 +1,18 @@
 package server
 
 import (
+	"context"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/rasorp/smuggle/internal/metrics"
 	"github.com/rasorp/smuggle/internal/types"
 )
 
-func (s *Server) startNetworkReaper() {
-	s.shutdownGroup.Add(1)
-	defer s.shutdownGroup.Done()
+// startNetworkReaper runs the network reaper until the context is cancelled,
+// which happens on shutdown or when the server loses leadership.
+func (s *Server) startNetworkReaper(ctx context.Context) {
 
 	// Perform an initial run of the reaper on startup, so we don't have to wait
 	// for the first interval to elapse.
@@ -19,14 +21,15 @@ func (s *Server) startNetworkReaper() {
 	ticker := time.NewTicker(s.cfg.Reaper.Interval)
 	defer ticker.Stop()
 
-	// Run the reaper at the configured interval until shutdown is signaled.
+	// Run the reaper at the configured interval until the context is
+	// cancelled.
 	// Errors are logged within the reaper run and are not terminal to the
 	// server process. This means transient errors will be retried on the next
 	// interval.
 	for {
 		select {
-		case <-s.shutdownCh:
-			s.logger.Info("shutting down network reaper")
+		case <-ctx.Done():
+			s.logger.Info("stopping network reaper")
 			return
 		case <-ticker.C:
 			s.networkReaper()
@@ -36,6 +39,7 @@ func (s *Server) startNetworkReaper() {
 }
 
 func (s *Server) networkReaper() {
+	metrics.ReaperRuns.Inc()
 
 	networks, err := s.store.ListNetworks(&types.StoreGetNetworksReq{})
 	if err != nil {
@@ -67,6 +71,8 @@ func (s *Server) runSubnetReap(net *types.Network) {
 		zap.Int("num", len(subnetsResp.Subnets)),
 	)
 
+	s.recordSubnetUsage(net, subnetsResp.Subnets)
+
 	now := time.Now()
 
 	for _, subnet := range subnetsResp.Subnets {
@@ -100,6 +106,7 @@ func (s *Server) handleSubnetExpired(subnet *types.Subnet) {
 			append(subnet.LoggingPairs(), zap.Error(err))...,
 		)
 	} else {
+		metrics.ReaperDeleted.WithLabelValues(subnet.NetworkName).Inc()
 		s.logger.Info("successfully deleted expired subnet", subnet.LoggingPairs()...)
 	}
 }
@@ -118,6 +125,34 @@ func (s *Server) handleSubnetExpiration(subnet *types.Subnet) {
 			append(subnet.LoggingPairs(), zap.Error(err))...,
 		)
 	} else {
+		metrics.ReaperExpired.WithLabelValues(subnet.NetworkName).Inc()
 		s.logger.Info("successfully marked subnet as expired", subnet.LoggingPairs()...)
 	}
 }
+
+// recordSubnetUsage updates the allocated and free subnet metrics of the
+// network. Expired subnets are counted as allocated, as their CIDR remains
+// claimed until the subnet is deleted. Free subnets are counted in the default
+// subnet size, so networks with size classes report the default sized subnets
+// which do not overlap any allocation.
+func (s *Server) recordSubnetUsage(net *types.Network, subnets []*types.Subnet) {
+	if net.IPv4 == nil || net.IPv4.Network == nil {
+		return
+	}
+
+	net.Canonicalize()
+
+	var allocated []*types.IPv4Net
+
+	for _, subnet := range subnets {
+		if subnet.IPv4Network != nil {
+			allocated = append(allocated, subnet.IPv4Network)
+		}
+	}
+
+	total := net.IPv4.TotalSubnets()
+	used := net.IPv4.UsedSubnets(allocated)
+
+	metrics.SubnetsAllocated.WithLabelValues(net.Name).Set(float64(len(allocated)))
+	metrics.SubnetsFree.WithLabelValues(net.Name).Set(float64(total - min(used, total)))
+}
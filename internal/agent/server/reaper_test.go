@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shoenig/test/must"
+
+	"github.com/rasorp/smuggle/internal/metrics"
+	"github.com/rasorp/smuggle/internal/store/memory"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func testSetSubnet(t *testing.T, store types.Store, id string, expiration time.Time, expired bool) {
+	t.Helper()
+
+	_, err := store.SetSubnet(&types.StoreSetSubnetReq{
+		Subnet: &types.Subnet{
+			ClientID:    id,
+			NetworkName: "vxlan",
+			Expiration:  expiration,
+			Expired:     expired,
+		},
+	})
+	must.NoError(t, err)
+}
+
+func testGetSubnet(t *testing.T, store types.Store, id string) *types.Subnet {
+	t.Helper()
+
+	resp, err := store.GetSubnet(&types.StoreGetSubnetReq{ID: id, NetworkName: "vxlan"})
+	must.NoError(t, err)
+	return resp.Subnet
+}
+
+func TestServer_networkReaper(t *testing.T) {
+	store := memory.New()
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{Name: "vxlan"}})
+	must.NoError(t, err)
+
+	now := time.Now()
+
+	testSetSubnet(t, store, "active", now.Add(time.Hour), false)
+	testSetSubnet(t, store, "expiring", now.Add(-time.Minute), false)
+	testSetSubnet(t, store, "expired-recent", now.Add(-time.Minute), true)
+	testSetSubnet(t, store, "expired-old", now.Add(-time.Hour), true)
+
+	srv := testServer(t, store)
+	srv.networkReaper()
+
+	// Subnets which have not reached their expiration should not be modified.
+	active := testGetSubnet(t, store, "active")
+	must.NotNil(t, active)
+	must.False(t, active.Expired)
+
+	// Subnets which have passed their expiration should be marked as expired.
+	expiring := testGetSubnet(t, store, "expiring")
+	must.NotNil(t, expiring)
+	must.True(t, expiring.Expired)
+
+	// Expired subnets should only be deleted once the threshold has passed.
+	must.NotNil(t, testGetSubnet(t, store, "expired-recent"))
+	must.Nil(t, testGetSubnet(t, store, "expired-old"))
+}
+
+func TestServer_networkReaper_storeFailures(t *testing.T) {
+	store := memory.New()
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{Name: "vxlan"}})
+	must.NoError(t, err)
+
+	testSetSubnet(t, store, "expiring", time.Now().Add(-time.Minute), false)
+
+	srv := testServer(t, store)
+
+	// A failure to list networks should stop the run, without attempting to
+	// list the subnets of any network.
+	store.FailNext(memory.OperationListNetworks, 1, nil)
+	srv.networkReaper()
+	must.Eq(t, 0, store.Calls(memory.OperationListSubnets))
+
+	// A failure to mark the subnet as expired should leave the subnet
+	// unmodified, so it is retried on the next run.
+	store.FailNext(memory.OperationSetSubnet, 1, nil)
+	srv.networkReaper()
+
+	expiring := testGetSubnet(t, store, "expiring")
+	must.NotNil(t, expiring)
+	must.False(t, expiring.Expired)
+
+	srv.networkReaper()
+
+	expiring = testGetSubnet(t, store, "expiring")
+	must.NotNil(t, expiring)
+	must.True(t, expiring.Expired)
+}
+
+func TestServer_networkReaper_subnetUsage(t *testing.T) {
+	store := memory.New()
+
+	var network types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"usage","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`,
+	), &network))
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &network})
+	must.NoError(t, err)
+
+	for _, id := range []string{"client-1", "client-2"} {
+		var subnet types.Subnet
+		must.NoError(t, json.Unmarshal([]byte(
+			`{"client_id":"`+id+`","network_name":"usage","ipv4_network":"10.10.1.0/24"}`,
+		), &subnet))
+		subnet.Expiration = time.Now().Add(time.Hour)
+
+		_, err := store.SetSubnet(&types.StoreSetSubnetReq{Subnet: &subnet})
+		must.NoError(t, err)
+	}
+
+	srv := testServer(t, store)
+	srv.networkReaper()
+
+	// The first and last subnets of the network are not allocatable, which
+	// leaves 254 subnets.
+	must.Eq(t, 2, testutil.ToFloat64(metrics.SubnetsAllocated.WithLabelValues("usage")))
+	must.Eq(t, 252, testutil.ToFloat64(metrics.SubnetsFree.WithLabelValues("usage")))
+}
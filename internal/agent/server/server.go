@@ -1,10 +1,15 @@
 package server
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/hashicorp/nomad/api"
+
 	"github.com/rasorp/smuggle/internal/config"
 	"github.com/rasorp/smuggle/internal/log"
 	"github.com/rasorp/smuggle/internal/types"
@@ -17,6 +22,17 @@ type Server struct {
 	// store
 	store types.Store
 
+	// nomad is the Nomad API client used to subscribe to node events and to
+	// elect the leader. It is only required when the node event reaper or
+	// leader election is enabled.
+	nomad *api.Client
+
+	// leaderLock is the Nomad variable lock which the servers compete for. It
+	// is nil when leader election is disabled, in which case the server always
+	// leads.
+	leaderLock api.Locker
+	leader     atomic.Bool
+
 	// shtutdownCh is used to signal to all server processes that the agent is
 	// shutting down. All long-running processes should monitor this channel and
 	// use the shutdownGroup wait group to ensure the agent does not exit before
@@ -29,20 +45,62 @@ type ServerReq struct {
 	Config *config.ServerConfig
 	Logger *log.Logger
 	Store  types.Store
+	Nomad  *api.Client
 }
 
 func New(req *ServerReq) (*Server, error) {
-	return &Server{
+
+	s := &Server{
 		cfg:        req.Config,
 		logger:     req.Logger.Named(log.ComponentNameServer),
 		store:      req.Store,
+		nomad:      req.Nomad,
 		shutdownCh: make(chan struct{}),
-	}, nil
+	}
+
+	if req.Config.Leader.IsEnabled() {
+		if req.Nomad == nil {
+			return nil, errors.New("leader election requires a Nomad client")
+		}
+
+		lock, err := req.Nomad.Locks(api.WriteOptions{}, api.Variable{
+			Path: req.Config.Leader.Path,
+			Lock: &api.VariableLock{TTL: req.Config.Leader.TTL.String()},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create leader lock: %w", err)
+		}
+		s.leaderLock = lock
+	}
+
+	return s, nil
 }
 
 func (s *Server) Start() error {
 	s.logger.Info("starting server")
-	go s.startNetworkReaper()
+
+	if s.cfg.Reaper.NodeEventsEnabled() && s.nomad == nil {
+		return errors.New("node event reaper requires a Nomad client")
+	}
+
+	s.shutdownGroup.Add(1)
+
+	if s.leaderLock != nil {
+		go s.leaderElectionImpl()
+		return nil
+	}
+
+	s.leader.Store(true)
+
+	go func() {
+		defer s.shutdownGroup.Done()
+
+		ctx, cancel := s.shutdownContext()
+		defer cancel()
+
+		s.runLeaderProcesses(ctx)
+	}()
+
 	return nil
 }
 
@@ -74,3 +132,20 @@ func (s *Server) Stop() error {
 	}
 	return nil
 }
+
+// shutdownContext returns a context which is cancelled when the server shuts
+// down, for processes which are stopped by context cancellation.
+func (s *Server) shutdownContext() (context.Context, context.CancelFunc) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		select {
+		case <-s.shutdownCh:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	return ctx, cancel
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shoenig/test/must"
+	"github.com/shoenig/test/wait"
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/config"
+	"github.com/rasorp/smuggle/internal/store/memory"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func testServer(t *testing.T, store types.Store) *Server {
+	t.Helper()
+
+	srv, err := New(&ServerReq{
+		Config: &config.ServerConfig{
+			Reaper: &config.ReaperConfig{
+				Interval:  time.Hour,
+				Threshold: 5 * time.Minute,
+			},
+		},
+		Logger: zap.NewNop(),
+		Store:  store,
+	})
+	must.NoError(t, err)
+	return srv
+}
+
+func TestServer_StartStop(t *testing.T) {
+	store := memory.New()
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{Name: "vxlan"}})
+	must.NoError(t, err)
+
+	srv := testServer(t, store)
+	must.NoError(t, srv.Start())
+
+	// The reaper performs an initial run on start, which lists the networks.
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool { return store.Calls(memory.OperationListNetworks) > 0 }),
+		wait.Timeout(5*time.Second),
+		wait.Gap(10*time.Millisecond),
+	))
+
+	must.NoError(t, srv.Stop())
+}
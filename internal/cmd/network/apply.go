@@ -0,0 +1,54 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/rasorp/smuggle/internal/store"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+const applyFileFlag = "file"
+
+func applyCommand() *cli.Command {
+	return &cli.Command{
+		Name:     "apply",
+		Category: "network",
+		Usage:    "Creates or updates a network from a configuration file",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  applyFileFlag,
+				Usage: "The path to the JSON network configuration file",
+				Value: networkInitFilename,
+			},
+		}, store.CommandFlags()...),
+		Action: func(_ context.Context, cmd *cli.Command) error {
+
+			data, err := os.ReadFile(cmd.String(applyFileFlag))
+			if err != nil {
+				return fmt.Errorf("failed to read file: %w", err)
+			}
+
+			var network types.Network
+			if err := json.Unmarshal(data, &network); err != nil {
+				return fmt.Errorf("failed to parse network: %w", err)
+			}
+
+			s, err := store.FromCommand(cmd)
+			if err != nil {
+				return err
+			}
+
+			if err := store.ApplyNetwork(s, &network); err != nil {
+				return err
+			}
+
+			_, _ = fmt.Fprintf(cmd.Writer, "successfully applied network %s\n", network.Name)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,49 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/rasorp/smuggle/internal/store"
+)
+
+func deleteCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "delete",
+		Category:  "network",
+		Usage:     "Deletes a network from the store",
+		ArgsUsage: "<name>",
+		Flags:     store.CommandFlags(),
+		Action: func(_ context.Context, cmd *cli.Command) error {
+
+			if cmd.NArg() != 1 {
+				return errors.New("expected a single network name argument")
+			}
+
+			s, err := store.FromCommand(cmd)
+			if err != nil {
+				return err
+			}
+
+			// Check the network exists, so a mistyped name is reported rather
+			// than silently succeeding.
+			network, err := getNetwork(s, cmd.Args().First())
+			if err != nil {
+				return err
+			}
+
+			// Running clients remove the network from their host once they
+			// observe the deletion, while the subnets left by clients which
+			// are down are deleted along with the network.
+			if err := store.DeleteNetwork(s, network.Name); err != nil {
+				return err
+			}
+
+			_, _ = fmt.Fprintf(cmd.Writer, "successfully deleted network %s\n", network.Name)
+			return nil
+		},
+	}
+}
@@ -49,8 +49,7 @@ const (
 
 	// networkInitContent is the content of the example network configuration
 	// file created by the init command. This is a basic VXLAN network that can
-	// be modified and written to Nomad via:
-	// nomad var put smuggle/networks/v1/vxlan data="$(cat smuggle-net.json)".
+	// be modified and written to the store via: smuggle network apply.
 	networkInitContent = `
 {
   "name": "vxlan",
@@ -0,0 +1,68 @@
+package network
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+
+	clihelp "github.com/rasorp/smuggle/internal/helper/cli"
+	"github.com/rasorp/smuggle/internal/store"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func listCommand() *cli.Command {
+	return &cli.Command{
+		Name:     "list",
+		Category: "network",
+		Usage:    "Lists the networks within the store",
+		Flags:    store.CommandFlags(),
+		Action: func(_ context.Context, cmd *cli.Command) error {
+
+			s, err := store.FromCommand(cmd)
+			if err != nil {
+				return err
+			}
+
+			resp, err := s.ListNetworks(&types.StoreGetNetworksReq{})
+			if err != nil {
+				return err
+			}
+
+			if len(resp.Networks) == 0 {
+				_, _ = fmt.Fprintln(cmd.Writer, "no networks found")
+				return nil
+			}
+
+			rows := []string{"Name|Provider|IPv4 Network|IPv4 Size|IPv6 Network|IPv6 Size"}
+
+			for _, network := range resp.Networks {
+				rows = append(rows, formatNetworkRow(network))
+			}
+
+			_, _ = fmt.Fprintln(cmd.Writer, clihelp.FormatList(rows))
+			return nil
+		},
+	}
+}
+
+// formatNetworkRow returns the table row of the network. Networks are not
+// validated when read, so missing blocks are displayed as empty columns.
+func formatNetworkRow(network *types.Network) string {
+
+	var provider, ipv4Net, ipv4Size, ipv6Net, ipv6Size string
+
+	if network.Provider != nil {
+		provider = network.Provider.Name
+	}
+	if network.IPv4 != nil && network.IPv4.Network != nil {
+		ipv4Net = network.IPv4.Network.String()
+		ipv4Size = fmt.Sprintf("/%d", network.IPv4.Size)
+	}
+	if network.IPv6 != nil && network.IPv6.Network != nil {
+		ipv6Net = network.IPv6.Network.String()
+		ipv6Size = fmt.Sprintf("/%d", network.IPv6.Size)
+	}
+
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s", network.Name, provider, ipv4Net, ipv4Size, ipv6Net, ipv6Size)
+}
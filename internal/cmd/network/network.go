@@ -5,10 +5,14 @@ import "github.com/urfave/cli/v3"
 func Command() *cli.Command {
 	return &cli.Command{
 		Name:      "network",
-		Usage:     "Initialize and read Smuggle network configurations",
+		Usage:     "Initialize, apply and read Smuggle network configurations",
 		UsageText: "smuggle network <command> [options] [args]",
 		Commands: []*cli.Command{
 			initCommand(),
+			applyCommand(),
+			listCommand(),
+			showCommand(),
+			deleteCommand(),
 		},
 	}
 }
@@ -0,0 +1,67 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/rasorp/smuggle/internal/store"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func showCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "show",
+		Category:  "network",
+		Usage:     "Outputs the configuration of a network",
+		ArgsUsage: "<name>",
+		Flags:     store.CommandFlags(),
+		Action: func(_ context.Context, cmd *cli.Command) error {
+
+			if cmd.NArg() != 1 {
+				return errors.New("expected a single network name argument")
+			}
+
+			s, err := store.FromCommand(cmd)
+			if err != nil {
+				return err
+			}
+
+			network, err := getNetwork(s, cmd.Args().First())
+			if err != nil {
+				return err
+			}
+
+			// Output the network as JSON, so it can be modified and passed to
+			// the apply command.
+			out, err := json.MarshalIndent(network, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to marshal network: %w", err)
+			}
+
+			_, _ = fmt.Fprintln(cmd.Writer, string(out))
+			return nil
+		},
+	}
+}
+
+// getNetwork returns the named network from the store, or an error if it does
+// not exist.
+func getNetwork(s types.Store, name string) (*types.Network, error) {
+
+	resp, err := s.ListNetworks(&types.StoreGetNetworksReq{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, network := range resp.Networks {
+		if network.Name == name {
+			return network, nil
+		}
+	}
+
+	return nil, fmt.Errorf("network %q not found", name)
+}
@@ -0,0 +1,52 @@
+package subnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/rasorp/smuggle/internal/store"
+)
+
+func expireCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "expire",
+		Category:  "subnet",
+		Usage:     "Marks the subnets held by a client as expired",
+		ArgsUsage: "<client-id>",
+		Flags:     append([]cli.Flag{networkCommandFlag()}, store.CommandFlags()...),
+		Action: func(_ context.Context, cmd *cli.Command) error {
+
+			if cmd.NArg() != 1 {
+				return errors.New("expected a single client ID argument")
+			}
+			clientID := cmd.Args().First()
+
+			s, err := store.FromCommand(cmd)
+			if err != nil {
+				return err
+			}
+
+			subnets, err := store.ClientSubnets(s, clientID, cmd.String(networkFlag))
+			if err != nil {
+				return err
+			}
+
+			if len(subnets) == 0 {
+				return fmt.Errorf("no subnets found for client %q", clientID)
+			}
+
+			for _, subnet := range subnets {
+				if err := store.ExpireSubnet(s, subnet); err != nil {
+					return fmt.Errorf("failed to expire subnet within network %q: %w", subnet.NetworkName, err)
+				}
+				_, _ = fmt.Fprintf(cmd.Writer, "successfully expired subnet %s within network %s\n",
+					subnet.IPv4Network, subnet.NetworkName)
+			}
+
+			return nil
+		},
+	}
+}
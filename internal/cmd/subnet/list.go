@@ -0,0 +1,53 @@
+package subnet
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+
+	clihelp "github.com/rasorp/smuggle/internal/helper/cli"
+	"github.com/rasorp/smuggle/internal/store"
+)
+
+func listCommand() *cli.Command {
+	return &cli.Command{
+		Name:     "list",
+		Category: "subnet",
+		Usage:    "Lists the client subnets within the store",
+		Flags:    append([]cli.Flag{networkCommandFlag()}, store.CommandFlags()...),
+		Action: func(_ context.Context, cmd *cli.Command) error {
+
+			s, err := store.FromCommand(cmd)
+			if err != nil {
+				return err
+			}
+
+			subnets, err := store.ListSubnets(s, cmd.String(networkFlag))
+			if err != nil {
+				return err
+			}
+
+			if len(subnets) == 0 {
+				_, _ = fmt.Fprintln(cmd.Writer, "no subnets found")
+				return nil
+			}
+
+			rows := []string{"Client ID|Network|CIDR|Host IP|Expiration|Expired"}
+
+			for _, subnet := range subnets {
+				rows = append(rows, fmt.Sprintf("%s|%s|%s|%s|%s|%t",
+					subnet.ClientID,
+					subnet.NetworkName,
+					subnet.IPv4Network,
+					formatHostIP(subnet),
+					formatExpiration(subnet),
+					subnet.Expired,
+				))
+			}
+
+			_, _ = fmt.Fprintln(cmd.Writer, clihelp.FormatList(rows))
+			return nil
+		},
+	}
+}
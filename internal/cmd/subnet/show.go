@@ -0,0 +1,76 @@
+package subnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/urfave/cli/v3"
+
+	clihelp "github.com/rasorp/smuggle/internal/helper/cli"
+	"github.com/rasorp/smuggle/internal/store"
+)
+
+func showCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "show",
+		Category:  "subnet",
+		Usage:     "Outputs the subnets held by a client",
+		ArgsUsage: "<client-id>",
+		Flags:     append([]cli.Flag{networkCommandFlag()}, store.CommandFlags()...),
+		Action: func(_ context.Context, cmd *cli.Command) error {
+
+			if cmd.NArg() != 1 {
+				return errors.New("expected a single client ID argument")
+			}
+			clientID := cmd.Args().First()
+
+			s, err := store.FromCommand(cmd)
+			if err != nil {
+				return err
+			}
+
+			subnets, err := store.ClientSubnets(s, clientID, cmd.String(networkFlag))
+			if err != nil {
+				return err
+			}
+
+			if len(subnets) == 0 {
+				return fmt.Errorf("no subnets found for client %q", clientID)
+			}
+
+			for i, subnet := range subnets {
+				if i > 0 {
+					_, _ = fmt.Fprintln(cmd.Writer)
+				}
+
+				var ipv6Network, hostIPv6 string
+				if subnet.IPv6Network != nil {
+					ipv6Network = subnet.IPv6Network.String()
+				}
+				if subnet.HostIPv6 != nil {
+					hostIPv6 = subnet.HostIPv6.String()
+				}
+
+				_, _ = fmt.Fprintln(cmd.Writer, clihelp.FormatKV([]string{
+					"Client ID|" + subnet.ClientID,
+					"Network|" + subnet.NetworkName,
+					"Provider|" + subnet.Provider,
+					"Nomad Node ID|" + subnet.NomadNodeID,
+					"Nomad Node Name|" + subnet.NomadNodeName,
+					"Nomad Datacenter|" + subnet.NomadDatacenter,
+					"IPv4 Network|" + subnet.IPv4Network.String(),
+					"IPv6 Network|" + ipv6Network,
+					"Host IPv4|" + formatHostIP(subnet),
+					"Host IPv6|" + hostIPv6,
+					"MTU|" + strconv.Itoa(subnet.MTU),
+					"Expiration|" + formatExpiration(subnet),
+					"Expired|" + strconv.FormatBool(subnet.Expired),
+				}))
+			}
+
+			return nil
+		},
+	}
+}
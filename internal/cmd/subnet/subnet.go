@@ -0,0 +1,60 @@
+package subnet
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// networkFlag is the flag used by the subnet commands to limit the subnets to
+// those within a single network.
+const networkFlag = "network"
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "subnet",
+		Usage:     "Inspect and expire Smuggle client subnets",
+		UsageText: "smuggle subnet <command> [options] [args]",
+		Commands: []*cli.Command{
+			listCommand(),
+			showCommand(),
+			expireCommand(),
+		},
+	}
+}
+
+// networkCommandFlag returns the flag used to limit the subnets to those
+// within a single network.
+func networkCommandFlag() cli.Flag {
+	return &cli.StringFlag{
+		HideDefault: true,
+		Name:        networkFlag,
+		Usage:       "Only include subnets within the named network",
+	}
+}
+
+// formatHostIP returns the host IPv4 address of the subnet, or an empty string
+// if it is not set.
+func formatHostIP(subnet *types.Subnet) string {
+	if subnet.HostIPv4 == nil {
+		return ""
+	}
+	return subnet.HostIPv4.String()
+}
+
+// formatExpiration returns the expiration of the subnet along with the time
+// remaining until it is reached, or how long ago it passed.
+func formatExpiration(subnet *types.Subnet) string {
+	if subnet.Expiration.IsZero() {
+		return ""
+	}
+
+	remaining := time.Until(subnet.Expiration).Round(time.Second)
+	if remaining <= 0 {
+		return fmt.Sprintf("%s (%s ago)", subnet.Expiration.Format(time.RFC3339), -remaining)
+	}
+	return fmt.Sprintf("%s (in %s)", subnet.Expiration.Format(time.RFC3339), remaining)
+}
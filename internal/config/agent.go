@@ -150,10 +150,11 @@ func parseHCLConfigFile(path string) (*AgentConfig, error) {
 	}
 
 	// Parse duration strings into time.Duration values
-	if resp.Server != nil && resp.Server.Reaper != nil {
-		if err := resp.Server.Reaper.Parse(); err != nil {
-			return nil, fmt.Errorf("failed to parse server config: %w", err)
-		}
+	if err := resp.Client.Parse(); err != nil {
+		return nil, fmt.Errorf("failed to parse client config: %w", err)
+	}
+	if err := resp.Server.Parse(); err != nil {
+		return nil, fmt.Errorf("failed to parse server config: %w", err)
 	}
 
 	return &resp, nil
@@ -178,10 +179,11 @@ func parseJSONConfigFile(path string) (*AgentConfig, error) {
 	}
 
 	// Parse duration strings into time.Duration values
-	if resp.Server != nil && resp.Server.Reaper != nil {
-		if err := resp.Server.Reaper.Parse(); err != nil {
-			return nil, fmt.Errorf("failed to parse server config: %w", err)
-		}
+	if err := resp.Client.Parse(); err != nil {
+		return nil, fmt.Errorf("failed to parse client config: %w", err)
+	}
+	if err := resp.Server.Parse(); err != nil {
+		return nil, fmt.Errorf("failed to parse server config: %w", err)
 	}
 
 	return &resp, nil
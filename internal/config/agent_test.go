@@ -104,9 +104,11 @@ func TestAgentConfig_Merge(t *testing.T) {
 			},
 			expected: &AgentConfig{
 				Client: &ClientConfig{
-					Enabled:       helper.PointerOf(true),
-					DataDir:       "/custom/dir",
-					DisableIPMasq: false,
+					Enabled:           helper.PointerOf(true),
+					DataDir:           "/custom/dir",
+					DisableIPMasq:     false,
+					FirewallBackend:   "auto",
+					ReconcileInterval: 1 * time.Minute,
 				},
 				HTTP: &HTTPConfig{
 					Enabled:        helper.PointerOf(true),
@@ -132,6 +134,14 @@ func TestAgentConfig_Merge(t *testing.T) {
 				Store: &StoreConfig{
 					Backend: "nvar",
 					NVar:    &StoreNVarConfig{Path: "smuggle/"},
+					Consul: &StoreConsulConfig{
+						Address:    "http://localhost:8500",
+						Path:       "smuggle/",
+						SkipVerify: helper.PointerOf(false),
+					},
+					File: &StoreFileConfig{
+						Path: "/var/lib/smuggle/store",
+					},
 				},
 			},
 		},
@@ -255,9 +265,12 @@ func Test_AgentConfigFromCommand(t *testing.T) {
 				Nomad:  &NomadConfig{},
 				Server: &ServerConfig{
 					Reaper: &ReaperConfig{},
+					Leader: &LeaderConfig{},
 				},
 				Store: &StoreConfig{
-					NVar: &StoreNVarConfig{},
+					NVar:   &StoreNVarConfig{},
+					Consul: &StoreConsulConfig{},
+					File:   &StoreFileConfig{},
 				},
 			},
 		},
@@ -268,6 +281,9 @@ func Test_AgentConfigFromCommand(t *testing.T) {
 				must.NoError(t, cmd.Set(clientDataDirFlag, "/opt/smuggle/subnet"))
 				must.NoError(t, cmd.Set(clientDisableIPMasqFlag, "true"))
 				must.NoError(t, cmd.Set(clientNetworkInterfaceFlag, "eth0"))
+				must.NoError(t, cmd.Set(clientFirewallBackendFlag, "nftables"))
+				must.NoError(t, cmd.Set(clientLeaveOnShutdownFlag, "true"))
+				must.NoError(t, cmd.Set(clientReconcileIntervalFlag, "30s"))
 
 				must.NoError(t, cmd.Set(httpEnabledFlag, "true"))
 				must.NoError(t, cmd.Set(httpAddressFlag, "192.168.130.191"))
@@ -295,13 +311,26 @@ func Test_AgentConfigFromCommand(t *testing.T) {
 
 				must.NoError(t, cmd.Set(storeBackendFlag, "nvar"))
 				must.NoError(t, cmd.Set(storeNVarPathFlag, "custom/path/"))
+				must.NoError(t, cmd.Set(storeConsulAddrFlag, "https://consul.example.com:8501"))
+				must.NoError(t, cmd.Set(storeConsulTokenFlag, "consul-token-123"))
+				must.NoError(t, cmd.Set(storeConsulPathFlag, "custom/consul/"))
+				must.NoError(t, cmd.Set(storeConsulCACertFlag, "/etc/consul/ca.pem"))
+				must.NoError(t, cmd.Set(storeConsulCAPathFlag, "/etc/consul/ca-dir"))
+				must.NoError(t, cmd.Set(storeConsulClientCertFlag, "/etc/consul/client.pem"))
+				must.NoError(t, cmd.Set(storeConsulClientKeyFlag, "/etc/consul/client-key.pem"))
+				must.NoError(t, cmd.Set(storeConsulTLSServerNameFlag, "server.consul.example.com"))
+				must.NoError(t, cmd.Set(storeConsulSkipVerifyFlag, "true"))
+				must.NoError(t, cmd.Set(storeFilePathFlag, "/var/lib/smuggle/custom"))
 			},
 			expected: &AgentConfig{
 				Client: &ClientConfig{
-					Enabled:          helper.PointerOf(true),
-					DataDir:          "/opt/smuggle/subnet",
-					DisableIPMasq:    true,
-					NetworkInterface: "eth0",
+					Enabled:           helper.PointerOf(true),
+					DataDir:           "/opt/smuggle/subnet",
+					DisableIPMasq:     true,
+					NetworkInterface:  "eth0",
+					FirewallBackend:   "nftables",
+					LeaveOnShutdown:   true,
+					ReconcileInterval: 30 * time.Second,
 				},
 				HTTP: &HTTPConfig{
 					Enabled:        helper.PointerOf(true),
@@ -332,12 +361,27 @@ func Test_AgentConfigFromCommand(t *testing.T) {
 						Interval:  10 * time.Minute,
 						Threshold: 15 * time.Minute,
 					},
+					Leader: &LeaderConfig{},
 				},
 				Store: &StoreConfig{
 					Backend: "nvar",
 					NVar: &StoreNVarConfig{
 						Path: "custom/path/",
 					},
+					Consul: &StoreConsulConfig{
+						Address:       "https://consul.example.com:8501",
+						Token:         "consul-token-123",
+						Path:          "custom/consul/",
+						CACert:        "/etc/consul/ca.pem",
+						CAPath:        "/etc/consul/ca-dir",
+						ClientCert:    "/etc/consul/client.pem",
+						ClientKey:     "/etc/consul/client-key.pem",
+						TLSServerName: "server.consul.example.com",
+						SkipVerify:    helper.PointerOf(true),
+					},
+					File: &StoreFileConfig{
+						Path: "/var/lib/smuggle/custom",
+					},
 				},
 			},
 		},
@@ -398,6 +442,8 @@ client {
   data_dir           = "/var/lib/smuggle/client"
   disable_ipmasq     = false
   network_interface  = "eth0"
+  firewall_backend   = "nftables"
+  reconcile_interval = "2m"
 }
 
 http {
@@ -444,10 +490,13 @@ store {
 			fileExt: "hcl",
 			expected: &AgentConfig{
 				Client: &ClientConfig{
-					Enabled:          helper.PointerOf(true),
-					DataDir:          "/var/lib/smuggle/client",
-					DisableIPMasq:    false,
-					NetworkInterface: "eth0",
+					Enabled:              helper.PointerOf(true),
+					DataDir:              "/var/lib/smuggle/client",
+					DisableIPMasq:        false,
+					NetworkInterface:     "eth0",
+					FirewallBackend:      "nftables",
+					ReconcileIntervalHCL: "2m",
+					ReconcileInterval:    2 * time.Minute,
 				},
 				HTTP: &HTTPConfig{
 					Enabled:        helper.PointerOf(true),
@@ -498,7 +547,9 @@ store {
     "enabled": true,
     "data_dir": "/var/lib/smuggle/client",
     "disable_ipmasq": false,
-    "network_interface": "eth0"
+    "network_interface": "eth0",
+    "firewall_backend": "nftables",
+    "reconcile_interval": "2m"
   },
   "http": {
     "enabled": true,
@@ -541,10 +592,13 @@ store {
 			fileExt: "json",
 			expected: &AgentConfig{
 				Client: &ClientConfig{
-					Enabled:          helper.PointerOf(true),
-					DataDir:          "/var/lib/smuggle/client",
-					DisableIPMasq:    false,
-					NetworkInterface: "eth0",
+					Enabled:              helper.PointerOf(true),
+					DataDir:              "/var/lib/smuggle/client",
+					DisableIPMasq:        false,
+					NetworkInterface:     "eth0",
+					FirewallBackend:      "nftables",
+					ReconcileIntervalHCL: "2m",
+					ReconcileInterval:    2 * time.Minute,
 				},
 				HTTP: &HTTPConfig{
 					Enabled:        helper.PointerOf(true),
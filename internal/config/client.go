@@ -5,17 +5,24 @@ import (
 	"fmt"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"time"
 
 	"github.com/urfave/cli/v3"
 
 	"github.com/rasorp/smuggle/internal/helper"
+	"github.com/rasorp/smuggle/internal/types"
 )
 
 const (
-	clientEnabledFlag          = "client-enabled"
-	clientDataDirFlag          = "client-data-dir"
-	clientDisableIPMasqFlag    = "client-disable-ipmasq"
-	clientNetworkInterfaceFlag = "client-network-interface"
+	clientEnabledFlag           = "client-enabled"
+	clientDataDirFlag           = "client-data-dir"
+	clientDisableIPMasqFlag     = "client-disable-ipmasq"
+	clientNetworkInterfaceFlag  = "client-network-interface"
+	clientFirewallBackendFlag   = "client-firewall-backend"
+	clientLeaveOnShutdownFlag   = "client-leave-on-shutdown"
+	clientReconcileIntervalFlag = "client-reconcile-interval"
+	clientUseNomadNodeIDFlag    = "client-use-nomad-node-id"
 )
 
 type ClientConfig struct {
@@ -35,14 +42,58 @@ type ClientConfig struct {
 	// networking. If not specified, the default interface will be identified
 	// and used.
 	NetworkInterface string `hcl:"network_interface,optional" json:"network_interface"`
+
+	// FirewallBackend specifies the firewall implementation used to manage
+	// forwarding, masquerading and isolation rules. This can be "iptables",
+	// "nftables" or "auto", which uses iptables if available on the host and
+	// nftables otherwise.
+	FirewallBackend string `hcl:"firewall_backend,optional" json:"firewall_backend"`
+
+	// LeaveOnShutdown indicates whether the client should leave its networks
+	// when it is shut down. This marks the client subnets as expired in the
+	// store, so peers remove their routes immediately, and removes the local
+	// network interfaces, firewall rules and CNI configurations. This should
+	// be used when decommissioning a host.
+	LeaveOnShutdown bool `hcl:"leave_on_shutdown,optional" json:"leave_on_shutdown"`
+
+	// ReconcileInterval is the interval at which the client compares the
+	// remote subnets within the store with the routing configuration on the
+	// host, repairing any missing entries and removing stale ones.
+	ReconcileIntervalHCL string `hcl:"reconcile_interval,optional" json:"reconcile_interval"`
+	ReconcileInterval    time.Duration
+
+	// UseNomadNodeID indicates whether the client ID should be the ID of the
+	// local Nomad node rather than an ID generated within the data directory.
+	// This keeps the identity of the client, and therefore its subnets, when
+	// the data directory is wiped.
+	UseNomadNodeID bool `hcl:"use_nomad_node_id,optional" json:"use_nomad_node_id"`
+}
+
+func (c *ClientConfig) Parse() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.ReconcileIntervalHCL != "" {
+		d, err := time.ParseDuration(c.ReconcileIntervalHCL)
+		if err != nil {
+			return err
+		}
+		c.ReconcileInterval = d
+	}
+
+	return nil
 }
 
 func DefaultClientConfig() *ClientConfig {
 	return &ClientConfig{
-		Enabled:          helper.PointerOf(false),
-		DataDir:          "/var/lib/smuggle/client",
-		DisableIPMasq:    false,
-		NetworkInterface: "",
+		Enabled:           helper.PointerOf(false),
+		DataDir:           "/var/lib/smuggle/client",
+		DisableIPMasq:     false,
+		NetworkInterface:  "",
+		FirewallBackend:   types.FirewallBackendAuto,
+		LeaveOnShutdown:   false,
+		ReconcileInterval: 1 * time.Minute,
 	}
 }
 
@@ -70,6 +121,18 @@ func (c *ClientConfig) Merge(other *ClientConfig) *ClientConfig {
 	if other.NetworkInterface != "" {
 		result.NetworkInterface = other.NetworkInterface
 	}
+	if other.FirewallBackend != "" {
+		result.FirewallBackend = other.FirewallBackend
+	}
+	if other.LeaveOnShutdown {
+		result.LeaveOnShutdown = other.LeaveOnShutdown
+	}
+	if other.ReconcileInterval != 0 {
+		result.ReconcileInterval = other.ReconcileInterval
+	}
+	if other.UseNomadNodeID {
+		result.UseNomadNodeID = other.UseNomadNodeID
+	}
 
 	return &result
 }
@@ -88,6 +151,12 @@ func (c *ClientConfig) Validate() []error {
 	if !filepath.IsAbs(c.DataDir) || c.DataDir == "" {
 		errs = append(errs, errors.New("client data directory must be an absolute path"))
 	}
+	if c.FirewallBackend != "" && !slices.Contains(types.SupportedFirewallBackends, c.FirewallBackend) {
+		errs = append(errs, fmt.Errorf("client firewall backend must be one of %v", types.SupportedFirewallBackends))
+	}
+	if c.ReconcileInterval < 0 {
+		errs = append(errs, errors.New("client reconcile interval must not be negative"))
+	}
 
 	return errs
 }
@@ -118,13 +187,39 @@ func ClientConfigCommandFlags() []cli.Flag {
 			Usage:       "The network interface to use for client networking",
 			Sources:     cli.EnvVars("SMUGGLE_CLIENT_NETWORK_INTERFACE"),
 		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        clientFirewallBackendFlag,
+			Usage:       "The firewall backend to use for client networking",
+			Sources:     cli.EnvVars("SMUGGLE_CLIENT_FIREWALL_BACKEND"),
+		},
+		&cli.BoolFlag{
+			HideDefault: true,
+			Name:        clientLeaveOnShutdownFlag,
+			Usage:       "Release the client subnets and remove host networking on shutdown",
+			Sources:     cli.EnvVars("SMUGGLE_CLIENT_LEAVE_ON_SHUTDOWN"),
+		},
+		&cli.DurationFlag{
+			HideDefault: true,
+			Name:        clientReconcileIntervalFlag,
+			Usage:       "Interval between reconciliations of remote subnet routing",
+			Sources:     cli.EnvVars("SMUGGLE_CLIENT_RECONCILE_INTERVAL"),
+		},
+		&cli.BoolFlag{
+			HideDefault: true,
+			Name:        clientUseNomadNodeIDFlag,
+			Usage:       "Use the ID of the local Nomad node as the client ID",
+			Sources:     cli.EnvVars("SMUGGLE_CLIENT_USE_NOMAD_NODE_ID"),
+		},
 	}
 }
 
 func ClientConfigFromCommand(c *cli.Command) *ClientConfig {
 	cfg := &ClientConfig{
-		DataDir:          c.String(clientDataDirFlag),
-		NetworkInterface: c.String(clientNetworkInterfaceFlag),
+		DataDir:           c.String(clientDataDirFlag),
+		NetworkInterface:  c.String(clientNetworkInterfaceFlag),
+		FirewallBackend:   c.String(clientFirewallBackendFlag),
+		ReconcileInterval: c.Duration(clientReconcileIntervalFlag),
 	}
 
 	if c.IsSet(clientEnabledFlag) {
@@ -133,6 +228,12 @@ func ClientConfigFromCommand(c *cli.Command) *ClientConfig {
 	if c.IsSet(clientDisableIPMasqFlag) {
 		cfg.DisableIPMasq = c.Bool(clientDisableIPMasqFlag)
 	}
+	if c.IsSet(clientLeaveOnShutdownFlag) {
+		cfg.LeaveOnShutdown = c.Bool(clientLeaveOnShutdownFlag)
+	}
+	if c.IsSet(clientUseNomadNodeIDFlag) {
+		cfg.UseNomadNodeID = c.Bool(clientUseNomadNodeIDFlag)
+	}
 
 	return cfg
 }
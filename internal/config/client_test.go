@@ -3,6 +3,7 @@ package config
 import (
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/shoenig/test/must"
 	"github.com/urfave/cli/v3"
@@ -18,6 +19,10 @@ func Test_DefaultClientConfig(t *testing.T) {
 	must.Eq(t, "/var/lib/smuggle/client", defaults.DataDir)
 	must.False(t, defaults.DisableIPMasq)
 	must.Eq(t, "", defaults.NetworkInterface)
+	must.Eq(t, "auto", defaults.FirewallBackend)
+	must.False(t, defaults.LeaveOnShutdown)
+	must.Eq(t, 1*time.Minute, defaults.ReconcileInterval)
+	must.False(t, defaults.UseNomadNodeID)
 }
 
 func TestClientConfig_IsEnabled(t *testing.T) {
@@ -71,10 +76,20 @@ func TestClientConfig_Merge(t *testing.T) {
 		{
 			name:  "override fields",
 			base:  &ClientConfig{DataDir: "/base/dir", DisableIPMasq: false},
-			other: &ClientConfig{DataDir: "/other/dir", DisableIPMasq: true},
+			other: &ClientConfig{DataDir: "/other/dir", DisableIPMasq: true, LeaveOnShutdown: true, UseNomadNodeID: true},
 			expected: &ClientConfig{
-				DataDir:       "/other/dir",
-				DisableIPMasq: true,
+				DataDir:         "/other/dir",
+				DisableIPMasq:   true,
+				LeaveOnShutdown: true,
+				UseNomadNodeID:  true,
+			},
+		},
+		{
+			name:  "override reconcile interval",
+			base:  &ClientConfig{ReconcileInterval: 1 * time.Minute},
+			other: &ClientConfig{ReconcileInterval: 30 * time.Second},
+			expected: &ClientConfig{
+				ReconcileInterval: 30 * time.Second,
 			},
 		},
 	}
@@ -121,6 +136,33 @@ func TestClientConfig_Validate(t *testing.T) {
 			},
 			expectedError: true,
 		},
+		{
+			name: "valid firewall backend",
+			config: &ClientConfig{
+				Enabled:         helper.PointerOf(true),
+				DataDir:         "/valid/dir",
+				FirewallBackend: "nftables",
+			},
+			expectedError: false,
+		},
+		{
+			name: "invalid firewall backend",
+			config: &ClientConfig{
+				Enabled:         helper.PointerOf(true),
+				DataDir:         "/valid/dir",
+				FirewallBackend: "pf",
+			},
+			expectedError: true,
+		},
+		{
+			name: "negative reconcile interval",
+			config: &ClientConfig{
+				Enabled:           helper.PointerOf(true),
+				DataDir:           "/valid/dir",
+				ReconcileInterval: -1 * time.Second,
+			},
+			expectedError: true,
+		},
 		{
 			name: "client disabled",
 			config: &ClientConfig{
@@ -169,6 +211,30 @@ func Test_ClientConfigCommandFlags(t *testing.T) {
 			Usage:       "The network interface to use for client networking",
 			Sources:     cli.EnvVars("SMUGGLE_CLIENT_NETWORK_INTERFACE"),
 		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        clientFirewallBackendFlag,
+			Usage:       "The firewall backend to use for client networking",
+			Sources:     cli.EnvVars("SMUGGLE_CLIENT_FIREWALL_BACKEND"),
+		},
+		&cli.BoolFlag{
+			HideDefault: true,
+			Name:        clientLeaveOnShutdownFlag,
+			Usage:       "Release the client subnets and remove host networking on shutdown",
+			Sources:     cli.EnvVars("SMUGGLE_CLIENT_LEAVE_ON_SHUTDOWN"),
+		},
+		&cli.DurationFlag{
+			HideDefault: true,
+			Name:        clientReconcileIntervalFlag,
+			Usage:       "Interval between reconciliations of remote subnet routing",
+			Sources:     cli.EnvVars("SMUGGLE_CLIENT_RECONCILE_INTERVAL"),
+		},
+		&cli.BoolFlag{
+			HideDefault: true,
+			Name:        clientUseNomadNodeIDFlag,
+			Usage:       "Use the ID of the local Nomad node as the client ID",
+			Sources:     cli.EnvVars("SMUGGLE_CLIENT_USE_NOMAD_NODE_ID"),
+		},
 	}
 	must.Eq(t, expectedFlags, ClientConfigCommandFlags())
 }
@@ -191,12 +257,20 @@ func Test_ClientConfigFromComand(t *testing.T) {
 				must.NoError(t, cmd.Set(clientDataDirFlag, "/custom/dir"))
 				must.NoError(t, cmd.Set(clientDisableIPMasqFlag, "true"))
 				must.NoError(t, cmd.Set(clientNetworkInterfaceFlag, "eth0"))
+				must.NoError(t, cmd.Set(clientFirewallBackendFlag, "iptables"))
+				must.NoError(t, cmd.Set(clientLeaveOnShutdownFlag, "true"))
+				must.NoError(t, cmd.Set(clientReconcileIntervalFlag, "30s"))
+				must.NoError(t, cmd.Set(clientUseNomadNodeIDFlag, "true"))
 			},
 			expected: &ClientConfig{
-				Enabled:          helper.PointerOf(true),
-				DataDir:          "/custom/dir",
-				DisableIPMasq:    true,
-				NetworkInterface: "eth0",
+				Enabled:           helper.PointerOf(true),
+				DataDir:           "/custom/dir",
+				DisableIPMasq:     true,
+				NetworkInterface:  "eth0",
+				FirewallBackend:   "iptables",
+				LeaveOnShutdown:   true,
+				ReconcileInterval: 30 * time.Second,
+				UseNomadNodeID:    true,
 			},
 		},
 	}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/urfave/cli/v3"
@@ -12,12 +14,36 @@ const (
 	serverEnabledFlag         = "server-enabled"
 	serverReaperIntervalFlag  = "server-reaper-interval"
 	serverReaperThresholdFlag = "server-reaper-threshold"
+
+	serverReaperNodeEventsFlag      = "server-reaper-node-events"
+	serverReaperNodeGracePeriodFlag = "server-reaper-node-grace-period"
+
+	serverLeaderEnabledFlag = "server-leader-enabled"
+	serverLeaderPathFlag    = "server-leader-path"
+	serverLeaderTTLFlag     = "server-leader-ttl"
+
+	// minLeaderTTL is the shortest TTL accepted by Nomad for variable locks.
+	minLeaderTTL = 10 * time.Second
 )
 
 type ServerConfig struct {
 	Enabled *bool `hcl:"enabled,optional" json:"enabled"`
 
 	Reaper *ReaperConfig `hcl:"reaper,block" json:"reaper"`
+
+	Leader *LeaderConfig `hcl:"leader,block" json:"leader"`
+}
+
+// Parse parses the duration strings of the server configuration blocks.
+func (s *ServerConfig) Parse() error {
+	if s == nil {
+		return nil
+	}
+
+	if err := s.Reaper.Parse(); err != nil {
+		return err
+	}
+	return s.Leader.Parse()
 }
 
 type ReaperConfig struct {
@@ -26,6 +52,17 @@ type ReaperConfig struct {
 
 	ThresholdHCL string `hcl:"threshold,optional" json:"threshold"`
 	Threshold    time.Duration
+
+	// NodeEvents enables expiring the subnets of clients whose Nomad node is
+	// marked down or purged, based on the Nomad event stream, rather than
+	// waiting for their lease to expire.
+	NodeEvents *bool `hcl:"node_events,optional" json:"node_events"`
+
+	// NodeGracePeriod is how long a Nomad node must remain down before the
+	// subnets of its clients are expired, so brief outages do not remove the
+	// routes to the node.
+	NodeGracePeriodHCL string `hcl:"node_grace_period,optional" json:"node_grace_period"`
+	NodeGracePeriod    time.Duration
 }
 
 func (r *ReaperConfig) Parse() error {
@@ -49,15 +86,72 @@ func (r *ReaperConfig) Parse() error {
 		r.Threshold = d
 	}
 
+	if r.NodeGracePeriodHCL != "" {
+		d, err := time.ParseDuration(r.NodeGracePeriodHCL)
+		if err != nil {
+			return err
+		}
+		r.NodeGracePeriod = d
+	}
+
 	return nil
 }
 
+// NodeEventsEnabled indicates whether subnets should be expired based on the
+// Nomad node lifecycle events.
+func (r *ReaperConfig) NodeEventsEnabled() bool {
+	return r != nil && r.NodeEvents != nil && *r.NodeEvents
+}
+
+// LeaderConfig configures the leader election among server agents. When
+// enabled, the servers compete for a lock on a Nomad variable and only the
+// holder runs the reapers, so multiple servers can be run for availability.
+type LeaderConfig struct {
+	Enabled *bool `hcl:"enabled,optional" json:"enabled"`
+
+	// Path is the Nomad variable path used as the leader lock. All servers
+	// within the cluster must use the same path.
+	Path string `hcl:"path,optional" json:"path"`
+
+	// TTL is the lifetime of the leader lock. The leader renews the lock at
+	// half this interval, so it bounds how long the cluster is without a
+	// leader when the leader fails.
+	TTLHCL string `hcl:"ttl,optional" json:"ttl"`
+	TTL    time.Duration
+}
+
+func (l *LeaderConfig) Parse() error {
+	if l == nil {
+		return nil
+	}
+
+	if l.TTLHCL != "" {
+		d, err := time.ParseDuration(l.TTLHCL)
+		if err != nil {
+			return err
+		}
+		l.TTL = d
+	}
+
+	return nil
+}
+
+// IsEnabled indicates whether the server should take part in leader election.
+func (l *LeaderConfig) IsEnabled() bool { return l != nil && l.Enabled != nil && *l.Enabled }
+
 func DefaultServerConfig() *ServerConfig {
 	return &ServerConfig{
 		Enabled: helper.PointerOf(false),
 		Reaper: &ReaperConfig{
-			Interval:  5 * time.Minute,
-			Threshold: 5 * time.Minute,
+			Interval:        5 * time.Minute,
+			Threshold:       5 * time.Minute,
+			NodeEvents:      helper.PointerOf(false),
+			NodeGracePeriod: 10 * time.Minute,
+		},
+		Leader: &LeaderConfig{
+			Enabled: helper.PointerOf(false),
+			Path:    "smuggle/leader",
+			TTL:     15 * time.Second,
 		},
 	}
 }
@@ -85,6 +179,26 @@ func (s *ServerConfig) Merge(other *ServerConfig) *ServerConfig {
 		if other.Reaper.Threshold != 0 {
 			s.Reaper.Threshold = other.Reaper.Threshold
 		}
+		if other.Reaper.NodeEvents != nil {
+			s.Reaper.NodeEvents = other.Reaper.NodeEvents
+		}
+		if other.Reaper.NodeGracePeriod != 0 {
+			s.Reaper.NodeGracePeriod = other.Reaper.NodeGracePeriod
+		}
+	}
+	if other.Leader != nil {
+		if s.Leader == nil {
+			s.Leader = &LeaderConfig{}
+		}
+		if other.Leader.Enabled != nil {
+			s.Leader.Enabled = other.Leader.Enabled
+		}
+		if other.Leader.Path != "" {
+			s.Leader.Path = other.Leader.Path
+		}
+		if other.Leader.TTL != 0 {
+			s.Leader.TTL = other.Leader.TTL
+		}
 	}
 
 	return s
@@ -97,6 +211,20 @@ func (s *ServerConfig) Validate() []error {
 	}
 
 	var errs []error
+
+	if s.Reaper != nil && s.Reaper.NodeGracePeriod < 0 {
+		errs = append(errs, errors.New("reaper node grace period must not be negative"))
+	}
+
+	if s.Leader.IsEnabled() {
+		if s.Leader.Path == "" {
+			errs = append(errs, errors.New("leader path must be set"))
+		}
+		if s.Leader.TTL < minLeaderTTL {
+			errs = append(errs, fmt.Errorf("leader TTL must be at least %s", minLeaderTTL))
+		}
+	}
+
 	return errs
 }
 
@@ -120,6 +248,36 @@ func ServerConfigCommandFlags() []cli.Flag {
 			Usage:       "Duration after which inactive clients are reaped",
 			Sources:     cli.EnvVars("SMUGGLE_SERVER_REAPER_THRESHOLD"),
 		},
+		&cli.BoolFlag{
+			HideDefault: true,
+			Name:        serverReaperNodeEventsFlag,
+			Usage:       "Expire the subnets of clients whose Nomad node is down or purged",
+			Sources:     cli.EnvVars("SMUGGLE_SERVER_REAPER_NODE_EVENTS"),
+		},
+		&cli.DurationFlag{
+			HideDefault: true,
+			Name:        serverReaperNodeGracePeriodFlag,
+			Usage:       "Duration a Nomad node must be down before its client subnets are expired",
+			Sources:     cli.EnvVars("SMUGGLE_SERVER_REAPER_NODE_GRACE_PERIOD"),
+		},
+		&cli.BoolFlag{
+			HideDefault: true,
+			Name:        serverLeaderEnabledFlag,
+			Usage:       "Enable leader election among server agents using a Nomad variable lock",
+			Sources:     cli.EnvVars("SMUGGLE_SERVER_LEADER_ENABLED"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        serverLeaderPathFlag,
+			Usage:       "Nomad variable path used as the leader lock",
+			Sources:     cli.EnvVars("SMUGGLE_SERVER_LEADER_PATH"),
+		},
+		&cli.DurationFlag{
+			HideDefault: true,
+			Name:        serverLeaderTTLFlag,
+			Usage:       "Lifetime of the leader lock",
+			Sources:     cli.EnvVars("SMUGGLE_SERVER_LEADER_TTL"),
+		},
 	}
 }
 
@@ -135,6 +293,25 @@ func ServerConfigFromCommand(cmd *cli.Command) *ServerConfig {
 		Reaper: &ReaperConfig{
 			Interval:  cmd.Duration(serverReaperIntervalFlag),
 			Threshold: cmd.Duration(serverReaperThresholdFlag),
+			NodeEvents: func() *bool {
+				if cmd.IsSet(serverReaperNodeEventsFlag) {
+					val := cmd.Bool(serverReaperNodeEventsFlag)
+					return &val
+				}
+				return nil
+			}(),
+			NodeGracePeriod: cmd.Duration(serverReaperNodeGracePeriodFlag),
+		},
+		Leader: &LeaderConfig{
+			Enabled: func() *bool {
+				if cmd.IsSet(serverLeaderEnabledFlag) {
+					val := cmd.Bool(serverLeaderEnabledFlag)
+					return &val
+				}
+				return nil
+			}(),
+			Path: cmd.String(serverLeaderPathFlag),
+			TTL:  cmd.Duration(serverLeaderTTLFlag),
 		},
 	}
 }
@@ -18,6 +18,12 @@ func Test_DefaultServerConfig(t *testing.T) {
 	must.NotNil(t, cfg.Reaper)
 	must.Eq(t, 5*time.Minute, cfg.Reaper.Interval)
 	must.Eq(t, 5*time.Minute, cfg.Reaper.Threshold)
+	must.False(t, cfg.Reaper.NodeEventsEnabled())
+	must.Eq(t, 10*time.Minute, cfg.Reaper.NodeGracePeriod)
+	must.NotNil(t, cfg.Leader)
+	must.False(t, cfg.Leader.IsEnabled())
+	must.Eq(t, "smuggle/leader", cfg.Leader.Path)
+	must.Eq(t, 15*time.Second, cfg.Leader.TTL)
 }
 
 func TestServerConfig_IsEnabled(t *testing.T) {
@@ -200,6 +206,49 @@ func TestServerConfig_Validate(t *testing.T) {
 			},
 			expectedError: false,
 		},
+		{
+			name: "negative node grace period",
+			config: &ServerConfig{
+				Enabled: helper.PointerOf(true),
+				Reaper: &ReaperConfig{
+					NodeGracePeriod: -time.Minute,
+				},
+			},
+			expectedError: true,
+		},
+		{
+			name: "leader without path",
+			config: &ServerConfig{
+				Enabled: helper.PointerOf(true),
+				Leader: &LeaderConfig{
+					Enabled: helper.PointerOf(true),
+					TTL:     15 * time.Second,
+				},
+			},
+			expectedError: true,
+		},
+		{
+			name: "leader TTL too short",
+			config: &ServerConfig{
+				Enabled: helper.PointerOf(true),
+				Leader: &LeaderConfig{
+					Enabled: helper.PointerOf(true),
+					Path:    "smuggle/leader",
+					TTL:     time.Second,
+				},
+			},
+			expectedError: true,
+		},
+		{
+			name: "leader disabled ignores TTL",
+			config: &ServerConfig{
+				Enabled: helper.PointerOf(true),
+				Leader: &LeaderConfig{
+					Enabled: helper.PointerOf(false),
+				},
+			},
+			expectedError: false,
+		},
 		{
 			name: "server disabled",
 			config: &ServerConfig{
@@ -241,6 +290,36 @@ func Test_ServerConfigCommandFlags(t *testing.T) {
 			Usage:       "Duration after which inactive clients are reaped",
 			Sources:     cli.EnvVars("SMUGGLE_SERVER_REAPER_THRESHOLD"),
 		},
+		&cli.BoolFlag{
+			HideDefault: true,
+			Name:        serverReaperNodeEventsFlag,
+			Usage:       "Expire the subnets of clients whose Nomad node is down or purged",
+			Sources:     cli.EnvVars("SMUGGLE_SERVER_REAPER_NODE_EVENTS"),
+		},
+		&cli.DurationFlag{
+			HideDefault: true,
+			Name:        serverReaperNodeGracePeriodFlag,
+			Usage:       "Duration a Nomad node must be down before its client subnets are expired",
+			Sources:     cli.EnvVars("SMUGGLE_SERVER_REAPER_NODE_GRACE_PERIOD"),
+		},
+		&cli.BoolFlag{
+			HideDefault: true,
+			Name:        serverLeaderEnabledFlag,
+			Usage:       "Enable leader election among server agents using a Nomad variable lock",
+			Sources:     cli.EnvVars("SMUGGLE_SERVER_LEADER_ENABLED"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        serverLeaderPathFlag,
+			Usage:       "Nomad variable path used as the leader lock",
+			Sources:     cli.EnvVars("SMUGGLE_SERVER_LEADER_PATH"),
+		},
+		&cli.DurationFlag{
+			HideDefault: true,
+			Name:        serverLeaderTTLFlag,
+			Usage:       "Lifetime of the leader lock",
+			Sources:     cli.EnvVars("SMUGGLE_SERVER_LEADER_TTL"),
+		},
 	}
 	must.Eq(t, expectedFlags, ServerConfigCommandFlags())
 }
@@ -256,6 +335,7 @@ func Test_ServerConfigFromCommand(t *testing.T) {
 			setFlags: func(_ *cli.Command) {},
 			expected: &ServerConfig{
 				Reaper: &ReaperConfig{},
+				Leader: &LeaderConfig{},
 			},
 		},
 		{
@@ -264,12 +344,24 @@ func Test_ServerConfigFromCommand(t *testing.T) {
 				must.NoError(t, cmd.Set(serverEnabledFlag, "true"))
 				must.NoError(t, cmd.Set(serverReaperIntervalFlag, "10m"))
 				must.NoError(t, cmd.Set(serverReaperThresholdFlag, "15m"))
+				must.NoError(t, cmd.Set(serverReaperNodeEventsFlag, "true"))
+				must.NoError(t, cmd.Set(serverReaperNodeGracePeriodFlag, "2m"))
+				must.NoError(t, cmd.Set(serverLeaderEnabledFlag, "true"))
+				must.NoError(t, cmd.Set(serverLeaderPathFlag, "ha/leader"))
+				must.NoError(t, cmd.Set(serverLeaderTTLFlag, "30s"))
 			},
 			expected: &ServerConfig{
 				Enabled: helper.PointerOf(true),
 				Reaper: &ReaperConfig{
-					Interval:  10 * time.Minute,
-					Threshold: 15 * time.Minute,
+					Interval:        10 * time.Minute,
+					Threshold:       15 * time.Minute,
+					NodeEvents:      helper.PointerOf(true),
+					NodeGracePeriod: 2 * time.Minute,
+				},
+				Leader: &LeaderConfig{
+					Enabled: helper.PointerOf(true),
+					Path:    "ha/leader",
+					TTL:     30 * time.Second,
 				},
 			},
 		},
@@ -281,6 +373,7 @@ func Test_ServerConfigFromCommand(t *testing.T) {
 			expected: &ServerConfig{
 				Enabled: helper.PointerOf(true),
 				Reaper:  &ReaperConfig{},
+				Leader:  &LeaderConfig{},
 			},
 		},
 	}
@@ -3,30 +3,74 @@ package config
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
 
+	consulapi "github.com/hashicorp/consul/api"
 	"github.com/urfave/cli/v3"
+
+	"github.com/rasorp/smuggle/internal/helper"
 )
 
 const (
 	storeBackendFlag  = "store-backend"
 	storeNVarPathFlag = "store-nvar-path"
+
+	storeConsulAddrFlag          = "store-consul-addr"
+	storeConsulTokenFlag         = "store-consul-token"
+	storeConsulPathFlag          = "store-consul-path"
+	storeConsulCACertFlag        = "store-consul-ca-cert"
+	storeConsulCAPathFlag        = "store-consul-ca-path"
+	storeConsulClientCertFlag    = "store-consul-client-cert"
+	storeConsulClientKeyFlag     = "store-consul-client-key"
+	storeConsulTLSServerNameFlag = "store-consul-tls-server-name"
+	storeConsulSkipVerifyFlag    = "store-consul-skip-verify"
+
+	storeFilePathFlag = "store-file-path"
 )
 
 type StoreConfig struct {
-	Backend string           `hcl:"backend" json:"backend"`
-	NVar    *StoreNVarConfig `hcl:"nvar,block" json:"nvar"`
+	Backend string             `hcl:"backend" json:"backend"`
+	NVar    *StoreNVarConfig   `hcl:"nvar,block" json:"nvar"`
+	Consul  *StoreConsulConfig `hcl:"consul,block" json:"consul"`
+	File    *StoreFileConfig   `hcl:"file,block" json:"file"`
 }
 
 type StoreNVarConfig struct {
 	Path string `hcl:"path" json:"path"`
 }
 
+// StoreConsulConfig is the configuration for the Consul KV store backend.
+type StoreConsulConfig struct {
+	Address       string `hcl:"address,optional" json:"address"`
+	Token         string `hcl:"token,optional" json:"token"`
+	Path          string `hcl:"path,optional" json:"path"`
+	CACert        string `hcl:"ca_cert,optional" json:"ca_cert"`
+	CAPath        string `hcl:"ca_path,optional" json:"ca_path"`
+	ClientCert    string `hcl:"client_cert,optional" json:"client_cert"`
+	ClientKey     string `hcl:"client_key,optional" json:"client_key"`
+	TLSServerName string `hcl:"tls_server_name,optional" json:"tls_server_name"`
+	SkipVerify    *bool  `hcl:"skip_verify,optional" json:"skip_verify"`
+}
+
+// StoreFileConfig is the configuration for the local file store backend.
+type StoreFileConfig struct {
+	Path string `hcl:"path,optional" json:"path"`
+}
+
 func DefaultStoreConfig() *StoreConfig {
 	return &StoreConfig{
 		Backend: "nvar",
 		NVar: &StoreNVarConfig{
 			Path: "smuggle/",
 		},
+		Consul: &StoreConsulConfig{
+			Address:    "http://localhost:8500",
+			Path:       "smuggle/",
+			SkipVerify: helper.PointerOf(false),
+		},
+		File: &StoreFileConfig{
+			Path: "/var/lib/smuggle/store",
+		},
 	}
 }
 
@@ -51,6 +95,17 @@ func (s *StoreConfig) Merge(other *StoreConfig) *StoreConfig {
 			result.NVar.Path = other.NVar.Path
 		}
 	}
+	if other.Consul != nil {
+		result.Consul = result.Consul.Merge(other.Consul)
+	}
+	if other.File != nil {
+		if result.File == nil {
+			result.File = &StoreFileConfig{}
+		}
+		if other.File.Path != "" {
+			result.File.Path = other.File.Path
+		}
+	}
 
 	return &result
 }
@@ -65,6 +120,18 @@ func (s *StoreConfig) Validate() []error {
 		} else if s.NVar.Path == "" {
 			errs = append(errs, errors.New("nvar backend requires path to be set"))
 		}
+	case "consul":
+		if s.Consul == nil {
+			errs = append(errs, errors.New("consul backend set without consul configuration"))
+		} else if s.Consul.Path == "" {
+			errs = append(errs, errors.New("consul backend requires path to be set"))
+		}
+	case "file":
+		if s.File == nil {
+			errs = append(errs, errors.New("file backend set without file configuration"))
+		} else if !filepath.IsAbs(s.File.Path) {
+			errs = append(errs, fmt.Errorf("file backend requires an absolute path: %q", s.File.Path))
+		}
 	default:
 		errs = append(errs, fmt.Errorf("unsupported backend: %q", s.Backend))
 	}
@@ -86,6 +153,66 @@ func StoreConfigCommandFlags() []cli.Flag {
 			Usage:       "The path prefix to use when storing network configuration in Nomad variables",
 			Sources:     cli.EnvVars("SMUGGLE_STORE_NVAR_PATH"),
 		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulAddrFlag,
+			Usage:       "The Consul agent address",
+			Sources:     cli.EnvVars("CONSUL_HTTP_ADDR"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulTokenFlag,
+			Usage:       "The Consul ACL token to use for HTTP requests",
+			Sources:     cli.EnvVars("CONSUL_HTTP_TOKEN"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulPathFlag,
+			Usage:       "The key prefix to use when storing network configuration in Consul KV",
+			Sources:     cli.EnvVars("SMUGGLE_STORE_CONSUL_PATH"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulCACertFlag,
+			Usage:       "Path to a PEM encoded CA cert file to use to verify the Consul agent SSL certificate",
+			Sources:     cli.EnvVars("CONSUL_CACERT"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulCAPathFlag,
+			Usage:       "Path to a directory of PEM encoded CA cert files to verify the Consul agent SSL certificate",
+			Sources:     cli.EnvVars("CONSUL_CAPATH"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulClientCertFlag,
+			Usage:       "Path to a PEM encoded client certificate for TLS authentication to the Consul agent",
+			Sources:     cli.EnvVars("CONSUL_CLIENT_CERT"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulClientKeyFlag,
+			Usage:       "Path to an unencrypted PEM encoded private key matching the client certificate",
+			Sources:     cli.EnvVars("CONSUL_CLIENT_KEY"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulTLSServerNameFlag,
+			Usage:       "The server name to use as the SNI host when connecting via TLS",
+			Sources:     cli.EnvVars("CONSUL_TLS_SERVER_NAME"),
+		},
+		&cli.BoolFlag{
+			HideDefault: true,
+			Name:        storeConsulSkipVerifyFlag,
+			Usage:       "Do not verify the Consul agent TLS certificate",
+			Sources:     cli.EnvVars("SMUGGLE_STORE_CONSUL_SKIP_VERIFY"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeFilePathFlag,
+			Usage:       "The directory to use when storing network configuration in local files",
+			Sources:     cli.EnvVars("SMUGGLE_STORE_FILE_PATH"),
+		},
 	}
 }
 
@@ -95,5 +222,102 @@ func StoreConfigFromCommand(cmd *cli.Command) *StoreConfig {
 		NVar: &StoreNVarConfig{
 			Path: cmd.String(storeNVarPathFlag),
 		},
+		Consul: &StoreConsulConfig{
+			Address:       cmd.String(storeConsulAddrFlag),
+			Token:         cmd.String(storeConsulTokenFlag),
+			Path:          cmd.String(storeConsulPathFlag),
+			CACert:        cmd.String(storeConsulCACertFlag),
+			CAPath:        cmd.String(storeConsulCAPathFlag),
+			ClientCert:    cmd.String(storeConsulClientCertFlag),
+			ClientKey:     cmd.String(storeConsulClientKeyFlag),
+			TLSServerName: cmd.String(storeConsulTLSServerNameFlag),
+			SkipVerify: func() *bool {
+				if cmd.IsSet(storeConsulSkipVerifyFlag) {
+					val := cmd.Bool(storeConsulSkipVerifyFlag)
+					return &val
+				}
+				return nil
+			}(),
+		},
+		File: &StoreFileConfig{
+			Path: cmd.String(storeFilePathFlag),
+		},
+	}
+}
+
+func (c *StoreConsulConfig) Merge(other *StoreConsulConfig) *StoreConsulConfig {
+	if c == nil {
+		return other
+	}
+	if other == nil {
+		return c
+	}
+
+	result := *c
+
+	if other.Address != "" {
+		result.Address = other.Address
+	}
+	if other.Token != "" {
+		result.Token = other.Token
+	}
+	if other.Path != "" {
+		result.Path = other.Path
+	}
+	if other.CACert != "" {
+		result.CACert = other.CACert
+	}
+	if other.CAPath != "" {
+		result.CAPath = other.CAPath
 	}
+	if other.ClientCert != "" {
+		result.ClientCert = other.ClientCert
+	}
+	if other.ClientKey != "" {
+		result.ClientKey = other.ClientKey
+	}
+	if other.TLSServerName != "" {
+		result.TLSServerName = other.TLSServerName
+	}
+	if other.SkipVerify != nil {
+		result.SkipVerify = other.SkipVerify
+	}
+
+	return &result
+}
+
+// ConsulClient creates a Consul API client from the passed configuration.
+// Unset options fall back to the Consul API defaults, which include reading
+// the standard Consul environment variables.
+func ConsulClient(cfg *StoreConsulConfig) (*consulapi.Client, error) {
+
+	consulConfig := consulapi.DefaultConfig()
+	if cfg != nil {
+		if cfg.Address != "" {
+			consulConfig.Address = cfg.Address
+		}
+		if cfg.Token != "" {
+			consulConfig.Token = cfg.Token
+		}
+		if cfg.CACert != "" {
+			consulConfig.TLSConfig.CAFile = cfg.CACert
+		}
+		if cfg.CAPath != "" {
+			consulConfig.TLSConfig.CAPath = cfg.CAPath
+		}
+		if cfg.ClientCert != "" {
+			consulConfig.TLSConfig.CertFile = cfg.ClientCert
+		}
+		if cfg.ClientKey != "" {
+			consulConfig.TLSConfig.KeyFile = cfg.ClientKey
+		}
+		if cfg.TLSServerName != "" {
+			consulConfig.TLSConfig.Address = cfg.TLSServerName
+		}
+		if cfg.SkipVerify != nil {
+			consulConfig.TLSConfig.InsecureSkipVerify = *cfg.SkipVerify
+		}
+	}
+
+	return consulapi.NewClient(consulConfig)
 }
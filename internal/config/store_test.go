@@ -5,6 +5,8 @@ import (
 
 	"github.com/shoenig/test/must"
 	"github.com/urfave/cli/v3"
+
+	"github.com/rasorp/smuggle/internal/helper"
 )
 
 func Test_DefaultStoreConfig(t *testing.T) {
@@ -13,6 +15,12 @@ func Test_DefaultStoreConfig(t *testing.T) {
 	must.Eq(t, "nvar", cfg.Backend)
 	must.NotNil(t, cfg.NVar)
 	must.Eq(t, "smuggle/", cfg.NVar.Path)
+	must.NotNil(t, cfg.Consul)
+	must.Eq(t, "http://localhost:8500", cfg.Consul.Address)
+	must.Eq(t, "smuggle/", cfg.Consul.Path)
+	must.False(t, *cfg.Consul.SkipVerify)
+	must.NotNil(t, cfg.File)
+	must.Eq(t, "/var/lib/smuggle/store", cfg.File.Path)
 }
 
 func TestStoreConfig_Merge(t *testing.T) {
@@ -81,6 +89,56 @@ func TestStoreConfig_Merge(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "consul",
+			base: DefaultStoreConfig(),
+			other: &StoreConfig{
+				Backend: "consul",
+				Consul: &StoreConsulConfig{
+					Address: "https://consul.example.com:8501",
+					Token:   "my-token",
+				},
+			},
+			expected: &StoreConfig{
+				Backend: "consul",
+				NVar: &StoreNVarConfig{
+					Path: "smuggle/",
+				},
+				Consul: &StoreConsulConfig{
+					Address:    "https://consul.example.com:8501",
+					Token:      "my-token",
+					Path:       "smuggle/",
+					SkipVerify: helper.PointerOf(false),
+				},
+				File: &StoreFileConfig{
+					Path: "/var/lib/smuggle/store",
+				},
+			},
+		},
+		{
+			name: "file",
+			base: DefaultStoreConfig(),
+			other: &StoreConfig{
+				Backend: "file",
+				File: &StoreFileConfig{
+					Path: "/tmp/smuggle",
+				},
+			},
+			expected: &StoreConfig{
+				Backend: "file",
+				NVar: &StoreNVarConfig{
+					Path: "smuggle/",
+				},
+				Consul: &StoreConsulConfig{
+					Address:    "http://localhost:8500",
+					Path:       "smuggle/",
+					SkipVerify: helper.PointerOf(false),
+				},
+				File: &StoreFileConfig{
+					Path: "/tmp/smuggle",
+				},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -116,6 +174,58 @@ func TestStoreConfig_Validate(t *testing.T) {
 			},
 			expectedError: true,
 		},
+		{
+			name: "valid consul config",
+			config: &StoreConfig{
+				Backend: "consul",
+				Consul: &StoreConsulConfig{
+					Path: "smuggle/",
+				},
+			},
+			expectedError: false,
+		},
+		{
+			name: "missing consul config",
+			config: &StoreConfig{
+				Backend: "consul",
+			},
+			expectedError: true,
+		},
+		{
+			name: "empty consul path",
+			config: &StoreConfig{
+				Backend: "consul",
+				Consul:  &StoreConsulConfig{},
+			},
+			expectedError: true,
+		},
+		{
+			name: "valid file config",
+			config: &StoreConfig{
+				Backend: "file",
+				File: &StoreFileConfig{
+					Path: "/var/lib/smuggle/store",
+				},
+			},
+			expectedError: false,
+		},
+		{
+			name: "missing file config",
+			config: &StoreConfig{
+				Backend: "file",
+			},
+			expectedError: true,
+		},
+		{
+			name: "relative file path",
+			config: &StoreConfig{
+				Backend: "file",
+				File: &StoreFileConfig{
+					Path: "smuggle/store",
+				},
+			},
+			expectedError: true,
+		},
 		{
 			name: "unsupported backend",
 			config: &StoreConfig{
@@ -151,6 +261,66 @@ func Test_StoreConfigCommandFlags(t *testing.T) {
 			Usage:       "The path prefix to use when storing network configuration in Nomad variables",
 			Sources:     cli.EnvVars("SMUGGLE_STORE_NVAR_PATH"),
 		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulAddrFlag,
+			Usage:       "The Consul agent address",
+			Sources:     cli.EnvVars("CONSUL_HTTP_ADDR"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulTokenFlag,
+			Usage:       "The Consul ACL token to use for HTTP requests",
+			Sources:     cli.EnvVars("CONSUL_HTTP_TOKEN"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulPathFlag,
+			Usage:       "The key prefix to use when storing network configuration in Consul KV",
+			Sources:     cli.EnvVars("SMUGGLE_STORE_CONSUL_PATH"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulCACertFlag,
+			Usage:       "Path to a PEM encoded CA cert file to use to verify the Consul agent SSL certificate",
+			Sources:     cli.EnvVars("CONSUL_CACERT"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulCAPathFlag,
+			Usage:       "Path to a directory of PEM encoded CA cert files to verify the Consul agent SSL certificate",
+			Sources:     cli.EnvVars("CONSUL_CAPATH"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulClientCertFlag,
+			Usage:       "Path to a PEM encoded client certificate for TLS authentication to the Consul agent",
+			Sources:     cli.EnvVars("CONSUL_CLIENT_CERT"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulClientKeyFlag,
+			Usage:       "Path to an unencrypted PEM encoded private key matching the client certificate",
+			Sources:     cli.EnvVars("CONSUL_CLIENT_KEY"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeConsulTLSServerNameFlag,
+			Usage:       "The server name to use as the SNI host when connecting via TLS",
+			Sources:     cli.EnvVars("CONSUL_TLS_SERVER_NAME"),
+		},
+		&cli.BoolFlag{
+			HideDefault: true,
+			Name:        storeConsulSkipVerifyFlag,
+			Usage:       "Do not verify the Consul agent TLS certificate",
+			Sources:     cli.EnvVars("SMUGGLE_STORE_CONSUL_SKIP_VERIFY"),
+		},
+		&cli.StringFlag{
+			HideDefault: true,
+			Name:        storeFilePathFlag,
+			Usage:       "The directory to use when storing network configuration in local files",
+			Sources:     cli.EnvVars("SMUGGLE_STORE_FILE_PATH"),
+		},
 	}
 	must.Eq(t, expectedFlags, StoreConfigCommandFlags())
 }
@@ -167,6 +337,8 @@ func Test_StoreConfigFromCommand(t *testing.T) {
 			expected: &StoreConfig{
 				Backend: "",
 				NVar:    &StoreNVarConfig{},
+				Consul:  &StoreConsulConfig{},
+				File:    &StoreFileConfig{},
 			},
 		},
 		{
@@ -174,12 +346,24 @@ func Test_StoreConfigFromCommand(t *testing.T) {
 			setFlags: func(cmd *cli.Command) {
 				must.NoError(t, cmd.Set(storeBackendFlag, "nvar"))
 				must.NoError(t, cmd.Set(storeNVarPathFlag, "my-path"))
+				must.NoError(t, cmd.Set(storeConsulAddrFlag, "http://127.0.0.1:8500"))
+				must.NoError(t, cmd.Set(storeConsulPathFlag, "my-consul-path"))
+				must.NoError(t, cmd.Set(storeConsulSkipVerifyFlag, "true"))
+				must.NoError(t, cmd.Set(storeFilePathFlag, "/tmp/smuggle"))
 			},
 			expected: &StoreConfig{
 				Backend: "nvar",
 				NVar: &StoreNVarConfig{
 					Path: "my-path",
 				},
+				Consul: &StoreConsulConfig{
+					Address:    "http://127.0.0.1:8500",
+					Path:       "my-consul-path",
+					SkipVerify: helper.PointerOf(true),
+				},
+				File: &StoreFileConfig{
+					Path: "/tmp/smuggle",
+				},
 			},
 		},
 	}
@@ -8,3 +8,11 @@ func FormatKV(in []string) string {
 	columnConf.Glue = " = "
 	return columnize.Format(in, columnConf)
 }
+
+// FormatList formats the pipe separated rows as a table, where the first row
+// is the header.
+func FormatList(in []string) string {
+	columnConf := columnize.DefaultConfig()
+	columnConf.Empty = "<none>"
+	return columnize.Format(in, columnConf)
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rasorp/smuggle/internal/log"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// LocalClient is implemented by the client and provides the state of the
+// networks configured by the local agent.
+type LocalClient interface {
+	Leases() []*types.SubnetLease
+	LocalRoutes() ([]*types.LocalRoutes, error)
+}
+
+type endpointLocal struct {
+	logger *log.Logger
+	client LocalClient
+}
+
+func (e *endpointLocal) registerLocalRoutes(r chi.Router) {
+	r.Route("/local", func(r chi.Router) {
+		r.Get("/leases", e.getLeases)
+		r.Get("/routes", e.getRoutes)
+	})
+}
+
+type GetLocalLeasesReq struct{}
+
+type GetLocalLeasesResp struct {
+	Leases []*types.SubnetLease `json:"leases"`
+}
+
+func (e *endpointLocal) getLeases(w http.ResponseWriter, _ *http.Request) {
+	respondJSON(e.logger, w, http.StatusOK, GetLocalLeasesResp{Leases: e.client.Leases()})
+}
+
+type GetLocalRoutesReq struct{}
+
+type GetLocalRoutesResp struct {
+	Networks []*types.LocalRoutes `json:"networks"`
+}
+
+func (e *endpointLocal) getRoutes(w http.ResponseWriter, _ *http.Request) {
+	routes, err := e.client.LocalRoutes()
+	if err != nil {
+		respondError(e.logger, w, http.StatusInternalServerError, fmt.Errorf("failed to list routes: %w", err))
+		return
+	}
+
+	respondJSON(e.logger, w, http.StatusOK, GetLocalRoutesResp{Networks: routes})
+}
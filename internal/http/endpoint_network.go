@@ -0,0 +1,120 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/rasorp/smuggle/internal/log"
+	"github.com/rasorp/smuggle/internal/store"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+type endpointNetwork struct {
+	logger *log.Logger
+	store  types.Store
+}
+
+func (e *endpointNetwork) registerNetworkRoutes(r chi.Router) {
+	r.Route("/networks", func(r chi.Router) {
+		r.Get("/", e.listNetworks)
+		r.Get("/{name}/subnets", e.listNetworkSubnets)
+	})
+	r.Route("/subnets", func(r chi.Router) {
+		r.Get("/{client_id}", e.getClientSubnets)
+	})
+}
+
+type GetNetworksReq struct{}
+
+type GetNetworksResp struct {
+	Networks []*types.Network `json:"networks"`
+}
+
+func (e *endpointNetwork) listNetworks(w http.ResponseWriter, _ *http.Request) {
+	resp, err := e.store.ListNetworks(&types.StoreGetNetworksReq{})
+	if err != nil {
+		respondError(e.logger, w, http.StatusInternalServerError, fmt.Errorf("failed to list networks: %w", err))
+		return
+	}
+
+	networks := resp.Networks
+	if networks == nil {
+		networks = []*types.Network{}
+	}
+
+	respondJSON(e.logger, w, http.StatusOK, GetNetworksResp{Networks: networks})
+}
+
+type GetNetworkSubnetsReq struct {
+	Name string
+}
+
+type GetNetworkSubnetsResp struct {
+	Subnets []*types.Subnet `json:"subnets"`
+}
+
+func (e *endpointNetwork) listNetworkSubnets(w http.ResponseWriter, r *http.Request) {
+	req := GetNetworkSubnetsReq{Name: chi.URLParam(r, "name")}
+
+	networksResp, err := e.store.ListNetworks(&types.StoreGetNetworksReq{})
+	if err != nil {
+		respondError(e.logger, w, http.StatusInternalServerError, fmt.Errorf("failed to list networks: %w", err))
+		return
+	}
+
+	if !containsNetwork(networksResp.Networks, req.Name) {
+		respondError(e.logger, w, http.StatusNotFound, fmt.Errorf("network %q not found", req.Name))
+		return
+	}
+
+	subnetsResp, err := e.store.ListSubnets(&types.StoreListSubnetsReq{Network: req.Name})
+	if err != nil {
+		respondError(e.logger, w, http.StatusInternalServerError, fmt.Errorf("failed to list subnets: %w", err))
+		return
+	}
+
+	subnets := subnetsResp.Subnets
+	if subnets == nil {
+		subnets = []*types.Subnet{}
+	}
+
+	respondJSON(e.logger, w, http.StatusOK, GetNetworkSubnetsResp{Subnets: subnets})
+}
+
+type GetClientSubnetsReq struct {
+	ClientID string
+}
+
+type GetClientSubnetsResp struct {
+	Subnets []*types.Subnet `json:"subnets"`
+}
+
+// getClientSubnets returns the subnet of the client within each network,
+// ordered by network name.
+func (e *endpointNetwork) getClientSubnets(w http.ResponseWriter, r *http.Request) {
+	req := GetClientSubnetsReq{ClientID: chi.URLParam(r, "client_id")}
+
+	subnets, err := store.ClientSubnets(e.store, req.ClientID, "")
+	if err != nil {
+		respondError(e.logger, w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(subnets) == 0 {
+		respondError(e.logger, w, http.StatusNotFound, fmt.Errorf("no subnets found for client %q", req.ClientID))
+		return
+	}
+
+	respondJSON(e.logger, w, http.StatusOK, GetClientSubnetsResp{Subnets: subnets})
+}
+
+func containsNetwork(networks []*types.Network, name string) bool {
+	for _, network := range networks {
+		if network.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -1,17 +1,21 @@
 package http
 
 import (
-	"encoding/json"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
-	"go.uber.org/zap"
 
 	"github.com/rasorp/smuggle/internal/log"
 )
 
+// LocalServer is implemented by the server and provides its leadership state.
+type LocalServer interface {
+	IsLeader() bool
+}
+
 type endpointSystem struct {
 	logger *log.Logger
+	server LocalServer
 }
 
 func (e *endpointSystem) registerSystemRoutes(r chi.Router) {
@@ -25,6 +29,10 @@ type GetSystemHealthReq struct{}
 type GetSystemHealthResp struct {
 	Status  string `json:"status"`
 	Message string `json:"message"`
+
+	// Leader indicates whether the local server is the leader, and is omitted
+	// when the server is not enabled.
+	Leader *bool `json:"leader,omitempty"`
 }
 
 func (e *endpointSystem) getHealth(w http.ResponseWriter, _ *http.Request) {
@@ -33,10 +41,10 @@ func (e *endpointSystem) getHealth(w http.ResponseWriter, _ *http.Request) {
 		Message: "Smuggle agent is healthy",
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		e.logger.Error("failed to encode health response", zap.Error(err))
+	if e.server != nil {
+		leader := e.server.IsLeader()
+		response.Leader = &leader
 	}
+
+	respondJSON(e.logger, w, http.StatusOK, response)
 }
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/log"
+)
+
+// ErrorResp is the response body returned by endpoints when a request fails.
+type ErrorResp struct {
+	Error string `json:"error"`
+}
+
+// respondJSON writes the passed object as the JSON response body with the
+// passed status code.
+func respondJSON(logger *log.Logger, w http.ResponseWriter, status int, obj any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		logger.Error("failed to encode response", zap.Error(err))
+	}
+}
+
+// respondError writes the error as the JSON response body with the passed
+// status code.
+func respondError(logger *log.Logger, w http.ResponseWriter, status int, err error) {
+	respondJSON(logger, w, status, ErrorResp{Error: err.Error()})
+}
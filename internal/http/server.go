@@ -8,10 +8,13 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 
 	"github.com/rasorp/smuggle/internal/config"
 	"github.com/rasorp/smuggle/internal/log"
+	"github.com/rasorp/smuggle/internal/metrics"
+	"github.com/rasorp/smuggle/internal/types"
 )
 
 // Server represents an HTTP server for the smuggle agent
@@ -19,14 +22,44 @@ type Server struct {
 	cfg    *config.HTTPConfig
 	logger *log.Logger
 	server *http.Server
+	store  types.Store
+	client LocalClient
+
+	localServer LocalServer
+}
+
+// ServerReq contains the parameters for creating a new HTTP server.
+type ServerReq struct {
+	Config *config.HTTPConfig
+	Logger *zap.Logger
+
+	// Store is used to read the networks and subnets. If nil, the network
+	// endpoints are not registered.
+	Store types.Store
+
+	// Client provides the state of the local networks. It is nil when the
+	// client is not enabled, in which case the local endpoints are not
+	// registered.
+	Client LocalClient
+
+	// Server provides the leadership state of the local server. It is nil
+	// when the server is not enabled, in which case the health response does
+	// not include it.
+	Server LocalServer
 }
 
 // New creates a new HTTP server
-func New(cfg *config.HTTPConfig, logger *zap.Logger) *Server {
+func New(req *ServerReq) *Server {
+
+	cfg := req.Config
 
 	s := &Server{
 		cfg:    cfg,
-		logger: logger.Named(log.ComponentNameHTTP),
+		logger: req.Logger.Named(log.ComponentNameHTTP),
+		store:  req.Store,
+		client: req.Client,
+
+		localServer: req.Server,
 	}
 
 	s.server = &http.Server{
@@ -51,9 +84,24 @@ func (s *Server) setupRouter() *chi.Mux {
 	r.Route("/v1", func(r chi.Router) {
 
 		s.logger.Debug("setting up system endpoint routes")
-		healthEndpoint := &endpointSystem{logger: s.logger}
+		healthEndpoint := &endpointSystem{logger: s.logger, server: s.localServer}
 		healthEndpoint.registerSystemRoutes(r)
 
+		s.logger.Debug("setting up metrics endpoint route")
+		r.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+
+		if s.store != nil {
+			s.logger.Debug("setting up network endpoint routes")
+			networkEndpoint := &endpointNetwork{logger: s.logger, store: s.store}
+			networkEndpoint.registerNetworkRoutes(r)
+		}
+
+		if s.client != nil {
+			s.logger.Debug("setting up local endpoint routes")
+			localEndpoint := &endpointLocal{logger: s.logger, client: s.client}
+			localEndpoint.registerLocalRoutes(r)
+		}
+
 		// If the operator has enabled debug endpoints, set up the Chi
 		// middleware that handles this.
 		if s.cfg.IsDebugEnabled() {
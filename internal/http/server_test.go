@@ -0,0 +1,175 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shoenig/test/must"
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/api"
+	"github.com/rasorp/smuggle/internal/config"
+	"github.com/rasorp/smuggle/internal/store/memory"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// testLocalClient is a LocalClient which returns fixed state.
+type testLocalClient struct {
+	leases    []*types.SubnetLease
+	routes    []*types.LocalRoutes
+	routesErr error
+}
+
+func (c *testLocalClient) Leases() []*types.SubnetLease { return c.leases }
+
+func (c *testLocalClient) LocalRoutes() ([]*types.LocalRoutes, error) { return c.routes, c.routesErr }
+
+// testLocalServer is a LocalServer which returns a fixed leadership state.
+type testLocalServer struct {
+	leader bool
+}
+
+func (s *testLocalServer) IsLeader() bool { return s.leader }
+
+// testAPIClient starts the HTTP server using the passed store and local
+// client and returns an API client pointing at it.
+func testAPIClient(t *testing.T, store types.Store, client LocalClient) *api.Client {
+	t.Helper()
+
+	s := New(&ServerReq{
+		Config: config.DefaultHTTPConfig(),
+		Logger: zap.NewNop(),
+		Store:  store,
+		Client: client,
+	})
+
+	srv := httptest.NewServer(s.server.Handler)
+	t.Cleanup(srv.Close)
+
+	apiClient, err := api.NewClient(&api.Config{Address: srv.URL})
+	must.NoError(t, err)
+
+	return apiClient
+}
+
+func TestServer_networkEndpoints(t *testing.T) {
+	store := memory.New()
+	apiClient := testAPIClient(t, store, nil)
+
+	// Listing networks when none exist should return an empty list.
+	networks, err := apiClient.ListNetworks()
+	must.NoError(t, err)
+	must.SliceEmpty(t, networks)
+
+	var network types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`,
+	), &network))
+	_, err = store.SetNetwork(&types.StoreSetNetworkReq{Network: &network})
+	must.NoError(t, err)
+
+	networks, err = apiClient.ListNetworks()
+	must.NoError(t, err)
+	must.Len(t, 1, networks)
+	must.Eq(t, "vxlan", networks[0].Name)
+	must.Eq(t, "10.10.0.0/16", networks[0].IPv4.Network)
+
+	var subnet types.Subnet
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"client_id":"client-1","network_name":"vxlan","ipv4_network":"10.10.1.0/24"}`,
+	), &subnet))
+	_, err = store.SetSubnet(&types.StoreSetSubnetReq{Subnet: &subnet})
+	must.NoError(t, err)
+
+	subnets, err := apiClient.ListNetworkSubnets("vxlan")
+	must.NoError(t, err)
+	must.Len(t, 1, subnets)
+	must.Eq(t, "10.10.1.0/24", subnets[0].IPv4Network)
+
+	subnets, err = apiClient.GetClientSubnets("client-1")
+	must.NoError(t, err)
+	must.Len(t, 1, subnets)
+	must.Eq(t, "client-1", subnets[0].ClientID)
+
+	// Unknown networks and clients should return not found errors.
+	_, err = apiClient.ListNetworkSubnets("unknown")
+	must.True(t, api.IsNotFound(err))
+
+	_, err = apiClient.GetClientSubnets("client-2")
+	must.True(t, api.IsNotFound(err))
+
+	// Store failures should be returned as server errors.
+	store.FailNext(memory.OperationListNetworks, 1, nil)
+
+	_, err = apiClient.ListNetworks()
+	var respErr *api.ResponseError
+	must.True(t, errors.As(err, &respErr))
+	must.Eq(t, 500, respErr.StatusCode)
+	must.StrContains(t, respErr.Message, memory.ErrInjected.Error())
+
+	// The local endpoints should not be registered without a client.
+	_, err = apiClient.LocalLeases()
+	must.True(t, api.IsNotFound(err))
+}
+
+func TestServer_localEndpoints(t *testing.T) {
+	client := &testLocalClient{
+		leases: []*types.SubnetLease{{ClientID: "client-1", NetworkName: "vxlan"}},
+		routes: []*types.LocalRoutes{{
+			NetworkName: "vxlan",
+			Provider:    types.ProviderNameVXLAN,
+			Routes:      []*types.RouteEntry{{Destination: "10.10.2.0/24", Gateway: "10.10.2.1", Interface: "vxlan0"}},
+			FDB:         []*types.FDBEntry{{MAC: "aa:bb:cc:dd:ee:ff", Destination: "192.168.1.2"}},
+			Neighbors:   []*types.NeighborEntry{{IP: "10.10.2.1", MAC: "aa:bb:cc:dd:ee:ff"}},
+		}},
+	}
+
+	apiClient := testAPIClient(t, memory.New(), client)
+
+	leases, err := apiClient.LocalLeases()
+	must.NoError(t, err)
+	must.Len(t, 1, leases)
+	must.Eq(t, "vxlan", leases[0].NetworkName)
+
+	routes, err := apiClient.LocalRoutes()
+	must.NoError(t, err)
+	must.Len(t, 1, routes)
+	must.Eq(t, "10.10.2.0/24", routes[0].Routes[0].Destination)
+	must.Eq(t, "192.168.1.2", routes[0].FDB[0].Destination)
+	must.Eq(t, "aa:bb:cc:dd:ee:ff", routes[0].Neighbors[0].MAC)
+
+	client.routesErr = errors.New("netlink failure")
+
+	_, err = apiClient.LocalRoutes()
+	must.ErrorContains(t, err, "netlink failure")
+}
+
+func TestServer_healthEndpoint(t *testing.T) {
+
+	// Without a server, the leadership state should be omitted.
+	health, err := testAPIClient(t, nil, nil).Health()
+	must.NoError(t, err)
+	must.Eq(t, "OK", health.Status)
+	must.Nil(t, health.Leader)
+
+	for _, leader := range []bool{true, false} {
+		s := New(&ServerReq{
+			Config: config.DefaultHTTPConfig(),
+			Logger: zap.NewNop(),
+			Server: &testLocalServer{leader: leader},
+		})
+
+		srv := httptest.NewServer(s.server.Handler)
+		t.Cleanup(srv.Close)
+
+		apiClient, err := api.NewClient(&api.Config{Address: srv.URL})
+		must.NoError(t, err)
+
+		health, err := apiClient.Health()
+		must.NoError(t, err)
+		must.NotNil(t, health.Leader)
+		must.Eq(t, leader, *health.Leader)
+	}
+}
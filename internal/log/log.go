@@ -14,6 +14,7 @@ const (
 	ComponentNameHTTP     = "http"
 	ComponentNameNetwork  = "network"
 	ComponentNameIptables = "store"
+	ComponentNameNftables = "nftables"
 )
 
 // Logger is an alias for zap.Logger which simplifies imports as all log
@@ -0,0 +1,164 @@
+// Package metrics defines the Prometheus metrics exposed by the Smuggle agent.
+// The metrics are registered with a dedicated registry, rather than the
+// Prometheus default, so only Smuggle and Go runtime metrics are exposed.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "smuggle"
+
+const (
+	// ResultSuccess and ResultFailure are the values of the result label.
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+
+	// WatcherNetworks and WatcherSubnets are the values of the watcher label.
+	WatcherNetworks = "networks"
+	WatcherSubnets  = "subnets"
+)
+
+// Registry contains all the metrics exposed by the agent.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// SubnetsAllocated and SubnetsFree are the number of IPv4 subnets which
+	// are allocated and available within each network. They are updated by
+	// the server reaper on each run.
+	SubnetsAllocated = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "network",
+		Name:      "subnets_allocated",
+		Help:      "Number of IPv4 subnets allocated within the network.",
+	}, []string{"network"})
+
+	SubnetsFree = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "network",
+		Name:      "subnets_free",
+		Help:      "Number of IPv4 subnets available for allocation within the network.",
+	}, []string{"network"})
+
+	// SubnetClaimConflicts is the number of times the client attempted to
+	// claim a subnet CIDR which was already claimed by another client.
+	SubnetClaimConflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "client",
+		Name:      "subnet_claim_conflicts_total",
+		Help:      "Number of subnet claims which conflicted with another client.",
+	}, []string{"network"})
+
+	// Heartbeats is the number of subnet lease renewals, partitioned by
+	// result.
+	Heartbeats = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "client",
+		Name:      "heartbeats_total",
+		Help:      "Number of subnet lease renewals.",
+	}, []string{"network", "result"})
+
+	// WatcherErrors is the number of errors received from the store watchers.
+	WatcherErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "client",
+		Name:      "watcher_errors_total",
+		Help:      "Number of errors received from store watchers.",
+	}, []string{"watcher"})
+
+	// ReaperRuns, ReaperExpired and ReaperDeleted track the work performed by
+	// the server reaper.
+	ReaperRuns = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "server",
+		Name:      "reaper_runs_total",
+		Help:      "Number of subnet reaper runs.",
+	})
+
+	ReaperExpired = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "server",
+		Name:      "reaper_subnets_expired_total",
+		Help:      "Number of subnets marked as expired by the reaper.",
+	}, []string{"network"})
+
+	ReaperDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "server",
+		Name:      "reaper_subnets_deleted_total",
+		Help:      "Number of expired subnets deleted by the reaper.",
+	}, []string{"network"})
+
+	// ProviderDuration and ProviderErrors track the calls made to network
+	// providers to program remote subnets.
+	ProviderDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "provider",
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of network provider operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider", "operation"})
+
+	ProviderErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "provider",
+		Name:      "operation_errors_total",
+		Help:      "Number of network provider operations which failed.",
+	}, []string{"provider", "operation"})
+
+	// StoreDuration and StoreErrors track the calls made to the store.
+	StoreDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "store",
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of store operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	StoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "store",
+		Name:      "operation_errors_total",
+		Help:      "Number of store operations which failed.",
+	}, []string{"operation"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		SubnetsAllocated,
+		SubnetsFree,
+		SubnetClaimConflicts,
+		Heartbeats,
+		WatcherErrors,
+		ReaperRuns,
+		ReaperExpired,
+		ReaperDeleted,
+		ProviderDuration,
+		ProviderErrors,
+		StoreDuration,
+		StoreErrors,
+	)
+}
+
+// ObserveProvider records the latency and result of a network provider
+// operation which started at the passed time.
+func ObserveProvider(provider, operation string, start time.Time, err error) {
+	ProviderDuration.WithLabelValues(provider, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ProviderErrors.WithLabelValues(provider, operation).Inc()
+	}
+}
+
+// ObserveStore records the latency and result of a store operation which
+// started at the passed time.
+func ObserveStore(operation string, start time.Time, err error) {
+	StoreDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		StoreErrors.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// Store wraps a types.Store and records the latency and errors of each call.
+// Watch calls only record establishing the watch, not the updates received.
+type Store struct {
+	store types.Store
+}
+
+// NewStore returns an instrumented wrapper around the passed store.
+func NewStore(store types.Store) types.Store { return &Store{store: store} }
+
+func (s *Store) ListNetworks(req *types.StoreGetNetworksReq) (*types.StoreGetNetworksResp, error) {
+	start := time.Now()
+	resp, err := s.store.ListNetworks(req)
+	ObserveStore("ListNetworks", start, err)
+	return resp, err
+}
+
+func (s *Store) WatchNetworks(req *types.StoreWatchNetworksReq) (*types.StoreWatchNetworksResp, error) {
+	start := time.Now()
+	resp, err := s.store.WatchNetworks(req)
+	ObserveStore("WatchNetworks", start, err)
+	return resp, err
+}
+
+func (s *Store) SetNetwork(req *types.StoreSetNetworkReq) (*types.StoreSetNetworkResp, error) {
+	start := time.Now()
+	resp, err := s.store.SetNetwork(req)
+	ObserveStore("SetNetwork", start, err)
+	return resp, err
+}
+
+func (s *Store) DeleteNetwork(req *types.StoreDeleteNetworkReq) (*types.StoreDeleteNetworkResp, error) {
+	start := time.Now()
+	resp, err := s.store.DeleteNetwork(req)
+	ObserveStore("DeleteNetwork", start, err)
+	return resp, err
+}
+
+func (s *Store) ListSubnets(req *types.StoreListSubnetsReq) (*types.StoreListSubnetsResp, error) {
+	start := time.Now()
+	resp, err := s.store.ListSubnets(req)
+	ObserveStore("ListSubnets", start, err)
+	return resp, err
+}
+
+func (s *Store) DeleteSubnet(req *types.StoreDeleteSubnetReq) (*types.StoreDeleteSubnetResp, error) {
+	start := time.Now()
+	resp, err := s.store.DeleteSubnet(req)
+	ObserveStore("DeleteSubnet", start, err)
+	return resp, err
+}
+
+func (s *Store) GetSubnet(req *types.StoreGetSubnetReq) (*types.StoreGetSubnetResp, error) {
+	start := time.Now()
+	resp, err := s.store.GetSubnet(req)
+	ObserveStore("GetSubnet", start, err)
+	return resp, err
+}
+
+func (s *Store) SetSubnet(req *types.StoreSetSubnetReq) (*types.StoreSetSubnetResp, error) {
+	start := time.Now()
+	resp, err := s.store.SetSubnet(req)
+	ObserveStore("SetSubnet", start, err)
+	return resp, err
+}
+
+func (s *Store) ClaimSubnet(req *types.StoreClaimSubnetReq) (*types.StoreClaimSubnetResp, error) {
+	start := time.Now()
+	resp, err := s.store.ClaimSubnet(req)
+
+	// A conflict is an expected outcome of the claim, which the client counts
+	// separately, rather than a failure of the store.
+	if errors.Is(err, types.ErrSubnetConflict) {
+		ObserveStore("ClaimSubnet", start, nil)
+	} else {
+		ObserveStore("ClaimSubnet", start, err)
+	}
+	return resp, err
+}
+
+func (s *Store) WatchSubnets(req *types.StoreWatchSubnetsReq) (*types.StoreWatchSubnetsResp, error) {
+	start := time.Now()
+	resp, err := s.store.WatchSubnets(req)
+	ObserveStore("WatchSubnets", start, err)
+	return resp, err
+}
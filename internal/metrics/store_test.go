@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shoenig/test/must"
+
+	"github.com/rasorp/smuggle/internal/store/memory"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func TestStore(t *testing.T) {
+	backend := memory.New()
+	store := NewStore(backend)
+
+	errorsBefore := testutil.ToFloat64(StoreErrors.WithLabelValues("ListNetworks"))
+
+	_, err := store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.Eq(t, errorsBefore, testutil.ToFloat64(StoreErrors.WithLabelValues("ListNetworks")))
+
+	// Errors from the wrapped store should be returned and counted.
+	backend.FailNext(memory.OperationListNetworks, 1, nil)
+
+	_, err = store.ListNetworks(nil)
+	must.ErrorIs(t, err, memory.ErrInjected)
+	must.Eq(t, errorsBefore+1, testutil.ToFloat64(StoreErrors.WithLabelValues("ListNetworks")))
+
+	// Claim conflicts should be returned but not counted as errors.
+	claimErrorsBefore := testutil.ToFloat64(StoreErrors.WithLabelValues("ClaimSubnet"))
+
+	_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{ClientID: "client-1", NetworkName: "vxlan", CIDR: "10.10.1.0/24"})
+	must.NoError(t, err)
+	_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{ClientID: "client-2", NetworkName: "vxlan", CIDR: "10.10.1.0/24"})
+	must.ErrorIs(t, err, types.ErrSubnetConflict)
+	must.Eq(t, claimErrorsBefore, testutil.ToFloat64(StoreErrors.WithLabelValues("ClaimSubnet")))
+}
@@ -10,6 +10,7 @@ type networkFingerprint struct {
 	ifaceName string
 	iface     *net.Interface
 	ipv4Addr  net.IP
+	ipv6Addr  net.IP
 }
 
 // Fingerprint discovers network interface information and populates an ExternalInterface.
@@ -62,17 +63,29 @@ func fingerprint(interfaceName string) (*networkFingerprint, error) {
 			if extIface.ipv4Addr == nil {
 				extIface.ipv4Addr = ip
 			}
+			continue
+		}
+
+		// Only global unicast IPv6 addresses are usable as tunnel endpoints,
+		// as link-local addresses are not routable between hosts.
+		if ip.IsGlobalUnicast() && extIface.ipv6Addr == nil {
+			extIface.ipv6Addr = ip
 		}
 	}
 
 	// Validate that we found at least one address
-	if extIface.ipv4Addr == nil {
+	if extIface.ipv4Addr == nil && extIface.ipv6Addr == nil {
 		return nil, fmt.Errorf("no valid addresses found on interface %s", iface.Name)
 	}
 
 	return extIface, nil
 }
 
+// defaultRouteProbeAddrs are the public addresses dialed to determine the
+// local address of the default route. The IPv6 address is used when the host
+// has no IPv4 default route, such as in IPv6-only datacenters.
+var defaultRouteProbeAddrs = []string{"8.8.8.8:80", "[2001:4860:4860::8888]:80"}
+
 // getDefaultInterface discovers the default network interface by finding
 // the interface used for the default route.
 func getDefaultInterface() (*net.Interface, error) {
@@ -82,16 +95,11 @@ func getDefaultInterface() (*net.Interface, error) {
 		return nil, fmt.Errorf("failed to list interfaces: %w", err)
 	}
 
-	// Try to find the default interface by attempting to connect to a public IP
-	// This doesn't actually send data, just determines which interface would be used
-	conn, err := net.Dial("udp", "8.8.8.8:80")
-	if err != nil {
+	localIP := defaultRouteLocalIP(net.Dial)
+	if localIP == nil {
 		// If we can't dial out, fall back to finding the first non-loopback interface
 		return getFirstNonLoopbackInterface(interfaces)
 	}
-	defer func() { _ = conn.Close() }()
-
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
 
 	// Find the interface that has this local address
 	for _, iface := range interfaces {
@@ -116,7 +124,7 @@ func getDefaultInterface() (*net.Interface, error) {
 				continue
 			}
 
-			if ipNet.IP.Equal(localAddr.IP) {
+			if ipNet.IP.Equal(localIP) {
 				return &iface, nil
 			}
 		}
@@ -126,6 +134,26 @@ func getDefaultInterface() (*net.Interface, error) {
 	return getFirstNonLoopbackInterface(interfaces)
 }
 
+// defaultRouteLocalIP returns the local address used to reach the first probe
+// address which can be dialed, or nil if none can. Dialing UDP doesn't
+// actually send data, it just determines which address would be used.
+func defaultRouteLocalIP(dial func(network, address string) (net.Conn, error)) net.IP {
+	for _, addr := range defaultRouteProbeAddrs {
+		conn, err := dial("udp", addr)
+		if err != nil {
+			continue
+		}
+
+		localAddr, ok := conn.LocalAddr().(*net.UDPAddr)
+		_ = conn.Close()
+
+		if ok {
+			return localAddr.IP
+		}
+	}
+	return nil
+}
+
 // getFirstNonLoopbackInterface returns the first non-loopback interface that is up
 // and has an assigned IP address.
 func getFirstNonLoopbackInterface(interfaces []net.Interface) (*net.Interface, error) {
@@ -0,0 +1,40 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+// testConn is a connection which only reports its local address.
+type testConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c *testConn) LocalAddr() net.Addr { return c.local }
+func (c *testConn) Close() error        { return nil }
+
+func Test_defaultRouteLocalIP(t *testing.T) {
+
+	// Hosts without an IPv4 default route cannot dial the IPv4 probe address,
+	// so the IPv6 probe address should be used instead.
+	var dialed []string
+
+	dial := func(_, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		if address == defaultRouteProbeAddrs[0] {
+			return nil, errors.New("network is unreachable")
+		}
+		return &testConn{local: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000}}, nil
+	}
+
+	must.Eq(t, net.ParseIP("2001:db8::1"), defaultRouteLocalIP(dial))
+	must.Eq(t, defaultRouteProbeAddrs, dialed)
+
+	// Without any route, no address should be returned.
+	unreachable := func(string, string) (net.Conn, error) { return nil, errors.New("network is unreachable") }
+	must.Nil(t, defaultRouteLocalIP(unreachable))
+}
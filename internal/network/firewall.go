@@ -0,0 +1,15 @@
+//go:build !linux
+
+package network
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func newFirewall(_ *zap.Logger, _ string) (types.Firewall, error) {
+	return nil, errors.New("firewall is only supported on Linux")
+}
@@ -1,6 +1,7 @@
 package iptables
 
 import (
+	"errors"
 	"fmt"
 	"slices"
 
@@ -35,6 +36,11 @@ const (
 type Manager struct {
 	ipt    *iptables.IPTables
 	logger *zap.Logger
+
+	// ipt6 manages the rules for the IPv6 traffic of dual-stack networks. It
+	// is nil if ip6tables is not available on the host, in which case
+	// networks with IPv6 cannot be configured.
+	ipt6 *iptables.IPTables
 }
 
 // New creates a new iptables manager
@@ -44,15 +50,35 @@ func NewManager(logger *zap.Logger) (types.Firewall, error) {
 		return nil, fmt.Errorf("failed to initialize iptables: %w", err)
 	}
 
+	logger = logger.Named(log.ComponentNameIptables)
+
+	ipt6, err := iptables.NewWithProtocol(iptables.ProtocolIPv6)
+	if err != nil {
+		logger.Warn("failed to initialize ip6tables; networks with IPv6 cannot be configured",
+			zap.Error(err))
+		ipt6 = nil
+	}
+
 	return &Manager{
 		ipt:    ipt,
-		logger: logger.Named(log.ComponentNameIptables),
+		ipt6:   ipt6,
+		logger: logger,
 	}, nil
 }
 
+// ip6tables returns the ip6tables handle, or an error if ip6tables is not
+// available on the host.
+func (i *Manager) ip6tables() (*iptables.IPTables, error) {
+	if i.ipt6 == nil {
+		return nil, errors.New("ip6tables is required for networks with IPv6 but is not available")
+	}
+	return i.ipt6, nil
+}
+
 // masqRules generates the iptables rules for masquerading traffic from the
-// network subnet to external destinations.
-func (i *Manager) masqRules(network *types.IPv4Net, subnet *types.IPv4Net) []rule {
+// network subnet to external destinations. The network and subnet must be of
+// the address family managed by the passed handle.
+func masqRules(ipt *iptables.IPTables, networkString, subnetString string) []rule {
 	rules := []rule{
 		// Jump from POSTROUTING to our custom chain so we can manage rules
 		// independently in our own chain and perform this before other firewall
@@ -69,10 +95,7 @@ func (i *Manager) masqRules(network *types.IPv4Net, subnet *types.IPv4Net) []rul
 		},
 	}
 
-	supportsRandomFully := i.ipt.HasRandomFully()
-
-	networkString := network.String()
-	subnetString := subnet.String()
+	supportsRandomFully := ipt.HasRandomFully()
 
 	// NAT traffic from local subnet that's NOT going to the cluster network, so
 	// it can reach the internet.
@@ -108,40 +131,57 @@ func (i *Manager) masqRules(network *types.IPv4Net, subnet *types.IPv4Net) []rul
 	return rules
 }
 
-// SetupMasqRules applies masquerading rules to iptables
+// SetupMasqRules applies masquerading rules to iptables. Dual-stack subnets
+// are masqueraded for both address families.
 func (i *Manager) SetupMasqRules(network *types.Network, subnet *types.Subnet) error {
 
-	ipv4Network := network.IPv4.Network
-	ipv4Subnet := subnet.IPv4Network
+	if err := i.setupMasqRules(i.ipt, network.IPv4.Network.String(), subnet.IPv4Network.String()); err != nil {
+		return err
+	}
+
+	if network.IPv6 == nil || subnet.IPv6Network == nil {
+		return nil
+	}
+
+	ipt6, err := i.ip6tables()
+	if err != nil {
+		return err
+	}
+
+	return i.setupMasqRules(ipt6, network.IPv6.Network.String(), subnet.IPv6Network.String())
+}
+
+// setupMasqRules applies the masquerading rules of a single address family.
+func (i *Manager) setupMasqRules(ipt *iptables.IPTables, networkCIDR, subnetCIDR string) error {
 
 	i.logger.Debug("setting up masquerading rules",
-		zap.String("network_cidr", ipv4Network.String()),
-		zap.String("subnet_cidr", ipv4Subnet.String()),
+		zap.String("network_cidr", networkCIDR),
+		zap.String("subnet_cidr", subnetCIDR),
 	)
 
 	// Ensure the custom chain exists
-	if err := i.ensureChain(natTableName, smugglePostroutingChainName); err != nil {
+	if err := i.ensureChain(ipt, natTableName, smugglePostroutingChainName); err != nil {
 		return fmt.Errorf("failed to ensure chain: %w", err)
 	}
 
 	// Iterate over the rules and apply them. Any error is considered fatal as
 	// we need these rules to be in place for proper networking.
-	for _, rule := range i.masqRules(ipv4Network, ipv4Subnet) {
-		if err := i.applyRule(rule); err != nil {
+	for _, rule := range masqRules(ipt, networkCIDR, subnetCIDR) {
+		if err := i.applyRule(ipt, rule); err != nil {
 			return fmt.Errorf("failed to apply rule: %w", err)
 		}
 	}
 
 	i.logger.Info("successfully set up masquerading rules",
-		zap.String("network_cidr", ipv4Network.String()),
-		zap.String("subnet_cidr", ipv4Subnet.String()),
+		zap.String("network_cidr", networkCIDR),
+		zap.String("subnet_cidr", subnetCIDR),
 	)
 	return nil
 }
 
 // ensureChain ensures an iptables chain exists, creating it if necessary
-func (i *Manager) ensureChain(table, chain string) error {
-	chains, err := i.ipt.ListChains(table)
+func (i *Manager) ensureChain(ipt *iptables.IPTables, table, chain string) error {
+	chains, err := ipt.ListChains(table)
 	if err != nil {
 		return fmt.Errorf("failed to list chains: %w", err)
 	}
@@ -161,7 +201,7 @@ func (i *Manager) ensureChain(table, chain string) error {
 		zap.String("chain", chain),
 	)
 
-	if err := i.ipt.NewChain(table, chain); err != nil {
+	if err := ipt.NewChain(table, chain); err != nil {
 		return fmt.Errorf("failed to create chain: %w", err)
 	}
 
@@ -174,8 +214,8 @@ func (i *Manager) ensureChain(table, chain string) error {
 }
 
 // applyRule ensures an iptables rule exists, adding it if necessary
-func (i *Manager) applyRule(rule rule) error {
-	exists, err := i.ipt.Exists(rule.table, rule.chain, rule.spec...)
+func (i *Manager) applyRule(ipt *iptables.IPTables, rule rule) error {
+	exists, err := ipt.Exists(rule.table, rule.chain, rule.spec...)
 	if err != nil {
 		return fmt.Errorf("failed to check if rule exists: %w", err)
 	}
@@ -187,7 +227,7 @@ func (i *Manager) applyRule(rule rule) error {
 	if !exists {
 		i.logger.Debug("applying iptables rule", loggingPairs...)
 
-		if err := i.ipt.Append(rule.table, rule.chain, rule.spec...); err != nil {
+		if err := ipt.Append(rule.table, rule.chain, rule.spec...); err != nil {
 			return fmt.Errorf("failed to apply rule: %w", err)
 		}
 
@@ -201,7 +241,7 @@ func (i *Manager) applyRule(rule rule) error {
 
 // forwardRules generates iptables rules for forwarding traffic that allows
 // traffic to be forwarded to and from the network range.
-func (i *Manager) forwardRules(networkCIDR, bridgeInterface, networkInterface string) []rule {
+func forwardRules(networkCIDR, bridgeInterface, networkInterface string) []rule {
 	return []rule{
 		// Jump to custom chain to manage forward rules independently. This
 		// ensures Smuggle rules are evaluated before other node firewall rules.
@@ -308,13 +348,40 @@ func (i *Manager) forwardRules(networkCIDR, bridgeInterface, networkInterface st
 	}
 }
 
-// SetupForwardRules applies forward rules to iptables
+// SetupForwardRules applies forward rules to iptables. Dual-stack networks have
+// the rules applied for both address families.
 func (i *Manager) SetupForwardRules(network *types.Network) error {
 
-	cidr := network.IPv4.Network.String()
 	bridgeInterface := network.BridgeInterfaceName()
 	networkInterface := network.InterfaceName()
 
+	// Networks that route directly via the host network send traffic via the
+	// host interface rather than the network interface, so match traffic on
+	// any interface instead. The network CIDR source and destination matches
+	// still apply.
+	if network.RoutesViaHost() {
+		networkInterface = "+"
+	}
+
+	if err := i.setupForwardRules(i.ipt, network.IPv4.Network.String(), bridgeInterface, networkInterface); err != nil {
+		return err
+	}
+
+	if network.IPv6 == nil {
+		return nil
+	}
+
+	ipt6, err := i.ip6tables()
+	if err != nil {
+		return err
+	}
+
+	return i.setupForwardRules(ipt6, network.IPv6.Network.String(), bridgeInterface, networkInterface)
+}
+
+// setupForwardRules applies the forward rules of a single address family.
+func (i *Manager) setupForwardRules(ipt *iptables.IPTables, cidr, bridgeInterface, networkInterface string) error {
+
 	i.logger.Debug("setting up forward rules",
 		zap.String("network_cidr", cidr),
 		zap.String("bridge_interface", bridgeInterface),
@@ -322,17 +389,17 @@ func (i *Manager) SetupForwardRules(network *types.Network) error {
 	)
 
 	// Ensure the custom chain exists
-	if err := i.ensureChain("filter", smuggleForwardChainName); err != nil {
+	if err := i.ensureChain(ipt, "filter", smuggleForwardChainName); err != nil {
 		return fmt.Errorf("failed to ensure chain %s: %w", smuggleForwardChainName, err)
 	}
 
 	// Apply all rules to the Smuggle forward chain but Skip the jump rule as
 	// we'll handle it separately.
-	for _, rule := range i.forwardRules(cidr, bridgeInterface, networkInterface) {
+	for _, rule := range forwardRules(cidr, bridgeInterface, networkInterface) {
 		if rule.chain == forwardChainName {
 			continue
 		}
-		if err := i.applyRule(rule); err != nil {
+		if err := i.applyRule(ipt, rule); err != nil {
 			return fmt.Errorf("failed to apply rule: %w", err)
 		}
 	}
@@ -340,35 +407,35 @@ func (i *Manager) SetupForwardRules(network *types.Network) error {
 	// Ensure jump rule is FIRST in FORWARD chain and before Docker chains. This
 	// is critical because Docker chains don't have a final ACCEPT, so packets
 	// that don't match fall through to the DROP policy.
-	if err := i.ensureJumpRuleFirst("filter", forwardChainName, smuggleForwardChainName); err != nil {
+	if err := i.ensureJumpRuleFirst(ipt, "filter", forwardChainName, smuggleForwardChainName); err != nil {
 		return fmt.Errorf("failed to ensure jump rule is first: %w", err)
 	}
 
-	i.logger.Info("successfully set up forward rules")
+	i.logger.Info("successfully set up forward rules", zap.String("network_cidr", cidr))
 	return nil
 }
 
 // ensureJumpRuleFirst ensures a jump rule exists and is at position 1
 // This is necessary to ensure Smuggle rules run before Docker's chains
-func (i *Manager) ensureJumpRuleFirst(table, chain, targetChain string) error {
+func (i *Manager) ensureJumpRuleFirst(ipt *iptables.IPTables, table, chain, targetChain string) error {
 	ruleSpec := []string{"-m", "comment", "--comment", "smuggle forward", "-j", targetChain}
 
 	// Check if rule exists
-	exists, err := i.ipt.Exists(table, chain, ruleSpec...)
+	exists, err := ipt.Exists(table, chain, ruleSpec...)
 	if err != nil {
 		return fmt.Errorf("failed to check if jump rule exists: %w", err)
 	}
 
 	if exists {
 		// Rule exists but might not be first. Delete and re-insert.
-		if err := i.ipt.Delete(table, chain, ruleSpec...); err != nil {
+		if err := ipt.Delete(table, chain, ruleSpec...); err != nil {
 			i.logger.Warn("failed to delete existing jump rule, will try to insert anyway",
 				zap.Error(err))
 		}
 	}
 
 	// Insert at position 1 (first rule, before Docker chains)
-	if err := i.ipt.Insert(table, chain, 1, ruleSpec...); err != nil {
+	if err := ipt.Insert(table, chain, 1, ruleSpec...); err != nil {
 		return fmt.Errorf("failed to insert jump rule at position 1: %w", err)
 	}
 
@@ -385,57 +452,75 @@ func (i *Manager) ensureJumpRuleFirst(table, chain, targetChain string) error {
 // For each pair of networks, it creates rules that reject traffic from one
 // network's interfaces to another network's interfaces. This uses the +
 // wildcard to match all interfaces belonging to a network (both bridge and
-// VXLAN).
+// VXLAN). Pairs of networks which both have IPv6 are also isolated using
+// ip6tables. Isolation rules for networks which are no longer passed are
+// removed.
 func (i *Manager) EnsureIsolation(networks []*types.Network) error {
 
-	// There is no need to apply isolation rules if there are less than 2
-	// networks.
-	if len(networks) < 2 {
-		i.logger.Debug("no isolation rules needed", zap.Int("network_count", len(networks)))
-		return nil
+	if err := i.ensureIsolation(i.ipt, networks); err != nil {
+		return err
 	}
 
-	i.logger.Info("ensuring network isolation",
-		zap.Int("network_count", len(networks)))
+	var ipv6Networks []*types.Network
 
-	// Ensure the custom chain exists
-	if err := i.ensureChain("filter", smuggleForwardChainName); err != nil {
-		return fmt.Errorf("failed to ensure chain: %w", err)
+	for _, network := range networks {
+		if network.IPv6 != nil {
+			ipv6Networks = append(ipv6Networks, network)
+		}
+	}
+
+	// Without ip6tables, there can be no stale IPv6 isolation rules to
+	// remove, so it is only required when there are IPv6 networks.
+	if i.ipt6 == nil && len(ipv6Networks) == 0 {
+		return nil
+	}
+
+	ipt6, err := i.ip6tables()
+	if err != nil {
+		return err
 	}
 
+	return i.ensureIsolation(ipt6, ipv6Networks)
+}
+
+// ensureIsolation applies the isolation rules between the passed networks for
+// the address family managed by the passed handle.
+func (i *Manager) ensureIsolation(ipt *iptables.IPTables, networks []*types.Network) error {
+
 	// Track rules we need to ensure exist
 	var isolationRules []rule
 
 	// For each network, create REJECT rules to all other networks
 	for _, sourceNetwork := range networks {
-		sourcePrefix := sourceNetwork.Name + "+"
-
 		for _, destNetwork := range networks {
 			// Skip if same network
 			if sourceNetwork.Name == destNetwork.Name {
 				continue
 			}
-
-			destPrefix := destNetwork.Name + "+"
-
-			// Create REJECT rule for this network pair. The + wildcard matches
-			// both bridge and VXLAN interfaces.
-			isolationRules = append(isolationRules, rule{
-				id:    fmt.Sprintf("reject-%s-to-%s", sourceNetwork.Name, destNetwork.Name),
-				table: "filter",
-				chain: smuggleForwardChainName,
-				spec: []string{
-					"-i", sourcePrefix,
-					"-o", destPrefix,
-					"-m", "comment",
-					"--comment", fmt.Sprintf("smuggle isolate %s from %s", sourceNetwork.Name, destNetwork.Name),
-					"-j", "REJECT",
-					"--reject-with", "icmp-net-prohibited",
-				},
-			})
+			isolationRules = append(isolationRules,
+				isolationRule(ipt.Proto(), sourceNetwork.Name, destNetwork.Name))
 		}
 	}
 
+	if err := i.removeStaleIsolationRules(ipt, isolationRules); err != nil {
+		return fmt.Errorf("failed to remove stale isolation rules: %w", err)
+	}
+
+	// There is no need to apply isolation rules if there are less than 2
+	// networks.
+	if len(isolationRules) == 0 {
+		i.logger.Debug("no isolation rules needed", zap.Int("network_count", len(networks)))
+		return nil
+	}
+
+	i.logger.Info("ensuring network isolation",
+		zap.Int("network_count", len(networks)))
+
+	// Ensure the custom chain exists
+	if err := i.ensureChain(ipt, "filter", smuggleForwardChainName); err != nil {
+		return fmt.Errorf("failed to ensure chain: %w", err)
+	}
+
 	i.logger.Debug("applying isolation rules",
 		zap.Int("rule_count", len(isolationRules)))
 
@@ -443,7 +528,7 @@ func (i *Manager) EnsureIsolation(networks []*types.Network) error {
 	// These need to be inserted near the beginning of the chain, right after
 	// ESTABLISHED,RELATED but before any ACCEPT rules
 	for _, rule := range isolationRules {
-		if err := i.ensureIsolationRule(rule); err != nil {
+		if err := i.ensureIsolationRule(ipt, rule); err != nil {
 			return fmt.Errorf("failed to apply isolation rule: %w", err)
 		}
 	}
@@ -455,11 +540,174 @@ func (i *Manager) EnsureIsolation(networks []*types.Network) error {
 	return nil
 }
 
+// isolationRule generates the REJECT rule for traffic from the source network
+// to the destination network. The + wildcard matches both bridge and VXLAN
+// interfaces. The ICMP reject type depends on the protocol of the rule.
+func isolationRule(proto iptables.Protocol, sourceNetwork, destNetwork string) rule {
+
+	rejectWith := "icmp-net-prohibited"
+	if proto == iptables.ProtocolIPv6 {
+		rejectWith = "icmp6-adm-prohibited"
+	}
+
+	return rule{
+		id:    fmt.Sprintf("reject-%s-to-%s", sourceNetwork, destNetwork),
+		table: "filter",
+		chain: smuggleForwardChainName,
+		spec: []string{
+			"-i", sourceNetwork + "+",
+			"-o", destNetwork + "+",
+			"-m", "comment",
+			"--comment", fmt.Sprintf("smuggle isolate %s from %s", sourceNetwork, destNetwork),
+			"-j", "REJECT",
+			"--reject-with", rejectWith,
+		},
+	}
+}
+
+// removeStaleIsolationRules removes the isolation rules within the Smuggle
+// forward chain which are not in the passed list of desired rules. This
+// handles networks which have been removed from the host.
+func (i *Manager) removeStaleIsolationRules(ipt *iptables.IPTables, desired []rule) error {
+	exists, err := ipt.ChainExists("filter", smuggleForwardChainName)
+	if err != nil {
+		return fmt.Errorf("failed to check if chain exists: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	listed, err := ipt.List("filter", smuggleForwardChainName)
+	if err != nil {
+		return fmt.Errorf("failed to list rules: %w", err)
+	}
+
+	desiredIDs := make(map[string]struct{}, len(desired))
+	for _, rule := range desired {
+		desiredIDs[rule.id] = struct{}{}
+	}
+
+	for _, listedRule := range listed {
+		sourceNetwork, destNetwork, ok := parseIsolationRule(listedRule)
+		if !ok {
+			continue
+		}
+
+		rule := isolationRule(ipt.Proto(), sourceNetwork, destNetwork)
+		if _, ok := desiredIDs[rule.id]; ok {
+			continue
+		}
+
+		if err := ipt.DeleteIfExists(rule.table, rule.chain, rule.spec...); err != nil {
+			return fmt.Errorf("failed to delete isolation rule: %w", err)
+		}
+
+		i.logger.Info("successfully removed stale isolation rule", rule.loggingPairs()...)
+	}
+
+	return nil
+}
+
+// RemoveNetworkRules removes the forward and masquerading rules of the network
+// and subnet for each address family. The jump rules and the rule accepting
+// established connections are shared by all networks, so are not removed.
+func (i *Manager) RemoveNetworkRules(network *types.Network, subnet *types.Subnet) error {
+
+	i.logger.Debug("removing network rules", zap.String("network_name", network.Name))
+
+	networkInterface := network.InterfaceName()
+	if network.RoutesViaHost() {
+		networkInterface = "+"
+	}
+
+	var subnetCIDR string
+	if subnet != nil && subnet.IPv4Network != nil {
+		subnetCIDR = subnet.IPv4Network.String()
+	}
+
+	rules := networkRules(i.ipt, network.IPv4.Network.String(), subnetCIDR,
+		network.BridgeInterfaceName(), networkInterface)
+
+	if err := i.deleteRules(i.ipt, rules); err != nil {
+		return err
+	}
+
+	// Without ip6tables, no IPv6 rules can have been applied.
+	if network.IPv6 != nil && i.ipt6 != nil {
+
+		subnetCIDR = ""
+		if subnet != nil && subnet.IPv6Network != nil {
+			subnetCIDR = subnet.IPv6Network.String()
+		}
+
+		rules := networkRules(i.ipt6, network.IPv6.Network.String(), subnetCIDR,
+			network.BridgeInterfaceName(), networkInterface)
+
+		if err := i.deleteRules(i.ipt6, rules); err != nil {
+			return err
+		}
+	}
+
+	i.logger.Info("successfully removed network rules", zap.String("network_name", network.Name))
+	return nil
+}
+
+// networkRules returns the forward and masquerading rules owned by a network
+// for the address family managed by the passed handle, excluding the shared
+// rules. The masquerading rules are only included if the subnet CIDR is set.
+func networkRules(ipt *iptables.IPTables, networkCIDR, subnetCIDR, bridgeInterface, networkInterface string) []rule {
+
+	var rules []rule
+
+	if subnetCIDR != "" {
+		for _, rule := range masqRules(ipt, networkCIDR, subnetCIDR) {
+			if rule.chain != postroutingChainName {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	for _, rule := range forwardRules(networkCIDR, bridgeInterface, networkInterface) {
+		if rule.chain != forwardChainName && rule.id != "accept-established-related" {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
+
+// deleteRules deletes each of the passed rules using the passed handle.
+func (i *Manager) deleteRules(ipt *iptables.IPTables, rules []rule) error {
+	for _, rule := range rules {
+		if err := i.deleteRule(ipt, rule); err != nil {
+			return fmt.Errorf("failed to delete rule: %w", err)
+		}
+	}
+	return nil
+}
+
+// deleteRule ensures an iptables rule does not exist, deleting it if
+// necessary. A rule within a chain which does not exist is considered
+// deleted.
+func (i *Manager) deleteRule(ipt *iptables.IPTables, rule rule) error {
+	exists, err := ipt.ChainExists(rule.table, rule.chain)
+	if err != nil {
+		return fmt.Errorf("failed to check if chain exists: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	i.logger.Debug("deleting iptables rule", rule.loggingPairs()...)
+
+	return ipt.DeleteIfExists(rule.table, rule.chain, rule.spec...)
+}
+
 // ensureIsolationRule ensures an isolation REJECT rule exists in the chain.
 // Unlike applyRule which appends, this inserts the rule at a specific position
 // to ensure isolation rules run before ACCEPT rules.
-func (i *Manager) ensureIsolationRule(rule rule) error {
-	exists, err := i.ipt.Exists(rule.table, rule.chain, rule.spec...)
+func (i *Manager) ensureIsolationRule(ipt *iptables.IPTables, rule rule) error {
+	exists, err := ipt.Exists(rule.table, rule.chain, rule.spec...)
 	if err != nil {
 		return fmt.Errorf("failed to check if rule exists: %w", err)
 	}
@@ -471,7 +719,7 @@ func (i *Manager) ensureIsolationRule(rule rule) error {
 
 		// Insert at position 2 (right after ESTABLISHED,RELATED which is at position 1)
 		// This ensures isolation rules run before any ACCEPT rules
-		if err := i.ipt.Insert(rule.table, rule.chain, 2, rule.spec...); err != nil {
+		if err := ipt.Insert(rule.table, rule.chain, 2, rule.spec...); err != nil {
 			return fmt.Errorf("failed to insert isolation rule: %w", err)
 		}
 
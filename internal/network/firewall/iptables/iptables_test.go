@@ -0,0 +1,41 @@
+package iptables
+
+import (
+	"testing"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/shoenig/test/must"
+)
+
+func Test_isolationRule(t *testing.T) {
+
+	ipv4Rule := isolationRule(iptables.ProtocolIPv4, "vxlan", "wg")
+	must.Eq(t, "reject-vxlan-to-wg", ipv4Rule.id)
+	must.Eq(t, []string{"--reject-with", "icmp-net-prohibited"}, ipv4Rule.spec[len(ipv4Rule.spec)-2:])
+
+	// The IPv4 reject type is not valid for ip6tables.
+	ipv6Rule := isolationRule(iptables.ProtocolIPv6, "vxlan", "wg")
+	must.Eq(t, ipv4Rule.id, ipv6Rule.id)
+	must.Eq(t, []string{"--reject-with", "icmp6-adm-prohibited"}, ipv6Rule.spec[len(ipv6Rule.spec)-2:])
+
+	// Listed rules of both protocols must parse back to the same networks, so
+	// stale rules can be removed.
+	source, dest, ok := parseIsolationRule(`-A SMUGGLE-FORWARD -m comment --comment "` + ipv6Rule.spec[7] + `"`)
+	must.True(t, ok)
+	must.Eq(t, "vxlan", source)
+	must.Eq(t, "wg", dest)
+}
+
+func Test_forwardRules(t *testing.T) {
+
+	// The rules only differ between address families by their CIDR matches,
+	// so the same rules are used for both protocols.
+	rules := forwardRules("fd00:10::/48", "vxlanbrd0", "vxlan0")
+
+	for _, rule := range rules {
+		if rule.chain == forwardChainName || rule.id == "accept-established-related" {
+			continue
+		}
+		must.SliceContains(t, rule.spec, "fd00:10::/48", must.Sprintf("rule %s", rule.id))
+	}
+}
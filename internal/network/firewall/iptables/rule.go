@@ -1,6 +1,10 @@
 package iptables
 
-import "go.uber.org/zap"
+import (
+	"regexp"
+
+	"go.uber.org/zap"
+)
 
 // rule represents an iptables rule entry.
 type rule struct {
@@ -19,3 +23,18 @@ func (r rule) loggingPairs() []zap.Field {
 		zap.String("rule_id", r.id),
 	}
 }
+
+// isolationCommentRegex matches the comment of an isolation rule as listed by
+// iptables, capturing the source and destination network names.
+var isolationCommentRegex = regexp.MustCompile(`--comment "?smuggle isolate (\S+) from ([^"\s]+)"?`)
+
+// parseIsolationRule parses an isolation rule as listed by iptables and
+// returns the source and destination network names. The boolean is false if
+// the listed rule is not an isolation rule.
+func parseIsolationRule(listed string) (string, string, bool) {
+	matches := isolationCommentRegex.FindStringSubmatch(listed)
+	if matches == nil {
+		return "", "", false
+	}
+	return matches[1], matches[2], true
+}
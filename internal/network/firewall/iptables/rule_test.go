@@ -25,3 +25,55 @@ func Test_rule_loggingPairs(t *testing.T) {
 
 	must.SliceContainsAll(t, expectedPairs, testRule.loggingPairs())
 }
+
+func Test_parseIsolationRule(t *testing.T) {
+
+	testCases := []struct {
+		name           string
+		inputListed    string
+		expectedSource string
+		expectedDest   string
+		expectedOK     bool
+	}{
+		{
+			name:           "isolation rule",
+			inputListed:    `-A SMUGGLE-FORWARD -i vxlan+ -o wg+ -m comment --comment "smuggle isolate vxlan from wg" -j REJECT --reject-with icmp-net-prohibited`,
+			expectedSource: "vxlan",
+			expectedDest:   "wg",
+			expectedOK:     true,
+		},
+		{
+			name:           "hyphenated network names",
+			inputListed:    `-A SMUGGLE-FORWARD -i net-a+ -o net-b+ -m comment --comment "smuggle isolate net-a from net-b" -j REJECT --reject-with icmp-net-prohibited`,
+			expectedSource: "net-a",
+			expectedDest:   "net-b",
+			expectedOK:     true,
+		},
+		{
+			name:           "ip6tables isolation rule",
+			inputListed:    `-A SMUGGLE-FORWARD -i vxlan+ -o wg+ -m comment --comment "smuggle isolate vxlan from wg" -j REJECT --reject-with icmp6-adm-prohibited`,
+			expectedSource: "vxlan",
+			expectedDest:   "wg",
+			expectedOK:     true,
+		},
+		{
+			name:        "forward rule",
+			inputListed: `-A SMUGGLE-FORWARD -i vxlanbrd0 -o vxlanbrd0 -s 10.10.0.0/16 -d 10.10.0.0/16 -m comment --comment "smuggle forward intra-network local" -j ACCEPT`,
+			expectedOK:  false,
+		},
+		{
+			name:        "chain definition",
+			inputListed: `-N SMUGGLE-FORWARD`,
+			expectedOK:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			source, dest, ok := parseIsolationRule(tc.inputListed)
+			must.Eq(t, tc.expectedOK, ok)
+			must.Eq(t, tc.expectedSource, source)
+			must.Eq(t, tc.expectedDest, dest)
+		})
+	}
+}
@@ -0,0 +1,535 @@
+package nftables
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+
+	"github.com/rasorp/smuggle/internal/log"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+const (
+	// tableName is the name of the nftables table owned by Smuggle. All
+	// Smuggle chains and rules live within this table, so they do not
+	// interfere with other firewall rules on the host.
+	tableName = "smuggle"
+
+	// forwardChainName is the name of the base chain hooked into forward
+	// processing, which accepts traffic to and from Smuggle networks.
+	forwardChainName = "forward"
+
+	// postroutingChainName is the name of the base chain hooked into
+	// postrouting NAT processing, which performs masquerading.
+	postroutingChainName = "postrouting"
+
+	// isolationChainName is the name of the regular chain containing the
+	// rules that reject traffic between Smuggle networks. It is jumped to from
+	// the forward chain before any accept rules are evaluated.
+	isolationChainName = "isolation"
+
+	// baseRuleOwner is the owner of the rules that are always present in the
+	// forward chain, regardless of the configured networks.
+	baseRuleOwner = "base"
+)
+
+// Manager handles nftables rules for Smuggle networking. It owns a dedicated
+// inet table containing forward, postrouting and isolation chains.
+type Manager struct {
+	conn   *nftables.Conn
+	logger *zap.Logger
+
+	table       *nftables.Table
+	forward     *nftables.Chain
+	postrouting *nftables.Chain
+	isolation   *nftables.Chain
+}
+
+// NewManager creates a new nftables manager. An error is returned if nftables
+// is not available on the host.
+func NewManager(logger *zap.Logger) (types.Firewall, error) {
+	conn, err := nftables.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize nftables: %w", err)
+	}
+
+	// Perform a read against the kernel, so an unavailable nftables subsystem
+	// is detected when the manager is created rather than when the first rule
+	// is applied.
+	if _, err := conn.ListTablesOfFamily(nftables.TableFamilyINet); err != nil {
+		return nil, fmt.Errorf("failed to initialize nftables: %w", err)
+	}
+
+	table := &nftables.Table{
+		Name:   tableName,
+		Family: nftables.TableFamilyINet,
+	}
+
+	return &Manager{
+		conn:   conn,
+		logger: logger.Named(log.ComponentNameNftables),
+		table:  table,
+		forward: &nftables.Chain{
+			Name:     forwardChainName,
+			Table:    table,
+			Type:     nftables.ChainTypeFilter,
+			Hooknum:  nftables.ChainHookForward,
+			Priority: nftables.ChainPriorityFilter,
+		},
+		postrouting: &nftables.Chain{
+			Name:     postroutingChainName,
+			Table:    table,
+			Type:     nftables.ChainTypeNAT,
+			Hooknum:  nftables.ChainHookPostrouting,
+			Priority: nftables.ChainPriorityNATSource,
+		},
+		isolation: &nftables.Chain{
+			Name:  isolationChainName,
+			Table: table,
+		},
+	}, nil
+}
+
+// chain returns the chain object for the passed chain name.
+func (m *Manager) chain(name string) *nftables.Chain {
+	switch name {
+	case forwardChainName:
+		return m.forward
+	case postroutingChainName:
+		return m.postrouting
+	default:
+		return m.isolation
+	}
+}
+
+// ensureTable ensures the Smuggle table and chains exist, along with the base
+// forward rules. Adding a table or chain which already exists is a no-op.
+func (m *Manager) ensureTable() error {
+	m.conn.AddTable(m.table)
+	m.conn.AddChain(m.forward)
+	m.conn.AddChain(m.postrouting)
+	m.conn.AddChain(m.isolation)
+
+	if err := m.conn.Flush(); err != nil {
+		return fmt.Errorf("failed to ensure table %s: %w", tableName, err)
+	}
+
+	existing, err := m.conn.GetRules(m.table, m.forward)
+	if err != nil {
+		return fmt.Errorf("failed to list rules: %w", err)
+	}
+
+	baseRules := m.baseRules()
+
+	// The base rules must be the first rules in the forward chain, so
+	// established traffic is accepted and isolation is enforced before any
+	// network accept rules. If they are already in place there is nothing to
+	// do.
+	if len(existing) >= len(baseRules) {
+		inPlace := true
+		for i, rule := range baseRules {
+			if ruleComment(existing[i]) != rule.comment() {
+				inPlace = false
+				break
+			}
+		}
+		if inPlace {
+			return nil
+		}
+	}
+
+	for _, r := range existing {
+		if strings.HasPrefix(ruleComment(r), ownerCommentPrefix(baseRuleOwner)) {
+			if err := m.conn.DelRule(r); err != nil {
+				return fmt.Errorf("failed to delete rule: %w", err)
+			}
+		}
+	}
+
+	// Inserting places the rule at the start of the chain, so insert the base
+	// rules in reverse order.
+	for i := len(baseRules) - 1; i >= 0; i-- {
+		m.logger.Debug("inserting nftables rule", baseRules[i].loggingPairs()...)
+		m.conn.InsertRule(m.nftRule(baseRules[i]))
+	}
+
+	if err := m.conn.Flush(); err != nil {
+		return fmt.Errorf("failed to insert base rules: %w", err)
+	}
+
+	m.logger.Info("successfully ensured base forward rules")
+	return nil
+}
+
+// baseRules returns the rules that must be at the start of the forward chain.
+func (m *Manager) baseRules() []rule {
+	return []rule{
+		// Allow established and related connections for return traffic.
+		{
+			id:    "accept-established-related",
+			owner: baseRuleOwner,
+			chain: forwardChainName,
+			exprs: exprs(matchEstablishedRelated(), accept()),
+		},
+		// Jump to the isolation chain, so cross-network traffic is rejected
+		// before any accept rules are evaluated.
+		{
+			id:    "jump-to-isolation-chain",
+			owner: baseRuleOwner,
+			chain: forwardChainName,
+			exprs: []expr.Any{&expr.Verdict{Kind: expr.VerdictJump, Chain: isolationChainName}},
+		},
+	}
+}
+
+// nftRule converts the rule to an nftables rule within the Smuggle table.
+func (m *Manager) nftRule(r rule) *nftables.Rule {
+	return &nftables.Rule{
+		Table:    m.table,
+		Chain:    m.chain(r.chain),
+		Exprs:    r.exprs,
+		UserData: r.userData(),
+	}
+}
+
+// replaceRules atomically replaces all rules in the chain with the passed
+// owner with the passed rules. Rules are replaced rather than compared, as
+// the kernel representation of an expression does not always match the
+// representation used to create it.
+func (m *Manager) replaceRules(chain, owner string, rules []rule) error {
+	existing, err := m.conn.GetRules(m.table, m.chain(chain))
+	if err != nil {
+		return fmt.Errorf("failed to list rules: %w", err)
+	}
+
+	for _, r := range existing {
+		if strings.HasPrefix(ruleComment(r), ownerCommentPrefix(owner)) {
+			if err := m.conn.DelRule(r); err != nil {
+				return fmt.Errorf("failed to delete rule: %w", err)
+			}
+		}
+	}
+
+	for _, r := range rules {
+		m.logger.Debug("applying nftables rule", r.loggingPairs()...)
+		m.conn.AddRule(m.nftRule(r))
+	}
+
+	return m.conn.Flush()
+}
+
+// masqRules generates the nftables rules for masquerading traffic from the
+// network subnet to external destinations. Dual-stack subnets are masqueraded
+// for both address families.
+func (m *Manager) masqRules(network *types.Network, subnet *types.Subnet) []rule {
+
+	var rules []rule
+
+	for _, cidrs := range networkSubnetCIDRs(network, subnet) {
+		rules = append(rules,
+			// NAT traffic from local subnet that's NOT going to the cluster
+			// network, so it can reach the internet.
+			rule{
+				id:    "masquerade-to-external" + familySuffix(cidrs.network),
+				owner: "masq " + network.Name,
+				chain: postroutingChainName,
+				exprs: exprs(
+					matchFamily(cidrs.network),
+					matchSrc(cidrs.subnet, false),
+					matchDst(cidrs.network, true),
+					[]expr.Any{&expr.Masq{FullyRandom: true}},
+				),
+			},
+		)
+	}
+
+	return rules
+}
+
+// subnetCIDRs pairs the network and subnet ranges of a single address family.
+type subnetCIDRs struct {
+	network *net.IPNet
+	subnet  *net.IPNet
+}
+
+// networkSubnetCIDRs returns the network and subnet ranges of each address
+// family configured on both the network and subnet.
+func networkSubnetCIDRs(network *types.Network, subnet *types.Subnet) []subnetCIDRs {
+
+	var cidrs []subnetCIDRs
+
+	if network.IPv4 != nil && subnet.IPv4Network != nil {
+		cidrs = append(cidrs, subnetCIDRs{
+			network: network.IPv4.Network.ToIPNet(),
+			subnet:  subnet.IPv4Network.ToIPNet(),
+		})
+	}
+	if network.IPv6 != nil && subnet.IPv6Network != nil {
+		cidrs = append(cidrs, subnetCIDRs{
+			network: network.IPv6.Network.ToIPNet(),
+			subnet:  subnet.IPv6Network.ToIPNet(),
+		})
+	}
+
+	return cidrs
+}
+
+// networkCIDRs returns the range of each address family configured on the
+// network.
+func networkCIDRs(network *types.Network) []*net.IPNet {
+
+	var cidrs []*net.IPNet
+
+	if network.IPv4 != nil {
+		cidrs = append(cidrs, network.IPv4.Network.ToIPNet())
+	}
+	if network.IPv6 != nil {
+		cidrs = append(cidrs, network.IPv6.Network.ToIPNet())
+	}
+
+	return cidrs
+}
+
+// familySuffix returns the suffix added to the IDs of rules matching the
+// address family of the passed network, so the IPv4 and IPv6 rules of a
+// network are distinguishable.
+func familySuffix(network *net.IPNet) string {
+	if network.IP.To4() != nil {
+		return ""
+	}
+	return "-ipv6"
+}
+
+// SetupMasqRules applies masquerading rules to nftables
+func (m *Manager) SetupMasqRules(network *types.Network, subnet *types.Subnet) error {
+
+	m.logger.Debug("setting up masquerading rules", subnet.LoggingPairs()...)
+
+	if err := m.ensureTable(); err != nil {
+		return err
+	}
+
+	rules := m.masqRules(network, subnet)
+
+	if err := m.replaceRules(postroutingChainName, "masq "+network.Name, rules); err != nil {
+		return fmt.Errorf("failed to apply masquerading rules: %w", err)
+	}
+
+	m.logger.Info("successfully set up masquerading rules", subnet.LoggingPairs()...)
+	return nil
+}
+
+// forwardRules generates nftables rules for forwarding traffic that allows
+// traffic to be forwarded to and from the network range. Dual-stack networks
+// have a set of rules for each address family.
+func (m *Manager) forwardRules(network *types.Network, bridgeInterface, networkInterface string) []rule {
+
+	owner := "forward " + network.Name
+
+	var rules []rule
+
+	for _, cidr := range networkCIDRs(network) {
+		suffix := familySuffix(cidr)
+
+		rules = append(rules,
+			// Allow forwarding packets from the bridge to external
+			// destinations (internet), but NOT to other cluster networks.
+			rule{
+				id:    "accept-forward-from-bridge-to-external" + suffix,
+				owner: owner,
+				chain: forwardChainName,
+				exprs: exprs(
+					matchFamily(cidr),
+					matchInputInterface(bridgeInterface),
+					matchSrc(cidr, false),
+					matchDst(cidr, true),
+					accept(),
+				),
+			},
+			// Allow forwarding packets to the bridge from external sources.
+			// This primarily handles return traffic that doesn't match
+			// established or related.
+			rule{
+				id:    "accept-forward-to-bridge-from-external" + suffix,
+				owner: owner,
+				chain: forwardChainName,
+				exprs: exprs(
+					matchFamily(cidr),
+					matchOutputInterface(bridgeInterface),
+					matchDst(cidr, false),
+					matchSrc(cidr, true),
+					accept(),
+				),
+			},
+			// Allow forwarding within the same network on the same node
+			// (bridge to bridge).
+			rule{
+				id:    "accept-forward-within-network-local" + suffix,
+				owner: owner,
+				chain: forwardChainName,
+				exprs: exprs(
+					matchFamily(cidr),
+					matchInputInterface(bridgeInterface),
+					matchOutputInterface(bridgeInterface),
+					matchSrc(cidr, false),
+					matchDst(cidr, false),
+					accept(),
+				),
+			},
+			// Allow forwarding from bridge to Smuggle (containers to remote
+			// nodes).
+			rule{
+				id:    "accept-forward-bridge-to-network" + suffix,
+				owner: owner,
+				chain: forwardChainName,
+				exprs: exprs(
+					matchFamily(cidr),
+					matchInputInterface(bridgeInterface),
+					matchOutputInterface(networkInterface),
+					matchSrc(cidr, false),
+					matchDst(cidr, false),
+					accept(),
+				),
+			},
+			// Allow forwarding from Smuggle to bridge (remote nodes to
+			// containers).
+			rule{
+				id:    "accept-forward-network-to-bridge" + suffix,
+				owner: owner,
+				chain: forwardChainName,
+				exprs: exprs(
+					matchFamily(cidr),
+					matchInputInterface(networkInterface),
+					matchOutputInterface(bridgeInterface),
+					matchSrc(cidr, false),
+					matchDst(cidr, false),
+					accept(),
+				),
+			},
+		)
+	}
+
+	return rules
+}
+
+// SetupForwardRules applies forward rules to nftables
+func (m *Manager) SetupForwardRules(network *types.Network) error {
+
+	bridgeInterface := network.BridgeInterfaceName()
+	networkInterface := network.InterfaceName()
+
+	// Networks that route directly via the host network send traffic via the
+	// host interface rather than the network interface, so match traffic on
+	// any interface instead. The network CIDR source and destination matches
+	// still apply.
+	if network.RoutesViaHost() {
+		networkInterface = "+"
+	}
+
+	m.logger.Debug("setting up forward rules",
+		append(network.LoggingPairs(),
+			zap.String("bridge_interface", bridgeInterface),
+			zap.String("network_interface", networkInterface),
+		)...,
+	)
+
+	if err := m.ensureTable(); err != nil {
+		return err
+	}
+
+	rules := m.forwardRules(network, bridgeInterface, networkInterface)
+
+	if err := m.replaceRules(forwardChainName, "forward "+network.Name, rules); err != nil {
+		return fmt.Errorf("failed to apply forward rules: %w", err)
+	}
+
+	m.logger.Info("successfully set up forward rules")
+	return nil
+}
+
+// RemoveNetworkRules removes the forward and masquerading rules owned by the
+// network. The isolation rules are rebuilt by EnsureIsolation, so are not
+// modified.
+func (m *Manager) RemoveNetworkRules(network *types.Network, _ *types.Subnet) error {
+
+	m.logger.Debug("removing network rules", zap.String("network_name", network.Name))
+
+	if err := m.ensureTable(); err != nil {
+		return err
+	}
+
+	if err := m.replaceRules(postroutingChainName, "masq "+network.Name, nil); err != nil {
+		return fmt.Errorf("failed to remove masquerading rules: %w", err)
+	}
+
+	if err := m.replaceRules(forwardChainName, "forward "+network.Name, nil); err != nil {
+		return fmt.Errorf("failed to remove forward rules: %w", err)
+	}
+
+	m.logger.Info("successfully removed network rules", zap.String("network_name", network.Name))
+	return nil
+}
+
+// EnsureIsolation creates reject rules to prevent cross-network communication.
+// For each pair of networks, it creates rules that reject traffic from one
+// network's interfaces to another network's interfaces. The rules only match
+// interfaces, so within the inet table they apply to both IPv4 and IPv6
+// traffic. The isolation chain is rebuilt atomically on each call, so rules
+// for networks which are no longer passed are removed.
+func (m *Manager) EnsureIsolation(networks []*types.Network) error {
+
+	m.logger.Info("ensuring network isolation",
+		zap.Int("network_count", len(networks)))
+
+	if err := m.ensureTable(); err != nil {
+		return err
+	}
+
+	var isolationRules []rule
+
+	// For each network, create reject rules to all other networks. The prefix
+	// match covers both the bridge and network interfaces.
+	for _, sourceNetwork := range networks {
+		for _, destNetwork := range networks {
+			if sourceNetwork.Name == destNetwork.Name {
+				continue
+			}
+
+			isolationRules = append(isolationRules, rule{
+				id:    fmt.Sprintf("reject-%s-to-%s", sourceNetwork.Name, destNetwork.Name),
+				owner: "isolate",
+				chain: isolationChainName,
+				exprs: exprs(
+					matchInputInterface(sourceNetwork.Name+"+"),
+					matchOutputInterface(destNetwork.Name+"+"),
+					[]expr.Any{&expr.Reject{
+						Type: unix.NFT_REJECT_ICMPX_UNREACH,
+						Code: unix.NFT_REJECT_ICMPX_ADMIN_PROHIBITED,
+					}},
+				),
+			})
+		}
+	}
+
+	m.conn.FlushChain(m.isolation)
+
+	for _, r := range isolationRules {
+		m.logger.Debug("applying isolation rule", r.loggingPairs()...)
+		m.conn.AddRule(m.nftRule(r))
+	}
+
+	if err := m.conn.Flush(); err != nil {
+		return fmt.Errorf("failed to apply isolation rules: %w", err)
+	}
+
+	m.logger.Info("successfully ensured network isolation",
+		zap.Int("network_count", len(networks)),
+		zap.Int("rule_count", len(isolationRules)))
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package nftables
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/shoenig/test/must"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func TestManager_dualStackRules(t *testing.T) {
+
+	var network types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"ipv6":{"network":"fd00:10::/48","size":64},"provider":{"name":"vxlan"}}`,
+	), &network))
+
+	var subnet types.Subnet
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"ipv4_network":"10.10.1.0/24","ipv6_network":"fd00:10:0:1::/64"}`,
+	), &subnet))
+
+	m := &Manager{}
+
+	// Each address family should have its own set of rules, with the IPv6
+	// rules distinguished by their ID.
+	forward := m.forwardRules(&network, "vxlanbrd0", "vxlan0")
+	must.Len(t, 10, forward)
+	must.Eq(t, "accept-forward-from-bridge-to-external", forward[0].id)
+	must.Eq(t, "accept-forward-from-bridge-to-external-ipv6", forward[5].id)
+
+	masq := m.masqRules(&network, &subnet)
+	must.Len(t, 2, masq)
+	must.Eq(t, "masquerade-to-external-ipv6", masq[1].id)
+
+	// Subnets without an IPv6 range should only be masqueraded for IPv4.
+	subnet.IPv6Network = nil
+	must.Len(t, 1, m.masqRules(&network, &subnet))
+
+	network.IPv6 = nil
+	must.Len(t, 5, m.forwardRules(&network, "vxlanbrd0", "vxlan0"))
+}
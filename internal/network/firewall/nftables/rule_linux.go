@@ -0,0 +1,216 @@
+package nftables
+
+import (
+	"net"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/google/nftables/userdata"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// rule represents an nftables rule entry. Rules are grouped by owner, which
+// allows all rules belonging to a network to be replaced atomically.
+type rule struct {
+	id    string
+	owner string
+	chain string
+	exprs []expr.Any
+}
+
+// comment returns the comment attached to the rule, which identifies the rule
+// and its owner within the Smuggle table.
+func (r rule) comment() string { return ownerCommentPrefix(r.owner) + r.id }
+
+// userData returns the rule comment encoded as nftables user data, which is
+// how the nft CLI stores and displays rule comments.
+func (r rule) userData() []byte {
+	return userdata.AppendString(nil, userdata.TypeComment, r.comment())
+}
+
+// loggingPairs returns zap fields for logging the rule.
+func (r rule) loggingPairs() []zap.Field {
+	return []zap.Field{
+		zap.String("table", tableName),
+		zap.String("chain", r.chain),
+		zap.String("owner", r.owner),
+		zap.String("rule_id", r.id),
+	}
+}
+
+// ownerCommentPrefix returns the comment prefix shared by all rules with the
+// passed owner.
+func ownerCommentPrefix(owner string) string { return "smuggle " + owner + ": " }
+
+// ruleComment returns the comment attached to an existing nftables rule, or an
+// empty string if it does not have one.
+func ruleComment(r *nftables.Rule) string {
+	comment, _ := userdata.GetString(r.UserData, userdata.TypeComment)
+	return comment
+}
+
+// matchIPv4 returns expressions which match IPv4 packets. This is required
+// before matching IPv4 header fields, as the Smuggle table uses the inet
+// family which sees both IPv4 and IPv6 traffic.
+func matchIPv4() []expr.Any {
+	return matchNFProto(unix.NFPROTO_IPV4)
+}
+
+// matchIPv6 returns expressions which match IPv6 packets. This is required
+// before matching IPv6 header fields, for the same reason as matchIPv4.
+func matchIPv6() []expr.Any {
+	return matchNFProto(unix.NFPROTO_IPV6)
+}
+
+// matchNFProto returns expressions which match packets of the passed netfilter
+// protocol family.
+func matchNFProto(proto byte) []expr.Any {
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{proto}},
+	}
+}
+
+// matchFamily returns expressions which match packets of the address family
+// of the passed network.
+func matchFamily(network *net.IPNet) []expr.Any {
+	if network.IP.To4() != nil {
+		return matchIPv4()
+	}
+	return matchIPv6()
+}
+
+// matchIPv4Src returns expressions which match packets where the IPv4 source
+// address is, or if negated is not, within the passed network.
+func matchIPv4Src(network *net.IPNet, negate bool) []expr.Any {
+	return matchAddr(network, 12, negate)
+}
+
+// matchIPv4Dst returns expressions which match packets where the IPv4
+// destination address is, or if negated is not, within the passed network.
+func matchIPv4Dst(network *net.IPNet, negate bool) []expr.Any {
+	return matchAddr(network, 16, negate)
+}
+
+// matchSrc returns expressions which match packets where the source address
+// is, or if negated is not, within the passed network of either family.
+func matchSrc(network *net.IPNet, negate bool) []expr.Any {
+	if network.IP.To4() != nil {
+		return matchIPv4Src(network, negate)
+	}
+	return matchAddr(network, 8, negate)
+}
+
+// matchDst returns expressions which match packets where the destination
+// address is, or if negated is not, within the passed network of either
+// family.
+func matchDst(network *net.IPNet, negate bool) []expr.Any {
+	if network.IP.To4() != nil {
+		return matchIPv4Dst(network, negate)
+	}
+	return matchAddr(network, 24, negate)
+}
+
+// matchAddr returns expressions which match the address at the passed offset
+// within the network header against the passed network. The length of the
+// address is that of the network family.
+func matchAddr(network *net.IPNet, offset uint32, negate bool) []expr.Any {
+	op := expr.CmpOpEq
+	if negate {
+		op = expr.CmpOpNeq
+	}
+
+	ip := network.IP.To4()
+	if ip == nil {
+		ip = network.IP.To16()
+	}
+
+	// IPv4 masks may be held in their 16 byte form, so are reduced to the
+	// length of the address.
+	mask := network.Mask[len(network.Mask)-len(ip):]
+
+	return []expr.Any{
+		&expr.Payload{
+			DestRegister: 1,
+			Base:         expr.PayloadBaseNetworkHeader,
+			Offset:       offset,
+			Len:          uint32(len(ip)),
+		},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            uint32(len(ip)),
+			Mask:           mask,
+			Xor:            make([]byte, len(ip)),
+		},
+		&expr.Cmp{Op: op, Register: 1, Data: ip.Mask(mask)},
+	}
+}
+
+// matchInterface returns expressions which match the input or output interface
+// name. A name ending with "+" matches all interfaces with that prefix, which
+// follows the iptables convention, and a name of "+" matches any interface, so
+// no expressions are returned.
+func matchInterface(key expr.MetaKey, name string) []expr.Any {
+	if name == "+" {
+		return nil
+	}
+
+	var data []byte
+
+	// A prefix match compares only the prefix bytes, whereas an exact match
+	// compares the full null padded interface name.
+	if prefix, ok := strings.CutSuffix(name, "+"); ok {
+		data = []byte(prefix)
+	} else {
+		data = make([]byte, unix.IFNAMSIZ)
+		copy(data, name)
+	}
+
+	return []expr.Any{
+		&expr.Meta{Key: key, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: data},
+	}
+}
+
+// matchInputInterface returns expressions which match the input interface.
+func matchInputInterface(name string) []expr.Any {
+	return matchInterface(expr.MetaKeyIIFNAME, name)
+}
+
+// matchOutputInterface returns expressions which match the output interface.
+func matchOutputInterface(name string) []expr.Any {
+	return matchInterface(expr.MetaKeyOIFNAME, name)
+}
+
+// matchEstablishedRelated returns expressions which match packets belonging
+// to established or related connections.
+func matchEstablishedRelated() []expr.Any {
+	return []expr.Any{
+		&expr.Ct{Register: 1, Key: expr.CtKeySTATE},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            4,
+			Mask:           binaryutil.NativeEndian.PutUint32(expr.CtStateBitESTABLISHED | expr.CtStateBitRELATED),
+			Xor:            binaryutil.NativeEndian.PutUint32(0),
+		},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(0)},
+	}
+}
+
+// exprs concatenates the passed expression groups into a single rule
+// expression list.
+func exprs(groups ...[]expr.Any) []expr.Any {
+	var result []expr.Any
+	for _, group := range groups {
+		result = append(result, group...)
+	}
+	return result
+}
+
+// accept returns the verdict expression which accepts the packet.
+func accept() []expr.Any { return []expr.Any{&expr.Verdict{Kind: expr.VerdictAccept}} }
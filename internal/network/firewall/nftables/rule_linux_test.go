@@ -0,0 +1,138 @@
+package nftables
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/shoenig/test/must"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+func Test_rule_loggingPairs(t *testing.T) {
+
+	testRule := rule{
+		id:    "test-rule-1",
+		owner: "forward vxlan",
+		chain: forwardChainName,
+	}
+
+	expectedPairs := []zap.Field{
+		zap.String("rule_id", "test-rule-1"),
+		zap.String("owner", "forward vxlan"),
+		zap.String("table", "smuggle"),
+		zap.String("chain", "forward"),
+	}
+
+	must.SliceContainsAll(t, expectedPairs, testRule.loggingPairs())
+}
+
+func Test_rule_comment(t *testing.T) {
+
+	testRule := rule{
+		id:    "masquerade-to-external",
+		owner: "masq vxlan",
+		chain: postroutingChainName,
+	}
+
+	must.Eq(t, "smuggle masq vxlan: masquerade-to-external", testRule.comment())
+
+	// The comment must round trip through the user data encoding, as it is
+	// used to identify existing rules.
+	nftRule := nftables.Rule{UserData: testRule.userData()}
+	must.Eq(t, testRule.comment(), ruleComment(&nftRule))
+	must.Eq(t, "", ruleComment(&nftables.Rule{}))
+}
+
+func Test_matchInterface(t *testing.T) {
+
+	testCases := []struct {
+		name         string
+		inputName    string
+		expectedData []byte
+	}{
+		{
+			name:         "any",
+			inputName:    "+",
+			expectedData: nil,
+		},
+		{
+			name:         "prefix",
+			inputName:    "vxlan+",
+			expectedData: []byte("vxlan"),
+		},
+		{
+			name:         "exact",
+			inputName:    "vxlan-br",
+			expectedData: []byte{'v', 'x', 'l', 'a', 'n', '-', 'b', 'r', 0, 0, 0, 0, 0, 0, 0, 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exprs := matchInterface(expr.MetaKeyIIFNAME, tc.inputName)
+
+			if tc.expectedData == nil {
+				must.SliceEmpty(t, exprs)
+				return
+			}
+
+			must.Len(t, 2, exprs)
+			must.Eq(t, &expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1}, exprs[0].(*expr.Meta))
+			must.Eq(t, tc.expectedData, exprs[1].(*expr.Cmp).Data)
+		})
+	}
+}
+
+func Test_matchIPv4Addr(t *testing.T) {
+
+	_, network, err := net.ParseCIDR("10.10.0.0/16")
+	must.NoError(t, err)
+
+	exprs := matchIPv4Dst(network, true)
+	must.Len(t, 3, exprs)
+
+	payload := exprs[0].(*expr.Payload)
+	must.Eq(t, 16, payload.Offset)
+	must.Eq(t, 4, payload.Len)
+
+	bitwise := exprs[1].(*expr.Bitwise)
+	must.Eq(t, []byte{255, 255, 0, 0}, bitwise.Mask)
+
+	cmp := exprs[2].(*expr.Cmp)
+	must.Eq(t, expr.CmpOpNeq, cmp.Op)
+	must.Eq(t, []byte{10, 10, 0, 0}, cmp.Data)
+
+	exprs = matchIPv4Src(network, false)
+	must.Eq(t, 12, exprs[0].(*expr.Payload).Offset)
+	must.Eq(t, expr.CmpOpEq, exprs[2].(*expr.Cmp).Op)
+}
+
+func Test_matchAddr_ipv6(t *testing.T) {
+
+	_, network, err := net.ParseCIDR("fd00:10::/48")
+	must.NoError(t, err)
+
+	exprs := matchSrc(network, false)
+	must.Len(t, 3, exprs)
+
+	payload := exprs[0].(*expr.Payload)
+	must.Eq(t, 8, payload.Offset)
+	must.Eq(t, 16, payload.Len)
+
+	bitwise := exprs[1].(*expr.Bitwise)
+	must.Eq(t, []byte(network.Mask), bitwise.Mask)
+
+	cmp := exprs[2].(*expr.Cmp)
+	must.Eq(t, expr.CmpOpEq, cmp.Op)
+	must.Eq(t, []byte(network.IP), cmp.Data)
+
+	exprs = matchDst(network, true)
+	must.Eq(t, 24, exprs[0].(*expr.Payload).Offset)
+	must.Eq(t, expr.CmpOpNeq, exprs[2].(*expr.Cmp).Op)
+
+	// The family match must select IPv6 packets within the inet table.
+	must.Eq(t, []byte{unix.NFPROTO_IPV6}, matchFamily(network)[1].(*expr.Cmp).Data)
+}
@@ -0,0 +1,34 @@
+package network
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/network/firewall/iptables"
+	"github.com/rasorp/smuggle/internal/network/firewall/nftables"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// newFirewall creates the firewall manager for the passed backend. The auto
+// backend prefers iptables, which includes hosts using the iptables-nft shim,
+// and falls back to nftables on hosts where iptables is not available.
+func newFirewall(logger *zap.Logger, backend string) (types.Firewall, error) {
+	switch backend {
+	case types.FirewallBackendIPTables:
+		return iptables.NewManager(logger)
+	case types.FirewallBackendNFTables:
+		return nftables.NewManager(logger)
+	case types.FirewallBackendAuto, "":
+		firewallManager, err := iptables.NewManager(logger)
+		if err == nil {
+			return firewallManager, nil
+		}
+
+		logger.Info("iptables not available, using nftables firewall backend", zap.Error(err))
+
+		return nftables.NewManager(logger)
+	default:
+		return nil, fmt.Errorf("unsupported firewall backend %q", backend)
+	}
+}
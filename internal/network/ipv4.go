@@ -0,0 +1,193 @@
+package network
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// ipv4Block is an aligned block of IPv4 addresses, identified by its first
+// address and prefix size.
+type ipv4Block struct {
+	ip   types.IPv4Addr
+	size uint
+}
+
+// ipv4BlockAlign returns the first address of the block of the passed size
+// which contains the address.
+func ipv4BlockAlign(ip types.IPv4Addr, size uint) types.IPv4Addr {
+	return types.IPv4Addr(uint64(ip) &^ (1<<(32-size) - 1))
+}
+
+// ipv4BuddyAllocator finds free subnets within a network whose subnets have
+// mixed sizes. The network range is treated as a binary tree of aligned
+// blocks, where each block is split into two halves of the next size. A block
+// is occupied if it, or any block within it, is allocated.
+type ipv4BuddyAllocator struct {
+	cfg *types.IPv4Config
+
+	used     map[ipv4Block]bool
+	occupied map[ipv4Block]bool
+
+	// levels holds the occupied blocks which are not allocated, keyed by their
+	// size. These are the blocks which may have a free half.
+	levels map[uint][]types.IPv4Addr
+}
+
+func newIPv4BuddyAllocator(cfg *types.IPv4Config, subnets []*types.IPv4Net) *ipv4BuddyAllocator {
+
+	a := ipv4BuddyAllocator{
+		cfg:      cfg,
+		used:     make(map[ipv4Block]bool, len(subnets)),
+		occupied: make(map[ipv4Block]bool),
+		levels:   make(map[uint][]types.IPv4Addr),
+	}
+
+	for _, subnet := range subnets {
+		if subnet.Size < cfg.Network.Size || !cfg.Network.Overlap(subnet) {
+			continue
+		}
+
+		// Subnets allocated before the size classes were configured may not
+		// be aligned to their size, so every aligned block the subnet overlaps
+		// is marked as used. The end is computed using 64-bit integers, so a
+		// subnet at the end of the address space does not overflow.
+		blockSize := uint64(1) << (32 - subnet.Size)
+		end := uint64(subnet.IP) + blockSize
+
+		for ip := uint64(ipv4BlockAlign(subnet.IP, subnet.Size)); ip < end; ip += blockSize {
+			a.markUsed(ipv4Block{ip: types.IPv4Addr(ip), size: subnet.Size})
+		}
+	}
+
+	for block := range a.occupied {
+		if !a.used[block] {
+			a.levels[block.size] = append(a.levels[block.size], block.ip)
+		}
+	}
+
+	return &a
+}
+
+// markUsed marks the block as allocated, and it and its ancestors as occupied.
+func (a *ipv4BuddyAllocator) markUsed(block ipv4Block) {
+
+	a.used[block] = true
+
+	// Once a block is found to be occupied, its ancestors must already have
+	// been marked as occupied.
+	for size := block.size; ; size-- {
+		ancestor := ipv4Block{ip: ipv4BlockAlign(block.ip, size), size: size}
+		if a.occupied[ancestor] {
+			break
+		}
+		a.occupied[ancestor] = true
+
+		if size == a.cfg.Network.Size {
+			break
+		}
+	}
+}
+
+// allocatable returns the first address and number of blocks of the passed
+// size which lie within the region and the allocatable range of the network.
+func (a *ipv4BuddyAllocator) allocatable(region ipv4Block, size uint) (types.IPv4Addr, uint64) {
+
+	blockSize := uint64(1) << (32 - size)
+
+	// The bounds are computed using 64-bit integers, so blocks at the end of
+	// the address space do not overflow.
+	lo := max(uint64(region.ip), (uint64(a.cfg.Min)+blockSize-1)&^(blockSize-1))
+	hi := min(uint64(region.ip)+1<<(32-region.size), uint64(a.cfg.LastAddr())+1)
+
+	if hi < lo+blockSize {
+		return 0, 0
+	}
+	return types.IPv4Addr(lo), (hi - lo) / blockSize
+}
+
+// find returns a free subnet of the passed size. To limit fragmentation, the
+// subnet is placed within the smallest free region which can hold it, where a
+// free region is the unoccupied half of an occupied block. The region and
+// subnet within it are chosen at random, so clients allocating at the same
+// time are unlikely to conflict.
+func (a *ipv4BuddyAllocator) find(size uint) (*types.IPv4Net, bool) {
+
+	type candidate struct {
+		ip    types.IPv4Addr
+		count uint64
+	}
+
+	for level := size - 1; level >= a.cfg.Network.Size && level < size; level-- {
+
+		var candidates []candidate
+
+		for _, ip := range a.levels[level] {
+			for _, half := range []types.IPv4Addr{ip, ip + 1<<(32-level-1)} {
+				region := ipv4Block{ip: half, size: level + 1}
+				if a.occupied[region] {
+					continue
+				}
+				if first, count := a.allocatable(region, size); count > 0 {
+					candidates = append(candidates, candidate{ip: first, count: count})
+				}
+			}
+		}
+
+		if len(candidates) > 0 {
+			c := candidates[rnd.Intn(len(candidates))]
+			return randomIPv4Block(c.ip, c.count, size), true
+		}
+	}
+
+	// If no subnet is allocated, the whole network is a free region.
+	root := ipv4Block{ip: a.cfg.Network.IP, size: a.cfg.Network.Size}
+
+	if !a.occupied[root] {
+		if first, count := a.allocatable(root, size); count > 0 {
+			return randomIPv4Block(first, count, size), true
+		}
+	}
+
+	return nil, false
+}
+
+// randomIPv4Block returns one of the count contiguous blocks of the passed size
+// starting at the address, chosen at random.
+func randomIPv4Block(first types.IPv4Addr, count uint64, size uint) *types.IPv4Net {
+	offset := uint64(rnd.Int63n(int64(count))) << (32 - size)
+	return &types.IPv4Net{IP: first + types.IPv4Addr(offset), Size: size}
+}
+
+// findBuddySubnet allocates a subnet of the passed size from a network whose
+// subnets have mixed sizes. The random and sequential strategies assume all
+// subnets have the same size, so cannot be used without risking overlap.
+func (m *Manager) findBuddySubnet(
+	id string,
+	cfg *types.Network,
+	size uint,
+	subnets []*types.Subnet,
+) (*types.Subnet, error) {
+
+	var used []*types.IPv4Net
+
+	for _, subnet := range subnets {
+		if subnet.IPv4Network != nil && subnet.NetworkName == cfg.Name {
+			used = append(used, subnet.IPv4Network)
+		}
+	}
+
+	m.logger.Debug("using buddy strategy for subnet allocation",
+		zap.String("network", cfg.Name),
+		zap.Uint("size", size),
+		zap.Int("used", len(used)))
+
+	ipv4Network, ok := newIPv4BuddyAllocator(cfg.IPv4, used).find(size)
+	if !ok {
+		return nil, fmt.Errorf("network %s is full", cfg.Name)
+	}
+
+	return m.createSubnet(id, cfg, ipv4Network.IP, ipv4Network.Size), nil
+}
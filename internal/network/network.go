@@ -1,16 +1,18 @@
 package network
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
+	"net"
 	"runtime"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/rasorp/smuggle/internal/log"
-	"github.com/rasorp/smuggle/internal/network/firewall/iptables"
+	"github.com/rasorp/smuggle/internal/metrics"
 	"github.com/rasorp/smuggle/internal/types"
 )
 
@@ -19,9 +21,12 @@ type Manager struct {
 	fingerprint *networkFingerprint
 	providers   map[string]types.NetworkProvider
 	Firewall    types.Firewall
+
+	// stopMonitor stops the provider monitors started by StartMonitor.
+	stopMonitor context.CancelFunc
 }
 
-func NewManager(logger *zap.Logger, intf string) (*Manager, error) {
+func NewManager(logger *zap.Logger, intf, firewallBackend string) (*Manager, error) {
 
 	// Network manager is only supported on Linux. It would be possible to
 	// constrain this via build tags, but it would require duplicating a lot of
@@ -36,7 +41,7 @@ func NewManager(logger *zap.Logger, intf string) (*Manager, error) {
 		return nil, err
 	}
 
-	firewallManager, err := iptables.NewManager(logger)
+	firewallManager, err := newFirewall(logger, firewallBackend)
 	if err != nil {
 		return nil, err
 	}
@@ -52,12 +57,41 @@ func NewManager(logger *zap.Logger, intf string) (*Manager, error) {
 	return &m, nil
 }
 
+// NewManagerWithProviders returns a network manager which uses the passed
+// providers and firewall instead of those of the host. The host is not
+// fingerprinted, so the manager can be used without modifying the host
+// networking, such as within tests.
+func NewManagerWithProviders(
+	logger *zap.Logger,
+	firewall types.Firewall,
+	providers ...types.NetworkProvider,
+) *Manager {
+
+	m := Manager{
+		logger:      logger.Named(log.ComponentNameNetwork),
+		fingerprint: &networkFingerprint{iface: &net.Interface{MTU: 1500}},
+		providers:   make(map[string]types.NetworkProvider, len(providers)),
+		Firewall:    firewall,
+	}
+
+	for _, provider := range providers {
+		m.providers[provider.Name()] = provider
+	}
+
+	return &m
+}
+
 func (m *Manager) SetLocal(
 	req *types.NetworkProviderSetReq,
 ) (*types.NetworkProviderSetResp, error) {
 
 	req.HostInteface = m.fingerprint.iface
 
+	// Ensure the subnet reflects the current host addresses, which may have
+	// changed since the subnet was allocated.
+	req.Client = req.Client.Copy()
+	m.setHostAddrs(req.Client)
+
 	provider, ok := m.providers[req.Client.Provider]
 	if !ok {
 		return nil, fmt.Errorf("unknown network provider %q", req.Client.Provider)
@@ -66,6 +100,18 @@ func (m *Manager) SetLocal(
 	return provider.SetLocal(req)
 }
 
+func (m *Manager) DeleteLocal(
+	req *types.NetworkProviderDeleteLocalReq,
+) (*types.NetworkProviderDeleteLocalResp, error) {
+
+	provider, ok := m.providers[req.Client.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown network provider %q", req.Client.Provider)
+	}
+
+	return provider.DeleteLocal(req)
+}
+
 func (m *Manager) DeleteRemote(
 	req *types.NetworkProviderDeleteRemoteReq,
 ) (*types.NetworkProviderDeleteRemoteResp, error) {
@@ -75,7 +121,11 @@ func (m *Manager) DeleteRemote(
 		return nil, fmt.Errorf("unknown network provider %q", req.Subnet.Provider)
 	}
 
-	return provider.DeleteRemote(req)
+	start := time.Now()
+	resp, err := provider.DeleteRemote(req)
+	metrics.ObserveProvider(provider.Name(), "DeleteRemote", start, err)
+
+	return resp, err
 }
 
 func (m *Manager) SetRemote(
@@ -87,19 +137,97 @@ func (m *Manager) SetRemote(
 		return nil, fmt.Errorf("unknown network provider %q", req.Subnet.Provider)
 	}
 
-	return provider.SetRemote(req)
+	start := time.Now()
+	resp, err := provider.SetRemote(req)
+	metrics.ObserveProvider(provider.Name(), "SetRemote", start, err)
+
+	return resp, err
+}
+
+func (m *Manager) Reconcile(
+	req *types.NetworkProviderReconcileReq,
+) (*types.NetworkProviderReconcileResp, error) {
+
+	provider, ok := m.providers[req.Local.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown network provider %q", req.Local.Provider)
+	}
+
+	return provider.Reconcile(req)
 }
 
-// GenerateIPv4Subnet allocates an available subnet from the configured network
-// range. It uses an adaptive strategy that switches between random probing
-// which is efficient for sparse networks, and sequential search which is
-// efficient for dense networks based on network utilization.
+// ListRoutes lists the routing entries programmed for the local subnet. If the
+// provider does not support listing its entries, an error wrapping
+// types.ErrNotSupported is returned.
+func (m *Manager) ListRoutes(
+	req *types.NetworkProviderListRoutesReq,
+) (*types.NetworkProviderListRoutesResp, error) {
+
+	provider, ok := m.providers[req.Local.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown network provider %q", req.Local.Provider)
+	}
+
+	lister, ok := provider.(types.NetworkProviderRouteLister)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", types.ErrNotSupported, req.Local.Provider)
+	}
+
+	return lister.ListRoutes(req)
+}
+
+// StartMonitor starts monitoring the host on behalf of each provider which
+// supports it. Events describing the removal of provider owned configuration
+// are sent on the returned channel until Stop is called. The channel is never
+// closed.
+func (m *Manager) StartMonitor() (<-chan *types.NetworkProviderEvent, error) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.stopMonitor = cancel
+
+	eventCh := make(chan *types.NetworkProviderEvent)
+
+	for name, provider := range m.providers {
+		monitor, ok := provider.(types.NetworkProviderMonitor)
+		if !ok {
+			continue
+		}
+
+		if err := monitor.Monitor(&types.NetworkProviderMonitorReq{
+			Context: ctx,
+			EventCh: eventCh,
+		}); err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to start %s provider monitor: %w", name, err)
+		}
+	}
+
+	return eventCh, nil
+}
+
+// Stop stops any provider monitors started by StartMonitor.
+func (m *Manager) Stop() {
+	if m.stopMonitor != nil {
+		m.stopMonitor()
+	}
+}
+
+// GenerateIPv4Subnet allocates an available subnet of the passed size from the
+// configured network range. It uses an adaptive strategy that switches between
+// random probing which is efficient for sparse networks, and sequential search
+// which is efficient for dense networks based on network utilization. Networks
+// with size classes have subnets of mixed sizes, so use a buddy allocator.
 func (m *Manager) GenerateIPv4Subnet(
 	id string,
 	cfg *types.Network,
+	size uint,
 	subnets []*types.Subnet,
 ) (*types.Subnet, error) {
 
+	if cfg.IPv4.MixedSizes() {
+		return m.findBuddySubnet(id, cfg, size, subnets)
+	}
+
 	// Build a set of used subnet IPs for O(1) lookup.
 	usedSubnets := make(map[types.IPv4Addr]bool, len(subnets))
 
@@ -168,7 +296,7 @@ func (m *Manager) findRandomSubnet(
 
 		// Check if this subnet is available and return it if so.
 		if !usedSubnets[candidateIP] {
-			return m.createSubnet(id, cfg, candidateIP), nil
+			return m.createSubnet(id, cfg, candidateIP, cfg.IPv4.Size), nil
 		}
 	}
 
@@ -192,7 +320,7 @@ func (m *Manager) findSequentialSubnet(
 
 	for candidateIP := cfg.IPv4.Min; candidateIP <= cfg.IPv4.Max; candidateIP += types.IPv4Addr(subnetSize) {
 		if !usedSubnets[candidateIP] {
-			return m.createSubnet(id, cfg, candidateIP), nil
+			return m.createSubnet(id, cfg, candidateIP, cfg.IPv4.Size), nil
 		}
 
 		// Prevent overflow when approaching the end of the address space.
@@ -207,21 +335,101 @@ func (m *Manager) findSequentialSubnet(
 }
 
 // createSubnet constructs a new Subnet object with the given IP address and
-// populates it with client and network metadata.
-func (m *Manager) createSubnet(id string, cfg *types.Network, ip types.IPv4Addr) *types.Subnet {
-	return &types.Subnet{
+// size and populates it with client and network metadata.
+func (m *Manager) createSubnet(id string, cfg *types.Network, ip types.IPv4Addr, size uint) *types.Subnet {
+	subnet := types.Subnet{
 		ClientID:    id,
 		NetworkName: cfg.Name,
 		Provider:    cfg.Provider.Name,
 		Config:      cfg.Provider.Config,
-		HostIPv4:    &m.fingerprint.ipv4Addr,
-		Expiration:  time.Now().Add(types.DefaultSubnetTTL),
+		Expiration:  time.Now().Add(cfg.LeaseTTL()),
 		MTU:         m.fingerprint.iface.MTU - 50,
 		IPv4Network: &types.IPv4Net{
 			IP:   ip,
-			Size: cfg.IPv4.Size,
+			Size: size,
 		},
 	}
+
+	m.setHostAddrs(&subnet)
+
+	return &subnet
+}
+
+// setHostAddrs sets the host IPv4 and IPv6 addresses of the subnet to those
+// discovered on the host interface. An address family which is not available
+// on the host interface is unset.
+func (m *Manager) setHostAddrs(subnet *types.Subnet) {
+	subnet.HostIPv4 = nil
+	subnet.HostIPv6 = nil
+
+	if m.fingerprint.ipv4Addr != nil {
+		ip := m.fingerprint.ipv4Addr
+		subnet.HostIPv4 = &ip
+	}
+	if m.fingerprint.ipv6Addr != nil {
+		ip := m.fingerprint.ipv6Addr
+		subnet.HostIPv6 = &ip
+	}
+}
+
+// GenerateIPv6Subnet allocates an available IPv6 subnet from the configured
+// network range of a dual-stack network. It follows the same adaptive strategy
+// as GenerateIPv4Subnet, although IPv6 networks are typically large enough
+// that random probing will almost always succeed.
+func (m *Manager) GenerateIPv6Subnet(
+	cfg *types.Network,
+	subnets []*types.Subnet,
+) (*types.IPv6Net, error) {
+
+	if cfg.IPv6 == nil || cfg.IPv6.Network == nil {
+		return nil, fmt.Errorf("network %s does not have an IPv6 configuration", cfg.Name)
+	}
+
+	// Build a set of used subnet IPs for O(1) lookup.
+	usedSubnets := make(map[types.IPv6Addr]bool, len(subnets))
+
+	for _, subnet := range subnets {
+		if subnet.IPv6Network != nil && subnet.NetworkName == cfg.Name {
+			usedSubnets[subnet.IPv6Network.IP] = true
+		}
+	}
+
+	totalSubnets := cfg.IPv6.TotalSubnets()
+	utilizationPct := float64(len(usedSubnets)) / float64(totalSubnets)
+
+	if utilizationPct < 0.8 {
+		m.logger.Debug("using random probe strategy for IPv6 subnet allocation",
+			zap.Float64("utilization", utilizationPct),
+			zap.String("network", cfg.Name),
+			zap.Int("used", len(usedSubnets)),
+			zap.Uint64("total", totalSubnets))
+
+		maxAttempts := max(len(usedSubnets)*3, 1000)
+
+		for attempt := 0; attempt < maxAttempts; attempt++ {
+			candidate := cfg.IPv6.Network.Subnet(uint64(rnd.Int63n(int64(totalSubnets))), cfg.IPv6.Size)
+			if !usedSubnets[candidate.IP] {
+				return candidate, nil
+			}
+		}
+	}
+
+	m.logger.Debug("using sequential search strategy for IPv6 subnet allocation",
+		zap.Float64("utilization", utilizationPct),
+		zap.String("network", cfg.Name),
+		zap.Int("used", len(usedSubnets)),
+		zap.Uint64("total", totalSubnets))
+
+	for index := uint64(0); index < totalSubnets; index++ {
+		candidate := cfg.IPv6.Network.Subnet(index, cfg.IPv6.Size)
+		if !usedSubnets[candidate.IP] {
+			return candidate, nil
+		}
+	}
+
+	// If we reached this point, the network is completely full and we have no
+	// available subnets to allocate.
+	return nil, fmt.Errorf("network %s IPv6 range is full", cfg.Name)
 }
 
 // rnd is a package-level random number generator. It is initialized once with
@@ -1,12 +1,16 @@
 package network
 
 import (
+	"github.com/rasorp/smuggle/internal/network/provider/hostgw"
 	"github.com/rasorp/smuggle/internal/network/provider/vxlan"
+	"github.com/rasorp/smuggle/internal/network/provider/wireguard"
 	"github.com/rasorp/smuggle/internal/types"
 )
 
 func (m *Manager) setProviderMap() {
 	m.providers = map[string]types.NetworkProvider{
-		"vxlan": vxlan.New(m.logger),
+		types.ProviderNameVXLAN:     vxlan.New(m.logger),
+		types.ProviderNameWireGuard: wireguard.New(m.logger),
+		types.ProviderNameHostGW:    hostgw.New(m.logger),
 	}
 }
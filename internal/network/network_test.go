@@ -0,0 +1,202 @@
+package network
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/shoenig/test/must"
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func TestManager_GenerateIPv4Subnet_mixedSizes(t *testing.T) {
+
+	m := &Manager{logger: zap.NewNop(), fingerprint: &networkFingerprint{iface: &net.Interface{MTU: 1500}}}
+
+	var network types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/22","size":26,"size_classes":[{"size":25,"node_classes":["large"]},{"size":28,"node_classes":["small"]}]},"provider":{"name":"vxlan"}}`,
+	), &network))
+	network.Canonicalize()
+
+	var subnets []*types.Subnet
+
+	allocate := func(size uint) error {
+		subnet, err := m.GenerateIPv4Subnet("client", &network, size, subnets)
+		if err != nil {
+			return err
+		}
+		must.Eq(t, size, subnet.IPv4Network.Size)
+		must.True(t, network.ContainsSubnet(subnet))
+
+		for _, existing := range subnets {
+			must.False(t, existing.IPv4Network.Overlap(subnet.IPv4Network),
+				must.Sprintf("%s overlaps %s", subnet.IPv4Network, existing.IPv4Network))
+		}
+
+		subnets = append(subnets, subnet)
+		return nil
+	}
+
+	// Allocate subnets of each size until the network can no longer hold
+	// them, then fill the remaining space with the smallest subnets.
+	for _, size := range []uint{25, 28, 26, 28, 25, 26} {
+		for allocate(size) == nil {
+		}
+	}
+
+	err := allocate(28)
+	must.ErrorContains(t, err, "is full")
+
+	// Without fragmentation, the allocations should cover the whole range
+	// between the reserved first and last default sized subnets.
+	var addrs uint64
+	for _, subnet := range subnets {
+		addrs += 1 << (32 - subnet.IPv4Network.Size)
+	}
+	must.Eq(t, 1024-128, addrs)
+
+	// Subnets belonging to other networks should not be considered used.
+	for _, subnet := range subnets {
+		subnet.NetworkName = "other"
+	}
+	_, err = m.GenerateIPv4Subnet("client", &network, 25, subnets)
+	must.NoError(t, err)
+}
+
+func TestManager_GenerateIPv4Subnet_unalignedSubnet(t *testing.T) {
+
+	m := &Manager{logger: zap.NewNop(), fingerprint: &networkFingerprint{iface: &net.Interface{MTU: 1500}}}
+
+	var network types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/22","size":25,"size_classes":[{"size":26,"node_classes":["small"]}]},"provider":{"name":"vxlan"}}`,
+	), &network))
+	network.Canonicalize()
+
+	// A subnet allocated from a minimum address which was not aligned to the
+	// default size, before the size classes were configured, spans two
+	// aligned blocks of its size.
+	subnets := []*types.Subnet{{
+		NetworkName: "vxlan",
+		IPv4Network: &types.IPv4Net{IP: 0x0A0A00C0, Size: 25},
+	}}
+
+	for _, size := range []uint{25, 26} {
+		for {
+			subnet, err := m.GenerateIPv4Subnet("client", &network, size, subnets)
+			if err != nil {
+				break
+			}
+			for _, existing := range subnets {
+				must.False(t, existing.IPv4Network.Overlap(subnet.IPv4Network),
+					must.Sprintf("%s overlaps %s", subnet.IPv4Network, existing.IPv4Network))
+			}
+			subnets = append(subnets, subnet)
+		}
+	}
+}
+
+func TestManager_GenerateIPv6Subnet(t *testing.T) {
+
+	m := &Manager{logger: zap.NewNop()}
+
+	var network types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"ipv6":{"network":"fd00:10::/124","size":126},"provider":{"name":"vxlan"}}`,
+	), &network))
+
+	// The network only contains four subnets, so allocating them all should
+	// result in four unique subnets followed by an error.
+	var subnets []*types.Subnet
+
+	for range 4 {
+		ipv6Network, err := m.GenerateIPv6Subnet(&network, subnets)
+		must.NoError(t, err)
+		must.Eq(t, 126, ipv6Network.Size)
+
+		for _, subnet := range subnets {
+			must.NotEq(t, subnet.IPv6Network.String(), ipv6Network.String())
+		}
+
+		subnets = append(subnets, &types.Subnet{
+			NetworkName: network.Name,
+			IPv6Network: ipv6Network,
+		})
+	}
+
+	_, err := m.GenerateIPv6Subnet(&network, subnets)
+	must.ErrorContains(t, err, "is full")
+
+	// Subnets belonging to other networks should not be considered used.
+	for _, subnet := range subnets {
+		subnet.NetworkName = "other"
+	}
+	_, err = m.GenerateIPv6Subnet(&network, subnets)
+	must.NoError(t, err)
+
+	// Networks without an IPv6 configuration cannot be used.
+	network.IPv6 = nil
+	_, err = m.GenerateIPv6Subnet(&network, nil)
+	must.Error(t, err)
+}
+
+func TestManager_setHostAddrs(t *testing.T) {
+
+	ipv4Addr := net.ParseIP("192.168.1.10")
+	ipv6Addr := net.ParseIP("2001:db8::10")
+
+	testCases := []struct {
+		name         string
+		fingerprint  *networkFingerprint
+		expectedIPv4 net.IP
+		expectedIPv6 net.IP
+	}{
+		{
+			name:         "ipv4 only",
+			fingerprint:  &networkFingerprint{ipv4Addr: ipv4Addr},
+			expectedIPv4: ipv4Addr,
+		},
+		{
+			name:         "ipv6 only",
+			fingerprint:  &networkFingerprint{ipv6Addr: ipv6Addr},
+			expectedIPv6: ipv6Addr,
+		},
+		{
+			name:         "dual-stack",
+			fingerprint:  &networkFingerprint{ipv4Addr: ipv4Addr, ipv6Addr: ipv6Addr},
+			expectedIPv4: ipv4Addr,
+			expectedIPv6: ipv6Addr,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &Manager{logger: zap.NewNop(), fingerprint: tc.fingerprint}
+
+			// Start with both addresses set, to ensure stale addresses are
+			// removed when no longer present on the host interface.
+			staleIPv4 := net.ParseIP("10.0.0.1")
+			staleIPv6 := net.ParseIP("2001:db8::1")
+			subnet := types.Subnet{HostIPv4: &staleIPv4, HostIPv6: &staleIPv6}
+
+			m.setHostAddrs(&subnet)
+
+			if tc.expectedIPv4 == nil {
+				must.Nil(t, subnet.HostIPv4)
+			} else {
+				must.NotNil(t, subnet.HostIPv4)
+				must.True(t, tc.expectedIPv4.Equal(*subnet.HostIPv4))
+			}
+
+			if tc.expectedIPv6 == nil {
+				must.Nil(t, subnet.HostIPv6)
+			} else {
+				must.NotNil(t, subnet.HostIPv6)
+				must.True(t, tc.expectedIPv6.Equal(*subnet.HostIPv6))
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package hostgw
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/helper/retry"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+const (
+	providerName = types.ProviderNameHostGW
+)
+
+// Provider implements a network provider which routes traffic to remote
+// subnets directly via the remote host IP address. It does not perform any
+// encapsulation, so requires all hosts to share a layer 2 network.
+type Provider struct {
+	logger *zap.Logger
+}
+
+func New(logger *zap.Logger) types.NetworkProvider {
+	return &Provider{
+		logger: logger.Named(providerName),
+	}
+}
+
+func (p *Provider) Name() string { return providerName }
+
+func (p *Provider) SetLocal(
+	req *types.NetworkProviderSetReq,
+) (*types.NetworkProviderSetResp, error) {
+
+	// Enable IPv4 forwarding to allow routing between the bridge and host
+	// interfaces.
+	if _, err := sysctl.Sysctl("net/ipv4/ip_forward", "1"); err != nil {
+		return nil, fmt.Errorf("failed to enable ipv4 forwarding: %w", err)
+	}
+
+	p.logger.Info("setup local host-gw subnet",
+		zap.String("host_interface", req.HostInteface.Name),
+		zap.Int("mtu", req.HostInteface.MTU),
+	)
+
+	// Create a copy of the subnet to avoid mutating the request object. There
+	// is no encapsulation overhead, so the full host interface MTU is used.
+	respSubnet := req.Client.Copy()
+	respSubnet.MTU = req.HostInteface.MTU
+
+	return &types.NetworkProviderSetResp{Network: respSubnet}, nil
+}
+
+func (p *Provider) DeleteLocal(
+	req *types.NetworkProviderDeleteLocalReq,
+) (*types.NetworkProviderDeleteLocalResp, error) {
+
+	// There is no local interface to remove, but the routes to remote subnets
+	// of the network must be removed, as they are added via the host
+	// interface.
+	routes, err := gatewayRoutes(req.Network.IPv4.Network.ToIPNet())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, route := range routes {
+		if err := p.deleteRoute(route); err != nil {
+			return nil, err
+		}
+	}
+
+	p.logger.Info("deleted local host-gw subnet", zap.String("network_name", req.Network.Name))
+
+	return &types.NetworkProviderDeleteLocalResp{}, nil
+}
+
+func (p *Provider) DeleteRemote(
+	req *types.NetworkProviderDeleteRemoteReq,
+) (*types.NetworkProviderDeleteRemoteResp, error) {
+
+	if req.Subnet.HostIPv4 == nil {
+		return nil, errors.New("remote subnet does not have a host IPv4 address")
+	}
+
+	route := &netlink.Route{
+		Dst:   req.Subnet.IPv4Network.ToIPNet(),
+		Gw:    *req.Subnet.HostIPv4,
+		Scope: netlink.SCOPE_UNIVERSE,
+	}
+
+	if err := retry.Retry(func() error {
+		err := netlink.RouteDel(route)
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			p.logger.Warn("failed to delete route", zap.Error(err))
+			return err
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return &types.NetworkProviderDeleteRemoteResp{}, nil
+}
+
+func (p *Provider) SetRemote(
+	req *types.NetworkProviderSetRemoteReq,
+) (*types.NetworkProviderSetRemoteResp, error) {
+
+	if req.Subnet.HostIPv4 == nil {
+		return nil, errors.New("remote subnet does not have a host IPv4 address")
+	}
+
+	linkIndex, err := directLinkIndex(*req.Subnet.HostIPv4)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add a route to the remote subnet using the remote host as the gateway.
+	// The kernel will forward the packets unencapsulated on the link that the
+	// remote host is directly connected to.
+	route := &netlink.Route{
+		LinkIndex: linkIndex,
+		Dst:       req.Subnet.IPv4Network.ToIPNet(),
+		Gw:        *req.Subnet.HostIPv4,
+		Scope:     netlink.SCOPE_UNIVERSE,
+	}
+
+	if err := retry.Retry(func() error {
+		err := netlink.RouteReplace(route)
+		if err != nil {
+			p.logger.Warn("failed to add route", zap.Error(err))
+			return err
+		}
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return &types.NetworkProviderSetRemoteResp{}, nil
+}
+
+// gatewayRoutes returns the IPv4 routes via a gateway to destinations within
+// the passed network, which are the routes added for remote subnets.
+func gatewayRoutes(network *net.IPNet) ([]netlink.Route, error) {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	var filtered []netlink.Route
+
+	for _, route := range routes {
+		if route.Gw != nil && route.Dst != nil && network.Contains(route.Dst.IP) {
+			filtered = append(filtered, route)
+		}
+	}
+
+	return filtered, nil
+}
+
+// deleteRoute deletes the route, ignoring routes which no longer exist.
+func (p *Provider) deleteRoute(route netlink.Route) error {
+	if err := netlink.RouteDel(&route); err != nil && !errors.Is(err, syscall.ESRCH) {
+		p.logger.Warn("failed to delete route", zap.Error(err))
+		return fmt.Errorf("failed to delete route to %s: %w", route.Dst, err)
+	}
+	return nil
+}
+
+// directLinkIndex returns the index of the link through which the passed IP is
+// directly reachable. An error is returned if the kernel would route traffic
+// to the IP via a gateway, as host-gw routing would not work in this case.
+func directLinkIndex(ip net.IP) (int, error) {
+	routes, err := netlink.RouteGet(ip)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup route to peer host %s: %w", ip, err)
+	}
+	if len(routes) == 0 {
+		return 0, fmt.Errorf("no route found to peer host %s", ip)
+	}
+
+	if routes[0].Gw != nil {
+		return 0, fmt.Errorf(
+			"peer host %s is not on a directly connected network (routed via %s); host-gw requires all hosts to share a layer 2 network",
+			ip, routes[0].Gw,
+		)
+	}
+
+	return routes[0].LinkIndex, nil
+}
@@ -0,0 +1,72 @@
+package hostgw
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// Reconcile ensures a route exists to each remote subnet via the remote host
+// and removes routes within the network which do not belong to a remote
+// subnet.
+func (p *Provider) Reconcile(
+	req *types.NetworkProviderReconcileReq,
+) (*types.NetworkProviderReconcileResp, error) {
+
+	routes, err := gatewayRoutes(req.Network.IPv4.Network.ToIPNet())
+	if err != nil {
+		return nil, err
+	}
+
+	// Index the existing routes by destination, so each remote subnet can be
+	// checked for a matching route.
+	existing := make(map[string]netlink.Route, len(routes))
+	for _, route := range routes {
+		existing[route.Dst.String()] = route
+	}
+
+	var (
+		resp    types.NetworkProviderReconcileResp
+		errs    []error
+		desired = make(map[string]struct{}, len(req.Remotes))
+	)
+
+	for _, remote := range req.Remotes {
+		if remote.HostIPv4 == nil {
+			continue
+		}
+
+		dst := remote.IPv4Network.ToIPNet().String()
+		desired[dst] = struct{}{}
+
+		if route, ok := existing[dst]; ok && route.Gw.Equal(*remote.HostIPv4) {
+			continue
+		}
+
+		if _, err := p.SetRemote(&types.NetworkProviderSetRemoteReq{Subnet: remote}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to repair remote subnet %s: %w", remote.ClientID, err))
+			continue
+		}
+		resp.Repaired++
+	}
+
+	for dst, route := range existing {
+		if _, ok := desired[dst]; ok {
+			continue
+		}
+		if err := p.deleteRoute(route); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resp.Removed++
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
@@ -18,9 +18,21 @@ type Config struct {
 
 	// MTU is the Maximum Transmission Unit for the VXLAN interface. This is
 	// typically set to the host interface MTU minus the VXLAN overhead of 50
-	// bytes.
+	// bytes, or 70 bytes when using an IPv6 underlay.
 	MTU int `json:"mtu"`
 
+	// Underlay is the IP address family used for the VXLAN tunnel endpoints
+	// and must be either "ipv4" or "ipv6". This defaults to "ipv4" if the host
+	// interface has an IPv4 address, otherwise "ipv6". All hosts within the
+	// network must use the same underlay.
+	Underlay string `json:"underlay"`
+
+	// DirectRouting enables routing traffic directly via the remote host IP
+	// address, without encapsulation, when the remote host is on the same
+	// layer 2 network as the host interface. Remote hosts on other networks
+	// continue to use the VXLAN overlay.
+	DirectRouting bool `json:"direct_routing"`
+
 	// VtepMAC is the MAC address of the local VXLAN interface that was created
 	// for the subnet.
 	VtepMAC string `json:"vtep_mac"`
@@ -33,6 +45,8 @@ func (c *Config) loggingPairs() []zap.Field {
 		zap.Int("vni", c.VNI),
 		zap.Int("port", c.Port),
 		zap.Int("mtu", c.MTU),
+		zap.String("underlay", c.Underlay),
+		zap.Bool("direct_routing", c.DirectRouting),
 		zap.String("vtep_mac", c.VtepMAC),
 	}
 }
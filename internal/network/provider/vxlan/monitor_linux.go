@@ -0,0 +1,240 @@
+package vxlan
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// expectedDeletionTTL is how long after the provider deletes an entry itself
+// the resulting monitor event is ignored. The event is delivered as soon as
+// the entry is deleted, so this only needs to cover a slow monitor.
+const expectedDeletionTTL = 30 * time.Second
+
+// Monitor subscribes to netlink link, route and neighbor updates and sends an
+// event whenever a VXLAN link set up by this provider, or an overlay route,
+// FDB or neighbor entry on one, is removed from the host. Direct routes are
+// added via the host interface and are not monitored; they are repaired by
+// the periodic reconciliation.
+func (p *Provider) Monitor(req *types.NetworkProviderMonitorReq) error {
+
+	linkCh := make(chan netlink.LinkUpdate)
+	routeCh := make(chan netlink.RouteUpdate)
+	neighCh := make(chan netlink.NeighUpdate)
+
+	errorCallback := func(err error) {
+		p.logger.Error("error received from netlink subscription", zap.Error(err))
+	}
+
+	if err := netlink.LinkSubscribeWithOptions(linkCh, req.Context.Done(), netlink.LinkSubscribeOptions{
+		ErrorCallback: errorCallback,
+	}); err != nil {
+		return fmt.Errorf("failed to subscribe to link updates: %w", err)
+	}
+
+	if err := netlink.RouteSubscribeWithOptions(routeCh, req.Context.Done(), netlink.RouteSubscribeOptions{
+		ErrorCallback: errorCallback,
+	}); err != nil {
+		return fmt.Errorf("failed to subscribe to route updates: %w", err)
+	}
+
+	if err := netlink.NeighSubscribeWithOptions(neighCh, req.Context.Done(), netlink.NeighSubscribeOptions{
+		ErrorCallback: errorCallback,
+	}); err != nil {
+		return fmt.Errorf("failed to subscribe to neighbor updates: %w", err)
+	}
+
+	go p.monitorImpl(req.Context, req.EventCh, linkCh, routeCh, neighCh)
+
+	return nil
+}
+
+func (p *Provider) monitorImpl(
+	ctx context.Context,
+	eventCh chan<- *types.NetworkProviderEvent,
+	linkCh <-chan netlink.LinkUpdate,
+	routeCh <-chan netlink.RouteUpdate,
+	neighCh <-chan netlink.NeighUpdate,
+) {
+	for {
+		var event *types.NetworkProviderEvent
+
+		select {
+		case <-ctx.Done():
+			return
+		case update, ok := <-linkCh:
+			if !ok {
+				return
+			}
+			event = p.linkEvent(update)
+		case update, ok := <-routeCh:
+			if !ok {
+				return
+			}
+			event = p.routeEvent(update)
+		case update, ok := <-neighCh:
+			if !ok {
+				return
+			}
+			event = p.neighEvent(update)
+		}
+
+		if event == nil {
+			continue
+		}
+
+		select {
+		case eventCh <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// linkEvent returns an event if the update describes the deletion of a
+// tracked VXLAN link. The link is no longer tracked once deleted, as it will
+// have a new index when it is set up again.
+func (p *Provider) linkEvent(update netlink.LinkUpdate) *types.NetworkProviderEvent {
+	if update.Header.Type != unix.RTM_DELLINK {
+		return nil
+	}
+
+	networkName, ok := p.trackedLink(update.Attrs().Index)
+	if !ok {
+		return nil
+	}
+	p.untrackLink(update.Attrs().Index)
+
+	return &types.NetworkProviderEvent{
+		NetworkName: networkName,
+		LinkDeleted: true,
+		Reason:      fmt.Sprintf("VXLAN link %q deleted", update.Attrs().Name),
+	}
+}
+
+// routeEvent returns an event if the update describes the deletion of an
+// overlay route via a gateway on a tracked VXLAN link, which was not deleted by
+// the provider itself.
+func (p *Provider) routeEvent(update netlink.RouteUpdate) *types.NetworkProviderEvent {
+	if update.Type != unix.RTM_DELROUTE || update.Gw == nil || update.Dst == nil {
+		return nil
+	}
+
+	networkName, ok := p.trackedLink(update.LinkIndex)
+	if !ok {
+		return nil
+	}
+
+	if p.deletionExpected(routeDeletionKey(&update.Route)) {
+		return nil
+	}
+
+	return &types.NetworkProviderEvent{
+		NetworkName: networkName,
+		Reason:      fmt.Sprintf("route to %s deleted", update.Dst),
+	}
+}
+
+// neighEvent returns an event if the update describes the deletion of a
+// permanent FDB or neighbor entry on a tracked VXLAN link, which was not
+// deleted by the provider itself. Entries learned by the kernel are not
+// permanent and are ignored.
+func (p *Provider) neighEvent(update netlink.NeighUpdate) *types.NetworkProviderEvent {
+	if update.Type != unix.RTM_DELNEIGH || update.State&netlink.NUD_PERMANENT == 0 {
+		return nil
+	}
+
+	networkName, ok := p.trackedLink(update.LinkIndex)
+	if !ok {
+		return nil
+	}
+
+	if p.deletionExpected(neighDeletionKey(&update.Neigh)) {
+		return nil
+	}
+
+	return &types.NetworkProviderEvent{
+		NetworkName: networkName,
+		Reason:      fmt.Sprintf("neighbor entry for %s deleted", update.IP),
+	}
+}
+
+// expectRouteDeletion records that the provider is deleting the route.
+func (p *Provider) expectRouteDeletion(route *netlink.Route) {
+	p.expectDeletion(routeDeletionKey(route))
+}
+
+// expectNeighDeletion records that the provider is deleting the FDB or
+// neighbor entry.
+func (p *Provider) expectNeighDeletion(neigh *netlink.Neigh) {
+	p.expectDeletion(neighDeletionKey(neigh))
+}
+
+func (p *Provider) expectDeletion(key string) {
+	p.deletionsLock.Lock()
+	defer p.deletionsLock.Unlock()
+
+	now := time.Now()
+
+	// Deletions which failed never generate an event, so their records are
+	// pruned once they can no longer be matched.
+	for existing, deleted := range p.deletions {
+		if now.Sub(deleted) > expectedDeletionTTL {
+			delete(p.deletions, existing)
+		}
+	}
+
+	p.deletions[key] = now
+}
+
+// deletionExpected indicates whether the provider recently deleted the entry
+// with the passed key, consuming the record, so a later deletion of the same
+// entry by another process still generates an event.
+func (p *Provider) deletionExpected(key string) bool {
+	p.deletionsLock.Lock()
+	defer p.deletionsLock.Unlock()
+
+	deleted, ok := p.deletions[key]
+	if !ok {
+		return false
+	}
+	delete(p.deletions, key)
+
+	return time.Since(deleted) <= expectedDeletionTTL
+}
+
+// routeDeletionKey identifies an overlay route by its link and destination.
+func routeDeletionKey(route *netlink.Route) string {
+	return fmt.Sprintf("route/%d/%s", route.LinkIndex, route.Dst)
+}
+
+// neighDeletionKey identifies an FDB or neighbor entry by its link, family and
+// address.
+func neighDeletionKey(neigh *netlink.Neigh) string {
+	return fmt.Sprintf("neigh/%d/%d/%s", neigh.LinkIndex, neigh.Family, neigh.IP)
+}
+
+func (p *Provider) trackLink(index int, networkName string) {
+	p.linksLock.Lock()
+	defer p.linksLock.Unlock()
+	p.links[index] = networkName
+}
+
+func (p *Provider) untrackLink(index int) {
+	p.linksLock.Lock()
+	defer p.linksLock.Unlock()
+	delete(p.links, index)
+}
+
+func (p *Provider) trackedLink(index int) (string, bool) {
+	p.linksLock.RLock()
+	defer p.linksLock.RUnlock()
+	name, ok := p.links[index]
+	return name, ok
+}
@@ -0,0 +1,59 @@
+package vxlan
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/shoenig/test/must"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+func TestProvider_routeEvent(t *testing.T) {
+
+	p := New(zap.NewNop()).(*Provider)
+	p.trackLink(10, "vxlan")
+
+	_, dst, err := net.ParseCIDR("10.10.1.0/24")
+	must.NoError(t, err)
+
+	update := netlink.RouteUpdate{
+		Type:  unix.RTM_DELROUTE,
+		Route: netlink.Route{LinkIndex: 10, Dst: dst, Gw: net.ParseIP("10.10.1.1")},
+	}
+
+	// Routes deleted by another process should trigger a repair.
+	event := p.routeEvent(update)
+	must.NotNil(t, event)
+	must.Eq(t, "vxlan", event.NetworkName)
+
+	// Routes deleted by the provider itself should be ignored once, so a
+	// later deletion by another process is still repaired.
+	p.expectRouteDeletion(&netlink.Route{LinkIndex: 10, Dst: dst, Gw: net.ParseIP("10.10.1.1")})
+	must.Nil(t, p.routeEvent(update))
+	must.NotNil(t, p.routeEvent(update))
+}
+
+func TestProvider_neighEvent(t *testing.T) {
+
+	p := New(zap.NewNop()).(*Provider)
+	p.trackLink(10, "vxlan")
+
+	neigh := netlink.Neigh{
+		LinkIndex: 10,
+		Family:    syscall.AF_BRIDGE,
+		State:     netlink.NUD_PERMANENT,
+		IP:        net.ParseIP("192.168.1.20"),
+	}
+	update := netlink.NeighUpdate{Type: unix.RTM_DELNEIGH, Neigh: neigh}
+
+	p.expectNeighDeletion(&neigh)
+	must.Nil(t, p.neighEvent(update))
+	must.NotNil(t, p.neighEvent(update))
+
+	// Entries on links which are not tracked should be ignored.
+	update.LinkIndex = 11
+	must.Nil(t, p.neighEvent(update))
+}
@@ -0,0 +1,298 @@
+package vxlan
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// linkEntries holds the FDB, neighbor and route entries which route traffic
+// to remote subnets. Entries are keyed so the entries expected for the remote
+// subnets can be compared with those that exist on the host.
+type linkEntries struct {
+	// fdb is keyed by the remote VTEP MAC and IP address.
+	fdb map[string]netlink.Neigh
+
+	// neighbors is keyed by the remote gateway IP address.
+	neighbors map[string]netlink.Neigh
+
+	// overlayRoutes are routes via the VXLAN interface, keyed by destination.
+	overlayRoutes map[string]netlink.Route
+
+	// directRoutes are routes via the host interface, keyed by destination.
+	directRoutes map[string]netlink.Route
+}
+
+func newLinkEntries() *linkEntries {
+	return &linkEntries{
+		fdb:           make(map[string]netlink.Neigh),
+		neighbors:     make(map[string]netlink.Neigh),
+		overlayRoutes: make(map[string]netlink.Route),
+		directRoutes:  make(map[string]netlink.Route),
+	}
+}
+
+// Reconcile ensures the FDB, neighbor and route entries for each remote subnet
+// exist and removes entries which do not belong to a remote subnet.
+func (p *Provider) Reconcile(
+	req *types.NetworkProviderReconcileReq,
+) (*types.NetworkProviderReconcileResp, error) {
+
+	name := req.Local.InterfaceName()
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find vxlan link %s: %w", name, err)
+	}
+
+	vxlan, ok := link.(*netlink.Vxlan)
+	if !ok {
+		return nil, fmt.Errorf("link %s is not a vxlan interface", name)
+	}
+
+	existing, err := listLinkEntries(vxlan, req.Network.IPv4.Network.ToIPNet())
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		resp    types.NetworkProviderReconcileResp
+		errs    []error
+		desired = newLinkEntries()
+	)
+
+	for _, remote := range req.Remotes {
+		expected, err := remoteLinkEntries(remote, vxlan)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid remote subnet %s: %w", remote.ClientID, err))
+			continue
+		}
+
+		desired.merge(expected)
+
+		if existing.contains(expected) {
+			continue
+		}
+
+		if _, err := p.SetRemote(&types.NetworkProviderSetRemoteReq{Subnet: remote}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to repair remote subnet %s: %w", remote.ClientID, err))
+			continue
+		}
+		resp.Repaired++
+	}
+
+	// Removing overlay routes and neighbor entries generates monitor events,
+	// which are expected, so must not trigger a repair.
+	for key, route := range existing.overlayRoutes {
+		if _, ok := desired.overlayRoutes[key]; !ok {
+			p.expectRouteDeletion(&route)
+			errs = append(errs, p.removeStale("route", key, netlink.RouteDel(&route), &resp))
+		}
+	}
+	for key, route := range existing.directRoutes {
+		if _, ok := desired.directRoutes[key]; !ok {
+			errs = append(errs, p.removeStale("direct route", key, netlink.RouteDel(&route), &resp))
+		}
+	}
+	for key, neigh := range existing.neighbors {
+		if _, ok := desired.neighbors[key]; !ok {
+			p.expectNeighDeletion(&neigh)
+			errs = append(errs, p.removeStale("neighbor entry", key, netlink.NeighDel(&neigh), &resp))
+		}
+	}
+	for key, neigh := range existing.fdb {
+		if _, ok := desired.fdb[key]; !ok {
+			p.expectNeighDeletion(&neigh)
+			errs = append(errs, p.removeStale("FDB entry", key, netlink.NeighDel(&neigh), &resp))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// removeStale handles the result of removing a stale entry. An entry which no
+// longer exists is considered removed.
+func (p *Provider) removeStale(
+	kind, key string, err error, resp *types.NetworkProviderReconcileResp,
+) error {
+	if err != nil && !errors.Is(err, syscall.ESRCH) && !errors.Is(err, syscall.ENOENT) {
+		p.logger.Warn("failed to remove stale "+kind, zap.String("key", key), zap.Error(err))
+		return fmt.Errorf("failed to remove stale %s %s: %w", kind, key, err)
+	}
+
+	p.logger.Info("removed stale "+kind, zap.String("key", key))
+	resp.Removed++
+
+	return nil
+}
+
+// listLinkEntries returns the permanent FDB and neighbor entries and the
+// gateway routes on the VXLAN interface, along with the direct routes to
+// destinations within the network via other interfaces.
+func listLinkEntries(vxlan *netlink.Vxlan, network *net.IPNet) (*linkEntries, error) {
+
+	entries := newLinkEntries()
+
+	fdbs, err := netlink.NeighList(vxlan.Index, syscall.AF_BRIDGE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list FDB entries: %w", err)
+	}
+	for _, fdb := range fdbs {
+		if fdb.IP != nil && fdb.State&netlink.NUD_PERMANENT != 0 {
+			entries.fdb[fdbKey(fdb.HardwareAddr, fdb.IP)] = fdb
+		}
+	}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		neighs, err := netlink.NeighList(vxlan.Index, family)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list neighbor entries: %w", err)
+		}
+		for _, neigh := range neighs {
+			if neigh.State&netlink.NUD_PERMANENT != 0 {
+				entries.neighbors[neigh.IP.String()] = neigh
+			}
+		}
+
+		routes, err := netlink.RouteList(vxlan, family)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list routes: %w", err)
+		}
+		for _, route := range routes {
+			if route.Gw != nil && route.Dst != nil {
+				entries.overlayRoutes[route.Dst.String()] = route
+			}
+		}
+	}
+
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %w", err)
+	}
+	for _, route := range routes {
+		if route.LinkIndex != vxlan.Index && route.Gw != nil && route.Dst != nil && network.Contains(route.Dst.IP) {
+			entries.directRoutes[route.Dst.String()] = route
+		}
+	}
+
+	return entries, nil
+}
+
+// remoteLinkEntries returns the entries SetRemote adds for the remote subnet.
+// The entries are only populated with the fields used for comparison.
+func remoteLinkEntries(remote *types.Subnet, vxlan *netlink.Vxlan) (*linkEntries, error) {
+
+	cfg, err := parseRemoteConfig(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	vtepIP, err := underlayAddr(remote, cfg.Underlay)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := newLinkEntries()
+
+	direct := false
+
+	if cfg.DirectRouting && remote.HostIPv4 != nil {
+		if direct, err = isDirectlyRoutable(*remote.HostIPv4, vxlan.VtepDevIndex); err != nil {
+			return nil, err
+		}
+		if direct {
+			dst := remote.IPv4Network.ToIPNet()
+			entries.directRoutes[dst.String()] = netlink.Route{Dst: dst, Gw: *remote.HostIPv4}
+		}
+	}
+
+	if direct && remote.IPv6Network == nil {
+		return entries, nil
+	}
+
+	hwAddr, err := net.ParseMAC(cfg.VtepMAC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse VTEP MAC address: %w", err)
+	}
+
+	entries.fdb[fdbKey(hwAddr, vtepIP)] = netlink.Neigh{IP: vtepIP, HardwareAddr: hwAddr}
+
+	if !direct {
+		gatewayIP := remote.IPv4Network.NextAddr().IP.ToNetIP()
+		entries.addOverlayRoute(remote.IPv4Network.ToIPNet(), gatewayIP, hwAddr)
+	}
+
+	if remote.IPv6Network != nil {
+		gatewayIP := remote.IPv6Network.NextAddr().IP.ToNetIP()
+		entries.addOverlayRoute(remote.IPv6Network.ToIPNet(), gatewayIP, hwAddr)
+	}
+
+	return entries, nil
+}
+
+// addOverlayRoute adds the route and neighbor entries which setOverlayRoute
+// adds for the destination.
+func (e *linkEntries) addOverlayRoute(dst *net.IPNet, gatewayIP net.IP, hwAddr net.HardwareAddr) {
+	e.overlayRoutes[dst.String()] = netlink.Route{Dst: dst, Gw: gatewayIP}
+	e.neighbors[gatewayIP.String()] = netlink.Neigh{IP: gatewayIP, HardwareAddr: hwAddr}
+}
+
+// contains reports whether all the passed entries exist with matching
+// gateways and hardware addresses.
+func (e *linkEntries) contains(other *linkEntries) bool {
+	for key := range other.fdb {
+		if _, ok := e.fdb[key]; !ok {
+			return false
+		}
+	}
+	for key, neigh := range other.neighbors {
+		existing, ok := e.neighbors[key]
+		if !ok || existing.HardwareAddr.String() != neigh.HardwareAddr.String() {
+			return false
+		}
+	}
+	for key, route := range other.overlayRoutes {
+		existing, ok := e.overlayRoutes[key]
+		if !ok || !existing.Gw.Equal(route.Gw) {
+			return false
+		}
+	}
+	for key, route := range other.directRoutes {
+		existing, ok := e.directRoutes[key]
+		if !ok || !existing.Gw.Equal(route.Gw) {
+			return false
+		}
+	}
+	return true
+}
+
+// merge adds the passed entries to the entries.
+func (e *linkEntries) merge(other *linkEntries) {
+	for key, fdb := range other.fdb {
+		e.fdb[key] = fdb
+	}
+	for key, neigh := range other.neighbors {
+		e.neighbors[key] = neigh
+	}
+	for key, route := range other.overlayRoutes {
+		e.overlayRoutes[key] = route
+	}
+	for key, route := range other.directRoutes {
+		e.directRoutes[key] = route
+	}
+}
+
+// fdbKey returns the key identifying an FDB entry for a remote VTEP.
+func fdbKey(hwAddr net.HardwareAddr, ip net.IP) string {
+	return hwAddr.String() + "/" + ip.String()
+}
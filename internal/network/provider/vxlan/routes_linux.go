@@ -0,0 +1,95 @@
+package vxlan
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// ListRoutes lists the permanent FDB and neighbor entries and the gateway
+// routes on the VXLAN interface, along with the direct routes to remote
+// subnets via the host interface.
+func (p *Provider) ListRoutes(
+	req *types.NetworkProviderListRoutesReq,
+) (*types.NetworkProviderListRoutesResp, error) {
+
+	name := req.Local.InterfaceName()
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find vxlan link %s: %w", name, err)
+	}
+
+	vxlan, ok := link.(*netlink.Vxlan)
+	if !ok {
+		return nil, fmt.Errorf("link %s is not a vxlan interface", name)
+	}
+
+	entries, err := listLinkEntries(vxlan, req.Network.IPv4.Network.ToIPNet())
+	if err != nil {
+		return nil, err
+	}
+
+	routes := types.LocalRoutes{
+		NetworkName: req.Network.Name,
+		Provider:    providerName,
+		Routes:      []*types.RouteEntry{},
+		FDB:         []*types.FDBEntry{},
+		Neighbors:   []*types.NeighborEntry{},
+	}
+
+	for _, route := range entries.overlayRoutes {
+		routes.Routes = append(routes.Routes, &types.RouteEntry{
+			Destination: route.Dst.String(),
+			Gateway:     route.Gw.String(),
+			Interface:   vxlan.Name,
+		})
+	}
+	for _, route := range entries.directRoutes {
+		routes.Routes = append(routes.Routes, &types.RouteEntry{
+			Destination: route.Dst.String(),
+			Gateway:     route.Gw.String(),
+			Interface:   linkName(route.LinkIndex),
+		})
+	}
+	for _, fdb := range entries.fdb {
+		routes.FDB = append(routes.FDB, &types.FDBEntry{
+			MAC:         fdb.HardwareAddr.String(),
+			Destination: fdb.IP.String(),
+		})
+	}
+	for _, neigh := range entries.neighbors {
+		routes.Neighbors = append(routes.Neighbors, &types.NeighborEntry{
+			IP:  neigh.IP.String(),
+			MAC: neigh.HardwareAddr.String(),
+		})
+	}
+
+	// The entries are held in maps, so sort them to provide a stable output.
+	slices.SortFunc(routes.Routes, func(a, b *types.RouteEntry) int {
+		return strings.Compare(a.Destination, b.Destination)
+	})
+	slices.SortFunc(routes.FDB, func(a, b *types.FDBEntry) int {
+		return strings.Compare(a.Destination, b.Destination)
+	})
+	slices.SortFunc(routes.Neighbors, func(a, b *types.NeighborEntry) int {
+		return strings.Compare(a.IP, b.IP)
+	})
+
+	return &types.NetworkProviderListRoutesResp{Routes: &routes}, nil
+}
+
+// linkName returns the name of the link with the passed index, falling back to
+// the index if the link cannot be found.
+func linkName(index int) string {
+	link, err := netlink.LinkByIndex(index)
+	if err != nil {
+		return strconv.Itoa(index)
+	}
+	return link.Attrs().Name
+}
@@ -2,6 +2,7 @@ package vxlan
 
 import (
 	"fmt"
+	"net"
 
 	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 	"github.com/vishvananda/netlink"
@@ -13,6 +14,7 @@ func (p *Provider) createIPv4(
 	cfg *types.Subnet,
 	providerCfg *Config,
 	vtepDevIndex int,
+	srcAddr net.IP,
 ) (*netlink.Vxlan, error) {
 
 	intfName := cfg.InterfaceName()
@@ -24,7 +26,7 @@ func (p *Provider) createIPv4(
 		},
 		VxlanId:      providerCfg.VNI,
 		VtepDevIndex: vtepDevIndex,
-		SrcAddr:      *cfg.HostIPv4,
+		SrcAddr:      srcAddr,
 		Port:         providerCfg.Port,
 	}
 
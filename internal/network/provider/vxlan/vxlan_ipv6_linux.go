@@ -0,0 +1,56 @@
+package vxlan
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	"go.uber.org/zap"
+)
+
+// configureIPv6 configures the host to route IPv6 traffic for dual-stack
+// subnets. This should only be called when the subnet has an IPv6 network
+// allocated.
+func (p *Provider) configureIPv6(hostIface *net.Interface) error {
+
+	// Enabling IPv6 forwarding causes the kernel to ignore router
+	// advertisements on interfaces where accept_ra is set to 1, so a host
+	// using SLAAC on its default interface would lose its default route when
+	// it expires. Setting accept_ra to 2 keeps accepting advertisements while
+	// forwarding.
+	if err := p.acceptRouterAdvertisements(hostIface.Name); err != nil {
+		return err
+	}
+
+	// Enable IPv6 forwarding to allow routing between interfaces.
+	if _, err := sysctl.Sysctl("net/ipv6/conf/all/forwarding", "1"); err != nil {
+		return fmt.Errorf("failed to enable ipv6 forwarding: %w", err)
+	}
+
+	return nil
+}
+
+// acceptRouterAdvertisements sets accept_ra to 2 on the named interface when
+// it is set to 1, so the interface continues to accept router advertisements
+// once forwarding is enabled. Interfaces which have IPv6 disabled, or where
+// router advertisements are already disabled or always accepted, are left
+// untouched.
+func (p *Provider) acceptRouterAdvertisements(name string) error {
+
+	key := fmt.Sprintf("net/ipv6/conf/%s/accept_ra", name)
+
+	value, err := sysctl.Sysctl(key)
+	if err != nil || strings.TrimSpace(value) != "1" {
+		return nil
+	}
+
+	if _, err := sysctl.Sysctl(key, "2"); err != nil {
+		return fmt.Errorf("failed to set accept_ra on interface %q: %w", name, err)
+	}
+
+	p.logger.Info("enabled router advertisements while forwarding on host interface",
+		zap.String("interface", name))
+
+	return nil
+}
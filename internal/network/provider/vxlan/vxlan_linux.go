@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/vishvananda/netlink"
 	"go.uber.org/zap"
@@ -15,7 +17,7 @@ import (
 )
 
 const (
-	providerName = "vxlan"
+	providerName = types.ProviderNameVXLAN
 
 	// defaultVNI is the default VXLAN Network Identifier used if none is
 	// specified by the operator.
@@ -28,15 +30,38 @@ const (
 	// encapsulation. This is used to adjust the MTU of the VXLAN interface to
 	// avoid fragmentation.
 	vxlanEncapuslationOverhead = 50
+
+	// vxlanIPv6EncapuslationOverhead is the overhead in bytes introduced by
+	// VXLAN encapsulation when using an IPv6 underlay, which has a 20 byte
+	// larger outer IP header.
+	vxlanIPv6EncapuslationOverhead = 70
+
+	// underlayIPv4 and underlayIPv6 are the supported underlay config values.
+	underlayIPv4 = "ipv4"
+	underlayIPv6 = "ipv6"
 )
 
 type Provider struct {
 	logger *zap.Logger
+
+	// links maps the index of each VXLAN link set up by this provider to the
+	// name of its network, so the monitor can identify the events which
+	// concern links it owns.
+	links     map[int]string
+	linksLock sync.RWMutex
+
+	// deletions records when the provider deleted each overlay route and
+	// neighbor entry itself, keyed by deletionKey, so the monitor can ignore
+	// the resulting events rather than repairing the network.
+	deletions     map[string]time.Time
+	deletionsLock sync.Mutex
 }
 
 func New(logger *zap.Logger) types.NetworkProvider {
 	return &Provider{
-		logger: logger.Named(providerName),
+		logger:    logger.Named(providerName),
+		links:     make(map[int]string),
+		deletions: make(map[string]time.Time),
 	}
 }
 
@@ -49,7 +74,6 @@ func (p *Provider) SetLocal(
 	cfg := Config{
 		VNI:  defaultVNI,
 		Port: defaultPort,
-		MTU:  req.HostInteface.MTU - vxlanEncapuslationOverhead,
 	}
 
 	if req.Client.Config != nil {
@@ -58,11 +82,37 @@ func (p *Provider) SetLocal(
 		}
 	}
 
-	vxlanLink, err := p.createIPv4(req.Client, &cfg, req.HostInteface.Index)
+	// Default the underlay to IPv4 where possible, which maintains the
+	// behaviour of hosts that only published an IPv4 address.
+	if cfg.Underlay == "" {
+		cfg.Underlay = underlayIPv4
+		if req.Client.HostIPv4 == nil {
+			cfg.Underlay = underlayIPv6
+		}
+	}
+
+	srcAddr, err := underlayAddr(req.Client, cfg.Underlay)
 	if err != nil {
 		return nil, err
 	}
 
+	if cfg.MTU == 0 {
+		cfg.MTU = req.HostInteface.MTU - encapsulationOverhead(cfg.Underlay)
+	}
+
+	vxlanLink, err := p.createIPv4(req.Client, &cfg, req.HostInteface.Index, srcAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Client.IPv6Network != nil {
+		if err := p.configureIPv6(req.HostInteface); err != nil {
+			return nil, err
+		}
+	}
+
+	p.trackLink(vxlanLink.Attrs().Index, req.Client.NetworkName)
+
 	// Store the VXLAN interface's MAC address in the config. This will be used
 	// by remote hosts to set up FDB and ARP entries for this subnet.
 	//
@@ -83,26 +133,56 @@ func (p *Provider) SetLocal(
 	p.logger.Info("setup local VXLAN interface", cfg.loggingPairs()...)
 
 	// Create a copy of the subnet to avoid mutating the request object and
-	// ensure we don't accidentally modify the caller's data.
+	// ensure we don't accidentally modify the caller's data. The MTU is
+	// updated to reflect the underlay overhead, so containers are configured
+	// correctly.
 	respSubnet := req.Client.Copy()
 	respSubnet.Config = marshaledCfg
+	respSubnet.MTU = cfg.MTU
 
 	return &types.NetworkProviderSetResp{Network: respSubnet}, nil
 }
 
+func (p *Provider) DeleteLocal(
+	req *types.NetworkProviderDeleteLocalReq,
+) (*types.NetworkProviderDeleteLocalResp, error) {
+
+	// Stop tracking the link before it is deleted, so the monitor does not
+	// report the deletion as requiring repair.
+	if link, err := netlink.LinkByName(req.Client.InterfaceName()); err == nil {
+		p.untrackLink(link.Attrs().Index)
+	}
+
+	// Deleting the VXLAN link also removes the overlay routes and the FDB and
+	// neighbor entries which were added to it for remote subnets.
+	if err := deleteLink(req.Client.InterfaceName()); err != nil {
+		return nil, err
+	}
+
+	// Direct routes to remote subnets are added via the host interface, so
+	// are not removed along with the VXLAN link.
+	if err := p.deleteGatewayRoutes(req.Network.IPv4.Network.ToIPNet()); err != nil {
+		return nil, err
+	}
+
+	p.logger.Info("deleted local VXLAN interface", zap.String("name", req.Client.InterfaceName()))
+
+	return &types.NetworkProviderDeleteLocalResp{}, nil
+}
+
 func (p *Provider) DeleteRemote(
 	req *types.NetworkProviderDeleteRemoteReq,
 ) (*types.NetworkProviderDeleteRemoteResp, error) {
 
 	// Parse the provider config to get the VNI and VTEP MAC address.
-	var cfg Config
+	cfg, err := parseRemoteConfig(req.Subnet)
+	if err != nil {
+		return nil, err
+	}
 
-	if req.Subnet.Config != nil {
-		if err := json.Unmarshal(req.Subnet.Config, &cfg); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal config: %w", err)
-		}
-	} else {
-		cfg.VNI = defaultVNI
+	vtepIP, err := underlayAddr(req.Subnet, cfg.Underlay)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get the VXLAN link by name
@@ -118,48 +198,45 @@ func (p *Provider) DeleteRemote(
 		return nil, fmt.Errorf("link %q is not a VXLAN interface", name)
 	}
 
+	// If direct routing is enabled and the remote host shares a layer 2
+	// network with the host interface, only the direct IPv4 route was added,
+	// so remove that. IPv6 traffic always uses the overlay, so the VXLAN
+	// entries must still be removed for dual-stack subnets.
+	direct := false
+
+	if cfg.DirectRouting && req.Subnet.HostIPv4 != nil {
+		if direct, err = isDirectlyRoutable(*req.Subnet.HostIPv4, vxlan.VtepDevIndex); err != nil {
+			return nil, err
+		}
+		if direct {
+			if err := p.deleteDirectRoute(req.Subnet); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if direct && req.Subnet.IPv6Network == nil {
+		return &types.NetworkProviderDeleteRemoteResp{}, nil
+	}
+
 	// Get the remote VTEP MAC address for the subnet config.
 	hwAddr, err := net.ParseMAC(cfg.VtepMAC)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse MAC address: %w", err)
 	}
 
-	gatewayIP := req.Subnet.IPv4Network.NextAddr().IP.ToNetIP()
-	route := &netlink.Route{
-		LinkIndex: vxlan.Index,
-		Dst:       req.Subnet.IPv4Network.ToIPNet(),
-		Gw:        gatewayIP,
-		Scope:     netlink.SCOPE_UNIVERSE,
-	}
-	route.SetFlag(syscall.RTNH_F_ONLINK)
-
-	if err := retry.Retry(func() error {
-		err := netlink.RouteDel(route)
-		if err != nil {
-			p.logger.Warn("failed to delete link route", zap.Error(err))
-			return err
+	if !direct {
+		gatewayIP := req.Subnet.IPv4Network.NextAddr().IP.ToNetIP()
+		if err := p.deleteOverlayRoute(vxlan, req.Subnet.IPv4Network.ToIPNet(), gatewayIP, hwAddr); err != nil {
+			return nil, err
 		}
-		return err
-	}); err != nil {
-		return nil, err
 	}
 
-	arpEntry := netlink.Neigh{
-		LinkIndex:    vxlan.Index,
-		Family:       syscall.AF_INET,
-		State:        netlink.NUD_PERMANENT,
-		IP:           gatewayIP,
-		HardwareAddr: hwAddr,
-	}
-
-	if err := retry.Retry(func() error {
-		if err := netlink.NeighDel(&arpEntry); err != nil {
-			p.logger.Warn("failed to delete ARP entry", zap.Error(err))
-			return err
+	if req.Subnet.IPv6Network != nil {
+		gatewayIP := req.Subnet.IPv6Network.NextAddr().IP.ToNetIP()
+		if err := p.deleteOverlayRoute(vxlan, req.Subnet.IPv6Network.ToIPNet(), gatewayIP, hwAddr); err != nil {
+			return nil, err
 		}
-		return nil
-	}); err != nil {
-		return nil, err
 	}
 
 	fdbEntry := netlink.Neigh{
@@ -167,10 +244,12 @@ func (p *Provider) DeleteRemote(
 		Family:       syscall.AF_BRIDGE,
 		State:        netlink.NUD_PERMANENT,
 		Flags:        netlink.NTF_SELF,
-		IP:           *req.Subnet.HostIPv4,
+		IP:           vtepIP,
 		HardwareAddr: hwAddr,
 	}
 
+	p.expectNeighDeletion(&fdbEntry)
+
 	if err := retry.Retry(func() error {
 		err := netlink.NeighDel(&fdbEntry)
 		if err != nil {
@@ -189,14 +268,15 @@ func (p *Provider) SetRemote(
 	req *types.NetworkProviderSetRemoteReq,
 ) (*types.NetworkProviderSetRemoteResp, error) {
 
-	// Parse the provider config to get the VNI.
-	var cfg Config
-	if req.Subnet.Config != nil {
-		if err := json.Unmarshal(req.Subnet.Config, &cfg); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal vxlan config: %w", err)
-		}
-	} else {
-		cfg.VNI = 1
+	// Parse the provider config to get the VNI and underlay.
+	cfg, err := parseRemoteConfig(req.Subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	vtepIP, err := underlayAddr(req.Subnet, cfg.Underlay)
+	if err != nil {
+		return nil, err
 	}
 
 	// Pull the VXLAN link by name, so we can add route, FDB and ARP entries to
@@ -213,6 +293,29 @@ func (p *Provider) SetRemote(
 		return nil, fmt.Errorf("link %s is not a vxlan interface", name)
 	}
 
+	// When direct routing is enabled and the remote host shares a layer 2
+	// network with the host interface, route IPv4 traffic directly to it
+	// without encapsulation. Otherwise, fall back to the VXLAN overlay. IPv6
+	// traffic always uses the overlay, as the remote host IPv4 address cannot
+	// be used as an IPv6 gateway. Remote hosts without an IPv4 address can
+	// only be reached via the overlay.
+	direct := false
+
+	if cfg.DirectRouting && req.Subnet.HostIPv4 != nil {
+		if direct, err = isDirectlyRoutable(*req.Subnet.HostIPv4, vxlan.VtepDevIndex); err != nil {
+			return nil, err
+		}
+		if direct {
+			if err := p.setDirectRoute(req.Subnet, vxlan.VtepDevIndex); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if direct && req.Subnet.IPv6Network == nil {
+		return &types.NetworkProviderSetRemoteResp{}, nil
+	}
+
 	// Get the remote VTEP MAC address from the subnet config. This is the
 	// actual MAC address of the remote host's VXLAN interface.
 	hwAddr, err := net.ParseMAC(cfg.VtepMAC)
@@ -221,13 +324,14 @@ func (p *Provider) SetRemote(
 	}
 
 	// Add FDB entry that maps the MAC address to the remote VTEP IP. This tells
-	// the VXLAN interface where to send packets destined for this MAC.
+	// the VXLAN interface where to send packets destined for this MAC. The VTEP
+	// IP is an IPv4 or IPv6 address depending on the underlay in use.
 	fdbEntry := netlink.Neigh{
 		LinkIndex:    vxlan.Index,
 		Family:       syscall.AF_BRIDGE,
 		State:        netlink.NUD_PERMANENT,
 		Flags:        netlink.NTF_SELF,
-		IP:           *req.Subnet.HostIPv4,
+		IP:           vtepIP,
 		HardwareAddr: hwAddr,
 	}
 
@@ -242,30 +346,57 @@ func (p *Provider) SetRemote(
 		return nil, err
 	}
 
-	// The gateway IP is the first usable IP of the remote subnet and used in a
-	// couple of places below, so extract it here.
-	gatewayIP := req.Subnet.IPv4Network.NextAddr().IP.ToNetIP()
+	// The gateway IP is the first usable IP of the remote subnet and is mapped
+	// to the remote VTEP MAC, so the kernel can route to the remote subnet via
+	// the VXLAN interface.
+	if !direct {
+		gatewayIP := req.Subnet.IPv4Network.NextAddr().IP.ToNetIP()
+		if err := p.setOverlayRoute(vxlan, req.Subnet.IPv4Network.ToIPNet(), gatewayIP, hwAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Subnet.IPv6Network != nil {
+		gatewayIP := req.Subnet.IPv6Network.NextAddr().IP.ToNetIP()
+		if err := p.setOverlayRoute(vxlan, req.Subnet.IPv6Network.ToIPNet(), gatewayIP, hwAddr); err != nil {
+			return nil, err
+		}
+	}
 
-	// Add a neighbor ARP entry that maps the remote gateway IP to the remote
-	// VTEP MAC. Gateway is the first usable IP of the remote subnet. This tells
-	// the kernel the MAC address to use when sending to the gateway IP.
-	arpEntry := netlink.Neigh{
+	return &types.NetworkProviderSetRemoteResp{}, nil
+}
+
+// setOverlayRoute adds a neighbor entry mapping the remote gateway IP to the
+// remote VTEP MAC and a route to the remote subnet via the gateway on the
+// VXLAN interface. The address family is derived from the gateway IP, so this
+// handles both IPv4 ARP and IPv6 NDP entries.
+func (p *Provider) setOverlayRoute(
+	vxlan *netlink.Vxlan,
+	dst *net.IPNet,
+	gatewayIP net.IP,
+	hwAddr net.HardwareAddr,
+) error {
+
+	// Add a neighbor entry that maps the remote gateway IP to the remote VTEP
+	// MAC. Gateway is the first usable IP of the remote subnet. This tells the
+	// kernel the MAC address to use when sending to the gateway IP.
+	neighEntry := netlink.Neigh{
 		LinkIndex:    vxlan.Index,
-		Family:       syscall.AF_INET,
+		Family:       ipFamily(gatewayIP),
 		State:        netlink.NUD_PERMANENT,
 		IP:           gatewayIP,
 		HardwareAddr: hwAddr,
 	}
 
 	if err := retry.Retry(func() error {
-		err := netlink.NeighSet(&arpEntry)
+		err := netlink.NeighSet(&neighEntry)
 		if err != nil {
-			p.logger.Warn("failed to add ARP entry", zap.Error(err))
+			p.logger.Warn("failed to add neighbor entry", zap.Error(err))
 			return err
 		}
 		return err
 	}); err != nil {
-		return nil, err
+		return err
 	}
 
 	// Add a route to the remote subnet via the VXLAN interface. The ONLINK flag
@@ -273,24 +404,126 @@ func (p *Provider) SetRemote(
 	// interface.
 	route := &netlink.Route{
 		LinkIndex: vxlan.Index,
-		Dst:       req.Subnet.IPv4Network.ToIPNet(),
+		Dst:       dst,
 		Gw:        gatewayIP,
 		Flags:     syscall.RTNH_F_ONLINK,
 		Scope:     netlink.SCOPE_UNIVERSE,
 	}
 
-	if err := retry.Retry(func() error {
+	return retry.Retry(func() error {
 		err := netlink.RouteReplace(route)
 		if err != nil {
 			p.logger.Warn("failed to add route", zap.Error(err))
 			return err
 		}
 		return err
+	})
+}
+
+// deleteOverlayRoute removes the route and neighbor entry added by
+// setOverlayRoute.
+func (p *Provider) deleteOverlayRoute(
+	vxlan *netlink.Vxlan,
+	dst *net.IPNet,
+	gatewayIP net.IP,
+	hwAddr net.HardwareAddr,
+) error {
+
+	route := &netlink.Route{
+		LinkIndex: vxlan.Index,
+		Dst:       dst,
+		Gw:        gatewayIP,
+		Scope:     netlink.SCOPE_UNIVERSE,
+	}
+	route.SetFlag(syscall.RTNH_F_ONLINK)
+
+	p.expectRouteDeletion(route)
+
+	if err := retry.Retry(func() error {
+		err := netlink.RouteDel(route)
+		if err != nil {
+			p.logger.Warn("failed to delete link route", zap.Error(err))
+			return err
+		}
+		return err
 	}); err != nil {
-		return nil, err
+		return err
 	}
 
-	return &types.NetworkProviderSetRemoteResp{}, nil
+	neighEntry := netlink.Neigh{
+		LinkIndex:    vxlan.Index,
+		Family:       ipFamily(gatewayIP),
+		State:        netlink.NUD_PERMANENT,
+		IP:           gatewayIP,
+		HardwareAddr: hwAddr,
+	}
+
+	p.expectNeighDeletion(&neighEntry)
+
+	return retry.Retry(func() error {
+		if err := netlink.NeighDel(&neighEntry); err != nil {
+			p.logger.Warn("failed to delete neighbor entry", zap.Error(err))
+			return err
+		}
+		return nil
+	})
+}
+
+// setDirectRoute adds a route to the remote subnet using the remote host IP as
+// the gateway via the host interface.
+func (p *Provider) setDirectRoute(subnet *types.Subnet, hostLinkIndex int) error {
+	route := &netlink.Route{
+		LinkIndex: hostLinkIndex,
+		Dst:       subnet.IPv4Network.ToIPNet(),
+		Gw:        *subnet.HostIPv4,
+		Scope:     netlink.SCOPE_UNIVERSE,
+	}
+
+	return retry.Retry(func() error {
+		err := netlink.RouteReplace(route)
+		if err != nil {
+			p.logger.Warn("failed to add direct route", zap.Error(err))
+			return err
+		}
+		return err
+	})
+}
+
+// deleteDirectRoute removes the route to the remote subnet that was added by
+// setDirectRoute.
+func (p *Provider) deleteDirectRoute(subnet *types.Subnet) error {
+	route := &netlink.Route{
+		Dst:   subnet.IPv4Network.ToIPNet(),
+		Gw:    *subnet.HostIPv4,
+		Scope: netlink.SCOPE_UNIVERSE,
+	}
+
+	return retry.Retry(func() error {
+		err := netlink.RouteDel(route)
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			p.logger.Warn("failed to delete direct route", zap.Error(err))
+			return err
+		}
+		return nil
+	})
+}
+
+// parseRemoteConfig parses the provider config published by a remote subnet.
+// Subnets published before the underlay option existed always used an IPv4
+// underlay.
+func parseRemoteConfig(subnet *types.Subnet) (*Config, error) {
+	cfg := Config{VNI: defaultVNI}
+
+	if subnet.Config != nil {
+		if err := json.Unmarshal(subnet.Config, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal vxlan config: %w", err)
+		}
+	}
+	if cfg.Underlay == "" {
+		cfg.Underlay = underlayIPv4
+	}
+
+	return &cfg, nil
 }
 
 func (p *Provider) ensureLink(vxlan *netlink.Vxlan) (*netlink.Vxlan, error) {
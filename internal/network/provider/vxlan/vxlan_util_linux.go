@@ -1,9 +1,70 @@
 package vxlan
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
 	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+
+	"github.com/rasorp/smuggle/internal/types"
 )
 
+// isDirectlyRoutable reports whether the kernel would route traffic to the
+// passed IP without a gateway, via the link with the passed index. This
+// indicates the IP is on the same layer 2 network as the link.
+func isDirectlyRoutable(ip net.IP, linkIndex int) (bool, error) {
+	routes, err := netlink.RouteGet(ip)
+	if err != nil {
+		return false, fmt.Errorf("failed to lookup route to %s: %w", ip, err)
+	}
+	if len(routes) == 0 {
+		return false, nil
+	}
+	return routes[0].Gw == nil && routes[0].LinkIndex == linkIndex, nil
+}
+
+// deleteLink deletes the link with the passed name. A link which does not
+// exist is not considered an error.
+func deleteLink(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to find VXLAN link: %w", err)
+	}
+
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete VXLAN interface: %w", err)
+	}
+	return nil
+}
+
+// deleteGatewayRoutes removes all IPv4 routes via a gateway to destinations
+// within the passed network, which are the routes added for remote subnets.
+func (p *Provider) deleteGatewayRoutes(network *net.IPNet) error {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	for _, route := range routes {
+		if route.Gw == nil || route.Dst == nil || !network.Contains(route.Dst.IP) {
+			continue
+		}
+		if err := netlink.RouteDel(&route); err != nil && !errors.Is(err, syscall.ESRCH) {
+			p.logger.Warn("failed to delete route", zap.Error(err))
+			return fmt.Errorf("failed to delete route to %s: %w", route.Dst, err)
+		}
+	}
+
+	return nil
+}
+
 // vxlansEqual compares two VXLAN links for equality based on relevant fields.
 func vxlansEqual(link1, link2 netlink.Link) bool {
 	if link1.Type() != link2.Type() {
@@ -37,3 +98,39 @@ func vxlansEqual(link1, link2 netlink.Link) bool {
 
 	return true
 }
+
+// ipFamily returns the netlink address family of the passed IP.
+func ipFamily(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+// underlayAddr returns the host address of the subnet to use as the VXLAN
+// tunnel endpoint for the passed underlay.
+func underlayAddr(subnet *types.Subnet, underlay string) (net.IP, error) {
+	switch underlay {
+	case underlayIPv4:
+		if subnet.HostIPv4 == nil {
+			return nil, fmt.Errorf("subnet %s does not have a host IPv4 address for the ipv4 underlay", subnet.ClientID)
+		}
+		return *subnet.HostIPv4, nil
+	case underlayIPv6:
+		if subnet.HostIPv6 == nil {
+			return nil, fmt.Errorf("subnet %s does not have a host IPv6 address for the ipv6 underlay", subnet.ClientID)
+		}
+		return *subnet.HostIPv6, nil
+	default:
+		return nil, fmt.Errorf("unsupported underlay %q; must be %q or %q", underlay, underlayIPv4, underlayIPv6)
+	}
+}
+
+// encapsulationOverhead returns the VXLAN encapsulation overhead in bytes for
+// the passed underlay.
+func encapsulationOverhead(underlay string) int {
+	if underlay == underlayIPv6 {
+		return vxlanIPv6EncapuslationOverhead
+	}
+	return vxlanEncapuslationOverhead
+}
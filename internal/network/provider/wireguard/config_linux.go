@@ -0,0 +1,40 @@
+package wireguard
+
+import (
+	"go.uber.org/zap"
+)
+
+// Config represents the configuration options for a WireGuard network
+// provider. Not all fields can be set by the user; some are populated by the
+// provider during setup.
+type Config struct {
+	// ListenPort is the UDP port the WireGuard interface listens on for
+	// encrypted traffic from peers. This defaults to 51820 which is the
+	// conventional WireGuard port.
+	ListenPort int `json:"listen_port"`
+
+	// MTU is the Maximum Transmission Unit for the WireGuard interface. This
+	// is typically set to the host interface MTU minus the WireGuard overhead
+	// of 80 bytes.
+	MTU int `json:"mtu"`
+
+	// PersistentKeepalive is the interval in seconds at which keepalive
+	// packets are sent to peers. This is useful when hosts sit behind NAT or
+	// stateful firewalls. A value of 0 disables keepalives.
+	PersistentKeepalive int `json:"persistent_keepalive"`
+
+	// PublicKey is the public key of the local WireGuard interface that was
+	// created for the subnet.
+	PublicKey string `json:"public_key"`
+}
+
+// loggingPairs returns a set of zap fields representing the WireGuard
+// configuration for logging purposes.
+func (c *Config) loggingPairs() []zap.Field {
+	return []zap.Field{
+		zap.Int("listen_port", c.ListenPort),
+		zap.Int("mtu", c.MTU),
+		zap.Int("persistent_keepalive", c.PersistentKeepalive),
+		zap.String("public_key", c.PublicKey),
+	}
+}
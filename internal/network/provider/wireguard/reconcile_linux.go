@@ -0,0 +1,152 @@
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// Reconcile ensures each remote subnet has a WireGuard peer and route on the
+// local WireGuard interface. Peers and routes which do not belong to a remote
+// subnet are removed.
+func (p *Provider) Reconcile(
+	req *types.NetworkProviderReconcileReq,
+) (*types.NetworkProviderReconcileResp, error) {
+
+	link, err := p.getLink(req.Local.InterfaceName())
+	if err != nil {
+		return nil, err
+	}
+
+	peers, err := devicePeers(link.Attrs().Name)
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := netlink.RouteList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	// Index the existing routes by destination, so each remote subnet can be
+	// checked for a matching route.
+	existingRoutes := make(map[string]netlink.Route, len(routes))
+	for _, route := range routes {
+		if route.Dst != nil {
+			existingRoutes[route.Dst.String()] = route
+		}
+	}
+
+	var (
+		resp          types.NetworkProviderReconcileResp
+		errs          []error
+		desiredPeers  = make(map[wgtypes.Key]struct{}, len(req.Remotes))
+		desiredRoutes = make(map[string]struct{}, len(req.Remotes))
+	)
+
+	for _, remote := range req.Remotes {
+		cfg, err := parseRemoteConfig(remote)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid remote subnet %s: %w", remote.ClientID, err))
+			continue
+		}
+
+		publicKey, err := wgtypes.ParseKey(cfg.PublicKey)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid remote subnet %s: failed to parse public key: %w", remote.ClientID, err))
+			continue
+		}
+
+		allowedIP := remote.IPv4Network.ToIPNet().String()
+
+		desiredPeers[publicKey] = struct{}{}
+		desiredRoutes[allowedIP] = struct{}{}
+
+		_, routeOK := existingRoutes[allowedIP]
+		if routeOK && containsIPNet(peers[publicKey], allowedIP) {
+			continue
+		}
+
+		if _, err := p.SetRemote(&types.NetworkProviderSetRemoteReq{Subnet: remote}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to repair remote subnet %s: %w", remote.ClientID, err))
+			continue
+		}
+		resp.Repaired++
+	}
+
+	var stalePeers []wgtypes.PeerConfig
+
+	for key := range peers {
+		if _, ok := desiredPeers[key]; !ok {
+			p.logger.Info("removing stale WireGuard peer", zap.String("public_key", key.String()))
+			stalePeers = append(stalePeers, wgtypes.PeerConfig{PublicKey: key, Remove: true})
+		}
+	}
+
+	if len(stalePeers) > 0 {
+		if err := configureDevice(link.Attrs().Name, wgtypes.Config{Peers: stalePeers}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove stale WireGuard peers: %w", err))
+		} else {
+			resp.Removed += len(stalePeers)
+		}
+	}
+
+	for dst, route := range existingRoutes {
+		if _, ok := desiredRoutes[dst]; ok {
+			continue
+		}
+		if err := netlink.RouteDel(&route); err != nil && !errors.Is(err, syscall.ESRCH) {
+			p.logger.Warn("failed to delete link route", zap.Error(err))
+			errs = append(errs, fmt.Errorf("failed to delete route to %s: %w", dst, err))
+			continue
+		}
+		resp.Removed++
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// devicePeers returns the allowed IPs of each peer on the named device, keyed
+// by the peer public key.
+func devicePeers(name string) (map[wgtypes.Key][]net.IPNet, error) {
+	wgClient, err := wgctrl.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WireGuard client: %w", err)
+	}
+	defer func() { _ = wgClient.Close() }()
+
+	device, err := wgClient.Device(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WireGuard device: %w", err)
+	}
+
+	peers := make(map[wgtypes.Key][]net.IPNet, len(device.Peers))
+	for _, peer := range device.Peers {
+		peers[peer.PublicKey] = peer.AllowedIPs
+	}
+
+	return peers, nil
+}
+
+// containsIPNet reports whether the list of networks contains the network with
+// the passed string representation.
+func containsIPNet(networks []net.IPNet, network string) bool {
+	for _, n := range networks {
+		if n.String() == network {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,414 @@
+package wireguard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/rasorp/smuggle/internal/helper/retry"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+const (
+	providerName = types.ProviderNameWireGuard
+
+	// defaultListenPort is the default UDP port used for WireGuard traffic if
+	// none is specified by the operator.
+	defaultListenPort = 51820
+
+	// wireguardEncapsulationOverhead is the overhead in bytes introduced by
+	// WireGuard encapsulation when using an IPv6 underlay, which is the worst
+	// case. This is used to adjust the MTU of the WireGuard interface to avoid
+	// fragmentation.
+	wireguardEncapsulationOverhead = 80
+)
+
+type Provider struct {
+	logger *zap.Logger
+}
+
+func New(logger *zap.Logger) types.NetworkProvider {
+	return &Provider{
+		logger: logger.Named(providerName),
+	}
+}
+
+func (p *Provider) Name() string { return providerName }
+
+func (p *Provider) SetLocal(
+	req *types.NetworkProviderSetReq,
+) (*types.NetworkProviderSetResp, error) {
+
+	cfg := Config{
+		ListenPort: defaultListenPort,
+		MTU:        req.HostInteface.MTU - wireguardEncapsulationOverhead,
+	}
+
+	if req.Client.Config != nil {
+		if err := json.Unmarshal(req.Client.Config, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	link, err := p.ensureLink(req.Client.InterfaceName(), cfg.MTU)
+	if err != nil {
+		return nil, err
+	}
+
+	wgClient, err := wgctrl.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WireGuard client: %w", err)
+	}
+	defer func() { _ = wgClient.Close() }()
+
+	device, err := wgClient.Device(link.Attrs().Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WireGuard device: %w", err)
+	}
+
+	// Reuse the private key of an existing device, so restarting the agent
+	// does not rotate the key and force every peer to update. A new key is
+	// only generated when the device has just been created.
+	privateKey := device.PrivateKey
+	if privateKey == (wgtypes.Key{}) {
+		if privateKey, err = wgtypes.GeneratePrivateKey(); err != nil {
+			return nil, fmt.Errorf("failed to generate WireGuard private key: %w", err)
+		}
+	}
+
+	if err := wgClient.ConfigureDevice(link.Attrs().Name, wgtypes.Config{
+		PrivateKey: &privateKey,
+		ListenPort: &cfg.ListenPort,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to configure WireGuard device: %w", err)
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to configure interface state: %w", err)
+	}
+
+	// Enable IPv4 forwarding to allow routing between interfaces.
+	if _, err := sysctl.Sysctl("net/ipv4/ip_forward", "1"); err != nil {
+		return nil, fmt.Errorf("failed to enable ipv4 forwarding: %w", err)
+	}
+
+	// Store the public key in the config. This will be used by remote hosts to
+	// add this host as a peer.
+	cfg.PublicKey = privateKey.PublicKey().String()
+
+	marshaledCfg, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal wireguard config: %v", err)
+	}
+
+	p.logger.Info("setup local WireGuard interface", cfg.loggingPairs()...)
+
+	// Create a copy of the subnet to avoid mutating the request object and
+	// ensure we don't accidentally modify the caller's data. The MTU is
+	// updated to reflect the WireGuard overhead, so containers are configured
+	// correctly.
+	respSubnet := req.Client.Copy()
+	respSubnet.Config = marshaledCfg
+	respSubnet.MTU = cfg.MTU
+
+	return &types.NetworkProviderSetResp{Network: respSubnet}, nil
+}
+
+func (p *Provider) DeleteLocal(
+	req *types.NetworkProviderDeleteLocalReq,
+) (*types.NetworkProviderDeleteLocalResp, error) {
+
+	name := req.Client.InterfaceName()
+
+	// Deleting the WireGuard link also removes its peers and the routes to
+	// remote subnets, which are all scoped to the link.
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return &types.NetworkProviderDeleteLocalResp{}, nil
+		}
+		return nil, fmt.Errorf("failed to lookup WireGuard interface: %w", err)
+	}
+
+	if err := netlink.LinkDel(link); err != nil {
+		return nil, fmt.Errorf("failed to delete WireGuard interface: %w", err)
+	}
+
+	p.logger.Info("deleted local WireGuard interface", zap.String("name", name))
+
+	return &types.NetworkProviderDeleteLocalResp{}, nil
+}
+
+func (p *Provider) DeleteRemote(
+	req *types.NetworkProviderDeleteRemoteReq,
+) (*types.NetworkProviderDeleteRemoteResp, error) {
+
+	cfg, err := parseRemoteConfig(req.Subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := wgtypes.ParseKey(cfg.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	link, err := p.getLink(req.Subnet.InterfaceName())
+	if err != nil {
+		return nil, err
+	}
+
+	route := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       req.Subnet.IPv4Network.ToIPNet(),
+		Scope:     netlink.SCOPE_LINK,
+	}
+
+	if err := retry.Retry(func() error {
+		err := netlink.RouteDel(route)
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			p.logger.Warn("failed to delete link route", zap.Error(err))
+			return err
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := retry.Retry(func() error {
+		err := configureDevice(link.Attrs().Name, wgtypes.Config{
+			Peers: []wgtypes.PeerConfig{{PublicKey: publicKey, Remove: true}},
+		})
+		if err != nil {
+			p.logger.Warn("failed to delete WireGuard peer", zap.Error(err))
+			return err
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return &types.NetworkProviderDeleteRemoteResp{}, nil
+}
+
+func (p *Provider) SetRemote(
+	req *types.NetworkProviderSetRemoteReq,
+) (*types.NetworkProviderSetRemoteResp, error) {
+
+	cfg, err := parseRemoteConfig(req.Subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := wgtypes.ParseKey(cfg.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	// Prefer the remote host IPv4 address as the peer endpoint, falling back to
+	// the IPv6 address for hosts on IPv6-only networks.
+	var endpointIP net.IP
+
+	switch {
+	case req.Subnet.HostIPv4 != nil:
+		endpointIP = *req.Subnet.HostIPv4
+	case req.Subnet.HostIPv6 != nil:
+		endpointIP = *req.Subnet.HostIPv6
+	default:
+		return nil, errors.New("remote subnet does not have a host IP address")
+	}
+
+	link, err := p.getLink(req.Subnet.InterfaceName())
+	if err != nil {
+		return nil, err
+	}
+
+	allowedIP := req.Subnet.IPv4Network.ToIPNet()
+	keepalive := time.Duration(cfg.PersistentKeepalive) * time.Second
+
+	peers := []wgtypes.PeerConfig{
+		{
+			PublicKey: publicKey,
+			Endpoint: &net.UDPAddr{
+				IP:   endpointIP,
+				Port: cfg.ListenPort,
+			},
+			PersistentKeepaliveInterval: &keepalive,
+			ReplaceAllowedIPs:           true,
+			AllowedIPs:                  []net.IPNet{*allowedIP},
+		},
+	}
+
+	// If the remote host has rotated its key, for example because the host was
+	// rebooted, an existing peer will still own the subnet AllowedIPs. Remove
+	// any such peer, so traffic is not sent to a key that no longer exists.
+	stale, err := stalePeers(link.Attrs().Name, publicKey, allowedIP)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range stale {
+		p.logger.Info("removing stale WireGuard peer", zap.String("public_key", key.String()))
+		peers = append(peers, wgtypes.PeerConfig{PublicKey: key, Remove: true})
+	}
+
+	if err := retry.Retry(func() error {
+		err := configureDevice(link.Attrs().Name, wgtypes.Config{Peers: peers})
+		if err != nil {
+			p.logger.Warn("failed to add WireGuard peer", zap.Error(err))
+			return err
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// Add a route to the remote subnet via the WireGuard interface. The
+	// interface uses the peer AllowedIPs to determine which peer should receive
+	// the encrypted traffic.
+	route := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       allowedIP,
+		Scope:     netlink.SCOPE_LINK,
+	}
+
+	if err := retry.Retry(func() error {
+		err := netlink.RouteReplace(route)
+		if err != nil {
+			p.logger.Warn("failed to add route", zap.Error(err))
+			return err
+		}
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return &types.NetworkProviderSetRemoteResp{}, nil
+}
+
+// ensureLink ensures a WireGuard link with the given name exists and has the
+// desired MTU. If a link with the name exists but is not a WireGuard link, it
+// is replaced.
+func (p *Provider) ensureLink(name string, mtu int) (netlink.Link, error) {
+
+	existing, err := netlink.LinkByName(name)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if !errors.As(err, &notFound) {
+			return nil, fmt.Errorf("failed to lookup WireGuard interface: %w", err)
+		}
+	}
+
+	if existing != nil && existing.Type() != "wireguard" {
+		p.logger.Warn("recreating existing interface as WireGuard interface",
+			zap.String("name", name),
+			zap.String("type", existing.Type()),
+		)
+		if err := netlink.LinkDel(existing); err != nil {
+			return nil, fmt.Errorf("failed to delete interface: %w", err)
+		}
+		existing = nil
+	}
+
+	if existing == nil {
+		link := &netlink.Wireguard{
+			LinkAttrs: netlink.LinkAttrs{
+				Name: name,
+				MTU:  mtu,
+			},
+		}
+		if err := netlink.LinkAdd(link); err != nil {
+			return nil, fmt.Errorf("failed to create WireGuard interface: %w", err)
+		}
+		return netlink.LinkByName(name)
+	}
+
+	if existing.Attrs().MTU != mtu {
+		if err := netlink.LinkSetMTU(existing, mtu); err != nil {
+			return nil, fmt.Errorf("failed to set WireGuard interface MTU: %w", err)
+		}
+	}
+
+	return existing, nil
+}
+
+// getLink returns the WireGuard link with the given name, erroring if it does
+// not exist or is not a WireGuard link.
+func (p *Provider) getLink(name string) (netlink.Link, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find wireguard link %s: %w", name, err)
+	}
+	if link.Type() != "wireguard" {
+		return nil, fmt.Errorf("link %s is not a wireguard interface", name)
+	}
+	return link, nil
+}
+
+// parseRemoteConfig parses the provider config published by a remote subnet.
+func parseRemoteConfig(subnet *types.Subnet) (*Config, error) {
+	cfg := Config{ListenPort: defaultListenPort}
+
+	if subnet.Config != nil {
+		if err := json.Unmarshal(subnet.Config, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal wireguard config: %w", err)
+		}
+	}
+	if cfg.PublicKey == "" {
+		return nil, errors.New("remote subnet does not have a WireGuard public key")
+	}
+
+	return &cfg, nil
+}
+
+// configureDevice applies the configuration to the named WireGuard device
+// using a short-lived control client.
+func configureDevice(name string, cfg wgtypes.Config) error {
+	wgClient, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("failed to create WireGuard client: %w", err)
+	}
+	defer func() { _ = wgClient.Close() }()
+
+	return wgClient.ConfigureDevice(name, cfg)
+}
+
+// stalePeers returns the public keys of peers on the named device which own
+// the passed AllowedIP but do not match the passed public key.
+func stalePeers(name string, publicKey wgtypes.Key, allowedIP *net.IPNet) ([]wgtypes.Key, error) {
+	wgClient, err := wgctrl.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WireGuard client: %w", err)
+	}
+	defer func() { _ = wgClient.Close() }()
+
+	device, err := wgClient.Device(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WireGuard device: %w", err)
+	}
+
+	var keys []wgtypes.Key
+
+	for _, peer := range device.Peers {
+		if peer.PublicKey == publicKey {
+			continue
+		}
+		for _, ip := range peer.AllowedIPs {
+			if ip.String() == allowedIP.String() {
+				keys = append(keys, peer.PublicKey)
+				break
+			}
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,505 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// ConsulKVStore implements the Store interface using the Consul KV store. Each
+// network and subnet is stored as a single JSON-encoded value.
+type ConsulKVStore struct {
+	client     *api.Client
+	configPath string
+	clientPath string
+	claimPath  string
+}
+
+// subnetClaim is the JSON-encoded value stored for each subnet CIDR claim.
+type subnetClaim struct {
+	ClientID string `json:"client_id"`
+}
+
+// New creates a new ConsulKVStore with the given Consul API client and base
+// path. The path parameter specifies the base key prefix under which all
+// entries will be stored.
+func New(client *api.Client, basePath string) *ConsulKVStore {
+
+	// Consul keys must not start with a slash, so remove any leading slash
+	// from the base path to allow operators to use a familiar path format.
+	basePath = strings.TrimPrefix(basePath, "/")
+
+	return &ConsulKVStore{
+		client:     client,
+		configPath: path.Join(basePath, "networks", types.StoreVersionLatest),
+		clientPath: path.Join(basePath, "subnets", types.StoreVersionLatest),
+		claimPath:  path.Join(basePath, "claims", types.StoreVersionLatest),
+	}
+}
+
+// ListNetworks retrieves all the network configurations stored under the
+// configured base path. Each key is expected to contain the JSON-encoded
+// network configuration.
+func (s *ConsulKVStore) ListNetworks(
+	_ *types.StoreGetNetworksReq,
+) (*types.StoreGetNetworksResp, error) {
+
+	pairs, _, err := s.client.KV().List(s.configPath+"/", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	networks, err := parseNetworks(pairs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse network: %w", err)
+	}
+
+	return &types.StoreGetNetworksResp{Networks: networks}, nil
+}
+
+// WatchNetworks watches for changes to the network configurations. It uses
+// Consul blocking queries on the network key prefix, so changes are detected
+// without excessive API calls. The watch continues until the context is
+// cancelled.
+func (s *ConsulKVStore) WatchNetworks(
+	req *types.StoreWatchNetworksReq,
+) (*types.StoreWatchNetworksResp, error) {
+
+	networksCh := make(chan []*types.Network)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(networksCh)
+		defer close(errCh)
+
+		// Start with index 0 to get initial state
+		waitIndex := uint64(0)
+
+		for {
+			select {
+			case <-req.Context.Done():
+				return
+			default:
+			}
+
+			queryOpts := &api.QueryOptions{
+				WaitIndex: waitIndex,
+				WaitTime:  5 * time.Minute,
+			}
+
+			pairs, queryMeta, err := s.client.KV().List(
+				s.configPath+"/",
+				queryOpts.WithContext(req.Context),
+			)
+			if err != nil {
+				select {
+				case errCh <- fmt.Errorf("failed to list networks: %w", err):
+				case <-req.Context.Done():
+					return
+				}
+				// Wait before retrying on error
+				select {
+				case <-time.After(10 * time.Second):
+				case <-req.Context.Done():
+					return
+				}
+				continue
+			}
+
+			// The Consul index can go backwards, for example after a snapshot
+			// restore. In this case, the watch must restart from the beginning
+			// to avoid missing updates.
+			if queryMeta.LastIndex < waitIndex {
+				waitIndex = 0
+				continue
+			}
+
+			// Check if the index changed (indicating actual changes)
+			if queryMeta.LastIndex == waitIndex {
+				continue
+			}
+
+			networks, err := parseNetworks(pairs)
+			if err != nil {
+				select {
+				case errCh <- fmt.Errorf("failed to parse network: %w", err):
+				case <-req.Context.Done():
+					return
+				}
+			} else {
+				select {
+				case networksCh <- networks:
+				case <-req.Context.Done():
+					return
+				}
+			}
+
+			// Update wait index for next iteration
+			waitIndex = queryMeta.LastIndex
+		}
+	}()
+
+	return &types.StoreWatchNetworksResp{
+		NetworksCh: networksCh,
+		ErrorCh:    errCh,
+	}, nil
+}
+
+// SetNetwork writes the network configuration as a JSON-encoded value at a key
+// named after the network.
+func (s *ConsulKVStore) SetNetwork(
+	req *types.StoreSetNetworkReq,
+) (*types.StoreSetNetworkResp, error) {
+
+	data, err := json.Marshal(req.Network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal network: %w", err)
+	}
+
+	pair := &api.KVPair{
+		Key:   path.Join(s.configPath, req.Network.Name),
+		Value: data,
+	}
+
+	if _, err := s.client.KV().Put(pair, nil); err != nil {
+		return nil, fmt.Errorf("failed to write network: %w", err)
+	}
+
+	return &types.StoreSetNetworkResp{}, nil
+}
+
+// DeleteNetwork deletes the key holding the network configuration.
+func (s *ConsulKVStore) DeleteNetwork(
+	req *types.StoreDeleteNetworkReq,
+) (*types.StoreDeleteNetworkResp, error) {
+
+	if _, err := s.client.KV().Delete(path.Join(s.configPath, req.Name), nil); err != nil {
+		return nil, fmt.Errorf("failed to delete network: %w", err)
+	}
+
+	return &types.StoreDeleteNetworkResp{}, nil
+}
+
+func (s *ConsulKVStore) ListSubnets(
+	req *types.StoreListSubnetsReq,
+) (*types.StoreListSubnetsResp, error) {
+
+	pairs, _, err := s.client.KV().List(s.subnetPrefix(req.Network), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subnets: %w", err)
+	}
+
+	resp := &types.StoreListSubnetsResp{}
+
+	for _, pair := range pairs {
+		subnet, err := parseSubnet(pair)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse subnet: %w", err)
+		}
+
+		resp.Subnets = append(resp.Subnets, subnet)
+	}
+
+	return resp, nil
+}
+
+// DeleteSubnet deletes the subnet and releases all the subnet CIDR claims held
+// by the client within the network. The claims are released first, so a
+// failure to delete the subnet can be retried without leaking claims.
+func (s *ConsulKVStore) DeleteSubnet(
+	req *types.StoreDeleteSubnetReq,
+) (*types.StoreDeleteSubnetResp, error) {
+
+	if err := s.releaseClaims(req.NetworkName, req.ID); err != nil {
+		return nil, fmt.Errorf("failed to release subnet claims: %w", err)
+	}
+
+	if _, err := s.client.KV().Delete(s.subnetKey(req.NetworkName, req.ID), nil); err != nil {
+		return nil, fmt.Errorf("failed to delete subnet: %w", err)
+	}
+
+	return &types.StoreDeleteSubnetResp{}, nil
+}
+
+// SetSubnet stores the subnet as a JSON-encoded value at a key derived from
+// the network name and client ID.
+func (s *ConsulKVStore) SetSubnet(
+	req *types.StoreSetSubnetReq,
+) (*types.StoreSetSubnetResp, error) {
+
+	data, err := json.Marshal(req.Subnet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal subnet: %w", err)
+	}
+
+	pair := &api.KVPair{
+		Key:   s.subnetKey(req.Subnet.NetworkName, req.Subnet.ClientID),
+		Value: data,
+	}
+
+	if _, err := s.client.KV().Put(pair, nil); err != nil {
+		return nil, fmt.Errorf("failed to write subnet: %w", err)
+	}
+
+	return &types.StoreSetSubnetResp{}, nil
+}
+
+// ClaimSubnet reserves the subnet CIDR for the client by writing a key per
+// CIDR using a check-and-set index of zero, which Consul only accepts if the
+// key does not exist.
+func (s *ConsulKVStore) ClaimSubnet(
+	req *types.StoreClaimSubnetReq,
+) (*types.StoreClaimSubnetResp, error) {
+
+	data, err := json.Marshal(&subnetClaim{ClientID: req.ClientID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal subnet claim: %w", err)
+	}
+
+	pair := &api.KVPair{
+		Key:   path.Join(s.claimPath, req.NetworkName, types.SubnetClaimKey(req.CIDR)),
+		Value: data,
+	}
+
+	ok, _, err := s.client.KV().CAS(pair, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim subnet: %w", err)
+	}
+	if ok {
+		return &types.StoreClaimSubnetResp{}, nil
+	}
+
+	// The claim already exists, which is not a conflict if this client holds
+	// it. If the claim was released since the write, the caller should retry
+	// in the same way as a conflict.
+	existing, _, err := s.client.KV().Get(pair.Key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subnet claim: %w", err)
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("%w: %s", types.ErrSubnetConflict, req.CIDR)
+	}
+
+	var claim subnetClaim
+	if err := json.Unmarshal(existing.Value, &claim); err != nil {
+		return nil, fmt.Errorf("failed to parse subnet claim: %w", err)
+	}
+
+	if claim.ClientID != req.ClientID {
+		return nil, fmt.Errorf("%w: %s claimed by client %s", types.ErrSubnetConflict, req.CIDR, claim.ClientID)
+	}
+
+	return &types.StoreClaimSubnetResp{}, nil
+}
+
+// releaseClaims deletes all the subnet CIDR claims held by the client within
+// the network. Each claim is deleted using its modify index, so a claim which
+// changed owner since it was read is not removed.
+func (s *ConsulKVStore) releaseClaims(networkName, id string) error {
+
+	pairs, _, err := s.client.KV().List(path.Join(s.claimPath, networkName)+"/", nil)
+	if err != nil {
+		return fmt.Errorf("failed to list subnet claims: %w", err)
+	}
+
+	for _, pair := range pairs {
+		var claim subnetClaim
+		if err := json.Unmarshal(pair.Value, &claim); err != nil {
+			return fmt.Errorf("failed to parse subnet claim: %w", err)
+		}
+
+		if claim.ClientID != id {
+			continue
+		}
+
+		if _, _, err := s.client.KV().DeleteCAS(pair, nil); err != nil {
+			return fmt.Errorf("failed to delete subnet claim: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *ConsulKVStore) GetSubnet(
+	req *types.StoreGetSubnetReq,
+) (*types.StoreGetSubnetResp, error) {
+
+	pair, _, err := s.client.KV().Get(s.subnetKey(req.NetworkName, req.ID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subnet: %w", err)
+	}
+
+	// A nil pair indicates the key does not exist, which is not an error.
+	if pair == nil {
+		return &types.StoreGetSubnetResp{}, nil
+	}
+
+	subnet, err := parseSubnet(pair)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse subnet: %w", err)
+	}
+
+	return &types.StoreGetSubnetResp{
+		Subnet: subnet,
+	}, nil
+}
+
+// WatchSubnets watches for changes to the subnets of a network. It uses
+// Consul blocking queries on the subnet key prefix, so changes are detected
+// without excessive API calls. The watch continues until the context is
+// cancelled.
+func (s *ConsulKVStore) WatchSubnets(
+	req *types.StoreWatchSubnetsReq,
+) (*types.StoreWatchSubnetsResp, error) {
+
+	modifyCh := make(chan []*types.Subnet)
+	deleteCh := make(chan []*types.Subnet)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(modifyCh)
+		defer close(deleteCh)
+		defer close(errCh)
+
+		// Start with index 0 to get initial state
+		waitIndex := uint64(0)
+
+		for {
+			select {
+			case <-req.Context.Done():
+				return
+			default:
+			}
+
+			queryOpts := &api.QueryOptions{
+				WaitIndex: waitIndex,
+				WaitTime:  5 * time.Minute,
+			}
+
+			pairs, queryMeta, err := s.client.KV().List(
+				s.subnetPrefix(req.NetworkName),
+				queryOpts.WithContext(req.Context),
+			)
+			if err != nil {
+				select {
+				case errCh <- fmt.Errorf("failed to list subnets: %w", err):
+				case <-req.Context.Done():
+					return
+				}
+				// Wait before retrying on error
+				select {
+				case <-time.After(10 * time.Second):
+				case <-req.Context.Done():
+					return
+				}
+				continue
+			}
+
+			// The Consul index can go backwards, for example after a snapshot
+			// restore. In this case, the watch must restart from the beginning
+			// to avoid missing updates.
+			if queryMeta.LastIndex < waitIndex {
+				waitIndex = 0
+				continue
+			}
+
+			// Check if the index changed (indicating actual changes)
+			if queryMeta.LastIndex == waitIndex {
+				continue
+			}
+
+			var (
+				modifiedSubnets []*types.Subnet
+				expiredSubnets  []*types.Subnet
+			)
+
+			for _, pair := range pairs {
+
+				if pair.ModifyIndex <= waitIndex {
+					continue
+				}
+
+				subnet, err := parseSubnet(pair)
+				if err != nil {
+					select {
+					case errCh <- fmt.Errorf("failed to parse subnet: %w", err):
+					case <-req.Context.Done():
+						return
+					}
+					continue
+				}
+
+				if subnet.Expired {
+					expiredSubnets = append(expiredSubnets, subnet)
+				} else {
+					modifiedSubnets = append(modifiedSubnets, subnet)
+				}
+			}
+
+			if len(expiredSubnets) > 0 {
+				select {
+				case deleteCh <- expiredSubnets:
+				case <-req.Context.Done():
+					return
+				}
+			}
+
+			if len(modifiedSubnets) > 0 {
+				select {
+				case modifyCh <- modifiedSubnets:
+				case <-req.Context.Done():
+					return
+				}
+			}
+
+			// Update wait index for next iteration
+			waitIndex = queryMeta.LastIndex
+		}
+	}()
+
+	return &types.StoreWatchSubnetsResp{
+		ModifyCh: modifyCh,
+		DeleteCh: deleteCh,
+		ErrorCh:  errCh,
+	}, nil
+}
+
+// subnetPrefix returns the key prefix under which all subnets of the passed
+// network are stored. The trailing slash ensures networks that share a name
+// prefix are not included.
+func (s *ConsulKVStore) subnetPrefix(networkName string) string {
+	return path.Join(s.clientPath, networkName) + "/"
+}
+
+// subnetKey returns the key at which the subnet is stored.
+func (s *ConsulKVStore) subnetKey(networkName, id string) string {
+	return path.Join(s.clientPath, networkName, id)
+}
+
+// parseNetworks converts Consul KV pairs into Networks.
+func parseNetworks(pairs api.KVPairs) ([]*types.Network, error) {
+	networks := make([]*types.Network, 0, len(pairs))
+	for _, pair := range pairs {
+		var network types.Network
+		if err := json.Unmarshal(pair.Value, &network); err != nil {
+			return nil, err
+		}
+		networks = append(networks, &network)
+	}
+	return networks, nil
+}
+
+// parseSubnet converts a Consul KV pair into a Subnet.
+func parseSubnet(pair *api.KVPair) (*types.Subnet, error) {
+	var subnet types.Subnet
+	if err := json.Unmarshal(pair.Value, &subnet); err != nil {
+		return nil, err
+	}
+	return &subnet, nil
+}
@@ -0,0 +1,233 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/shoenig/test/must"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// testStore returns a store backed by the Consul agent identified by the
+// CONSUL_HTTP_ADDR environment variable, such as one started using "consul
+// agent -dev". The test is skipped if the variable is not set. Each test uses
+// a unique base path, which is removed once the test completes.
+func testStore(t *testing.T) (*ConsulKVStore, *api.Client) {
+	t.Helper()
+
+	if os.Getenv("CONSUL_HTTP_ADDR") == "" {
+		t.Skip("CONSUL_HTTP_ADDR not set; skipping Consul store test")
+	}
+
+	client, err := api.NewClient(api.DefaultConfig())
+	must.NoError(t, err)
+
+	basePath := "smuggle-test/" + t.Name()
+
+	t.Cleanup(func() {
+		_, _ = client.KV().DeleteTree(basePath, nil)
+	})
+
+	return New(client, basePath), client
+}
+
+func testSubnet(t *testing.T, id string) *types.Subnet {
+	t.Helper()
+
+	var subnet types.Subnet
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"client_id":"`+id+`","network_name":"vxlan","ipv4_network":"10.10.1.0/24"}`,
+	), &subnet))
+	return &subnet
+}
+
+func TestConsulKVStore_ListNetworks(t *testing.T) {
+	store, client := testStore(t)
+
+	resp, err := store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.SliceEmpty(t, resp.Networks)
+
+	_, err = client.KV().Put(&api.KVPair{
+		Key:   store.configPath + "/vxlan",
+		Value: []byte(`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`),
+	}, nil)
+	must.NoError(t, err)
+
+	resp, err = store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.Len(t, 1, resp.Networks)
+	must.Eq(t, "vxlan", resp.Networks[0].Name)
+}
+
+func TestConsulKVStore_WatchNetworks(t *testing.T) {
+	store, client := testStore(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	watchResp, err := store.WatchNetworks(&types.StoreWatchNetworksReq{Context: ctx})
+	must.NoError(t, err)
+
+	// The initial state should be sent, even when no networks exist.
+	select {
+	case networks := <-watchResp.NetworksCh:
+		must.SliceEmpty(t, networks)
+	case err := <-watchResp.ErrorCh:
+		t.Fatalf("unexpected watch error: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for initial networks")
+	}
+
+	_, err = client.KV().Put(&api.KVPair{
+		Key:   store.configPath + "/vxlan",
+		Value: []byte(`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`),
+	}, nil)
+	must.NoError(t, err)
+
+	select {
+	case networks := <-watchResp.NetworksCh:
+		must.Len(t, 1, networks)
+		must.Eq(t, "vxlan", networks[0].Name)
+	case err := <-watchResp.ErrorCh:
+		t.Fatalf("unexpected watch error: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for added network")
+	}
+}
+
+func TestConsulKVStore_Networks(t *testing.T) {
+	store, _ := testStore(t)
+
+	var network types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`,
+	), &network))
+
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &network})
+	must.NoError(t, err)
+
+	resp, err := store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.Len(t, 1, resp.Networks)
+	must.Eq(t, "10.10.0.0/16", resp.Networks[0].IPv4.Network.String())
+
+	_, err = store.DeleteNetwork(&types.StoreDeleteNetworkReq{Name: "vxlan"})
+	must.NoError(t, err)
+
+	resp, err = store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.SliceEmpty(t, resp.Networks)
+}
+
+func TestConsulKVStore_Subnets(t *testing.T) {
+	store, _ := testStore(t)
+
+	// Reading a subnet that does not exist should not error.
+	getResp, err := store.GetSubnet(&types.StoreGetSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+	must.Nil(t, getResp.Subnet)
+
+	subnet := testSubnet(t, "client-1")
+
+	_, err = store.SetSubnet(&types.StoreSetSubnetReq{Subnet: subnet})
+	must.NoError(t, err)
+
+	getResp, err = store.GetSubnet(&types.StoreGetSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+	must.NotNil(t, getResp.Subnet)
+	must.Eq(t, "10.10.1.0/24", getResp.Subnet.IPv4Network.String())
+
+	listResp, err := store.ListSubnets(&types.StoreListSubnetsReq{Network: "vxlan"})
+	must.NoError(t, err)
+	must.Len(t, 1, listResp.Subnets)
+
+	// A network sharing a name prefix must not include the subnet.
+	listResp, err = store.ListSubnets(&types.StoreListSubnetsReq{Network: "vx"})
+	must.NoError(t, err)
+	must.SliceEmpty(t, listResp.Subnets)
+
+	_, err = store.DeleteSubnet(&types.StoreDeleteSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+
+	listResp, err = store.ListSubnets(&types.StoreListSubnetsReq{Network: "vxlan"})
+	must.NoError(t, err)
+	must.SliceEmpty(t, listResp.Subnets)
+}
+
+func TestConsulKVStore_ClaimSubnet(t *testing.T) {
+	store, _ := testStore(t)
+
+	claimReq := types.StoreClaimSubnetReq{ClientID: "client-1", NetworkName: "vxlan", CIDR: "10.10.1.0/24"}
+
+	_, err := store.ClaimSubnet(&claimReq)
+	must.NoError(t, err)
+
+	// Claiming the same CIDR as the owning client should succeed.
+	_, err = store.ClaimSubnet(&claimReq)
+	must.NoError(t, err)
+
+	// Claiming the same CIDR as another client should conflict.
+	_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{
+		ClientID: "client-2", NetworkName: "vxlan", CIDR: "10.10.1.0/24",
+	})
+	must.ErrorIs(t, err, types.ErrSubnetConflict)
+
+	// Deleting the subnet should release the claim.
+	_, err = store.DeleteSubnet(&types.StoreDeleteSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+
+	_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{
+		ClientID: "client-2", NetworkName: "vxlan", CIDR: "10.10.1.0/24",
+	})
+	must.NoError(t, err)
+}
+
+func TestConsulKVStore_WatchSubnets(t *testing.T) {
+	store, _ := testStore(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := store.SetSubnet(&types.StoreSetSubnetReq{Subnet: testSubnet(t, "client-1")})
+	must.NoError(t, err)
+
+	watchResp, err := store.WatchSubnets(&types.StoreWatchSubnetsReq{
+		NetworkName: "vxlan",
+		Context:     ctx,
+	})
+	must.NoError(t, err)
+
+	// The initial state should be sent as modifications.
+	select {
+	case subnets := <-watchResp.ModifyCh:
+		must.Len(t, 1, subnets)
+		must.Eq(t, "client-1", subnets[0].ClientID)
+	case err := <-watchResp.ErrorCh:
+		t.Fatalf("unexpected watch error: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for initial subnets")
+	}
+
+	// Only the updated subnet should be sent when it is marked as expired.
+	expired := testSubnet(t, "client-2")
+	expired.Expired = true
+
+	_, err = store.SetSubnet(&types.StoreSetSubnetReq{Subnet: expired})
+	must.NoError(t, err)
+
+	select {
+	case subnets := <-watchResp.DeleteCh:
+		must.Len(t, 1, subnets)
+		must.Eq(t, "client-2", subnets[0].ClientID)
+	case err := <-watchResp.ErrorCh:
+		t.Fatalf("unexpected watch error: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for expired subnet")
+	}
+}
@@ -0,0 +1,90 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes the data to the file at the passed path atomically.
+// The write is atomic by first writing to a temporary file in the same
+// directory, then renaming it to the target file. This ensures that readers
+// never see a partially written file. The parent directory must exist.
+func writeFileAtomic(path string, data []byte) error {
+
+	tempPath, err := writeTempFile(filepath.Dir(path), data)
+	if err != nil {
+		return err
+	}
+
+	// Atomically rename the temporary file to the target path
+	// On Unix systems, this is atomic even if the target file exists
+	if err := os.Rename(tempPath, path); err != nil {
+		_ = os.Remove(tempPath)
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+
+	return nil
+}
+
+// createFileAtomic writes the data to the file at the passed path atomically,
+// only if the file does not already exist. The temporary file is hard linked
+// to the target path, which fails if the target exists, so concurrent callers
+// can use this as a compare-and-set primitive. If the file exists, the
+// returned error wraps fs.ErrExist. The parent directory must exist.
+func createFileAtomic(path string, data []byte) error {
+
+	tempPath, err := writeTempFile(filepath.Dir(path), data)
+	if err != nil {
+		return err
+	}
+
+	// The temporary file is no longer needed once the link has been created,
+	// or has failed to be created.
+	defer func() { _ = os.Remove(tempPath) }()
+
+	if err := os.Link(tempPath, path); err != nil {
+		return fmt.Errorf("failed to link temporary file: %w", err)
+	}
+
+	return nil
+}
+
+// writeTempFile writes the data to a new temporary file within the directory
+// and returns its path. The temporary file is in the same directory as the
+// target file, which ensures it is on the same filesystem, as required for
+// atomic rename and link operations.
+func writeTempFile(dir string, data []byte) (string, error) {
+
+	tempFile, err := os.CreateTemp(dir, ".smuggle-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tempPath := tempFile.Name()
+
+	// Clean up the temp file if we fail before returning
+	defer func() {
+		if tempFile != nil {
+			_ = tempFile.Close()
+			_ = os.Remove(tempPath)
+		}
+	}()
+
+	// Write the data to the temporary file
+	if _, err := tempFile.Write(data); err != nil {
+		return "", fmt.Errorf("failed to write to temporary file: %w", err)
+	}
+
+	// Sync to ensure data is written to disk
+	if err := tempFile.Sync(); err != nil {
+		return "", fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+
+	// Close the temporary file before it is renamed or linked
+	if err := tempFile.Close(); err != nil {
+		return "", fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	tempFile = nil // Prevent deferred cleanup from trying to close again
+
+	return tempPath, nil
+}
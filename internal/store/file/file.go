@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 
@@ -45,46 +46,15 @@ func (s *CNIStore) Set(cfg *types.CNIConfig) error {
 		return fmt.Errorf("failed to marshal CNI config: %w", err)
 	}
 
-	// Create a temporary file in the same directory as the target file
-	// This ensures the temp file is on the same filesystem, which is required
-	// for atomic rename operations
-	tempFile, err := os.CreateTemp(s.path, ".cni-*.tmp")
-	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %w", err)
-	}
-	tempPath := tempFile.Name()
-
-	// Clean up the temp file if we fail before the rename
-	defer func() {
-		if tempFile != nil {
-			_ = tempFile.Close()
-			_ = os.Remove(tempPath)
-		}
-	}()
-
-	// Write the data to the temporary file
-	if _, err := tempFile.Write(data); err != nil {
-		return fmt.Errorf("failed to write to temporary file: %w", err)
-	}
-
-	// Sync to ensure data is written to disk
-	if err := tempFile.Sync(); err != nil {
-		return fmt.Errorf("failed to sync temporary file: %w", err)
-	}
-
-	// Close the temporary file before renaming
-	if err := tempFile.Close(); err != nil {
-		return fmt.Errorf("failed to close temporary file: %w", err)
-	}
-	tempFile = nil // Prevent deferred cleanup from trying to close again
-
-	filename := filepath.Join(s.path, cfg.Name+".conf")
+	return writeFileAtomic(filepath.Join(s.path, cfg.Name+".conf"), data)
+}
 
-	// Atomically rename the temporary file to the target path
-	// On Unix systems, this is atomic even if the target file exists
-	if err := os.Rename(tempPath, filename); err != nil {
-		return fmt.Errorf("failed to rename temporary file: %w", err)
+// Delete removes the CNI configuration file for the named network. A missing
+// file is not considered an error, so the delete can be safely retried.
+func (s *CNIStore) Delete(name string) error {
+	err := os.Remove(filepath.Join(s.path, name+".conf"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete CNI config: %w", err)
 	}
-
 	return nil
 }
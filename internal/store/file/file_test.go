@@ -216,3 +216,24 @@ func TestCNIStore_Set(t *testing.T) {
 		})
 	}
 }
+
+func TestCNIStore_Delete(t *testing.T) {
+	storePath := t.TempDir()
+	store := NewCNIStore(storePath)
+
+	must.NoError(t, store.Set(&types.CNIConfig{
+		Name: "network-1",
+		MTU:  1450,
+		IPv4: &types.IPv4CNIConfig{
+			Network: "10.0.0.0/16",
+			Subnet:  "10.0.1.1/24",
+		},
+	}))
+	must.FileExists(t, filepath.Join(storePath, "network-1.conf"))
+
+	must.NoError(t, store.Delete("network-1"))
+	must.FileNotExists(t, filepath.Join(storePath, "network-1.conf"))
+
+	// Deleting a config which does not exist should not error.
+	must.NoError(t, store.Delete("network-1"))
+}
@@ -0,0 +1,517 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+const (
+	// fileExtension is the extension of all network and subnet files written
+	// and read by the store.
+	fileExtension = ".json"
+
+	// defaultPollInterval is the interval at which the subnet directory is
+	// scanned for changes when watching subnets.
+	defaultPollInterval = 1 * time.Second
+)
+
+// subnetClaim is the JSON content of each subnet CIDR claim file.
+type subnetClaim struct {
+	ClientID string `json:"client_id"`
+}
+
+// Store implements the Store interface by persisting networks and subnets as
+// JSON files under a directory on the local filesystem. It is intended for
+// single host and test setups, where running Nomad or Consul is not desirable.
+//
+// Networks are read from "<path>/networks/v1/<name>.json", subnets are written
+// to "<path>/subnets/v1/<network>/<client_id>.json" and subnet CIDR claims to
+// "<path>/claims/v1/<network>/<cidr>.json".
+type Store struct {
+	configPath string
+	clientPath string
+	claimPath  string
+
+	// pollInterval is the interval at which the subnet directory is scanned
+	// for changes when watching subnets.
+	pollInterval time.Duration
+}
+
+// NewStore creates a new Store that persists data under the passed directory.
+// The directory is created when the first subnet is written.
+func NewStore(path string) *Store {
+	return &Store{
+		configPath:   filepath.Join(path, "networks", types.StoreVersionLatest),
+		clientPath:   filepath.Join(path, "subnets", types.StoreVersionLatest),
+		claimPath:    filepath.Join(path, "claims", types.StoreVersionLatest),
+		pollInterval: defaultPollInterval,
+	}
+}
+
+// ListNetworks retrieves all the network configurations stored as JSON files
+// within the network directory. A missing directory indicates there are no
+// networks and is not an error.
+func (s *Store) ListNetworks(
+	_ *types.StoreGetNetworksReq,
+) (*types.StoreGetNetworksResp, error) {
+
+	files, err := readDir(s.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	networks, err := parseNetworks(files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse network: %w", err)
+	}
+
+	return &types.StoreGetNetworksResp{Networks: networks}, nil
+}
+
+// WatchNetworks watches for changes to the network configurations by polling
+// the network directory. The networks are sent whenever the content of the
+// directory differs from the previous scan. The watch continues until the
+// context is cancelled.
+func (s *Store) WatchNetworks(
+	req *types.StoreWatchNetworksReq,
+) (*types.StoreWatchNetworksResp, error) {
+
+	networksCh := make(chan []*types.Network)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(networksCh)
+		defer close(errCh)
+
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+
+		// Track the content of each network file from the previous scan. It
+		// starts nil, so the initial scan always sends the current state.
+		var previous map[string][]byte
+
+		for {
+			current, err := readDir(s.configPath)
+			if err != nil {
+				select {
+				case errCh <- fmt.Errorf("failed to list networks: %w", err):
+				case <-req.Context.Done():
+					return
+				}
+			} else if previous == nil || !maps.EqualFunc(previous, current, bytes.Equal) {
+				networks, err := parseNetworks(current)
+				if err != nil {
+					select {
+					case errCh <- fmt.Errorf("failed to parse network: %w", err):
+					case <-req.Context.Done():
+						return
+					}
+				} else {
+					select {
+					case networksCh <- networks:
+					case <-req.Context.Done():
+						return
+					}
+				}
+
+				previous = current
+			}
+
+			select {
+			case <-ticker.C:
+			case <-req.Context.Done():
+				return
+			}
+		}
+	}()
+
+	return &types.StoreWatchNetworksResp{
+		NetworksCh: networksCh,
+		ErrorCh:    errCh,
+	}, nil
+}
+
+// SetNetwork writes the network configuration as a JSON file atomically, so
+// the network watcher never reads a partially written network.
+func (s *Store) SetNetwork(
+	req *types.StoreSetNetworkReq,
+) (*types.StoreSetNetworkResp, error) {
+
+	data, err := json.MarshalIndent(req.Network, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal network: %w", err)
+	}
+
+	if err := os.MkdirAll(s.configPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if err := writeFileAtomic(s.networkFile(req.Network.Name), data); err != nil {
+		return nil, fmt.Errorf("failed to write network: %w", err)
+	}
+
+	return &types.StoreSetNetworkResp{}, nil
+}
+
+// DeleteNetwork removes the network configuration file. A missing file is not
+// an error.
+func (s *Store) DeleteNetwork(
+	req *types.StoreDeleteNetworkReq,
+) (*types.StoreDeleteNetworkResp, error) {
+
+	err := os.Remove(s.networkFile(req.Name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to delete network: %w", err)
+	}
+
+	return &types.StoreDeleteNetworkResp{}, nil
+}
+
+func (s *Store) ListSubnets(
+	req *types.StoreListSubnetsReq,
+) (*types.StoreListSubnetsResp, error) {
+
+	files, err := readDir(filepath.Join(s.clientPath, req.Network))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subnets: %w", err)
+	}
+
+	resp := &types.StoreListSubnetsResp{}
+
+	for _, name := range sortedKeys(files) {
+		subnet, err := parseSubnet(files[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse subnet: %w", err)
+		}
+
+		resp.Subnets = append(resp.Subnets, subnet)
+	}
+
+	return resp, nil
+}
+
+// DeleteSubnet deletes the subnet and releases all the subnet CIDR claims held
+// by the client within the network. The claims are released first, so a
+// failure to delete the subnet can be retried without leaking claims.
+func (s *Store) DeleteSubnet(
+	req *types.StoreDeleteSubnetReq,
+) (*types.StoreDeleteSubnetResp, error) {
+
+	if err := s.releaseClaims(req.NetworkName, req.ID); err != nil {
+		return nil, fmt.Errorf("failed to release subnet claims: %w", err)
+	}
+
+	err := os.Remove(s.subnetFile(req.NetworkName, req.ID))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to delete subnet: %w", err)
+	}
+
+	return &types.StoreDeleteSubnetResp{}, nil
+}
+
+func (s *Store) GetSubnet(
+	req *types.StoreGetSubnetReq,
+) (*types.StoreGetSubnetResp, error) {
+
+	data, err := os.ReadFile(s.subnetFile(req.NetworkName, req.ID))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &types.StoreGetSubnetResp{}, nil
+		}
+		return nil, fmt.Errorf("failed to read subnet: %w", err)
+	}
+
+	subnet, err := parseSubnet(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse subnet: %w", err)
+	}
+
+	return &types.StoreGetSubnetResp{
+		Subnet: subnet,
+	}, nil
+}
+
+// SetSubnet writes the subnet as a JSON file atomically, so concurrent readers
+// never see a partially written subnet.
+func (s *Store) SetSubnet(
+	req *types.StoreSetSubnetReq,
+) (*types.StoreSetSubnetResp, error) {
+
+	data, err := json.MarshalIndent(req.Subnet, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal subnet: %w", err)
+	}
+
+	dir := filepath.Join(s.clientPath, req.Subnet.NetworkName)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if err := writeFileAtomic(s.subnetFile(req.Subnet.NetworkName, req.Subnet.ClientID), data); err != nil {
+		return nil, fmt.Errorf("failed to write subnet: %w", err)
+	}
+
+	return &types.StoreSetSubnetResp{}, nil
+}
+
+// ClaimSubnet reserves the subnet CIDR for the client by creating a file per
+// CIDR. The file is created using a hard link, which fails if the file exists,
+// so only a single client can hold the claim.
+func (s *Store) ClaimSubnet(
+	req *types.StoreClaimSubnetReq,
+) (*types.StoreClaimSubnetResp, error) {
+
+	data, err := json.Marshal(&subnetClaim{ClientID: req.ClientID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal subnet claim: %w", err)
+	}
+
+	dir := filepath.Join(s.claimPath, req.NetworkName)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	claimFile := filepath.Join(dir, types.SubnetClaimKey(req.CIDR)+fileExtension)
+
+	err = createFileAtomic(claimFile, data)
+	if err == nil {
+		return &types.StoreClaimSubnetResp{}, nil
+	}
+	if !errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("failed to claim subnet: %w", err)
+	}
+
+	// The claim already exists, which is not a conflict if this client holds
+	// it. If the claim was released since the write, the caller should retry
+	// in the same way as a conflict.
+	existing, err := os.ReadFile(claimFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", types.ErrSubnetConflict, req.CIDR)
+		}
+		return nil, fmt.Errorf("failed to read subnet claim: %w", err)
+	}
+
+	var claim subnetClaim
+	if err := json.Unmarshal(existing, &claim); err != nil {
+		return nil, fmt.Errorf("failed to parse subnet claim: %w", err)
+	}
+
+	if claim.ClientID != req.ClientID {
+		return nil, fmt.Errorf("%w: %s claimed by client %s", types.ErrSubnetConflict, req.CIDR, claim.ClientID)
+	}
+
+	return &types.StoreClaimSubnetResp{}, nil
+}
+
+// WatchSubnets watches for changes to the subnets of a network by polling the
+// subnet directory. Each scan is compared against the previous one, so only
+// subnets which have been added or changed are sent. The watch continues until
+// the context is cancelled.
+func (s *Store) WatchSubnets(
+	req *types.StoreWatchSubnetsReq,
+) (*types.StoreWatchSubnetsResp, error) {
+
+	modifyCh := make(chan []*types.Subnet)
+	deleteCh := make(chan []*types.Subnet)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(modifyCh)
+		defer close(deleteCh)
+		defer close(errCh)
+
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+
+		// Track the content of each subnet file from the previous scan. It
+		// starts empty, so the initial scan sends the current state.
+		previous := map[string][]byte{}
+
+		for {
+			current, err := readDir(filepath.Join(s.clientPath, req.NetworkName))
+			if err != nil {
+				select {
+				case errCh <- fmt.Errorf("failed to list subnets: %w", err):
+				case <-req.Context.Done():
+					return
+				}
+			} else {
+				var (
+					modifiedSubnets []*types.Subnet
+					expiredSubnets  []*types.Subnet
+				)
+
+				for _, name := range sortedKeys(current) {
+					if bytes.Equal(previous[name], current[name]) {
+						continue
+					}
+
+					subnet, err := parseSubnet(current[name])
+					if err != nil {
+						select {
+						case errCh <- fmt.Errorf("failed to parse subnet: %w", err):
+						case <-req.Context.Done():
+							return
+						}
+						continue
+					}
+
+					if subnet.Expired {
+						expiredSubnets = append(expiredSubnets, subnet)
+					} else {
+						modifiedSubnets = append(modifiedSubnets, subnet)
+					}
+				}
+
+				if len(expiredSubnets) > 0 {
+					select {
+					case deleteCh <- expiredSubnets:
+					case <-req.Context.Done():
+						return
+					}
+				}
+
+				if len(modifiedSubnets) > 0 {
+					select {
+					case modifyCh <- modifiedSubnets:
+					case <-req.Context.Done():
+						return
+					}
+				}
+
+				previous = current
+			}
+
+			select {
+			case <-ticker.C:
+			case <-req.Context.Done():
+				return
+			}
+		}
+	}()
+
+	return &types.StoreWatchSubnetsResp{
+		ModifyCh: modifyCh,
+		DeleteCh: deleteCh,
+		ErrorCh:  errCh,
+	}, nil
+}
+
+// releaseClaims deletes all the subnet CIDR claims held by the client within
+// the network.
+func (s *Store) releaseClaims(networkName, id string) error {
+
+	dir := filepath.Join(s.claimPath, networkName)
+
+	files, err := readDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list subnet claims: %w", err)
+	}
+
+	for name, data := range files {
+		var claim subnetClaim
+		if err := json.Unmarshal(data, &claim); err != nil {
+			return fmt.Errorf("failed to parse subnet claim: %w", err)
+		}
+
+		if claim.ClientID != id {
+			continue
+		}
+
+		err := os.Remove(filepath.Join(dir, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete subnet claim: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// networkFile returns the path of the file in which the network is stored.
+func (s *Store) networkFile(name string) string {
+	return filepath.Join(s.configPath, name+fileExtension)
+}
+
+// subnetFile returns the path of the file in which the subnet is stored.
+func (s *Store) subnetFile(networkName, id string) string {
+	return filepath.Join(s.clientPath, networkName, id+fileExtension)
+}
+
+// readDir reads all the JSON files within the passed directory and returns
+// their content keyed by file name. Temporary files created during atomic
+// writes are ignored. A missing directory returns an empty result.
+func readDir(dir string) (map[string][]byte, error) {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return map[string][]byte{}, nil
+		}
+		return nil, err
+	}
+
+	files := make(map[string][]byte, len(entries))
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), fileExtension) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			// The file may have been deleted since the directory was read,
+			// which is not an error.
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		files[entry.Name()] = data
+	}
+
+	return files, nil
+}
+
+// sortedKeys returns the keys of the map in sorted order, so results are
+// deterministic.
+func sortedKeys(m map[string][]byte) []string {
+	return slices.Sorted(maps.Keys(m))
+}
+
+// parseNetworks converts the JSON file content keyed by file name into
+// Networks, ordered by file name.
+func parseNetworks(files map[string][]byte) ([]*types.Network, error) {
+	networks := make([]*types.Network, 0, len(files))
+	for _, name := range sortedKeys(files) {
+		var network types.Network
+		if err := json.Unmarshal(files[name], &network); err != nil {
+			return nil, err
+		}
+		networks = append(networks, &network)
+	}
+	return networks, nil
+}
+
+// parseSubnet converts the JSON file content into a Subnet.
+func parseSubnet(data []byte) (*types.Subnet, error) {
+	var subnet types.Subnet
+	if err := json.Unmarshal(data, &subnet); err != nil {
+		return nil, err
+	}
+	return &subnet, nil
+}
@@ -0,0 +1,277 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test/must"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func testFileStore(t *testing.T) (*Store, string) {
+	t.Helper()
+
+	path := t.TempDir()
+	store := NewStore(path)
+	store.pollInterval = 10 * time.Millisecond
+
+	return store, path
+}
+
+func testFileSubnet(t *testing.T, id string) *types.Subnet {
+	t.Helper()
+
+	var subnet types.Subnet
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"client_id":"`+id+`","network_name":"vxlan","ipv4_network":"10.10.1.0/24"}`,
+	), &subnet))
+	return &subnet
+}
+
+func TestStore_ListNetworks(t *testing.T) {
+	store, path := testFileStore(t)
+
+	// A missing network directory should not error.
+	resp, err := store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.SliceEmpty(t, resp.Networks)
+
+	networkDir := filepath.Join(path, "networks", types.StoreVersionLatest)
+	must.NoError(t, os.MkdirAll(networkDir, 0755))
+	must.NoError(t, os.WriteFile(
+		filepath.Join(networkDir, "vxlan.json"),
+		[]byte(`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`),
+		0644,
+	))
+
+	// Files without the JSON extension, such as in-flight temporary files,
+	// should be ignored.
+	must.NoError(t, os.WriteFile(filepath.Join(networkDir, ".smuggle-1.tmp"), []byte("{"), 0644))
+
+	resp, err = store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.Len(t, 1, resp.Networks)
+	must.Eq(t, "vxlan", resp.Networks[0].Name)
+}
+
+func TestStore_WatchNetworks(t *testing.T) {
+	store, path := testFileStore(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	watchResp, err := store.WatchNetworks(&types.StoreWatchNetworksReq{Context: ctx})
+	must.NoError(t, err)
+
+	// The initial state should be sent, even when no networks exist.
+	select {
+	case networks := <-watchResp.NetworksCh:
+		must.SliceEmpty(t, networks)
+	case err := <-watchResp.ErrorCh:
+		t.Fatalf("unexpected watch error: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for initial networks")
+	}
+
+	networkDir := filepath.Join(path, "networks", types.StoreVersionLatest)
+	must.NoError(t, os.MkdirAll(networkDir, 0755))
+	must.NoError(t, os.WriteFile(
+		filepath.Join(networkDir, "vxlan.json"),
+		[]byte(`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`),
+		0644,
+	))
+
+	select {
+	case networks := <-watchResp.NetworksCh:
+		must.Len(t, 1, networks)
+		must.Eq(t, "vxlan", networks[0].Name)
+	case err := <-watchResp.ErrorCh:
+		t.Fatalf("unexpected watch error: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for added network")
+	}
+
+	// Removing the network should send the updated, empty, set.
+	must.NoError(t, os.Remove(filepath.Join(networkDir, "vxlan.json")))
+
+	select {
+	case networks := <-watchResp.NetworksCh:
+		must.SliceEmpty(t, networks)
+	case err := <-watchResp.ErrorCh:
+		t.Fatalf("unexpected watch error: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for removed network")
+	}
+}
+
+func TestStore_Networks(t *testing.T) {
+	store, path := testFileStore(t)
+
+	var network types.Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`,
+	), &network))
+
+	// Writing a network should create the network directory.
+	_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &network})
+	must.NoError(t, err)
+	must.FileExists(t, filepath.Join(path, "networks", types.StoreVersionLatest, "vxlan.json"))
+
+	resp, err := store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.Len(t, 1, resp.Networks)
+	must.Eq(t, "10.10.0.0/16", resp.Networks[0].IPv4.Network.String())
+
+	_, err = store.DeleteNetwork(&types.StoreDeleteNetworkReq{Name: "vxlan"})
+	must.NoError(t, err)
+
+	// Deleting a network that does not exist should not error.
+	_, err = store.DeleteNetwork(&types.StoreDeleteNetworkReq{Name: "vxlan"})
+	must.NoError(t, err)
+
+	resp, err = store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.SliceEmpty(t, resp.Networks)
+}
+
+func TestStore_Subnets(t *testing.T) {
+	store, _ := testFileStore(t)
+
+	// Reading a subnet that does not exist should not error.
+	getResp, err := store.GetSubnet(&types.StoreGetSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+	must.Nil(t, getResp.Subnet)
+
+	_, err = store.SetSubnet(&types.StoreSetSubnetReq{Subnet: testFileSubnet(t, "client-1")})
+	must.NoError(t, err)
+
+	getResp, err = store.GetSubnet(&types.StoreGetSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+	must.NotNil(t, getResp.Subnet)
+	must.Eq(t, "10.10.1.0/24", getResp.Subnet.IPv4Network.String())
+
+	listResp, err := store.ListSubnets(&types.StoreListSubnetsReq{Network: "vxlan"})
+	must.NoError(t, err)
+	must.Len(t, 1, listResp.Subnets)
+
+	// Subnets should be listed in client ID order, regardless of the order
+	// they were written.
+	for _, id := range []string{"client-4", "client-2", "client-3"} {
+		_, err = store.SetSubnet(&types.StoreSetSubnetReq{Subnet: testFileSubnet(t, id)})
+		must.NoError(t, err)
+	}
+
+	for range 5 {
+		listResp, err = store.ListSubnets(&types.StoreListSubnetsReq{Network: "vxlan"})
+		must.NoError(t, err)
+
+		var ids []string
+		for _, subnet := range listResp.Subnets {
+			ids = append(ids, subnet.ClientID)
+		}
+		must.Eq(t, []string{"client-1", "client-2", "client-3", "client-4"}, ids)
+	}
+
+	for _, id := range []string{"client-2", "client-3", "client-4"} {
+		_, err = store.DeleteSubnet(&types.StoreDeleteSubnetReq{ID: id, NetworkName: "vxlan"})
+		must.NoError(t, err)
+	}
+
+	_, err = store.DeleteSubnet(&types.StoreDeleteSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+
+	// Deleting a subnet that does not exist should not error.
+	_, err = store.DeleteSubnet(&types.StoreDeleteSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+
+	listResp, err = store.ListSubnets(&types.StoreListSubnetsReq{Network: "vxlan"})
+	must.NoError(t, err)
+	must.SliceEmpty(t, listResp.Subnets)
+}
+
+func TestStore_ClaimSubnet(t *testing.T) {
+	store, path := testFileStore(t)
+
+	claimReq := types.StoreClaimSubnetReq{ClientID: "client-1", NetworkName: "vxlan", CIDR: "10.10.1.0/24"}
+
+	_, err := store.ClaimSubnet(&claimReq)
+	must.NoError(t, err)
+	must.FileExists(t, filepath.Join(path, "claims", types.StoreVersionLatest, "vxlan", "10-10-1-0-24.json"))
+
+	// Claiming the same CIDR as the owning client should succeed.
+	_, err = store.ClaimSubnet(&claimReq)
+	must.NoError(t, err)
+
+	// Claiming the same CIDR as another client should conflict.
+	_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{
+		ClientID: "client-2", NetworkName: "vxlan", CIDR: "10.10.1.0/24",
+	})
+	must.ErrorIs(t, err, types.ErrSubnetConflict)
+
+	// Deleting the subnet should release the claim, even if the subnet itself
+	// does not exist.
+	_, err = store.DeleteSubnet(&types.StoreDeleteSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+
+	_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{
+		ClientID: "client-2", NetworkName: "vxlan", CIDR: "10.10.1.0/24",
+	})
+	must.NoError(t, err)
+}
+
+func TestStore_WatchSubnets(t *testing.T) {
+	store, _ := testFileStore(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := store.SetSubnet(&types.StoreSetSubnetReq{Subnet: testFileSubnet(t, "client-1")})
+	must.NoError(t, err)
+
+	watchResp, err := store.WatchSubnets(&types.StoreWatchSubnetsReq{
+		NetworkName: "vxlan",
+		Context:     ctx,
+	})
+	must.NoError(t, err)
+
+	// The initial state should be sent as modifications.
+	select {
+	case subnets := <-watchResp.ModifyCh:
+		must.Len(t, 1, subnets)
+		must.Eq(t, "client-1", subnets[0].ClientID)
+	case err := <-watchResp.ErrorCh:
+		t.Fatalf("unexpected watch error: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for initial subnets")
+	}
+
+	// Only the updated subnet should be sent when it is marked as expired.
+	expired := testFileSubnet(t, "client-2")
+	expired.Expired = true
+
+	_, err = store.SetSubnet(&types.StoreSetSubnetReq{Subnet: expired})
+	must.NoError(t, err)
+
+	select {
+	case subnets := <-watchResp.DeleteCh:
+		must.Len(t, 1, subnets)
+		must.Eq(t, "client-2", subnets[0].ClientID)
+	case subnets := <-watchResp.ModifyCh:
+		t.Fatalf("unexpected modified subnets: %v", subnets)
+	case err := <-watchResp.ErrorCh:
+		t.Fatalf("unexpected watch error: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for expired subnet")
+	}
+
+	// Cancelling the context should stop the watch and close the channels.
+	cancel()
+
+	for range watchResp.ModifyCh {
+	}
+}
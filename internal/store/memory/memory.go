@@ -0,0 +1,590 @@
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// Operation identifies a Store method, so faults and latency can be injected
+// into individual operations.
+type Operation string
+
+const (
+	OperationAll           Operation = "*"
+	OperationListNetworks  Operation = "ListNetworks"
+	OperationWatchNetworks Operation = "WatchNetworks"
+	OperationSetNetwork    Operation = "SetNetwork"
+	OperationDeleteNetwork Operation = "DeleteNetwork"
+	OperationListSubnets   Operation = "ListSubnets"
+	OperationDeleteSubnet  Operation = "DeleteSubnet"
+	OperationGetSubnet     Operation = "GetSubnet"
+	OperationSetSubnet     Operation = "SetSubnet"
+	OperationClaimSubnet   Operation = "ClaimSubnet"
+	OperationWatchSubnets  Operation = "WatchSubnets"
+)
+
+// ErrInjected is the error returned by operations failed using FailNext when
+// no specific error is provided.
+var ErrInjected = errors.New("injected store failure")
+
+// MemoryStore implements the Store interface by holding all networks and
+// subnets in memory. It is intended for tests and simulations, where it
+// supports injecting failures and latency into operations, so behaviour
+// under store degradation can be tested deterministically.
+//
+// All values are copied via JSON encoding when written and read, which
+// mirrors the serialization performed by the persistent store backends and
+// ensures callers cannot modify stored state through returned pointers.
+type MemoryStore struct {
+	lock sync.Mutex
+
+	// networks holds the encoded network configurations keyed by name.
+	networks map[string][]byte
+
+	// subnets holds the subnet entries keyed by network name and then client
+	// ID.
+	subnets map[string]map[string]*subnetEntry
+
+	// claims holds the client ID owning each subnet CIDR claim keyed by
+	// network name and then CIDR.
+	claims map[string]map[string]string
+
+	// index is incremented on every subnet write and is used by watchers to
+	// determine which subnets have changed, similar to a Consul or Nomad
+	// modify index.
+	index uint64
+
+	// networkIndex is incremented on every network write or delete and is
+	// used by watchers to determine whether the networks have changed.
+	networkIndex uint64
+
+	// changeCh is closed and replaced whenever a network or subnet is written,
+	// which notifies all watchers that the state has changed.
+	changeCh chan struct{}
+
+	faults  map[Operation]*fault
+	latency map[Operation]time.Duration
+	calls   map[Operation]int
+}
+
+type subnetEntry struct {
+	data        []byte
+	modifyIndex uint64
+}
+
+type fault struct {
+	remaining int
+	err       error
+}
+
+// New creates a new, empty MemoryStore.
+func New() *MemoryStore {
+	return &MemoryStore{
+		networks: make(map[string][]byte),
+		subnets:  make(map[string]map[string]*subnetEntry),
+		claims:   make(map[string]map[string]string),
+		changeCh: make(chan struct{}),
+		faults:   make(map[Operation]*fault),
+		latency:  make(map[Operation]time.Duration),
+		calls:    make(map[Operation]int),
+	}
+}
+
+// FailNext causes the next n calls of the operation to fail with the passed
+// error. If the error is nil, ErrInjected is returned. OperationAll can be used
+// to fail the next n calls of any operation; operation specific faults take
+// precedence. Calling FailNext with n set to zero clears the fault.
+func (s *MemoryStore) FailNext(op Operation, n int, err error) {
+	if err == nil {
+		err = ErrInjected
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if n <= 0 {
+		delete(s.faults, op)
+		return
+	}
+	s.faults[op] = &fault{remaining: n, err: err}
+}
+
+// SetLatency adds the passed delay to every call of the operation. OperationAll
+// can be used to delay all operations; operation specific latency takes
+// precedence. Setting a zero duration removes the latency.
+func (s *MemoryStore) SetLatency(op Operation, d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if d <= 0 {
+		delete(s.latency, op)
+		return
+	}
+	s.latency[op] = d
+}
+
+// Calls returns the number of times the operation has been called, including
+// calls which failed due to injected faults. OperationAll returns the total
+// number of calls across all operations.
+func (s *MemoryStore) Calls(op Operation) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if op == OperationAll {
+		var total int
+		for _, n := range s.calls {
+			total += n
+		}
+		return total
+	}
+	return s.calls[op]
+}
+
+func (s *MemoryStore) ListNetworks(
+	_ *types.StoreGetNetworksReq,
+) (*types.StoreGetNetworksResp, error) {
+
+	if err := s.call(OperationListNetworks); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	networks, err := s.parseNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse network: %w", err)
+	}
+
+	return &types.StoreGetNetworksResp{Networks: networks}, nil
+}
+
+// WatchNetworks watches for changes to the network configurations. The current
+// set of networks is sent first, followed by the full set each time a network
+// is written or deleted. The watch continues until the context is cancelled.
+func (s *MemoryStore) WatchNetworks(
+	req *types.StoreWatchNetworksReq,
+) (*types.StoreWatchNetworksResp, error) {
+
+	if err := s.call(OperationWatchNetworks); err != nil {
+		return nil, err
+	}
+
+	networksCh := make(chan []*types.Network)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(networksCh)
+		defer close(errCh)
+
+		var (
+			lastIndex uint64
+			initial   = true
+		)
+
+		for {
+			s.lock.Lock()
+
+			var (
+				networks []*types.Network
+				err      error
+			)
+
+			changed := initial || s.networkIndex != lastIndex
+			if changed {
+				networks, err = s.parseNetworks()
+			}
+
+			lastIndex = s.networkIndex
+			changeCh := s.changeCh
+
+			s.lock.Unlock()
+
+			initial = false
+
+			if err != nil {
+				select {
+				case errCh <- fmt.Errorf("failed to parse network: %w", err):
+				case <-req.Context.Done():
+					return
+				}
+			} else if changed {
+				select {
+				case networksCh <- networks:
+				case <-req.Context.Done():
+					return
+				}
+			}
+
+			select {
+			case <-changeCh:
+			case <-req.Context.Done():
+				return
+			}
+		}
+	}()
+
+	return &types.StoreWatchNetworksResp{
+		NetworksCh: networksCh,
+		ErrorCh:    errCh,
+	}, nil
+}
+
+// SetNetwork writes the network configuration to the store, replacing any
+// existing network with the same name, and notifies all watchers of the
+// change.
+func (s *MemoryStore) SetNetwork(
+	req *types.StoreSetNetworkReq,
+) (*types.StoreSetNetworkResp, error) {
+
+	if err := s.call(OperationSetNetwork); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(req.Network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal network: %w", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.networks[req.Network.Name] = data
+	s.networkIndex++
+	s.notify()
+
+	return &types.StoreSetNetworkResp{}, nil
+}
+
+// DeleteNetwork removes the network configuration from the store. Deleting a
+// network which does not exist is not an error and does not notify watchers.
+func (s *MemoryStore) DeleteNetwork(
+	req *types.StoreDeleteNetworkReq,
+) (*types.StoreDeleteNetworkResp, error) {
+
+	if err := s.call(OperationDeleteNetwork); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.networks[req.Name]; ok {
+		delete(s.networks, req.Name)
+		s.networkIndex++
+		s.notify()
+	}
+
+	return &types.StoreDeleteNetworkResp{}, nil
+}
+
+func (s *MemoryStore) ListSubnets(
+	req *types.StoreListSubnetsReq,
+) (*types.StoreListSubnetsResp, error) {
+
+	if err := s.call(OperationListSubnets); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	resp := &types.StoreListSubnetsResp{}
+
+	entries := s.subnets[req.Network]
+
+	for _, id := range sortedKeys(entries) {
+		subnet, err := parseSubnet(entries[id].data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse subnet: %w", err)
+		}
+
+		resp.Subnets = append(resp.Subnets, subnet)
+	}
+
+	return resp, nil
+}
+
+// DeleteSubnet deletes the subnet and releases all the subnet CIDR claims held
+// by the client within the network.
+func (s *MemoryStore) DeleteSubnet(
+	req *types.StoreDeleteSubnetReq,
+) (*types.StoreDeleteSubnetResp, error) {
+
+	if err := s.call(OperationDeleteSubnet); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for cidr, owner := range s.claims[req.NetworkName] {
+		if owner == req.ID {
+			delete(s.claims[req.NetworkName], cidr)
+		}
+	}
+
+	if entries, ok := s.subnets[req.NetworkName]; ok {
+		delete(entries, req.ID)
+		if len(entries) == 0 {
+			delete(s.subnets, req.NetworkName)
+		}
+	}
+
+	return &types.StoreDeleteSubnetResp{}, nil
+}
+
+func (s *MemoryStore) GetSubnet(
+	req *types.StoreGetSubnetReq,
+) (*types.StoreGetSubnetResp, error) {
+
+	if err := s.call(OperationGetSubnet); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entry, ok := s.subnets[req.NetworkName][req.ID]
+	if !ok {
+		return &types.StoreGetSubnetResp{}, nil
+	}
+
+	subnet, err := parseSubnet(entry.data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse subnet: %w", err)
+	}
+
+	return &types.StoreGetSubnetResp{
+		Subnet: subnet,
+	}, nil
+}
+
+// SetSubnet writes the subnet to the store and notifies all watchers of the
+// change.
+func (s *MemoryStore) SetSubnet(
+	req *types.StoreSetSubnetReq,
+) (*types.StoreSetSubnetResp, error) {
+
+	if err := s.call(OperationSetSubnet); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(req.Subnet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal subnet: %w", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entries, ok := s.subnets[req.Subnet.NetworkName]
+	if !ok {
+		entries = make(map[string]*subnetEntry)
+		s.subnets[req.Subnet.NetworkName] = entries
+	}
+
+	s.index++
+	entries[req.Subnet.ClientID] = &subnetEntry{data: data, modifyIndex: s.index}
+	s.notify()
+
+	return &types.StoreSetSubnetResp{}, nil
+}
+
+func (s *MemoryStore) ClaimSubnet(
+	req *types.StoreClaimSubnetReq,
+) (*types.StoreClaimSubnetResp, error) {
+
+	if err := s.call(OperationClaimSubnet); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	claims, ok := s.claims[req.NetworkName]
+	if !ok {
+		claims = make(map[string]string)
+		s.claims[req.NetworkName] = claims
+	}
+
+	if owner, ok := claims[req.CIDR]; ok && owner != req.ClientID {
+		return nil, fmt.Errorf("%w: %s claimed by client %s", types.ErrSubnetConflict, req.CIDR, owner)
+	}
+
+	claims[req.CIDR] = req.ClientID
+
+	return &types.StoreClaimSubnetResp{}, nil
+}
+
+// WatchSubnets watches for changes to the subnets of a network. The current
+// state is sent first, followed by each subnet as it is written. Subnets marked
+// as expired are sent on the delete channel, all others on the modify channel.
+// As with the persistent backends, subnets removed using DeleteSubnet are not
+// sent. The watch continues until the context is cancelled.
+func (s *MemoryStore) WatchSubnets(
+	req *types.StoreWatchSubnetsReq,
+) (*types.StoreWatchSubnetsResp, error) {
+
+	if err := s.call(OperationWatchSubnets); err != nil {
+		return nil, err
+	}
+
+	modifyCh := make(chan []*types.Subnet)
+	deleteCh := make(chan []*types.Subnet)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(modifyCh)
+		defer close(deleteCh)
+		defer close(errCh)
+
+		// Start with index 0 to get initial state
+		waitIndex := uint64(0)
+
+		for {
+			var (
+				modifiedSubnets []*types.Subnet
+				expiredSubnets  []*types.Subnet
+			)
+
+			s.lock.Lock()
+
+			entries := s.subnets[req.NetworkName]
+			lastIndex := s.index
+			changeCh := s.changeCh
+
+			for _, id := range sortedKeys(entries) {
+				if entries[id].modifyIndex <= waitIndex {
+					continue
+				}
+
+				// The data was encoded by the store, so decoding can only fail
+				// if the subnet type changes in an incompatible manner.
+				subnet, err := parseSubnet(entries[id].data)
+				if err != nil {
+					continue
+				}
+
+				if subnet.Expired {
+					expiredSubnets = append(expiredSubnets, subnet)
+				} else {
+					modifiedSubnets = append(modifiedSubnets, subnet)
+				}
+			}
+
+			s.lock.Unlock()
+
+			if len(expiredSubnets) > 0 {
+				select {
+				case deleteCh <- expiredSubnets:
+				case <-req.Context.Done():
+					return
+				}
+			}
+
+			if len(modifiedSubnets) > 0 {
+				select {
+				case modifyCh <- modifiedSubnets:
+				case <-req.Context.Done():
+					return
+				}
+			}
+
+			waitIndex = lastIndex
+
+			select {
+			case <-changeCh:
+			case <-req.Context.Done():
+				return
+			}
+		}
+	}()
+
+	return &types.StoreWatchSubnetsResp{
+		ModifyCh: modifyCh,
+		DeleteCh: deleteCh,
+		ErrorCh:  errCh,
+	}, nil
+}
+
+// call records the operation call and applies any injected latency and fault.
+// The latency is applied before the fault, so failed calls are also delayed.
+func (s *MemoryStore) call(op Operation) error {
+
+	s.lock.Lock()
+
+	s.calls[op]++
+
+	latency, ok := s.latency[op]
+	if !ok {
+		latency = s.latency[OperationAll]
+	}
+
+	var err error
+
+	for _, key := range []Operation{op, OperationAll} {
+		if f, ok := s.faults[key]; ok {
+			err = f.err
+			if f.remaining--; f.remaining <= 0 {
+				delete(s.faults, key)
+			}
+			break
+		}
+	}
+
+	s.lock.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	return err
+}
+
+// notify wakes all watchers by closing and replacing the change channel. The
+// caller must hold the lock.
+func (s *MemoryStore) notify() {
+	close(s.changeCh)
+	s.changeCh = make(chan struct{})
+}
+
+// parseNetworks decodes all the stored networks, ordered by name. The caller
+// must hold the lock.
+func (s *MemoryStore) parseNetworks() ([]*types.Network, error) {
+	networks := make([]*types.Network, 0, len(s.networks))
+	for _, name := range sortedKeys(s.networks) {
+		var network types.Network
+		if err := json.Unmarshal(s.networks[name], &network); err != nil {
+			return nil, err
+		}
+		networks = append(networks, &network)
+	}
+	return networks, nil
+}
+
+// sortedKeys returns the keys of the map in sorted order, so results are
+// deterministic.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseSubnet converts the encoded subnet into a Subnet.
+func parseSubnet(data []byte) (*types.Subnet, error) {
+	var subnet types.Subnet
+	if err := json.Unmarshal(data, &subnet); err != nil {
+		return nil, err
+	}
+	return &subnet, nil
+}
@@ -0,0 +1,285 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test/must"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func testSubnet(t *testing.T, id string) *types.Subnet {
+	t.Helper()
+
+	var subnet types.Subnet
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"client_id":"`+id+`","network_name":"vxlan","ipv4_network":"10.10.1.0/24"}`,
+	), &subnet))
+	return &subnet
+}
+
+func TestMemoryStore_ListNetworks(t *testing.T) {
+	store := New()
+
+	resp, err := store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.SliceEmpty(t, resp.Networks)
+
+	_, err = store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{Name: "vxlan"}})
+	must.NoError(t, err)
+	_, err = store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{Name: "bridge"}})
+	must.NoError(t, err)
+
+	resp, err = store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.Len(t, 2, resp.Networks)
+	must.Eq(t, "bridge", resp.Networks[0].Name)
+	must.Eq(t, "vxlan", resp.Networks[1].Name)
+
+	// Modifying a returned network must not modify the stored network.
+	resp.Networks[0].Name = "modified"
+
+	resp, err = store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.Eq(t, "bridge", resp.Networks[0].Name)
+}
+
+func TestMemoryStore_WatchNetworks(t *testing.T) {
+	store := New()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	watchResp, err := store.WatchNetworks(&types.StoreWatchNetworksReq{Context: ctx})
+	must.NoError(t, err)
+
+	// The initial state should be sent, even when no networks exist.
+	select {
+	case networks := <-watchResp.NetworksCh:
+		must.SliceEmpty(t, networks)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for initial networks")
+	}
+
+	// Writing a subnet should not trigger a network update.
+	_, err = store.SetSubnet(&types.StoreSetSubnetReq{Subnet: testSubnet(t, "client-1")})
+	must.NoError(t, err)
+
+	_, err = store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{Name: "vxlan"}})
+	must.NoError(t, err)
+
+	select {
+	case networks := <-watchResp.NetworksCh:
+		must.Len(t, 1, networks)
+		must.Eq(t, "vxlan", networks[0].Name)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for added network")
+	}
+
+	_, err = store.DeleteNetwork(&types.StoreDeleteNetworkReq{Name: "vxlan"})
+	must.NoError(t, err)
+
+	select {
+	case networks := <-watchResp.NetworksCh:
+		must.SliceEmpty(t, networks)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for removed network")
+	}
+}
+
+func TestMemoryStore_Subnets(t *testing.T) {
+	store := New()
+
+	// Reading a subnet that does not exist should not error.
+	getResp, err := store.GetSubnet(&types.StoreGetSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+	must.Nil(t, getResp.Subnet)
+
+	subnet := testSubnet(t, "client-1")
+
+	_, err = store.SetSubnet(&types.StoreSetSubnetReq{Subnet: subnet})
+	must.NoError(t, err)
+
+	// Modifying the written subnet must not modify the stored subnet.
+	subnet.Expired = true
+
+	getResp, err = store.GetSubnet(&types.StoreGetSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+	must.NotNil(t, getResp.Subnet)
+	must.False(t, getResp.Subnet.Expired)
+	must.Eq(t, "10.10.1.0/24", getResp.Subnet.IPv4Network.String())
+
+	listResp, err := store.ListSubnets(&types.StoreListSubnetsReq{Network: "vxlan"})
+	must.NoError(t, err)
+	must.Len(t, 1, listResp.Subnets)
+
+	_, err = store.DeleteSubnet(&types.StoreDeleteSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+
+	listResp, err = store.ListSubnets(&types.StoreListSubnetsReq{Network: "vxlan"})
+	must.NoError(t, err)
+	must.SliceEmpty(t, listResp.Subnets)
+}
+
+func TestMemoryStore_ClaimSubnet(t *testing.T) {
+	store := New()
+
+	claimReq := types.StoreClaimSubnetReq{ClientID: "client-1", NetworkName: "vxlan", CIDR: "10.10.1.0/24"}
+
+	_, err := store.ClaimSubnet(&claimReq)
+	must.NoError(t, err)
+
+	// Claiming the same CIDR as the owning client should succeed.
+	_, err = store.ClaimSubnet(&claimReq)
+	must.NoError(t, err)
+
+	// Claiming the same CIDR as another client should conflict.
+	_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{
+		ClientID: "client-2", NetworkName: "vxlan", CIDR: "10.10.1.0/24",
+	})
+	must.ErrorIs(t, err, types.ErrSubnetConflict)
+
+	// The same CIDR within another network should not conflict.
+	_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{
+		ClientID: "client-2", NetworkName: "bridge", CIDR: "10.10.1.0/24",
+	})
+	must.NoError(t, err)
+
+	// Deleting the subnet should release the claim.
+	_, err = store.DeleteSubnet(&types.StoreDeleteSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+
+	_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{
+		ClientID: "client-2", NetworkName: "vxlan", CIDR: "10.10.1.0/24",
+	})
+	must.NoError(t, err)
+}
+
+func TestMemoryStore_WatchSubnets(t *testing.T) {
+	store := New()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := store.SetSubnet(&types.StoreSetSubnetReq{Subnet: testSubnet(t, "client-1")})
+	must.NoError(t, err)
+
+	watchResp, err := store.WatchSubnets(&types.StoreWatchSubnetsReq{
+		NetworkName: "vxlan",
+		Context:     ctx,
+	})
+	must.NoError(t, err)
+
+	// The initial state should be sent as modifications.
+	select {
+	case subnets := <-watchResp.ModifyCh:
+		must.Len(t, 1, subnets)
+		must.Eq(t, "client-1", subnets[0].ClientID)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for initial subnets")
+	}
+
+	// Only the updated subnet should be sent when it is marked as expired.
+	expired := testSubnet(t, "client-2")
+	expired.Expired = true
+
+	_, err = store.SetSubnet(&types.StoreSetSubnetReq{Subnet: expired})
+	must.NoError(t, err)
+
+	select {
+	case subnets := <-watchResp.DeleteCh:
+		must.Len(t, 1, subnets)
+		must.Eq(t, "client-2", subnets[0].ClientID)
+	case subnets := <-watchResp.ModifyCh:
+		t.Fatalf("unexpected modified subnets: %v", subnets)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for expired subnet")
+	}
+
+	// Subnets within other networks should not be sent.
+	other := testSubnet(t, "client-3")
+	other.NetworkName = "bridge"
+
+	_, err = store.SetSubnet(&types.StoreSetSubnetReq{Subnet: other})
+	must.NoError(t, err)
+
+	_, err = store.SetSubnet(&types.StoreSetSubnetReq{Subnet: testSubnet(t, "client-1")})
+	must.NoError(t, err)
+
+	select {
+	case subnets := <-watchResp.ModifyCh:
+		must.Len(t, 1, subnets)
+		must.Eq(t, "client-1", subnets[0].ClientID)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for modified subnet")
+	}
+
+	// Cancelling the context should stop the watch and close the channels.
+	cancel()
+
+	for range watchResp.ModifyCh {
+	}
+}
+
+func TestMemoryStore_FailNext(t *testing.T) {
+	store := New()
+
+	errCustom := errors.New("custom failure")
+
+	store.FailNext(OperationSetSubnet, 2, errCustom)
+
+	for range 2 {
+		_, err := store.SetSubnet(&types.StoreSetSubnetReq{Subnet: testSubnet(t, "client-1")})
+		must.ErrorIs(t, err, errCustom)
+	}
+
+	// Other operations should not be affected by operation specific faults.
+	_, err := store.ListSubnets(&types.StoreListSubnetsReq{Network: "vxlan"})
+	must.NoError(t, err)
+
+	_, err = store.SetSubnet(&types.StoreSetSubnetReq{Subnet: testSubnet(t, "client-1")})
+	must.NoError(t, err)
+
+	// Faults for all operations should use the default error.
+	store.FailNext(OperationAll, 1, nil)
+
+	_, err = store.ListNetworks(nil)
+	must.ErrorIs(t, err, ErrInjected)
+
+	_, err = store.ListNetworks(nil)
+	must.NoError(t, err)
+
+	// Clearing a fault should allow calls to succeed.
+	store.FailNext(OperationGetSubnet, 5, nil)
+	store.FailNext(OperationGetSubnet, 0, nil)
+
+	_, err = store.GetSubnet(&types.StoreGetSubnetReq{ID: "client-1", NetworkName: "vxlan"})
+	must.NoError(t, err)
+
+	must.Eq(t, 3, store.Calls(OperationSetSubnet))
+	must.Eq(t, 2, store.Calls(OperationListNetworks))
+	must.Eq(t, 7, store.Calls(OperationAll))
+}
+
+func TestMemoryStore_SetLatency(t *testing.T) {
+	store := New()
+
+	store.SetLatency(OperationListNetworks, 50*time.Millisecond)
+
+	start := time.Now()
+	_, err := store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.GreaterEq(t, 50*time.Millisecond, time.Since(start))
+
+	// Removing the latency should not delay calls.
+	store.SetLatency(OperationListNetworks, 0)
+
+	start = time.Now()
+	_, err = store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.Less(t, 50*time.Millisecond, time.Since(start))
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"path"
 	"path/filepath"
 	"time"
@@ -17,6 +18,7 @@ type NomadVariableStore struct {
 	client     *api.Client
 	configPath string
 	clientPath string
+	claimPath  string
 }
 
 // New creates a new NomadVariableStore with the given Nomad API client and base path.
@@ -26,6 +28,7 @@ func New(client *api.Client, basePath string) *NomadVariableStore {
 		client:     client,
 		configPath: filepath.Join(basePath, "networks", types.StoreVersionLatest),
 		clientPath: filepath.Join(basePath, "subnets", types.StoreVersionLatest),
+		claimPath:  filepath.Join(basePath, "claims", types.StoreVersionLatest),
 	}
 }
 
@@ -42,7 +45,116 @@ func (s *NomadVariableStore) ListNetworks(
 		return nil, fmt.Errorf("failed to list networks: %w", err)
 	}
 
-	resp := types.StoreGetNetworksResp{}
+	networks, err := s.readNetworks(varList)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.StoreGetNetworksResp{Networks: networks}, nil
+}
+
+// WatchNetworks watches for changes to the network configurations stored in
+// Nomad variables. It uses blocking queries on the network path prefix and
+// compares the modify index of each variable, so the networks are only read
+// and sent when a network has been added, modified, or removed. The watch
+// continues until the context is cancelled.
+func (s *NomadVariableStore) WatchNetworks(
+	req *types.StoreWatchNetworksReq,
+) (*types.StoreWatchNetworksResp, error) {
+
+	networksCh := make(chan []*types.Network)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(networksCh)
+		defer close(errCh)
+
+		// Start with index 0 to get initial state
+		waitIndex := uint64(0)
+
+		// Track the modify index of each network variable, so changes to
+		// other variables, which also increment the index, are ignored. It
+		// starts nil, so the initial state is always sent.
+		var previous map[string]uint64
+
+		for {
+			select {
+			case <-req.Context.Done():
+				return
+			default:
+			}
+
+			queryOpts := &api.QueryOptions{
+				Prefix:    s.configPath,
+				WaitIndex: waitIndex,
+				WaitTime:  5 * time.Minute,
+			}
+
+			varList, queryMeta, err := s.client.Variables().List(queryOpts.WithContext(req.Context))
+			if err != nil {
+				select {
+				case errCh <- fmt.Errorf("failed to list networks: %w", err):
+				case <-req.Context.Done():
+					return
+				}
+				// Wait before retrying on error
+				select {
+				case <-time.After(10 * time.Second):
+				case <-req.Context.Done():
+					return
+				}
+				continue
+			}
+
+			current := make(map[string]uint64, len(varList))
+			for _, varStub := range varList {
+				current[varStub.Path] = varStub.ModifyIndex
+			}
+
+			if previous != nil && maps.Equal(previous, current) {
+				waitIndex = queryMeta.LastIndex
+				continue
+			}
+
+			// The wait index is not updated on error, so the next query
+			// returns immediately and the read is retried.
+			networks, err := s.readNetworks(varList)
+			if err != nil {
+				select {
+				case errCh <- err:
+				case <-req.Context.Done():
+					return
+				}
+				select {
+				case <-time.After(10 * time.Second):
+				case <-req.Context.Done():
+					return
+				}
+				continue
+			}
+
+			select {
+			case networksCh <- networks:
+			case <-req.Context.Done():
+				return
+			}
+
+			previous = current
+			waitIndex = queryMeta.LastIndex
+		}
+	}()
+
+	return &types.StoreWatchNetworksResp{
+		NetworksCh: networksCh,
+		ErrorCh:    errCh,
+	}, nil
+}
+
+// readNetworks reads and parses the network variables identified by the passed
+// metadata.
+func (s *NomadVariableStore) readNetworks(varList []*api.VariableMetadata) ([]*types.Network, error) {
+
+	networks := make([]*types.Network, 0, len(varList))
 
 	for _, varMD := range varList {
 		variable, _, err := s.client.Variables().Read(varMD.Path, nil)
@@ -55,10 +167,47 @@ func (s *NomadVariableStore) ListNetworks(
 			return nil, fmt.Errorf("failed to parse network: %w", err)
 		}
 
-		resp.Networks = append(resp.Networks, network)
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// SetNetwork writes the network configuration as a Nomad variable named after
+// the network, with the JSON-encoded network stored in the "data" item.
+func (s *NomadVariableStore) SetNetwork(
+	req *types.StoreSetNetworkReq,
+) (*types.StoreSetNetworkResp, error) {
+
+	data, err := json.Marshal(req.Network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal network: %w", err)
+	}
+
+	variable := &api.Variable{
+		Path: path.Join(s.configPath, req.Network.Name),
+		Items: map[string]string{
+			"data": string(data),
+		},
+	}
+
+	if _, _, err := s.client.Variables().Update(variable, nil); err != nil {
+		return nil, fmt.Errorf("failed to write network: %w", err)
 	}
 
-	return &resp, nil
+	return &types.StoreSetNetworkResp{}, nil
+}
+
+// DeleteNetwork deletes the Nomad variable holding the network configuration.
+func (s *NomadVariableStore) DeleteNetwork(
+	req *types.StoreDeleteNetworkReq,
+) (*types.StoreDeleteNetworkResp, error) {
+
+	if _, err := s.client.Variables().Delete(path.Join(s.configPath, req.Name), nil); err != nil {
+		return nil, fmt.Errorf("failed to delete network: %w", err)
+	}
+
+	return &types.StoreDeleteNetworkResp{}, nil
 }
 
 func (s *NomadVariableStore) ListSubnets(
@@ -67,7 +216,9 @@ func (s *NomadVariableStore) ListSubnets(
 
 	varList, _, err := s.client.Variables().List(
 		&api.QueryOptions{
-			Prefix: path.Join(s.clientPath, req.Network),
+			// The prefix is terminated so networks whose names share a
+			// prefix, such as "foo" and "foobar", are not listed together.
+			Prefix: path.Join(s.clientPath, req.Network) + "/",
 		},
 	)
 	if err != nil {
@@ -93,10 +244,17 @@ func (s *NomadVariableStore) ListSubnets(
 	return resp, nil
 }
 
+// DeleteSubnet deletes the subnet and releases all the subnet CIDR claims held
+// by the client within the network. The claims are released first, so a
+// failure to delete the subnet can be retried without leaking claims.
 func (s *NomadVariableStore) DeleteSubnet(
 	req *types.StoreDeleteSubnetReq,
 ) (*types.StoreDeleteSubnetResp, error) {
 
+	if err := s.releaseClaims(req.NetworkName, req.ID); err != nil {
+		return nil, fmt.Errorf("failed to release subnet claims: %w", err)
+	}
+
 	path := filepath.Join(s.clientPath, req.NetworkName, req.ID)
 
 	_, err := s.client.Variables().Delete(path, nil)
@@ -107,6 +265,88 @@ func (s *NomadVariableStore) DeleteSubnet(
 	return &types.StoreDeleteSubnetResp{}, nil
 }
 
+// ClaimSubnet reserves the subnet CIDR for the client by creating a variable
+// per CIDR using a check-and-set index of zero, which Nomad only accepts if
+// the variable does not exist.
+func (s *NomadVariableStore) ClaimSubnet(
+	req *types.StoreClaimSubnetReq,
+) (*types.StoreClaimSubnetResp, error) {
+
+	variable := &api.Variable{
+		Path: path.Join(s.claimPath, req.NetworkName, types.SubnetClaimKey(req.CIDR)),
+		Items: map[string]string{
+			"client_id": req.ClientID,
+		},
+	}
+
+	_, _, err := s.client.Variables().CheckedCreate(variable, nil)
+	if err == nil {
+		return &types.StoreClaimSubnetResp{}, nil
+	}
+
+	var casErr api.ErrCASConflict
+	if !errors.As(err, &casErr) {
+		return nil, fmt.Errorf("failed to claim subnet: %w", err)
+	}
+
+	// The claim already exists, which is not a conflict if this client holds
+	// it. If the claim was released since the write, the caller should retry
+	// in the same way as a conflict.
+	existing, _, err := s.client.Variables().Read(variable.Path, nil)
+	if err != nil {
+		if errors.Is(err, api.ErrVariablePathNotFound) {
+			return nil, fmt.Errorf("%w: %s", types.ErrSubnetConflict, req.CIDR)
+		}
+		return nil, fmt.Errorf("failed to read subnet claim: %w", err)
+	}
+
+	if owner := existing.Items["client_id"]; owner != req.ClientID {
+		return nil, fmt.Errorf("%w: %s claimed by client %s", types.ErrSubnetConflict, req.CIDR, owner)
+	}
+
+	return &types.StoreClaimSubnetResp{}, nil
+}
+
+// releaseClaims deletes all the subnet CIDR claims held by the client within
+// the network. Each claim is deleted using its modify index, so a claim which
+// changed owner since it was read is not removed.
+func (s *NomadVariableStore) releaseClaims(networkName, id string) error {
+
+	varList, _, err := s.client.Variables().List(
+		&api.QueryOptions{
+			Prefix: path.Join(s.claimPath, networkName) + "/",
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to list subnet claims: %w", err)
+	}
+
+	for _, varStub := range varList {
+		variable, _, err := s.client.Variables().Read(varStub.Path, nil)
+		if err != nil {
+			if errors.Is(err, api.ErrVariablePathNotFound) {
+				continue
+			}
+			return fmt.Errorf("failed to read subnet claim: %w", err)
+		}
+
+		if variable.Items["client_id"] != id {
+			continue
+		}
+
+		_, err = s.client.Variables().CheckedDelete(variable.Path, variable.ModifyIndex, nil)
+		if err != nil {
+			var casErr api.ErrCASConflict
+			if errors.As(err, &casErr) {
+				continue
+			}
+			return fmt.Errorf("failed to delete subnet claim: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // SetClientConfig stores the client configuration as a Nomad variable.
 // The configuration is stored at a path derived from the client's IP address.
 func (s *NomadVariableStore) SetSubnet(
@@ -184,7 +424,7 @@ func (s *NomadVariableStore) WatchSubnets(
 
 			// Use blocking query with wait index
 			queryOpts := &api.QueryOptions{
-				Prefix:    filepath.Join(s.clientPath, req.NetworkName),
+				Prefix:    path.Join(s.clientPath, req.NetworkName) + "/",
 				WaitIndex: waitIndex,
 				WaitTime:  5 * time.Minute,
 			}
@@ -0,0 +1,97 @@
+package nvar
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/shoenig/test/must"
+
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// testStore returns a store backed by a fake Nomad agent which implements the
+// variable list, read and write endpoints, matching list prefixes in the same
+// manner as Nomad.
+func testStore(t *testing.T) *NomadVariableStore {
+	t.Helper()
+
+	var (
+		lock      sync.Mutex
+		variables = make(map[string]*api.Variable)
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		w.Header().Set("X-Nomad-Index", "1")
+
+		switch {
+		case r.URL.Path == "/v1/vars":
+			stubs := []*api.VariableMetadata{}
+			for _, varPath := range slices.Sorted(maps.Keys(variables)) {
+				if strings.HasPrefix(varPath, r.URL.Query().Get("prefix")) {
+					stubs = append(stubs, &api.VariableMetadata{Path: varPath, ModifyIndex: 1})
+				}
+			}
+			_ = json.NewEncoder(w).Encode(stubs)
+
+		case strings.HasPrefix(r.URL.Path, "/v1/var/") && r.Method == http.MethodPut:
+			var variable api.Variable
+			must.NoError(t, json.NewDecoder(r.Body).Decode(&variable))
+			variable.ModifyIndex = 1
+			variables[variable.Path] = &variable
+			_ = json.NewEncoder(w).Encode(&variable)
+
+		case strings.HasPrefix(r.URL.Path, "/v1/var/"):
+			variable, ok := variables[strings.TrimPrefix(r.URL.Path, "/v1/var/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(variable)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := api.NewClient(&api.Config{Address: srv.URL})
+	must.NoError(t, err)
+
+	return New(client, "smuggle")
+}
+
+func TestNomadVariableStore_ListSubnets_sharedPrefix(t *testing.T) {
+	store := testStore(t)
+
+	for _, networkName := range []string{"foo", "foobar"} {
+		var subnet types.Subnet
+		must.NoError(t, json.Unmarshal([]byte(
+			`{"client_id":"client-1","network_name":"`+networkName+`","ipv4_network":"10.10.1.0/24"}`,
+		), &subnet))
+
+		_, err := store.SetSubnet(&types.StoreSetSubnetReq{Subnet: &subnet})
+		must.NoError(t, err)
+	}
+
+	// Networks whose names share a prefix must not list each other's
+	// subnets.
+	resp, err := store.ListSubnets(&types.StoreListSubnetsReq{Network: "foo"})
+	must.NoError(t, err)
+	must.Len(t, 1, resp.Subnets)
+	must.Eq(t, "foo", resp.Subnets[0].NetworkName)
+
+	// Listing without a network should return the subnets of all networks.
+	resp, err = store.ListSubnets(&types.StoreListSubnetsReq{})
+	must.NoError(t, err)
+	must.Len(t, 2, resp.Subnets)
+}
@@ -0,0 +1,229 @@
+// Package store provides helpers for creating and administering the store
+// backends, which are shared by the CLI and the agent.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/rasorp/smuggle/internal/config"
+	"github.com/rasorp/smuggle/internal/store/consul"
+	"github.com/rasorp/smuggle/internal/store/file"
+	"github.com/rasorp/smuggle/internal/store/nvar"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+// New creates the store backend identified by the configuration. Unlike the
+// agent, no connectivity check is performed, so errors reaching the backend
+// are returned by the first store call.
+func New(cfg *config.StoreConfig, nomadCfg *config.NomadConfig) (types.Store, error) {
+
+	switch cfg.Backend {
+	case "nvar":
+		nomadClient, err := config.NomadClient(nomadCfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Nomad client: %w", err)
+		}
+		return nvar.New(nomadClient, cfg.NVar.Path), nil
+	case "consul":
+		consulClient, err := config.ConsulClient(cfg.Consul)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Consul client: %w", err)
+		}
+		return consul.New(consulClient, cfg.Consul.Path), nil
+	case "file":
+		return file.NewStore(cfg.File.Path), nil
+	default:
+		return nil, fmt.Errorf("unsupported store backend: %q", cfg.Backend)
+	}
+}
+
+// CommandFlags returns the flags used to configure the store backend of CLI
+// commands which read or write the store directly.
+func CommandFlags() []cli.Flag {
+	return append(config.StoreConfigCommandFlags(), config.NomadConfigCommandFlags()...)
+}
+
+// FromCommand creates the store backend configured by the flags returned by
+// CommandFlags, using the default store configuration for unset flags.
+func FromCommand(cmd *cli.Command) (types.Store, error) {
+
+	cfg := config.DefaultStoreConfig().Merge(config.StoreConfigFromCommand(cmd))
+
+	if err := errors.Join(cfg.Validate()...); err != nil {
+		return nil, fmt.Errorf("invalid store configuration: %w", err)
+	}
+
+	return New(cfg, config.NomadConfigFromCommand(cmd))
+}
+
+// ApplyNetwork validates and canonicalizes the network, then writes it to the
+// store. When the network already exists, the write is refused if any subnet
+// allocated within it would no longer be contained by the new configuration,
+// as shrinking or moving the range would leave clients with unroutable
+// subnets. Allocated subnets must be released before such a change is made.
+func ApplyNetwork(store types.Store, network *types.Network) error {
+
+	if network.Name == "" {
+		return errors.New("network name is required")
+	}
+	if strings.ContainsAny(network.Name, `/\`) {
+		return fmt.Errorf("network name must not contain path separators: %q", network.Name)
+	}
+
+	if err := network.Validate(); err != nil {
+		return fmt.Errorf("invalid network: %w", err)
+	}
+
+	network.Canonicalize()
+
+	subnetsResp, err := store.ListSubnets(&types.StoreListSubnetsReq{Network: network.Name})
+	if err != nil {
+		return fmt.Errorf("failed to list network subnets: %w", err)
+	}
+
+	var errs []error
+
+	for _, subnet := range subnetsResp.Subnets {
+		if !network.ContainsSubnet(subnet) {
+			errs = append(errs, fmt.Errorf("subnet %s of client %s is outside the network range",
+				subnet.IPv4Network, subnet.ClientID))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("network change would orphan allocated subnets: %w", err)
+	}
+
+	if _, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: network}); err != nil {
+		return fmt.Errorf("failed to write network: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteNetwork deletes the network from the store, then deletes each subnet
+// still allocated within it, which releases the claims of their clients.
+// Running clients tear down the network and delete their own subnet once they
+// observe the deletion, but the subnets of clients which are down would
+// otherwise remain, as the server reaper only considers networks within the
+// store. A subnet written back by a client renewing it before it observes the
+// deletion is deleted again by the client when it tears down the network.
+func DeleteNetwork(store types.Store, name string) error {
+
+	if _, err := store.DeleteNetwork(&types.StoreDeleteNetworkReq{Name: name}); err != nil {
+		return fmt.Errorf("failed to delete network: %w", err)
+	}
+
+	resp, err := store.ListSubnets(&types.StoreListSubnetsReq{Network: name})
+	if err != nil {
+		return fmt.Errorf("failed to list network subnets: %w", err)
+	}
+
+	var errs []error
+
+	for _, subnet := range resp.Subnets {
+		if _, err := store.DeleteSubnet(&types.StoreDeleteSubnetReq{
+			ID:          subnet.ClientID,
+			NetworkName: name,
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete subnet of client %s: %w", subnet.ClientID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ListSubnets returns the subnets within the named network, or within all
+// networks when the name is empty. Subnets are ordered by network name and
+// then by client ID.
+func ListSubnets(store types.Store, networkName string) ([]*types.Subnet, error) {
+
+	names, err := networkNames(store, networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	var subnets []*types.Subnet
+
+	for _, name := range names {
+		resp, err := store.ListSubnets(&types.StoreListSubnetsReq{Network: name})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list subnets of network %q: %w", name, err)
+		}
+		subnets = append(subnets, resp.Subnets...)
+	}
+
+	return subnets, nil
+}
+
+// ClientSubnets returns the subnets held by the client within the named
+// network, or within all networks when the name is empty. Subnets are ordered
+// by network name.
+func ClientSubnets(store types.Store, clientID, networkName string) ([]*types.Subnet, error) {
+
+	names, err := networkNames(store, networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	var subnets []*types.Subnet
+
+	for _, name := range names {
+		resp, err := store.GetSubnet(&types.StoreGetSubnetReq{ID: clientID, NetworkName: name})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get subnet within network %q: %w", name, err)
+		}
+		if resp.Subnet != nil {
+			subnets = append(subnets, resp.Subnet)
+		}
+	}
+
+	return subnets, nil
+}
+
+// ExpireSubnet marks the subnet as expired within the store. Clients treat an
+// expired subnet as deleted, so they remove their routes to its host
+// immediately, and the server reaper deletes the subnet and releases its claims
+// once the reaper threshold passes. A client which is still running restores
+// its subnet as soon as it sees it expired.
+func ExpireSubnet(store types.Store, subnet *types.Subnet) error {
+
+	expired := subnet.Copy()
+	expired.Expired = true
+	expired.Expiration = time.Now()
+
+	if _, err := store.SetSubnet(&types.StoreSetSubnetReq{Subnet: expired}); err != nil {
+		return fmt.Errorf("failed to expire subnet: %w", err)
+	}
+
+	return nil
+}
+
+// networkNames returns the names of all the networks within the store, or just
+// the passed name if it is set and the network exists.
+func networkNames(store types.Store, networkName string) ([]string, error) {
+
+	resp, err := store.ListNetworks(&types.StoreGetNetworksReq{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	var names []string
+
+	for _, network := range resp.Networks {
+		if networkName == "" || network.Name == networkName {
+			names = append(names, network.Name)
+		}
+	}
+
+	if networkName != "" && len(names) == 0 {
+		return nil, fmt.Errorf("network %q not found", networkName)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/shoenig/test/must"
+
+	"github.com/rasorp/smuggle/internal/store/memory"
+	"github.com/rasorp/smuggle/internal/types"
+)
+
+func testNetwork(t *testing.T, input string) *types.Network {
+	t.Helper()
+
+	var network types.Network
+	must.NoError(t, json.Unmarshal([]byte(input), &network))
+	return &network
+}
+
+func TestApplyNetwork(t *testing.T) {
+	store := memory.New()
+
+	// Invalid networks should not be written.
+	err := ApplyNetwork(store, testNetwork(t, `{"name":"vxlan","provider":{"name":"vxlan"}}`))
+	must.ErrorContains(t, err, "invalid network")
+
+	err = ApplyNetwork(store, testNetwork(t,
+		`{"name":"a/b","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`,
+	))
+	must.ErrorContains(t, err, "path separators")
+	must.Eq(t, 0, store.Calls(memory.OperationSetNetwork))
+
+	// A valid network should be written in its canonical form.
+	must.NoError(t, ApplyNetwork(store, testNetwork(t,
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`,
+	)))
+
+	resp, err := store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.Len(t, 1, resp.Networks)
+	must.Eq(t, "10.10.1.0", resp.Networks[0].IPv4.Min.String())
+	must.True(t, *resp.Networks[0].IPMasq)
+
+	var subnet types.Subnet
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"client_id":"client-1","network_name":"vxlan","ipv4_network":"10.10.200.0/24"}`,
+	), &subnet))
+	_, err = store.SetSubnet(&types.StoreSetSubnetReq{Subnet: &subnet})
+	must.NoError(t, err)
+
+	// Growing the range should be allowed while subnets are allocated.
+	must.NoError(t, ApplyNetwork(store, testNetwork(t,
+		`{"name":"vxlan","ipv4":{"network":"10.8.0.0/14","size":24},"provider":{"name":"vxlan"}}`,
+	)))
+
+	// Shrinking the range so the allocated subnet is excluded should be
+	// refused, as should changing the subnet size.
+	err = ApplyNetwork(store, testNetwork(t,
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/17","size":24},"provider":{"name":"vxlan"}}`,
+	))
+	must.ErrorContains(t, err, "10.10.200.0/24 of client client-1")
+
+	err = ApplyNetwork(store, testNetwork(t,
+		`{"name":"vxlan","ipv4":{"network":"10.8.0.0/14","size":26},"provider":{"name":"vxlan"}}`,
+	))
+	must.ErrorContains(t, err, "orphan allocated subnets")
+
+	resp, err = store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.Eq(t, "10.8.0.0/14", resp.Networks[0].IPv4.Network.String())
+}
+
+func TestSubnets(t *testing.T) {
+	store := memory.New()
+
+	for _, name := range []string{"vxlan", "vxlan6", "wg"} {
+		_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{Name: name}})
+		must.NoError(t, err)
+	}
+
+	for _, input := range []string{
+		`{"client_id":"client-1","network_name":"vxlan","ipv4_network":"10.10.1.0/24"}`,
+		`{"client_id":"client-2","network_name":"vxlan","ipv4_network":"10.10.2.0/24"}`,
+		`{"client_id":"client-1","network_name":"wg","ipv4_network":"10.20.1.0/24"}`,
+		`{"client_id":"client-3","network_name":"vxlan6","ipv4_network":"10.30.1.0/24"}`,
+	} {
+		var subnet types.Subnet
+		must.NoError(t, json.Unmarshal([]byte(input), &subnet))
+		_, err := store.SetSubnet(&types.StoreSetSubnetReq{Subnet: &subnet})
+		must.NoError(t, err)
+	}
+
+	subnets, err := ListSubnets(store, "")
+	must.NoError(t, err)
+	must.Len(t, 4, subnets)
+
+	// Networks whose names share a prefix must not list each other's
+	// subnets.
+	subnets, err = ListSubnets(store, "vxlan")
+	must.NoError(t, err)
+	must.Len(t, 2, subnets)
+	for _, subnet := range subnets {
+		must.Eq(t, "vxlan", subnet.NetworkName)
+	}
+
+	subnets, err = ListSubnets(store, "wg")
+	must.NoError(t, err)
+	must.Len(t, 1, subnets)
+
+	_, err = ListSubnets(store, "bridge")
+	must.ErrorContains(t, err, `network "bridge" not found`)
+
+	subnets, err = ClientSubnets(store, "client-1", "")
+	must.NoError(t, err)
+	must.Len(t, 2, subnets)
+	must.Eq(t, "vxlan", subnets[0].NetworkName)
+	must.Eq(t, "wg", subnets[1].NetworkName)
+
+	// Expiring a subnet should only modify the stored copy.
+	must.NoError(t, ExpireSubnet(store, subnets[0]))
+	must.False(t, subnets[0].Expired)
+
+	subnets, err = ClientSubnets(store, "client-1", "vxlan")
+	must.NoError(t, err)
+	must.Len(t, 1, subnets)
+	must.True(t, subnets[0].Expired)
+	must.Eq(t, "10.10.1.0/24", subnets[0].IPv4Network.String())
+}
+
+func TestDeleteNetwork(t *testing.T) {
+	store := memory.New()
+
+	for _, name := range []string{"vxlan", "wg"} {
+		_, err := store.SetNetwork(&types.StoreSetNetworkReq{Network: &types.Network{Name: name}})
+		must.NoError(t, err)
+	}
+
+	for _, input := range []string{
+		`{"client_id":"client-1","network_name":"vxlan","ipv4_network":"10.10.1.0/24"}`,
+		`{"client_id":"client-2","network_name":"vxlan","ipv4_network":"10.10.2.0/24"}`,
+		`{"client_id":"client-1","network_name":"wg","ipv4_network":"10.20.1.0/24"}`,
+	} {
+		var subnet types.Subnet
+		must.NoError(t, json.Unmarshal([]byte(input), &subnet))
+		_, err := store.SetSubnet(&types.StoreSetSubnetReq{Subnet: &subnet})
+		must.NoError(t, err)
+		_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{
+			ClientID:    subnet.ClientID,
+			NetworkName: subnet.NetworkName,
+			CIDR:        subnet.IPv4Network.String(),
+		})
+		must.NoError(t, err)
+	}
+
+	must.NoError(t, DeleteNetwork(store, "vxlan"))
+
+	networks, err := store.ListNetworks(nil)
+	must.NoError(t, err)
+	must.Len(t, 1, networks.Networks)
+	must.Eq(t, "wg", networks.Networks[0].Name)
+
+	// The subnets left within the deleted network should be removed and their
+	// claims released, while other networks are untouched.
+	subnets, err := store.ListSubnets(&types.StoreListSubnetsReq{Network: "vxlan"})
+	must.NoError(t, err)
+	must.SliceEmpty(t, subnets.Subnets)
+
+	_, err = store.ClaimSubnet(&types.StoreClaimSubnetReq{
+		ClientID: "client-3", NetworkName: "vxlan", CIDR: "10.10.1.0/24",
+	})
+	must.NoError(t, err)
+
+	subnets, err = store.ListSubnets(&types.StoreListSubnetsReq{Network: "wg"})
+	must.NoError(t, err)
+	must.Len(t, 1, subnets.Subnets)
+}
@@ -2,6 +2,10 @@ package types
 
 type CNIStore interface {
 	Set(*CNIConfig) error
+
+	// Delete removes the CNI configuration for the named network. Deleting a
+	// configuration which does not exist does not return an error.
+	Delete(name string) error
 }
 
 // CNIConfig represents a CNI (Container Network Interface) configuration.
@@ -11,6 +15,7 @@ type CNIConfig struct {
 	MTU    int            `json:"mtu"`
 	IPMasq bool           `json:"ipmasq"`
 	IPv4   *IPv4CNIConfig `json:"ipv4"`
+	IPv6   *IPv6CNIConfig `json:"ipv6,omitempty"`
 }
 
 // IPv4CNIConfig represents IPv4-specific CNI configuration.
@@ -20,9 +25,16 @@ type IPv4CNIConfig struct {
 	Gateway string `json:"gateway,omitempty"`
 }
 
+// IPv6CNIConfig represents IPv6-specific CNI configuration.
+type IPv6CNIConfig struct {
+	Network string `json:"network"`
+	Subnet  string `json:"subnet"`
+	Gateway string `json:"gateway,omitempty"`
+}
+
 // GenerateCNIConfig creates a CNI configuration from network and subnet configurations.
 func GenerateCNIConfig(network *Network, subnet *Subnet) *CNIConfig {
-	return &CNIConfig{
+	cfg := CNIConfig{
 		Name:   network.Name,
 		Bridge: network.Name + "brd0",
 		MTU:    subnet.MTU,
@@ -33,4 +45,14 @@ func GenerateCNIConfig(network *Network, subnet *Subnet) *CNIConfig {
 			Gateway: subnet.IPv4Network.NextAddr().IP.String(),
 		},
 	}
+
+	if network.IPv6 != nil && subnet.IPv6Network != nil {
+		cfg.IPv6 = &IPv6CNIConfig{
+			Network: network.IPv6.Network.String(),
+			Subnet:  subnet.IPv6Network.NextAddr().String(),
+			Gateway: subnet.IPv6Network.NextAddr().IP.String(),
+		}
+	}
+
+	return &cfg
 }
@@ -0,0 +1,46 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestGenerateCNIConfig(t *testing.T) {
+
+	var network Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"ipv6":{"network":"fd00:10::/48","size":64},"provider":{"name":"vxlan"}}`,
+	), &network))
+	network.Canonicalize()
+
+	var subnet Subnet
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"network_name":"vxlan","ipv4_network":"10.10.5.0/24","ipv6_network":"fd00:10:0:5::/64","mtu":1450}`,
+	), &subnet))
+
+	expected := &CNIConfig{
+		Name:   "vxlan",
+		Bridge: "vxlanbrd0",
+		MTU:    1450,
+		IPMasq: false,
+		IPv4: &IPv4CNIConfig{
+			Network: "10.10.0.0/16",
+			Subnet:  "10.10.5.1/24",
+			Gateway: "10.10.5.1",
+		},
+		IPv6: &IPv6CNIConfig{
+			Network: "fd00:10::/48",
+			Subnet:  "fd00:10:0:5::1/64",
+			Gateway: "fd00:10:0:5::1",
+		},
+	}
+	must.Eq(t, expected, GenerateCNIConfig(&network, &subnet))
+
+	// Removing the IPv6 network configuration should result in an IPv4 only
+	// CNI configuration.
+	network.IPv6 = nil
+	expected.IPv6 = nil
+	must.Eq(t, expected, GenerateCNIConfig(&network, &subnet))
+}
@@ -0,0 +1,31 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration which is encoded within JSON as a string, such
+// as "1h30m", so it is readable and writable by operators within network
+// configuration objects.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+
+	val, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(val)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) { return json.Marshal(d.String()) }
+
+func (d Duration) String() string { return time.Duration(d).String() }
@@ -1,5 +1,25 @@
 package types
 
+const (
+	// FirewallBackendAuto selects the iptables firewall backend if iptables is
+	// available on the host, otherwise the nftables backend.
+	FirewallBackendAuto = "auto"
+
+	// FirewallBackendIPTables is the name of the iptables firewall backend.
+	FirewallBackendIPTables = "iptables"
+
+	// FirewallBackendNFTables is the name of the nftables firewall backend.
+	FirewallBackendNFTables = "nftables"
+)
+
+// SupportedFirewallBackends is the list of firewall backend names that can be
+// used within the client configuration.
+var SupportedFirewallBackends = []string{
+	FirewallBackendAuto,
+	FirewallBackendIPTables,
+	FirewallBackendNFTables,
+}
+
 // Firewall defines the interface for managing firewall rules. Implementations
 // of this interface are responsible for setting up the necessary rules to
 // allow traffic forwarding and masquerading for networks and subnets.
@@ -8,7 +28,8 @@ type Firewall interface {
 	// EnsureIsolation ensures that all networks in the provided list are
 	// isolated from each other by adding REJECT rules for cross-network
 	// traffic. This prevents containers on different networks from
-	// communicating with each other.
+	// communicating with each other. Isolation rules for networks which are
+	// not in the list are removed.
 	EnsureIsolation([]*Network) error
 
 	// SetupForwardRules sets up firewall forwarding rules for the provided
@@ -20,4 +41,9 @@ type Firewall interface {
 	// network and subnet. This is used to enable NAT for traffic leaving the
 	// subnet to external destinations.
 	SetupMasqRules(*Network, *Subnet) error
+
+	// RemoveNetworkRules removes the forwarding and masquerading rules for the
+	// provided network and subnet. This is used when the network is no longer
+	// configured on the host. Rules which do not exist are ignored.
+	RemoveNetworkRules(*Network, *Subnet) error
 }
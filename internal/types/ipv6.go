@@ -0,0 +1,121 @@
+package types
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// IPv6Net represents an IPv6 network in CIDR notation. It stores the network
+// address and prefix length efficiently with useful methods for manipulation
+// and comparison.
+type IPv6Net struct {
+	IP   IPv6Addr `json:"ip"`
+	Size uint     `json:"size"`
+}
+
+func (i *IPv6Net) UnmarshalJSON(data []byte) error {
+	if _, val, err := net.ParseCIDR(string(bytes.Trim(data, "\""))); err != nil {
+		return err
+	} else if val.IP.To4() != nil {
+		return errors.New("unexpected address type; expected IPv6")
+	} else {
+		prefixLen, _ := val.Mask.Size()
+		*i = IPv6Net{IP: fromIPv6(val.IP), Size: uint(prefixLen)}
+		return nil
+	}
+}
+
+func (i *IPv6Net) MarshalJSON() ([]byte, error) { return fmt.Appendf(nil, `"%s"`, i), nil }
+
+func (i *IPv6Net) ToIPNet() *net.IPNet {
+	return &net.IPNet{
+		IP:   i.IP.ToNetIP(),
+		Mask: net.CIDRMask(int(i.Size), 128),
+	}
+}
+
+func (i *IPv6Net) String() string {
+	return fmt.Sprintf("%s/%d", i.IP.String(), i.Size)
+}
+
+// NextAddr returns the network with IP incremented by 1
+func (i *IPv6Net) NextAddr() *IPv6Net {
+	n := *i
+	n.IP = n.IP.add(1, 0)
+	return &n
+}
+
+// Subnet returns the subnet of the passed size at the passed index within the
+// network. The caller is responsible for ensuring the index is within the
+// bounds of the network.
+func (i *IPv6Net) Subnet(index uint64, size uint) *IPv6Net {
+	return &IPv6Net{
+		IP:   i.IP.add(index, 128-size),
+		Size: size,
+	}
+}
+
+// IPv6Addr represents an IPv6 address as a pair of 64-bit unsigned integers.
+// This provides efficient storage and manipulation of IP addresses, and allows
+// the type to be used as a map key.
+type IPv6Addr struct {
+	hi uint64
+	lo uint64
+}
+
+func (i *IPv6Addr) MarshalJSON() ([]byte, error) { return fmt.Appendf(nil, `"%s"`, i), nil }
+
+func (i *IPv6Addr) UnmarshalJSON(j []byte) error {
+	j = bytes.Trim(j, "\"")
+	if parsedIP := net.ParseIP(string(j)); parsedIP == nil || parsedIP.To4() != nil {
+		return errors.New("failed to parse IPv6 address")
+	} else {
+		*i = fromIPv6(parsedIP)
+		return nil
+	}
+}
+
+// fromIPv6 converts a net.IP to an IPv6Addr
+func fromIPv6(ip net.IP) IPv6Addr {
+	ip = ip.To16()
+	return IPv6Addr{
+		hi: binary.BigEndian.Uint64(ip[:8]),
+		lo: binary.BigEndian.Uint64(ip[8:]),
+	}
+}
+
+// add returns the address incremented by val shifted left by the passed
+// number of bits, carrying between the two halves of the address.
+func (i IPv6Addr) add(val uint64, shift uint) IPv6Addr {
+	var hi, lo uint64
+
+	switch {
+	case shift >= 128:
+		return i
+	case shift >= 64:
+		hi = val << (shift - 64)
+	case shift == 0:
+		lo = val
+	default:
+		hi = val >> (64 - shift)
+		lo = val << shift
+	}
+
+	sum := IPv6Addr{hi: i.hi + hi, lo: i.lo + lo}
+	if sum.lo < i.lo {
+		sum.hi++
+	}
+	return sum
+}
+
+func (i IPv6Addr) ToNetIP() net.IP {
+	ip := make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(ip[:8], i.hi)
+	binary.BigEndian.PutUint64(ip[8:], i.lo)
+	return ip
+}
+
+func (i IPv6Addr) String() string { return i.ToNetIP().String() }
@@ -0,0 +1,83 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestIPv6Net_JSON(t *testing.T) {
+	var ipNet IPv6Net
+	must.NoError(t, json.Unmarshal([]byte(`"fd00:10::/48"`), &ipNet))
+	must.Eq(t, "fd00:10::/48", ipNet.String())
+	must.Eq(t, 48, ipNet.Size)
+
+	out, err := json.Marshal(&ipNet)
+	must.NoError(t, err)
+	must.Eq(t, `"fd00:10::/48"`, string(out))
+
+	must.Error(t, json.Unmarshal([]byte(`"10.10.0.0/16"`), &ipNet))
+	must.Error(t, json.Unmarshal([]byte(`"not-a-cidr"`), &ipNet))
+}
+
+func TestIPv6Net_NextAddr(t *testing.T) {
+	var ipNet IPv6Net
+	must.NoError(t, json.Unmarshal([]byte(`"fd00:10:0:5::/64"`), &ipNet))
+	must.Eq(t, "fd00:10:0:5::1/64", ipNet.NextAddr().String())
+	must.Eq(t, "fd00:10:0:5::1", ipNet.NextAddr().IP.String())
+}
+
+func TestIPv6Net_Subnet(t *testing.T) {
+	testCases := []struct {
+		name     string
+		network  string
+		index    uint64
+		size     uint
+		expected string
+	}{
+		{
+			name:     "first",
+			network:  "fd00:10::/48",
+			index:    0,
+			size:     64,
+			expected: "fd00:10::/64",
+		},
+		{
+			name:     "second",
+			network:  "fd00:10::/48",
+			index:    1,
+			size:     64,
+			expected: "fd00:10:0:1::/64",
+		},
+		{
+			name:     "last",
+			network:  "fd00:10::/48",
+			index:    65535,
+			size:     64,
+			expected: "fd00:10:0:ffff::/64",
+		},
+		{
+			name:     "low half",
+			network:  "fd00:10::/112",
+			index:    3,
+			size:     120,
+			expected: "fd00:10::300/120",
+		},
+		{
+			name:     "across halves",
+			network:  "fd00::/56",
+			index:    0x1ff,
+			size:     72,
+			expected: "fd00:0:0:1:ff00::/72",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var ipNet IPv6Net
+			must.NoError(t, json.Unmarshal([]byte(`"`+tc.network+`"`), &ipNet))
+			must.Eq(t, tc.expected, ipNet.Subnet(tc.index, tc.size).String())
+		})
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -16,7 +18,14 @@ type Network struct {
 	Name     string          `json:"name"`
 	IPMasq   *bool           `json:"ipmasq"`
 	IPv4     *IPv4Config     `json:"ipv4"`
+	IPv6     *IPv6Config     `json:"ipv6,omitempty"`
 	Provider *ProviderConfig `json:"provider"`
+	Lease    *LeaseConfig    `json:"lease,omitempty"`
+
+	// Placement restricts the clients which configure the network to those
+	// running on matching Nomad nodes. When unset, all clients configure the
+	// network.
+	Placement *PlacementConfig `json:"placement,omitempty"`
 }
 
 // IPv4Config defines the IPv4 address space configuration for a network.
@@ -25,6 +34,213 @@ type IPv4Config struct {
 	Min     IPv4Addr `json:"min"`
 	Max     IPv4Addr `json:"max"`
 	Size    uint     `json:"size"`
+
+	// SizeClasses override the subnet size for clients running on matching
+	// Nomad nodes. The first matching class is used, and clients which match
+	// no class are allocated subnets of the default size.
+	SizeClasses []*IPv4SizeClass `json:"size_classes,omitempty"`
+}
+
+// IPv4SizeClass is an IPv4 subnet size used for the clients running on Nomad
+// nodes which have any of the node classes and all of the node metadata.
+type IPv4SizeClass struct {
+	Size        uint              `json:"size"`
+	NodeClasses []string          `json:"node_classes,omitempty"`
+	NodeMeta    map[string]string `json:"node_meta,omitempty"`
+}
+
+// maxIPv4SizeClassBits is the maximum difference between the largest and
+// smallest subnet sizes of a network. Subnets are claimed in units of the
+// smallest size, so this bounds the number of claims made for each subnet.
+const maxIPv4SizeClassBits = 8
+
+// Matches indicates whether the size class applies to a node with the passed
+// attributes. A class setting both node classes and node metadata requires
+// both to match.
+func (s *IPv4SizeClass) Matches(node *NodeAttributes) bool {
+	placement := PlacementConfig{NodeClasses: s.NodeClasses, NodeMeta: s.NodeMeta}
+	return !placement.IsEmpty() && placement.Matches(node)
+}
+
+// SubnetSize returns the size of the subnet allocated to a client running on
+// a node with the passed attributes. The node is nil if the client could not
+// discover its Nomad node, in which case the default size is used.
+func (c *IPv4Config) SubnetSize(node *NodeAttributes) uint {
+	for _, class := range c.SizeClasses {
+		if class.Matches(node) {
+			return class.Size
+		}
+	}
+	return c.Size
+}
+
+// MixedSizes indicates whether subnets of different sizes may be allocated
+// within the network.
+func (c *IPv4Config) MixedSizes() bool { return len(c.SizeClasses) > 0 }
+
+// ValidSize indicates whether subnets of the passed size may be allocated
+// within the network.
+func (c *IPv4Config) ValidSize(size uint) bool {
+	if size == c.Size {
+		return true
+	}
+	return slices.ContainsFunc(c.SizeClasses, func(class *IPv4SizeClass) bool { return class.Size == size })
+}
+
+// ClaimSize returns the size of the units in which subnets are claimed within
+// the store, which is the smallest subnet size of the network. Subnets of
+// different sizes only overlap if they contain a common unit, so claiming each
+// unit of a subnet detects overlapping allocations as claim conflicts.
+func (c *IPv4Config) ClaimSize() uint {
+	size := c.Size
+	for _, class := range c.SizeClasses {
+		size = max(size, class.Size)
+	}
+	return size
+}
+
+// ClaimNetworks returns the units which must be claimed within the store to
+// allocate the subnet. Networks with a single subnet size claim the subnet
+// itself.
+func (c *IPv4Config) ClaimNetworks(subnet *IPv4Net) []*IPv4Net {
+
+	claimSize := max(c.ClaimSize(), subnet.Size)
+	unitSize := IPv4Addr(1) << (32 - claimSize)
+
+	units := make([]*IPv4Net, 0, 1<<(claimSize-subnet.Size))
+
+	for i := range 1 << (claimSize - subnet.Size) {
+		units = append(units, &IPv4Net{IP: subnet.IP + IPv4Addr(i)*unitSize, Size: claimSize})
+	}
+
+	return units
+}
+
+// LastAddr returns the last allocatable address of the network, which is the
+// end of the default sized subnet starting at or before the maximum address.
+// The network must have been canonicalized, so the maximum address is set.
+func (c *IPv4Config) LastAddr() IPv4Addr {
+	blockSize := IPv4Addr(1) << (32 - c.Size)
+	return c.Max&^(blockSize-1) + blockSize - 1
+}
+
+// UsedSubnets returns the number of subnets of the default size which overlap
+// the allocated subnets, so allocations of mixed sizes can be compared with
+// TotalSubnets. Subnets smaller than the default size which share a default
+// sized subnet are counted once.
+func (c *IPv4Config) UsedSubnets(subnets []*IPv4Net) uint64 {
+
+	var used uint64
+
+	blocks := make(map[IPv4Addr]struct{})
+
+	for _, subnet := range subnets {
+		if subnet.Size > c.Size {
+			blocks[subnet.IP&^(IPv4Addr(1)<<(32-c.Size)-1)] = struct{}{}
+			continue
+		}
+		used += 1 << (c.Size - subnet.Size)
+	}
+
+	return used + uint64(len(blocks))
+}
+
+// TotalSubnets returns the number of subnets of the configured size between
+// the minimum and maximum addresses, which must have been set by
+// Canonicalize.
+func (c *IPv4Config) TotalSubnets() uint64 {
+	if c.Max < c.Min {
+		return 0
+	}
+	return uint64(c.Max-c.Min)/(1<<(32-c.Size)) + 1
+}
+
+// IPv6Config defines the IPv6 address space configuration for a network. When
+// set alongside the IPv4 configuration, the network is dual-stack and each
+// client subnet receives both an IPv4 and IPv6 range.
+type IPv6Config struct {
+	Network *IPv6Net `json:"network"`
+	Size    uint     `json:"size"`
+}
+
+// maxIPv6SubnetBits is the maximum number of bits between the IPv6 network
+// prefix and the subnet size. This bounds the number of subnets, so it can be
+// represented and randomly probed using 64-bit integers.
+const maxIPv6SubnetBits = 62
+
+// TotalSubnets returns the number of subnets of the configured size that fit
+// within the IPv6 network.
+func (c *IPv6Config) TotalSubnets() uint64 { return 1 << (c.Size - c.Network.Size) }
+
+// LeaseConfig defines how long client subnets within a network are leased for
+// and how often clients renew them. A client which fails to renew its subnet
+// before the TTL passes has the subnet expired by the server, which causes all
+// other clients to remove their routes to it.
+type LeaseConfig struct {
+
+	// TTL is the time-to-live of each client subnet. When unset, the
+	// DefaultSubnetTTL is used.
+	TTL Duration `json:"ttl"`
+
+	// RenewInterval is how often clients renew their subnet. When unset,
+	// clients renew their subnet three times per TTL.
+	RenewInterval Duration `json:"renew_interval"`
+}
+
+// PlacementConfig selects the Nomad nodes whose clients configure a network.
+// Each field which is set must match the node, and a field listing multiple
+// values matches a node with any of them.
+type PlacementConfig struct {
+	Datacenters []string          `json:"datacenters,omitempty"`
+	NodePools   []string          `json:"node_pools,omitempty"`
+	NodeClasses []string          `json:"node_classes,omitempty"`
+	NodeMeta    map[string]string `json:"node_meta,omitempty"`
+}
+
+// NodeAttributes are the attributes of the Nomad node a client runs on, which
+// are evaluated by network placements.
+type NodeAttributes struct {
+	Datacenter string
+	NodePool   string
+	NodeClass  string
+	Meta       map[string]string
+}
+
+// IsEmpty indicates whether the placement does not restrict the nodes.
+func (p *PlacementConfig) IsEmpty() bool {
+	return p == nil ||
+		len(p.Datacenters) == 0 && len(p.NodePools) == 0 && len(p.NodeClasses) == 0 && len(p.NodeMeta) == 0
+}
+
+// Matches indicates whether a node with the passed attributes satisfies the
+// placement. A nil node, which happens when the client could not discover its
+// Nomad node, only matches an empty placement.
+func (p *PlacementConfig) Matches(node *NodeAttributes) bool {
+
+	if p.IsEmpty() {
+		return true
+	}
+	if node == nil {
+		return false
+	}
+
+	if len(p.Datacenters) > 0 && !slices.Contains(p.Datacenters, node.Datacenter) {
+		return false
+	}
+	if len(p.NodePools) > 0 && !slices.Contains(p.NodePools, node.NodePool) {
+		return false
+	}
+	if len(p.NodeClasses) > 0 && !slices.Contains(p.NodeClasses, node.NodeClass) {
+		return false
+	}
+
+	for key, value := range p.NodeMeta {
+		if nodeValue, ok := node.Meta[key]; !ok || nodeValue != value {
+			return false
+		}
+	}
+
+	return true
 }
 
 // ProviderConfig specifies which network provider implementation to use.
@@ -62,6 +278,80 @@ func (n *Network) Canonicalize() {
 // this with a static suffix of "0" is sufficient to be unique.
 func (n *Network) InterfaceName() string { return n.Name + "0" }
 
+// RoutesViaHost indicates whether traffic to remote subnets may be routed
+// directly via the host network instead of through the network interface. This
+// is the case for the host-gw provider and the VXLAN provider when direct
+// routing is enabled.
+func (n *Network) RoutesViaHost() bool {
+	if n.Provider == nil {
+		return false
+	}
+
+	switch n.Provider.Name {
+	case ProviderNameHostGW:
+		return true
+	case ProviderNameVXLAN:
+		var cfg struct {
+			DirectRouting bool `json:"direct_routing"`
+		}
+		if n.Provider.Config != nil {
+			_ = json.Unmarshal(n.Provider.Config, &cfg)
+		}
+		return cfg.DirectRouting
+	default:
+		return false
+	}
+}
+
+// LeaseTTL returns the time-to-live of client subnets within the network.
+func (n *Network) LeaseTTL() time.Duration {
+	if n.Lease != nil && n.Lease.TTL > 0 {
+		return time.Duration(n.Lease.TTL)
+	}
+	return DefaultSubnetTTL
+}
+
+// LeaseRenewInterval returns how often clients renew their subnet within the
+// network.
+func (n *Network) LeaseRenewInterval() time.Duration {
+	if n.Lease != nil && n.Lease.RenewInterval > 0 {
+		return time.Duration(n.Lease.RenewInterval)
+	}
+	return n.LeaseTTL() / 3
+}
+
+// ContainsSubnet indicates whether the subnet could have been allocated within
+// the network, meaning its ranges are of a configured size and within the
+// allocatable address space. The network must have been canonicalized, so the
+// minimum and maximum addresses are set.
+func (n *Network) ContainsSubnet(subnet *Subnet) bool {
+
+	if subnet.IPv4Network != nil {
+		if n.IPv4 == nil || !n.IPv4.ValidSize(subnet.IPv4Network.Size) {
+			return false
+		}
+
+		// The end of the subnet is computed using 64-bit integers, so a
+		// subnet at the end of the address space does not overflow.
+		end := uint64(subnet.IPv4Network.IP) + 1<<(32-subnet.IPv4Network.Size) - 1
+
+		if subnet.IPv4Network.IP < n.IPv4.Min || end > uint64(n.IPv4.LastAddr()) {
+			return false
+		}
+	}
+
+	if subnet.IPv6Network != nil {
+		if n.IPv6 == nil || subnet.IPv6Network.Size != n.IPv6.Size {
+			return false
+		}
+		if !n.IPv6.Network.ToIPNet().Contains(subnet.IPv6Network.IP.ToNetIP()) {
+			return false
+		}
+	}
+
+	return true
+}
+
 // BridgeInterfaceName returns the name of the bridge interface that containers
 // connect to for this network.
 func (n *Network) BridgeInterfaceName() string { return n.Name + "brd0" }
@@ -80,6 +370,14 @@ func (n *Network) LoggingPairs() []zap.Field {
 		)
 	}
 
+	if n.IPv6 != nil && n.IPv6.Network != nil {
+		f = append(
+			f,
+			zap.String("ipv6_network", n.IPv6.Network.String()),
+			zap.Uint("ipv6_size", n.IPv6.Size),
+		)
+	}
+
 	if n.Provider != nil {
 		f = append(f, zap.String("provider", n.Provider.Name))
 	}
@@ -105,11 +403,91 @@ func (n *Network) Validate() error {
 		return errors.New("IPv4 minimum address is out of network range")
 	}
 
+	// Validation for the optional IPv4 size classes. Classes without selectors
+	// would never match a node, so are most likely a mistake.
+	for i, class := range n.IPv4.SizeClasses {
+		if class.Size <= n.IPv4.Network.Size || class.Size > 32 {
+			return fmt.Errorf("IPv4 size class %d size must be between %d and 32", i, n.IPv4.Network.Size+1)
+		}
+		if len(class.NodeClasses) == 0 && len(class.NodeMeta) == 0 {
+			return fmt.Errorf("IPv4 size class %d must set node classes or node meta", i)
+		}
+		if slices.Contains(class.NodeClasses, "") {
+			return fmt.Errorf("IPv4 size class %d node classes must not be empty", i)
+		}
+		if _, ok := class.NodeMeta[""]; ok {
+			return fmt.Errorf("IPv4 size class %d node meta keys must not be empty", i)
+		}
+	}
+	if n.IPv4.MixedSizes() {
+		minSize := n.IPv4.Size
+		for _, class := range n.IPv4.SizeClasses {
+			minSize = min(minSize, class.Size)
+		}
+		if n.IPv4.ClaimSize()-minSize > maxIPv4SizeClassBits {
+			return fmt.Errorf("IPv4 subnet sizes must be within %d bits of each other", maxIPv4SizeClassBits)
+		}
+
+		// Subnets are claimed in aligned units, so the minimum address must
+		// be aligned to a unit, otherwise subnets allocated from it would not
+		// contain whole units and overlapping subnets would not conflict.
+		if n.IPv4.Min&(IPv4Addr(1)<<(32-n.IPv4.ClaimSize())-1) != 0 {
+			return fmt.Errorf("IPv4 minimum address must be aligned to a /%d subnet", n.IPv4.ClaimSize())
+		}
+	}
+
+	// Validation for the optional IPv6 configuration.
+	if n.IPv6 != nil {
+		if n.IPv6.Network == nil {
+			return errors.New("IPv6 network configuration is missing")
+		}
+		if n.IPv6.Size <= n.IPv6.Network.Size || n.IPv6.Size > 128 {
+			return fmt.Errorf("IPv6 subnet size must be between %d and 128", n.IPv6.Network.Size+1)
+		}
+		if n.IPv6.Size-n.IPv6.Network.Size > maxIPv6SubnetBits {
+			return fmt.Errorf("IPv6 subnet size must be within %d bits of the network prefix", maxIPv6SubnetBits)
+		}
+		if n.Provider != nil && n.Provider.Name != ProviderNameVXLAN {
+			return fmt.Errorf("IPv6 networks are not supported by the %q provider", n.Provider.Name)
+		}
+	}
+
+	// Validation for the optional lease configuration. The renew interval must
+	// be shorter than the TTL, otherwise subnets would expire between renewals.
+	if n.Lease != nil {
+		if n.Lease.TTL < 0 {
+			return errors.New("lease TTL must not be negative")
+		}
+		if n.Lease.RenewInterval < 0 {
+			return errors.New("lease renew interval must not be negative")
+		}
+		if n.LeaseRenewInterval() >= n.LeaseTTL() {
+			return errors.New("lease renew interval must be less than the TTL")
+		}
+	}
+
+	// Validation for the optional placement configuration. Empty values would
+	// never match a node, so are most likely a mistake.
+	if n.Placement != nil {
+		if slices.Contains(n.Placement.Datacenters, "") {
+			return errors.New("placement datacenters must not be empty")
+		}
+		if slices.Contains(n.Placement.NodePools, "") {
+			return errors.New("placement node pools must not be empty")
+		}
+		if slices.Contains(n.Placement.NodeClasses, "") {
+			return errors.New("placement node classes must not be empty")
+		}
+		if _, ok := n.Placement.NodeMeta[""]; ok {
+			return errors.New("placement node meta keys must not be empty")
+		}
+	}
+
 	// Validation for the network provider configuration.
 	if n.Provider == nil {
 		return errors.New("network provider configuration is missing")
 	}
-	if n.Provider.Name != "vxlan" {
+	if !slices.Contains(supportedProviders, n.Provider.Name) {
 		return fmt.Errorf("unsupported network provider: %q", n.Provider.Name)
 	}
 
@@ -0,0 +1,444 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/shoenig/test/must"
+)
+
+func TestNetwork_Validate(t *testing.T) {
+	testCases := []struct {
+		name          string
+		input         string
+		expectedError bool
+	}{
+		{
+			name:          "vxlan provider",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"}}`,
+			expectedError: false,
+		},
+		{
+			name:          "wireguard provider",
+			input:         `{"name":"wg","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"wireguard"}}`,
+			expectedError: false,
+		},
+		{
+			name:          "host-gw provider",
+			input:         `{"name":"direct","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"host-gw"}}`,
+			expectedError: false,
+		},
+		{
+			name:          "unsupported provider",
+			input:         `{"name":"gre","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"gre"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "missing provider",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24}}`,
+			expectedError: true,
+		},
+		{
+			name:          "dual-stack",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"ipv6":{"network":"fd00:10::/48","size":64},"provider":{"name":"vxlan"}}`,
+			expectedError: false,
+		},
+		{
+			name:          "dual-stack missing ipv6 network",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"ipv6":{"size":64},"provider":{"name":"vxlan"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "dual-stack ipv6 size too small",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"ipv6":{"network":"fd00:10::/48","size":48},"provider":{"name":"vxlan"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "dual-stack ipv6 too many subnets",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"ipv6":{"network":"fd00::/8","size":128},"provider":{"name":"vxlan"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "dual-stack unsupported provider",
+			input:         `{"name":"wg","ipv4":{"network":"10.10.0.0/16","size":24},"ipv6":{"network":"fd00:10::/48","size":64},"provider":{"name":"wireguard"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "lease",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"},"lease":{"ttl":"10m","renew_interval":"1m"}}`,
+			expectedError: false,
+		},
+		{
+			name:          "lease ttl only",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"},"lease":{"ttl":"10m"}}`,
+			expectedError: false,
+		},
+		{
+			name:          "lease renew interval exceeds ttl",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"},"lease":{"ttl":"1m","renew_interval":"1m"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "lease negative ttl",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"},"lease":{"ttl":"-1m"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "placement",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"},"placement":{"datacenters":["dc1"],"node_meta":{"gpu":"true"}}}`,
+			expectedError: false,
+		},
+		{
+			name:          "placement empty node pool",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"},"placement":{"node_pools":[""]}}`,
+			expectedError: true,
+		},
+		{
+			name:          "placement empty node meta key",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"provider":{"name":"vxlan"},"placement":{"node_meta":{"":"true"}}}`,
+			expectedError: true,
+		},
+		{
+			name:          "size classes",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24,"size_classes":[{"size":22,"node_classes":["large"]},{"size":26,"node_meta":{"edge":"true"}}]},"provider":{"name":"vxlan"}}`,
+			expectedError: false,
+		},
+		{
+			name:          "size class without selector",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24,"size_classes":[{"size":22}]},"provider":{"name":"vxlan"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "size class size outside network",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24,"size_classes":[{"size":16,"node_classes":["large"]}]},"provider":{"name":"vxlan"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "size class empty node class",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24,"size_classes":[{"size":22,"node_classes":[""]}]},"provider":{"name":"vxlan"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "size classes too far apart",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24,"size_classes":[{"size":18,"node_classes":["large"]},{"size":28,"node_classes":["small"]}]},"provider":{"name":"vxlan"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "size classes unaligned min",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","min":"10.10.1.32","size":24,"size_classes":[{"size":26,"node_classes":["small"]}]},"provider":{"name":"vxlan"}}`,
+			expectedError: true,
+		},
+		{
+			name:          "size classes min aligned to smallest subnet",
+			input:         `{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","min":"10.10.1.64","size":24,"size_classes":[{"size":26,"node_classes":["small"]}]},"provider":{"name":"vxlan"}}`,
+			expectedError: false,
+		},
+		{
+			name:          "missing ipv4",
+			input:         `{"name":"vxlan","provider":{"name":"vxlan"}}`,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var network Network
+			must.NoError(t, json.Unmarshal([]byte(tc.input), &network))
+
+			if tc.expectedError {
+				must.Error(t, network.Validate())
+			} else {
+				must.NoError(t, network.Validate())
+			}
+		})
+	}
+}
+
+func TestNetwork_RoutesViaHost(t *testing.T) {
+	testCases := []struct {
+		name     string
+		provider *ProviderConfig
+		expected bool
+	}{
+		{
+			name:     "nil provider",
+			provider: nil,
+			expected: false,
+		},
+		{
+			name:     "vxlan",
+			provider: &ProviderConfig{Name: ProviderNameVXLAN},
+			expected: false,
+		},
+		{
+			name: "vxlan direct routing",
+			provider: &ProviderConfig{
+				Name:   ProviderNameVXLAN,
+				Config: json.RawMessage(`{"vni":1,"direct_routing":true}`),
+			},
+			expected: true,
+		},
+		{
+			name:     "wireguard",
+			provider: &ProviderConfig{Name: ProviderNameWireGuard},
+			expected: false,
+		},
+		{
+			name:     "host-gw",
+			provider: &ProviderConfig{Name: ProviderNameHostGW},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			must.Eq(t, tc.expected, (&Network{Provider: tc.provider}).RoutesViaHost())
+		})
+	}
+}
+
+func TestNetwork_Lease(t *testing.T) {
+
+	// Networks without a lease block should use the default TTL.
+	network := &Network{}
+	must.Eq(t, DefaultSubnetTTL, network.LeaseTTL())
+	must.Eq(t, DefaultSubnetTTL/3, network.LeaseRenewInterval())
+
+	// The renew interval should be derived from a configured TTL.
+	must.NoError(t, json.Unmarshal([]byte(`{"lease":{"ttl":"15m"}}`), network))
+	must.Eq(t, 15*time.Minute, network.LeaseTTL())
+	must.Eq(t, 5*time.Minute, network.LeaseRenewInterval())
+
+	must.NoError(t, json.Unmarshal([]byte(`{"lease":{"ttl":"15m","renew_interval":"1m"}}`), network))
+	must.Eq(t, 1*time.Minute, network.LeaseRenewInterval())
+
+	// The durations should be encoded as strings.
+	out, err := json.Marshal(network.Lease)
+	must.NoError(t, err)
+	must.Eq(t, `{"ttl":"15m0s","renew_interval":"1m0s"}`, string(out))
+
+	must.Error(t, json.Unmarshal([]byte(`{"lease":{"ttl":900}}`), network))
+}
+
+func TestNetwork_ContainsSubnet(t *testing.T) {
+
+	var network Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24},"ipv6":{"network":"fd00::/48","size":64},"provider":{"name":"vxlan"}}`,
+	), &network))
+	network.Canonicalize()
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected bool
+	}{
+		{
+			name:     "within range",
+			input:    `{"ipv4_network":"10.10.1.0/24","ipv6_network":"fd00:0:0:1::/64"}`,
+			expected: true,
+		},
+		{
+			name:     "outside IPv4 network",
+			input:    `{"ipv4_network":"10.11.1.0/24"}`,
+			expected: false,
+		},
+		{
+			name:     "reserved first IPv4 subnet",
+			input:    `{"ipv4_network":"10.10.0.0/24"}`,
+			expected: false,
+		},
+		{
+			name:     "different IPv4 size",
+			input:    `{"ipv4_network":"10.10.1.0/25"}`,
+			expected: false,
+		},
+		{
+			name:     "outside IPv6 network",
+			input:    `{"ipv4_network":"10.10.1.0/24","ipv6_network":"fd01::/64"}`,
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var subnet Subnet
+			must.NoError(t, json.Unmarshal([]byte(tc.input), &subnet))
+			must.Eq(t, tc.expected, network.ContainsSubnet(&subnet))
+		})
+	}
+}
+
+func TestNetwork_ContainsSubnetSizeClasses(t *testing.T) {
+
+	var network Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24,"size_classes":[{"size":22,"node_classes":["large"]},{"size":26,"node_classes":["small"]}]},"provider":{"name":"vxlan"}}`,
+	), &network))
+	network.Canonicalize()
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected bool
+	}{
+		{
+			name:     "default size",
+			input:    `{"ipv4_network":"10.10.1.0/24"}`,
+			expected: true,
+		},
+		{
+			name:     "larger class size",
+			input:    `{"ipv4_network":"10.10.4.0/22"}`,
+			expected: true,
+		},
+		{
+			name:     "smaller class size",
+			input:    `{"ipv4_network":"10.10.254.192/26"}`,
+			expected: true,
+		},
+		{
+			name:     "unconfigured size",
+			input:    `{"ipv4_network":"10.10.1.0/25"}`,
+			expected: false,
+		},
+		{
+			name:     "overlaps reserved first subnet",
+			input:    `{"ipv4_network":"10.10.0.0/22"}`,
+			expected: false,
+		},
+		{
+			name:     "overlaps reserved last subnet",
+			input:    `{"ipv4_network":"10.10.252.0/22"}`,
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var subnet Subnet
+			must.NoError(t, json.Unmarshal([]byte(tc.input), &subnet))
+			must.Eq(t, tc.expected, network.ContainsSubnet(&subnet))
+		})
+	}
+}
+
+func TestIPv4Config_SizeClasses(t *testing.T) {
+
+	var network Network
+	must.NoError(t, json.Unmarshal([]byte(
+		`{"name":"vxlan","ipv4":{"network":"10.10.0.0/16","size":24,"size_classes":[{"size":22,"node_classes":["large"],"node_meta":{"rack":"r1"}},{"size":26,"node_meta":{"edge":"true"}}]},"provider":{"name":"vxlan"}}`,
+	), &network))
+	network.Canonicalize()
+
+	// The first matching class should be used, and nodes matching no class or
+	// clients without a node should use the default size.
+	must.Eq(t, 22, network.IPv4.SubnetSize(&NodeAttributes{NodeClass: "large", Meta: map[string]string{"rack": "r1", "edge": "true"}}))
+	must.Eq(t, 26, network.IPv4.SubnetSize(&NodeAttributes{NodeClass: "large", Meta: map[string]string{"edge": "true"}}))
+	must.Eq(t, 24, network.IPv4.SubnetSize(&NodeAttributes{NodeClass: "large"}))
+	must.Eq(t, 24, network.IPv4.SubnetSize(nil))
+
+	// Subnets should be claimed in units of the smallest size.
+	must.Eq(t, 26, network.IPv4.ClaimSize())
+
+	var claims []string
+	for _, unit := range network.IPv4.ClaimNetworks(&IPv4Net{IP: 0x0A0A0100, Size: 24}) {
+		claims = append(claims, unit.String())
+	}
+	must.Eq(t, []string{"10.10.1.0/26", "10.10.1.64/26", "10.10.1.128/26", "10.10.1.192/26"}, claims)
+
+	// Used subnets are counted in the default size, with the smaller subnets
+	// sharing a default sized subnet counted once.
+	must.Eq(t, 7, network.IPv4.UsedSubnets([]*IPv4Net{
+		{IP: 0x0A0A0100, Size: 24},
+		{IP: 0x0A0A0400, Size: 22},
+		{IP: 0x0A0A0800, Size: 26},
+		{IP: 0x0A0A0840, Size: 26},
+		{IP: 0x0A0A0900, Size: 26},
+	}))
+
+	// Networks without size classes claim the subnet itself.
+	network.IPv4.SizeClasses = nil
+	must.Eq(t, 1, len(network.IPv4.ClaimNetworks(&IPv4Net{IP: 0x0A0A0100, Size: 24})))
+}
+
+func TestPlacementConfig_Matches(t *testing.T) {
+
+	node := &NodeAttributes{
+		Datacenter: "dc1",
+		NodePool:   "gpu",
+		NodeClass:  "large",
+		Meta:       map[string]string{"rack": "r1", "gpu": "true"},
+	}
+
+	testCases := []struct {
+		name     string
+		input    string
+		node     *NodeAttributes
+		expected bool
+	}{
+		{
+			name:     "no placement",
+			input:    `{}`,
+			node:     node,
+			expected: true,
+		},
+		{
+			name:     "empty placement without node",
+			input:    `{"placement":{}}`,
+			node:     nil,
+			expected: true,
+		},
+		{
+			name:     "placement without node",
+			input:    `{"placement":{"datacenters":["dc1"]}}`,
+			node:     nil,
+			expected: false,
+		},
+		{
+			name:     "all fields match",
+			input:    `{"placement":{"datacenters":["dc1"],"node_pools":["default","gpu"],"node_classes":["large"],"node_meta":{"gpu":"true"}}}`,
+			node:     node,
+			expected: true,
+		},
+		{
+			name:     "datacenter mismatch",
+			input:    `{"placement":{"datacenters":["dc2"],"node_pools":["gpu"]}}`,
+			node:     node,
+			expected: false,
+		},
+		{
+			name:     "node pool mismatch",
+			input:    `{"placement":{"node_pools":["default"]}}`,
+			node:     node,
+			expected: false,
+		},
+		{
+			name:     "node class mismatch",
+			input:    `{"placement":{"node_classes":["small"]}}`,
+			node:     node,
+			expected: false,
+		},
+		{
+			name:     "node meta value mismatch",
+			input:    `{"placement":{"node_meta":{"rack":"r2"}}}`,
+			node:     node,
+			expected: false,
+		},
+		{
+			name:     "node meta key missing",
+			input:    `{"placement":{"node_meta":{"zone":"a"}}}`,
+			node:     node,
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var network Network
+			must.NoError(t, json.Unmarshal([]byte(tc.input), &network))
+			must.Eq(t, tc.expected, network.Placement.Matches(tc.node))
+		})
+	}
+}
@@ -1,9 +1,35 @@
 package types
 
 import (
+	"context"
+	"errors"
 	"net"
 )
 
+// ErrNotSupported is returned when an optional operation is not supported by
+// the network provider of a network.
+var ErrNotSupported = errors.New("operation not supported by network provider")
+
+const (
+	// ProviderNameVXLAN is the name of the VXLAN network provider.
+	ProviderNameVXLAN = "vxlan"
+
+	// ProviderNameWireGuard is the name of the WireGuard network provider.
+	ProviderNameWireGuard = "wireguard"
+
+	// ProviderNameHostGW is the name of the host gateway network provider,
+	// which routes directly between hosts without encapsulation.
+	ProviderNameHostGW = "host-gw"
+)
+
+// supportedProviders is the list of network provider names that can be used
+// within a network configuration.
+var supportedProviders = []string{
+	ProviderNameVXLAN,
+	ProviderNameWireGuard,
+	ProviderNameHostGW,
+}
+
 // NetworkProvider defines the interface for network provider implementations.
 // Providers are responsible for setting up the underlying network infrastructure
 // (e.g., VXLAN, WireGuard, etc.) for the Smuggle network fabric.
@@ -14,11 +40,75 @@ type NetworkProvider interface {
 	// SetLocal configures the local subnet for this host.
 	SetLocal(*NetworkProviderSetReq) (*NetworkProviderSetResp, error)
 
+	// DeleteLocal removes the local subnet configuration for this host, along
+	// with any routing configuration to remote subnets of the network.
+	DeleteLocal(*NetworkProviderDeleteLocalReq) (*NetworkProviderDeleteLocalResp, error)
+
 	// DeleteRemote removes a remote subnet's routing configuration.
 	DeleteRemote(*NetworkProviderDeleteRemoteReq) (*NetworkProviderDeleteRemoteResp, error)
 
 	// SetRemote configures routing to a remote subnet.
 	SetRemote(*NetworkProviderSetRemoteReq) (*NetworkProviderSetRemoteResp, error)
+
+	// Reconcile compares the routing configuration to remote subnets on the
+	// host with the passed remote subnets. Missing configuration is added and
+	// stale configuration, which does not belong to any remote subnet, is
+	// removed.
+	Reconcile(*NetworkProviderReconcileReq) (*NetworkProviderReconcileResp, error)
+}
+
+// NetworkProviderMonitor is implemented by network providers which can watch
+// the host for the removal of configuration they own, such as a link deleted
+// by an operator. This allows the configuration to be repaired immediately,
+// rather than on the next reconciliation.
+type NetworkProviderMonitor interface {
+
+	// Monitor starts watching the host and returns once the watch has been
+	// established. The watch runs until the request context is cancelled.
+	Monitor(*NetworkProviderMonitorReq) error
+}
+
+// NetworkProviderMonitorReq contains parameters for monitoring the host.
+type NetworkProviderMonitorReq struct {
+	Context context.Context
+
+	// EventCh receives an event each time configuration owned by the
+	// provider is removed from the host. The provider must not close the
+	// channel, as it may be shared with other providers.
+	EventCh chan<- *NetworkProviderEvent
+}
+
+// NetworkProviderEvent describes configuration owned by a provider which has
+// been removed from the host outside of Smuggle.
+type NetworkProviderEvent struct {
+	NetworkName string
+
+	// LinkDeleted indicates the network interface was deleted, so the local
+	// subnet must be set up again before remote subnets can be repaired.
+	LinkDeleted bool
+
+	// Reason describes the removal, for logging.
+	Reason string
+}
+
+// NetworkProviderRouteLister is implemented by network providers which can
+// list the entries they have programmed on the host to route traffic to remote
+// subnets.
+type NetworkProviderRouteLister interface {
+	ListRoutes(*NetworkProviderListRoutesReq) (*NetworkProviderListRoutesResp, error)
+}
+
+// NetworkProviderListRoutesReq contains parameters for listing the routing
+// entries of the local subnet.
+type NetworkProviderListRoutesReq struct {
+	Network *Network
+	Local   *Subnet
+}
+
+// NetworkProviderListRoutesResp contains the result of listing the routing
+// entries of the local subnet.
+type NetworkProviderListRoutesResp struct {
+	Routes *LocalRoutes
 }
 
 // NetworkProviderSetReq contains parameters for setting up a local subnet.
@@ -32,6 +122,16 @@ type NetworkProviderSetResp struct {
 	Network *Subnet
 }
 
+// NetworkProviderDeleteLocalReq contains parameters for deleting the local
+// subnet.
+type NetworkProviderDeleteLocalReq struct {
+	Network *Network
+	Client  *Subnet
+}
+
+// NetworkProviderDeleteLocalResp is returned after deleting the local subnet.
+type NetworkProviderDeleteLocalResp struct{}
+
 // NetworkProviderDeleteRemoteReq contains parameters for deleting a remote subnet.
 type NetworkProviderDeleteRemoteReq struct {
 	Subnet *Subnet
@@ -47,3 +147,26 @@ type NetworkProviderSetRemoteReq struct {
 
 // NetworkProviderSetRemoteResp is returned after setting up a remote subnet.
 type NetworkProviderSetRemoteResp struct{}
+
+// NetworkProviderReconcileReq contains parameters for reconciling the routing
+// configuration to remote subnets.
+type NetworkProviderReconcileReq struct {
+	Network *Network
+	Local   *Subnet
+
+	// Remotes is the full set of remote subnets which should be routable. It
+	// must not include the local subnet or expired subnets.
+	Remotes []*Subnet
+}
+
+// NetworkProviderReconcileResp contains the result of reconciling the routing
+// configuration to remote subnets.
+type NetworkProviderReconcileResp struct {
+
+	// Repaired is the number of remote subnets which had missing routing
+	// configuration that has been added.
+	Repaired int
+
+	// Removed is the number of stale routing entries which have been removed.
+	Removed int
+}
@@ -0,0 +1,38 @@
+package types
+
+// LocalRoutes describes the entries a network provider has programmed on the
+// host to route traffic to the remote subnets of a network.
+type LocalRoutes struct {
+	NetworkName string `json:"network_name"`
+	Provider    string `json:"provider"`
+
+	// Routes are the routes to remote subnets.
+	Routes []*RouteEntry `json:"routes"`
+
+	// FDB are the forwarding database entries, which map the MAC address of
+	// each remote VTEP to its underlay IP address.
+	FDB []*FDBEntry `json:"fdb"`
+
+	// Neighbors are the ARP and NDP entries, which map the gateway IP address
+	// of each remote subnet to its MAC address.
+	Neighbors []*NeighborEntry `json:"neighbors"`
+}
+
+// RouteEntry is a route to a remote subnet via a gateway.
+type RouteEntry struct {
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway"`
+	Interface   string `json:"interface"`
+}
+
+// FDBEntry is a forwarding database entry for a remote VTEP.
+type FDBEntry struct {
+	MAC         string `json:"mac"`
+	Destination string `json:"destination"`
+}
+
+// NeighborEntry is a permanent ARP or NDP entry for a remote gateway.
+type NeighborEntry struct {
+	IP  string `json:"ip"`
+	MAC string `json:"mac"`
+}
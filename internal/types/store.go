@@ -2,6 +2,8 @@ package types
 
 import (
 	"context"
+	"errors"
+	"strings"
 )
 
 // StoreVersionLatest defines the latest version identifier of the state store
@@ -13,6 +15,11 @@ import (
 // migrations from older versions to the latest.
 const StoreVersionLatest = "v1"
 
+// ErrSubnetConflict is returned by the store when a subnet CIDR claim fails
+// because the CIDR is already claimed by another client. Callers should
+// allocate a different CIDR and try again.
+var ErrSubnetConflict = errors.New("subnet CIDR already claimed by another client")
+
 // Store defines the interface for persisting and retrieving network and subnet
 // configurations. Each implementation is responsible for managing the storage
 // and how version schema migrations are handled as well as their own internal
@@ -20,6 +27,23 @@ const StoreVersionLatest = "v1"
 type Store interface {
 	ListNetworks(*StoreGetNetworksReq) (*StoreGetNetworksResp, error)
 
+	// WatchNetworks watches for changes to the network configurations. The
+	// full set of networks is sent whenever a change is detected, starting with
+	// the current set, which may be empty.
+	WatchNetworks(*StoreWatchNetworksReq) (*StoreWatchNetworksResp, error)
+
+	// SetNetwork writes the network configuration, replacing any existing
+	// network with the same name. The store does not validate the network, so
+	// callers are responsible for ensuring it is valid and does not orphan
+	// allocated subnets.
+	SetNetwork(*StoreSetNetworkReq) (*StoreSetNetworkResp, error)
+
+	// DeleteNetwork removes the network configuration. Deleting a network which
+	// does not exist is not an error. Subnets allocated within the network are
+	// not removed and are released by their clients once the network is
+	// removed from the host.
+	DeleteNetwork(*StoreDeleteNetworkReq) (*StoreDeleteNetworkResp, error)
+
 	ListSubnets(*StoreListSubnetsReq) (*StoreListSubnetsResp, error)
 
 	DeleteSubnet(*StoreDeleteSubnetReq) (*StoreDeleteSubnetResp, error)
@@ -28,6 +52,13 @@ type Store interface {
 
 	SetSubnet(*StoreSetSubnetReq) (*StoreSetSubnetResp, error)
 
+	// ClaimSubnet reserves a subnet CIDR for a client using a compare-and-set
+	// write, so two clients can never claim the same CIDR within a network.
+	// Claiming a CIDR already held by the same client succeeds. If another
+	// client holds the claim, an error wrapping ErrSubnetConflict is returned.
+	// Claims are released when the owning client's subnet is deleted.
+	ClaimSubnet(*StoreClaimSubnetReq) (*StoreClaimSubnetResp, error)
+
 	WatchSubnets(*StoreWatchSubnetsReq) (*StoreWatchSubnetsResp, error)
 }
 
@@ -44,6 +75,27 @@ type StoreGetNetworksResp struct {
 	Networks []*Network
 }
 
+type StoreWatchNetworksReq struct {
+	Context context.Context
+}
+
+type StoreWatchNetworksResp struct {
+	NetworksCh chan []*Network
+	ErrorCh    chan error
+}
+
+type StoreSetNetworkReq struct {
+	Network *Network
+}
+
+type StoreSetNetworkResp struct{}
+
+type StoreDeleteNetworkReq struct {
+	Name string
+}
+
+type StoreDeleteNetworkResp struct{}
+
 type StoreListSubnetsReq struct {
 	Network string
 }
@@ -58,6 +110,23 @@ type StoreSetSubnetReq struct {
 
 type StoreSetSubnetResp struct{}
 
+type StoreClaimSubnetReq struct {
+	ClientID    string
+	NetworkName string
+
+	// CIDR is the IPv4 or IPv6 subnet CIDR to claim, such as "10.10.1.0/24".
+	CIDR string
+}
+
+type StoreClaimSubnetResp struct{}
+
+// SubnetClaimKey converts the CIDR into a key which can be used as a single
+// path segment by all store backends. Separators are replaced with dashes,
+// so "10.10.1.0/24" becomes "10-10-1-0-24".
+func SubnetClaimKey(cidr string) string {
+	return strings.NewReplacer(".", "-", ":", "-", "/", "-").Replace(cidr)
+}
+
 type StoreGetSubnetReq struct {
 	ID          string
 	NetworkName string
@@ -21,14 +21,30 @@ type Subnet struct {
 	NetworkName string `json:"network_name"`
 
 	// Provider is the name of the network provider used to create and manage
-	// this subnet. This currently only supports "vxlan".
+	// this subnet.
 	Provider string `json:"provider"`
 
+	// NomadNodeID is the ID of the Nomad node the client runs on. It is used
+	// by the server to expire the subnet when Nomad reports the node as down
+	// or purged, and is empty if the client could not discover its node.
+	NomadNodeID string `json:"nomad_node_id,omitempty"`
+
+	// NomadNodeName and NomadDatacenter are the name and datacenter of the
+	// Nomad node the client runs on, so operators can map subnets to nodes.
+	// They are empty if the client could not discover its node.
+	NomadNodeName   string `json:"nomad_node_name,omitempty"`
+	NomadDatacenter string `json:"nomad_datacenter,omitempty"`
+
 	// HostIPv4 is the IPv4 address of the host interface on which this subnet
 	// is configured. This is used by the network provider to set up the overlay
 	// network.
 	HostIPv4 *net.IP `json:"host_ipv4"`
 
+	// HostIPv6 is the global unicast IPv6 address of the host interface on
+	// which this subnet is configured. This is used by network providers that
+	// support an IPv6 underlay network.
+	HostIPv6 *net.IP `json:"host_ipv6,omitempty"`
+
 	// Config is the provider-specific configuration for this subnet. This is
 	// a JSON-encoded object that contains settings required by the network
 	// provider to set up the subnet and should be opaque to the core system.
@@ -48,6 +64,10 @@ type Subnet struct {
 	// network fabric.
 	IPv4Network *IPv4Net `json:"ipv4_network"`
 
+	// IPv6Network is the IPv6 subnet allocated to this client within the
+	// network fabric. This is only set when the network is dual-stack.
+	IPv6Network *IPv6Net `json:"ipv6_network,omitempty"`
+
 	// MTU is the maximum transmission unit size for this subnet, which is
 	// typically derived from the host interface's MTU minus any overhead for
 	// the network provider.
@@ -68,11 +88,21 @@ func (s *Subnet) Copy() *Subnet {
 		copy.HostIPv4 = &ipCopy
 	}
 
+	if s.HostIPv6 != nil {
+		ipCopy := *s.HostIPv6
+		copy.HostIPv6 = &ipCopy
+	}
+
 	if s.IPv4Network != nil {
 		ipv4Copy := *s.IPv4Network
 		copy.IPv4Network = &ipv4Copy
 	}
 
+	if s.IPv6Network != nil {
+		ipv6Copy := *s.IPv6Network
+		copy.IPv6Network = &ipv6Copy
+	}
+
 	return &copy
 }
 
@@ -89,17 +119,61 @@ func (s *Subnet) LoggingPairs() []zap.Field {
 		zap.String("provider", s.Provider),
 	}
 
+	if s.NomadNodeID != "" {
+		fields = append(fields, zap.String("nomad_node_id", s.NomadNodeID))
+	}
+	if s.NomadNodeName != "" {
+		fields = append(fields, zap.String("nomad_node_name", s.NomadNodeName))
+	}
+	if s.NomadDatacenter != "" {
+		fields = append(fields, zap.String("nomad_datacenter", s.NomadDatacenter))
+	}
+
 	if s.HostIPv4 != nil {
 		fields = append(fields, zap.String("host_ipv4", s.HostIPv4.String()))
 	}
+	if s.HostIPv6 != nil {
+		fields = append(fields, zap.String("host_ipv6", s.HostIPv6.String()))
+	}
 	if s.IPv4Network != nil {
 		fields = append(fields, zap.String("ipv4_network", s.IPv4Network.String()))
 	}
+	if s.IPv6Network != nil {
+		fields = append(fields, zap.String("ipv6_network", s.IPv6Network.String()))
+	}
 
 	return fields
 }
 
-// DefaultSubnetTTL is the default time-to-live for subnets. Subnets must be
-// refreshed via heartbeat before this TTL expires, or they will be considered
-// expired and ready for reaping.
+// SubnetLease describes the lease a client holds on its subnet within a
+// network, as renewed by the client heartbeat.
+type SubnetLease struct {
+	ClientID    string   `json:"client_id"`
+	NetworkName string   `json:"network_name"`
+	IPv4Network *IPv4Net `json:"ipv4_network"`
+
+	// TTL and RenewInterval are the lease settings of the network.
+	TTL           Duration `json:"ttl"`
+	RenewInterval Duration `json:"renew_interval"`
+
+	// Expiration is the time the lease expires, unless it is renewed. This is
+	// the expiration of the subnet which was last written to the store.
+	Expiration time.Time `json:"expiration"`
+
+	// LastRenewal is the time the lease was last renewed successfully. It is
+	// the zero time if the lease has not been renewed since the client
+	// started.
+	LastRenewal time.Time `json:"last_renewal"`
+
+	// LastError is the error from the most recent renewal attempt, if it
+	// failed, and ConsecutiveFailures is the number of renewal attempts which
+	// have failed since the last success.
+	LastError           string `json:"last_error,omitempty"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+}
+
+// DefaultSubnetTTL is the default time-to-live for subnets, used when the
+// network does not configure a lease TTL. Subnets must be refreshed via
+// heartbeat before this TTL expires, or they will be considered expired and
+// ready for reaping.
 var DefaultSubnetTTL = 24 * time.Hour